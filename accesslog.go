@@ -0,0 +1,218 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"acln.ro/log"
+)
+
+// An AccessLogOption configures AccessLog.
+type AccessLogOption func(*accessLogConfig)
+
+type accessLogConfig struct {
+	fields  RequestLoggerOptions
+	sampler Sampler
+	slow    time.Duration
+	dump    bool
+}
+
+// AccessLogFields configures the request fields recorded by AccessLog,
+// including the redaction of sensitive query parameters and headers.
+// By default, the zero RequestLoggerOptions is used, which records the
+// same fields as RequestLogger.
+func AccessLogFields(opts RequestLoggerOptions) AccessLogOption {
+	return func(cfg *accessLogConfig) {
+		cfg.fields = opts
+	}
+}
+
+// A Sampler decides whether a request is recorded in the access log,
+// based on the request and the summary of its response.
+type Sampler func(req *http.Request, s Summary) bool
+
+// AccessLogSampler configures AccessLog to record only the requests
+// selected by sampler. By default, all requests are recorded.
+func AccessLogSampler(sampler Sampler) AccessLogOption {
+	return func(cfg *accessLogConfig) {
+		cfg.sampler = sampler
+	}
+}
+
+// AccessLogSlow configures AccessLog to escalate requests which take
+// longer than threshold to the Error level, and to mark their entries with
+// "slow" set to true. Slow requests are always recorded, regardless of
+// sampling.
+//
+// If dump is true, the stacks of all goroutines are captured when the
+// threshold elapses, while the request is still being served, and
+// recorded under the "stacks" key, to show where the handler is stuck.
+func AccessLogSlow(threshold time.Duration, dump bool) AccessLogOption {
+	return func(cfg *accessLogConfig) {
+		cfg.slow = threshold
+		cfg.dump = dump
+	}
+}
+
+// maxStackDump limits the size of the stacks captured for slow requests.
+const maxStackDump = 64 << 10
+
+// serve serves req using next, and returns the Summary, along with the
+// additional fields recorded for slow requests, or nil if the request is
+// not to be recorded at all.
+func (cfg *accessLogConfig) serve(next http.Handler, w http.ResponseWriter, req *http.Request) (Summary, log.KV) {
+	var (
+		mu     sync.Mutex
+		stacks []byte
+	)
+	if cfg.slow > 0 && cfg.dump {
+		timer := time.AfterFunc(cfg.slow, func() {
+			buf := make([]byte, maxStackDump)
+			buf = buf[:runtime.Stack(buf, true)]
+			mu.Lock()
+			stacks = buf
+			mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+	s := ServeInstrumented(next, w, req)
+	if cfg.slow > 0 && s.Duration > cfg.slow {
+		extra := log.KV{"slow": true}
+		mu.Lock()
+		if stacks != nil {
+			extra["stacks"] = string(stacks)
+		}
+		mu.Unlock()
+		return s, extra
+	}
+	if cfg.sampler != nil && !cfg.sampler(req, s) {
+		return s, nil
+	}
+	return s, log.KV{}
+}
+
+// SampleRate returns a Sampler which selects requests with probability
+// rate, between 0 and 1.
+func SampleRate(rate float64) Sampler {
+	return func(*http.Request, Summary) bool {
+		return sample(rate)
+	}
+}
+
+// SampleByStatus returns a Sampler which selects requests with a
+// probability which depends on the class of the response status: rates
+// maps the class, e.g. 2 for 2xx responses, to a probability between 0
+// and 1. Classes missing from rates are always selected. For example,
+// SampleByStatus(map[int]float64{2: 0.01}) records all errors, but only
+// one percent of successful requests.
+func SampleByStatus(rates map[int]float64) Sampler {
+	return func(_ *http.Request, s Summary) bool {
+		rate, ok := rates[s.Status/100]
+		return !ok || sample(rate)
+	}
+}
+
+func sample(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// AccessLog returns a handler which serves requests using next, and emits
+// one structured log entry per request, at the Info level. The entry
+// combines the keys recorded by RequestLogger with those of Summary.KV.
+//
+// Before calling next, AccessLog stores the request path using WithPath,
+// assigns a random request identifier, unless one is assigned already,
+// and installs request state using WithRequestState. It also stores the
+// request-scoped logger using WithLogger, unless one is stored already.
+func AccessLog(logger *log.Logger, next http.Handler, opts ...AccessLogOption) http.Handler {
+	cfg := newAccessLogConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = prepareAccessLog(req)
+		if Logger(req) == nil {
+			req = WithLogger(req, cfg.fields.Logger(logger, req))
+		}
+		s, extra := cfg.serve(next, w, req)
+		if extra == nil {
+			return
+		}
+		kv := s.KV()
+		for k, v := range extra {
+			kv[k] = v
+		}
+		l := cfg.fields.Logger(logger, req)
+		if extra["slow"] == true {
+			l.Error(kv)
+		} else {
+			l.Info(kv)
+		}
+	})
+}
+
+// AccessLogWriter is like AccessLog, but formats entries using f, and
+// writes them to w, for use with logging backends other than acln.ro/log.
+// Each entry is written using a single call to w.Write, and calls are
+// serialized.
+func AccessLogWriter(w io.Writer, f Formatter, next http.Handler, opts ...AccessLogOption) http.Handler {
+	cfg := newAccessLogConfig(opts)
+	var mu sync.Mutex
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req = prepareAccessLog(req)
+		s, extra := cfg.serve(next, rw, req)
+		if extra == nil {
+			return
+		}
+		e := &AccessLogEntry{
+			Time:    time.Now(),
+			Request: req,
+			URI:     cfg.fields.redactedURI(req),
+			Summary: s,
+			Fields:  cfg.fields.KV(req),
+		}
+		for k, v := range s.KV() {
+			e.Fields[k] = v
+		}
+		for k, v := range extra {
+			e.Fields[k] = v
+		}
+		var buf bytes.Buffer
+		if err := f.Format(&buf, e); err != nil {
+			return
+		}
+		mu.Lock()
+		w.Write(buf.Bytes())
+		mu.Unlock()
+	})
+}
+
+func newAccessLogConfig(opts []AccessLogOption) *accessLogConfig {
+	cfg := new(accessLogConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func prepareAccessLog(req *http.Request) *http.Request {
+	req = WithPath(req)
+	req = WithRequestID(req, RandomIDs.NewID())
+	return WithRequestState(req)
+}
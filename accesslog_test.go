@@ -0,0 +1,198 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+	"acln.ro/log"
+)
+
+func TestSampleByStatus(t *testing.T) {
+	sampler := httpx.SampleByStatus(map[int]float64{2: 0, 3: 1})
+	req := httptest.NewRequest("GET", "/", nil)
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{200, false},
+		{204, false},
+		{304, true},
+		{404, true},
+		{503, true},
+	}
+	for _, tt := range tests {
+		if got := sampler(req, httpx.Summary{Status: tt.status}); got != tt.want {
+			t.Errorf("status %d: got %t, want %t", tt.status, got, tt.want)
+		}
+	}
+
+	never, always := httpx.SampleRate(0), httpx.SampleRate(1)
+	for i := 0; i < 100; i++ {
+		if never(req, httpx.Summary{}) || !always(req, httpx.Summary{}) {
+			t.Fatal("SampleRate ignored its rate")
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.Logger(req).Info(log.KV{"msg": "handling"})
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	})
+	tests := []struct {
+		name    string
+		opts    []httpx.AccessLogOption
+		entries int
+	}{
+		{"all", nil, 2},
+		{"sampled out", []httpx.AccessLogOption{httpx.AccessLogSampler(httpx.SampleRate(0))}, 1},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		lh := httpx.AccessLog(log.New(&buf), h, tt.opts...)
+		req := httpx.WithRequestID(httptest.NewRequest("PUT", "/widgets/7", nil), "r1")
+		rec := httptest.NewRecorder()
+		lh.ServeHTTP(rec, req)
+		if rec.Code != http.StatusTeapot {
+			t.Errorf("%s: status == %d, want %d", tt.name, rec.Code, http.StatusTeapot)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != tt.entries {
+			t.Fatalf("%s: got %d entries, want %d: %q", tt.name, len(lines), tt.entries, buf.String())
+		}
+		// Both the entries of the handler, using the request-scoped
+		// logger, and that of AccessLog record the request fields.
+		for _, line := range lines {
+			for _, want := range []string{"PUT", "/widgets/7", "r1"} {
+				if !strings.Contains(line, want) {
+					t.Errorf("%s: entry %q does not record %q", tt.name, line, want)
+				}
+			}
+		}
+		if !strings.Contains(lines[0], "handling") {
+			t.Errorf("%s: first entry %q is not that of the handler", tt.name, lines[0])
+		}
+		if tt.entries < 2 {
+			continue
+		}
+		for _, want := range []string{"status", "418", "written", "15"} {
+			if !strings.Contains(lines[1], want) {
+				t.Errorf("%s: access log entry %q does not record %q", tt.name, lines[1], want)
+			}
+		}
+	}
+}
+
+func TestAccessLogWriter(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.Annotate(req, "user", "jane doe")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	tests := []struct {
+		name   string
+		format httpx.Formatter
+		check  func(t *testing.T, line string)
+	}{
+		{
+			name:   "json",
+			format: httpx.JSONFormat,
+			check: func(t *testing.T, line string) {
+				var obj map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &obj); err != nil {
+					t.Fatal(err)
+				}
+				if obj["status"] != float64(http.StatusTeapot) || obj["user"] != "jane doe" || obj["method"] != "GET" {
+					t.Errorf("unexpected entry %v", obj)
+				}
+				if _, ok := obj["duration"].(string); !ok {
+					t.Errorf("duration == %#v, want string", obj["duration"])
+				}
+			},
+		},
+		{
+			name:   "logfmt",
+			format: httpx.LogfmtFormat,
+			check: func(t *testing.T, line string) {
+				for _, want := range []string{" method=GET ", " status=418 ", ` user="jane doe"`} {
+					if !strings.Contains(line, want) {
+						t.Errorf("line %q does not contain %q", line, want)
+					}
+				}
+				if !strings.HasPrefix(line, "time=") {
+					t.Errorf("line %q does not start with the time", line)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			httpx.AccessLogWriter(&buf, tt.format, h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+			line := buf.String()
+			if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+				t.Fatalf("got %q, want a single line", line)
+			}
+			tt.check(t, line)
+		})
+	}
+}
+
+func TestAccessLogSlow(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+	})
+	never := httpx.AccessLogSampler(func(*http.Request, httpx.Summary) bool { return false })
+	tests := []struct {
+		path       string
+		wantLogged bool
+	}{
+		{"/fast", false},
+		{"/slow", true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		lh := httpx.AccessLogWriter(&buf, httpx.JSONFormat, h, never, httpx.AccessLogSlow(10*time.Millisecond, true))
+		lh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		if logged := buf.Len() > 0; logged != tt.wantLogged {
+			t.Errorf("%s: logged %t, want %t", tt.path, logged, tt.wantLogged)
+			continue
+		}
+		if !tt.wantLogged {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+			t.Fatal(err)
+		}
+		if obj["slow"] != true {
+			t.Errorf("%s: slow == %v, want true", tt.path, obj["slow"])
+		}
+		if stacks, _ := obj["stacks"].(string); !strings.Contains(stacks, "goroutine") {
+			t.Errorf("%s: stacks missing: %q", tt.path, stacks)
+		}
+	}
+}
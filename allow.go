@@ -0,0 +1,149 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Methods is a handler which dispatches requests to handlers by method,
+// for the leaves of handler trees routed by Shift:
+//
+//	case "users":
+//		httpx.Methods{"GET": listUsers, "POST": createUser}.ServeHTTP(w, req)
+//
+// HEAD requests are served by the GET handler, unless a HEAD handler is
+// present. OPTIONS requests, unless an OPTIONS handler is present, and
+// requests using methods which have no handler, are answered as by
+// AllowMethods.
+type Methods map[string]http.Handler
+
+func (m Methods) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h, ok := m[req.Method]; ok {
+		h.ServeHTTP(w, req)
+		return
+	}
+	if h, ok := m[http.MethodGet]; ok && req.Method == http.MethodHead {
+		h.ServeHTTP(w, req)
+		return
+	}
+	methods := make([]string, 0, len(m))
+	for method := range m {
+		methods = append(methods, method)
+	}
+	AllowMethods(w, req, methods...)
+}
+
+// AllowMethods responds to req, whose method is not among the allowed
+// methods of the resource. OPTIONS requests are answered with 204 No
+// Content, and other requests with 405 Method Not Allowed and a problem
+// details body. In both cases, the Allow header lists the allowed
+// methods, as computed by Allow.
+func AllowMethods(w http.ResponseWriter, req *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(Allow(allowed...), ", "))
+	if req.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeProblem(w, http.StatusMethodNotAllowed, "")
+}
+
+// Allow returns the value of the Allow header for a resource which
+// handles the specified methods: the methods, sorted and without
+// duplicates, with HEAD added if GET is present, and OPTIONS, which is
+// always allowed.
+func Allow(methods ...string) []string {
+	set := map[string]bool{http.MethodOptions: true}
+	for _, m := range methods {
+		set[m] = true
+		if m == http.MethodGet {
+			set[http.MethodHead] = true
+		}
+	}
+	allow := make([]string, 0, len(set))
+	for m := range set {
+		allow = append(allow, m)
+	}
+	sort.Strings(allow)
+	return allow
+}
+
+// Allow returns the methods allowed for the request path p by the route
+// table, as computed by the package-level Allow function, or nil if no
+// route matches p.
+func (rs Routes) Allow(p string) []string {
+	var methods []string
+	for _, r := range rs {
+		if matchPattern(r.Pattern, p) {
+			methods = append(methods, r.method())
+		}
+	}
+	if methods == nil {
+		return nil
+	}
+	return Allow(methods...)
+}
+
+// EnforceMethods returns a handler which answers requests for paths
+// matched by the route table using methods for which there is no route,
+// as AllowMethods does, and passes all other requests to next. Requests
+// for paths which no route matches are passed to next as well, which
+// typically responds with 404 Not Found.
+//
+// The route table must describe the paths as next sees them.
+func (rs Routes) EnforceMethods(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p := path.Clean("/" + req.URL.Path)
+		var methods []string
+		for _, r := range rs {
+			if !matchPattern(r.Pattern, p) {
+				continue
+			}
+			m := r.method()
+			if m == req.Method || m == http.MethodGet && req.Method == http.MethodHead {
+				next.ServeHTTP(w, req)
+				return
+			}
+			methods = append(methods, m)
+		}
+		if methods == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		AllowMethods(w, req, methods...)
+	})
+}
+
+// matchPattern reports whether the request path p matches pattern, in
+// which parameters match any single non-empty segment.
+func matchPattern(pattern, p string) bool {
+	ps, segs := strings.Split(pattern, "/"), strings.Split(p, "/")
+	if len(ps) != len(segs) {
+		return false
+	}
+	for i := range ps {
+		if isParam(ps[i]) {
+			if segs[i] == "" {
+				return false
+			}
+		} else if ps[i] != segs[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestMethods(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Method))
+	})
+	h := httpx.Methods{"GET": ok, "POST": ok}
+	tests := []struct {
+		method string
+		status int
+		allow  string
+	}{
+		{"GET", http.StatusOK, ""},
+		{"HEAD", http.StatusOK, ""},
+		{"POST", http.StatusOK, ""},
+		{"DELETE", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, POST"},
+		{"OPTIONS", http.StatusNoContent, "GET, HEAD, OPTIONS, POST"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status == %d, want %d", tt.method, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s: Allow == %q, want %q", tt.method, got, tt.allow)
+		}
+	}
+}
+
+func TestRoutesAllow(t *testing.T) {
+	rs := httpx.Routes{
+		{Pattern: "/users"},
+		{Method: "POST", Pattern: "/users"},
+		{Pattern: "/users/{id}"},
+		{Method: "DELETE", Pattern: "/users/{uid}"},
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/users", []string{"GET", "HEAD", "OPTIONS", "POST"}},
+		{"/users/42", []string{"DELETE", "GET", "HEAD", "OPTIONS"}},
+		{"/users/", nil},
+		{"/posts", nil},
+	}
+	for _, tt := range tests {
+		if got := rs.Allow(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Allow(%q) == %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRoutesEnforceMethods(t *testing.T) {
+	rs := httpx.Routes{
+		{Pattern: "/users/{id}"},
+		{Method: "PUT", Pattern: "/users/{id}"},
+	}
+	h := rs.EnforceMethods(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.NotFound(w, req)
+	}))
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"GET", "/users/1", http.StatusNotFound, ""},
+		{"HEAD", "/users/1", http.StatusNotFound, ""},
+		{"PUT", "/users/1", http.StatusNotFound, ""},
+		{"POST", "/users/1", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, PUT"},
+		{"OPTIONS", "/users/1", http.StatusNoContent, "GET, HEAD, OPTIONS, PUT"},
+		{"POST", "/posts", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status == %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow == %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+
+	"acln.ro/log"
+)
+
+// Annotate records a field, such as a database timing, a cache hit or
+// the identifier of the authenticated user, against the request being
+// served. ServeInstrumented reports the fields in Summary.Annotations, so
+// that they are merged into the single entry which AccessLog emits for
+// the request. Later calls with the same key replace earlier values.
+//
+// If req carries no request state, Annotate is a no-op.
+func Annotate(req *http.Request, key string, value interface{}) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.fields == nil {
+		st.fields = make(log.KV)
+	}
+	st.fields[key] = value
+}
+
+// Annotations returns a copy of the fields recorded for req using Annotate.
+func Annotations(req *http.Request) log.KV {
+	st := stateOf(req)
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.fields) == 0 {
+		return nil
+	}
+	kv := make(log.KV, len(st.fields))
+	for k, v := range st.fields {
+		kv[k] = v
+	}
+	return kv
+}
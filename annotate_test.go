@@ -0,0 +1,51 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestAnnotate(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.Annotate(req, "user_id", "u42")
+		httpx.Annotate(req, "cache_hit", false)
+		httpx.Annotate(req, "cache_hit", true)
+		httpx.Annotate(req, "status", "shadowed")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	kv := s.KV()
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"user_id", "u42"},
+		{"cache_hit", true},
+		{"status", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if got := kv[tt.key]; got != tt.want {
+			t.Errorf("KV[%q] == %v, want %v", tt.key, got, tt.want)
+		}
+	}
+	if got := s.Annotations["status"]; got != "shadowed" {
+		t.Errorf("Annotations[%q] == %v, want %q", "status", got, "shadowed")
+	}
+}
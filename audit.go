@@ -0,0 +1,192 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"acln.ro/log"
+)
+
+// An AuditEvent records an action performed by an actor, for audit
+// trails.
+type AuditEvent struct {
+	Time time.Time
+
+	// Actor identifies who performed the action, as computed by the
+	// function configured using AuditActor.
+	Actor string
+
+	// Action is the method and route of the request, e.g.
+	// "DELETE /users/{id}". If no route was recorded using SetRoute, the
+	// request path is used instead.
+	Action string
+
+	// Status is the status of the response, and Outcome its class:
+	// "success" for 1xx, 2xx and 3xx responses, "denied" for 401 and 403
+	// responses, and "failure" otherwise.
+	Status  int
+	Outcome string
+
+	RequestID string
+	RemoteIP  string
+}
+
+// KV returns key-value pairs representing the event.
+func (e AuditEvent) KV() log.KV {
+	kv := log.KV{
+		"audit":   true,
+		"action":  e.Action,
+		"status":  e.Status,
+		"outcome": e.Outcome,
+	}
+	if e.Actor != "" {
+		kv["actor"] = e.Actor
+	}
+	if e.RequestID != "" {
+		kv["request_id"] = e.RequestID
+	}
+	if e.RemoteIP != "" {
+		kv["remote_ip"] = e.RemoteIP
+	}
+	return kv
+}
+
+// An AuditSink records audit events. Implementations are called after
+// the response has been written, from the goroutine serving the request,
+// and must handle their own errors, since there is no one left to report
+// them to.
+type AuditSink interface {
+	Audit(ctx context.Context, e AuditEvent)
+}
+
+// AuditSinkFunc is an adapter which allows the use of ordinary functions
+// as audit sinks.
+type AuditSinkFunc func(ctx context.Context, e AuditEvent)
+
+// Audit calls fn(ctx, e).
+func (fn AuditSinkFunc) Audit(ctx context.Context, e AuditEvent) {
+	fn(ctx, e)
+}
+
+// AuditLogger returns an AuditSink which records events as Info entries
+// using logger, with the keys of AuditEvent.KV.
+func AuditLogger(logger *log.Logger) AuditSink {
+	return AuditSinkFunc(func(_ context.Context, e AuditEvent) {
+		logger.Info(e.KV())
+	})
+}
+
+// An AuditOption configures Audit.
+type AuditOption func(*auditConfig)
+
+type auditConfig struct {
+	actor  func(req *http.Request) string
+	routes map[string]bool
+	cond   func(req *http.Request) bool
+}
+
+// AuditActor configures the function which identifies the actor of a
+// request, typically by looking up the authenticated user in the request
+// context, or in state shared with the handler, such as Memo. It is
+// called after the request has been served.
+func AuditActor(actor func(req *http.Request) string) AuditOption {
+	return func(cfg *auditConfig) {
+		cfg.actor = actor
+	}
+}
+
+// AuditRoutes configures Audit to record only requests whose route, as
+// recorded using SetRoute, is one of patterns.
+func AuditRoutes(patterns ...string) AuditOption {
+	return func(cfg *auditConfig) {
+		if cfg.routes == nil {
+			cfg.routes = make(map[string]bool)
+		}
+		for _, p := range patterns {
+			cfg.routes[p] = true
+		}
+	}
+}
+
+// AuditIf configures Audit to record only requests for which cond returns
+// true. It is called after the request has been served.
+func AuditIf(cond func(req *http.Request) bool) AuditOption {
+	return func(cfg *auditConfig) {
+		cfg.cond = cond
+	}
+}
+
+// Audit returns a handler which serves requests using next, and records an
+// AuditEvent for each of them to sink. By default, all requests using the
+// POST, PUT, PATCH and DELETE methods are recorded. AuditRoutes and AuditIf
+// select requests explicitly instead.
+//
+// Audit installs request state using WithRequestState, so that the route
+// recorded by the handler is visible.
+func Audit(sink AuditSink, next http.Handler, opts ...AuditOption) http.Handler {
+	cfg := new(auditConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithRequestState(req)
+		s := ServeInstrumented(next, w, req)
+		if !cfg.selected(req, s.Route) {
+			return
+		}
+		action := s.Route
+		if action == "" {
+			action = req.URL.Path
+		}
+		e := AuditEvent{
+			Time:      time.Now(),
+			Action:    req.Method + " " + action,
+			Status:    s.Status,
+			Outcome:   auditOutcome(s.Status),
+			RequestID: RequestID(req),
+		}
+		if cfg.actor != nil {
+			e.Actor = cfg.actor(req)
+		}
+		if ip := ClientIP(req, ClientIPPolicy{}); ip != nil {
+			e.RemoteIP = ip.String()
+		}
+		sink.Audit(req.Context(), e)
+	})
+}
+
+func (cfg *auditConfig) selected(req *http.Request, route string) bool {
+	if cfg.routes == nil && cfg.cond == nil {
+		return isMutating(req.Method)
+	}
+	if cfg.routes != nil && !cfg.routes[route] {
+		return false
+	}
+	return cfg.cond == nil || cfg.cond(req)
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status < 400:
+		return "success"
+	default:
+		return "failure"
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+type userKey struct{}
+
+func TestAudit(t *testing.T) {
+	var events []httpx.AuditEvent
+	sink := httpx.AuditSinkFunc(func(_ context.Context, e httpx.AuditEvent) {
+		events = append(events, e)
+	})
+	app := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.SetRoute(req, "/users/{id}")
+		httpx.Memo(req).Set(userKey{}, "alice")
+		if req.Header.Get("X-Deny") != "" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	actor := func(req *http.Request) string {
+		u, _ := httpx.Memo(req).Get(userKey{})
+		s, _ := u.(string)
+		return s
+	}
+	tests := []struct {
+		name    string
+		opts    []httpx.AuditOption
+		method  string
+		deny    bool
+		want    bool
+		outcome string
+	}{
+		{"default mutating", nil, "DELETE", false, true, "success"},
+		{"default safe", nil, "GET", false, false, ""},
+		{"denied", nil, "POST", true, true, "denied"},
+		{"route match", []httpx.AuditOption{httpx.AuditRoutes("/users/{id}")}, "GET", false, true, "success"},
+		{"route miss", []httpx.AuditOption{httpx.AuditRoutes("/admin")}, "DELETE", false, false, ""},
+		{"cond", []httpx.AuditOption{httpx.AuditIf(func(*http.Request) bool { return false })}, "DELETE", false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			opts := append([]httpx.AuditOption{httpx.AuditActor(actor)}, tt.opts...)
+			h := httpx.Audit(sink, app, opts...)
+			req := httptest.NewRequest(tt.method, "/users/42", nil)
+			if tt.deny {
+				req.Header.Set("X-Deny", "1")
+			}
+			h.ServeHTTP(httptest.NewRecorder(), httpx.WithRequestID(req, "r1"))
+			if !tt.want {
+				if len(events) != 0 {
+					t.Fatalf("got %d events, want none", len(events))
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			e := events[0]
+			if want := tt.method + " /users/{id}"; e.Action != want {
+				t.Errorf("Action == %q, want %q", e.Action, want)
+			}
+			if e.Actor != "alice" || e.RequestID != "r1" || e.Outcome != tt.outcome {
+				t.Errorf("got %+v, want actor alice, request r1, outcome %q", e, tt.outcome)
+			}
+		})
+	}
+}
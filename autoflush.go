@@ -0,0 +1,143 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// AutoFlush returns a handler which serves requests using next, with a
+// ResponseWriter which flushes automatically, as described by
+// NewAutoFlusher.
+func AutoFlush(next http.Handler, interval time.Duration, threshold int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w, stop := NewAutoFlusher(w, interval, threshold)
+		defer stop()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// NewAutoFlusher returns a ResponseWriter which wraps w, and flushes data
+// written since the last flush once interval elapses, or once threshold
+// bytes are pending, whichever happens first. A zero interval or
+// threshold disables the respective trigger. This suits long-running
+// responses, such as log tails and progress reports, which write
+// sporadically.
+//
+// The caller must call stop once it is done with the ResponseWriter, and
+// before the handler returns. If w does not implement http.Flusher, w is
+// returned unchanged.
+func NewAutoFlusher(w http.ResponseWriter, interval time.Duration, threshold int) (ww http.ResponseWriter, stop func()) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return w, func() {}
+	}
+	af := &autoFlusher{flusher: flusher, interval: interval, threshold: threshold}
+	ww = httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				af.mu.Lock()
+				defer af.mu.Unlock()
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				af.mu.Lock()
+				defer af.mu.Unlock()
+				n, err := next(b)
+				af.wrote(n)
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				// Copy through Write, such that the data is
+				// flushed as it arrives.
+				return io.Copy(writerFunc(func(b []byte) (int, error) {
+					return ww.Write(b)
+				}), src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				af.mu.Lock()
+				defer af.mu.Unlock()
+				af.flush()
+			}
+		},
+	})
+	return ww, af.stop
+}
+
+type autoFlusher struct {
+	flusher   http.Flusher
+	interval  time.Duration
+	threshold int
+
+	mu      sync.Mutex
+	pending int
+	timer   *time.Timer // armed while data is pending
+	stopped bool
+}
+
+// wrote accounts for n bytes written. af.mu must be held.
+func (af *autoFlusher) wrote(n int) {
+	if n == 0 || af.stopped {
+		return
+	}
+	af.pending += n
+	if af.threshold > 0 && af.pending >= af.threshold {
+		af.flush()
+		return
+	}
+	if af.interval > 0 && af.timer == nil {
+		af.timer = time.AfterFunc(af.interval, af.tick)
+	}
+}
+
+func (af *autoFlusher) tick() {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	af.timer = nil
+	if !af.stopped && af.pending > 0 {
+		af.flush()
+	}
+}
+
+// flush flushes the pending data. af.mu must be held.
+func (af *autoFlusher) flush() {
+	if af.timer != nil {
+		af.timer.Stop()
+		af.timer = nil
+	}
+	af.pending = 0
+	af.flusher.Flush()
+}
+
+func (af *autoFlusher) stop() {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	af.stopped = true
+	if af.timer != nil {
+		af.timer.Stop()
+		af.timer = nil
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+// flushCounter is a ResponseWriter which counts flushes, safely for
+// concurrent use.
+type flushCounter struct {
+	http.ResponseWriter
+	flushes int32
+}
+
+func (fc *flushCounter) Flush() {
+	atomic.AddInt32(&fc.flushes, 1)
+}
+
+func (fc *flushCounter) count() int32 {
+	return atomic.LoadInt32(&fc.flushes)
+}
+
+func TestAutoFlusherThreshold(t *testing.T) {
+	fc := &flushCounter{ResponseWriter: httptest.NewRecorder()}
+	w, stop := httpx.NewAutoFlusher(fc, 0, 10)
+	defer stop()
+	w.Write(make([]byte, 6))
+	if n := fc.count(); n != 0 {
+		t.Errorf("after 6 bytes: %d flushes, want 0", n)
+	}
+	w.Write(make([]byte, 6))
+	if n := fc.count(); n != 1 {
+		t.Errorf("after 12 bytes: %d flushes, want 1", n)
+	}
+}
+
+func TestAutoFlusherInterval(t *testing.T) {
+	fc := &flushCounter{ResponseWriter: httptest.NewRecorder()}
+	w, stop := httpx.NewAutoFlusher(fc, 10*time.Millisecond, 0)
+	w.Write([]byte("tick"))
+	deadline := time.Now().Add(5 * time.Second)
+	for fc.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := fc.count(); n != 1 {
+		t.Fatalf("%d flushes, want 1", n)
+	}
+	// Nothing is pending, so nothing is flushed.
+	time.Sleep(30 * time.Millisecond)
+	if n := fc.count(); n != 1 {
+		t.Errorf("idle: %d flushes, want 1", n)
+	}
+	w.Write([]byte("tock"))
+	stop()
+	time.Sleep(30 * time.Millisecond)
+	if n := fc.count(); n != 1 {
+		t.Errorf("after stop: %d flushes, want 1", n)
+	}
+}
+
+func TestAutoFlusherNoFlusher(t *testing.T) {
+	var w struct{ http.ResponseWriter }
+	w.ResponseWriter = httptest.NewRecorder()
+	ww, stop := httpx.NewAutoFlusher(w, time.Millisecond, 1)
+	defer stop()
+	if _, err := ww.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ww.(http.Flusher); ok {
+		t.Error("wrapped writer gained a Flush method")
+	}
+}
+
+func TestAutoFlush(t *testing.T) {
+	srv := httptest.NewServer(httpx.AutoFlush(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("progress\n"))
+		<-req.Context().Done()
+	}), time.Millisecond, 0))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b := make([]byte, 9)
+	if _, err := io.ReadFull(resp.Body, b); err != nil || string(b) != "progress\n" {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+)
+
+// SetBaggage associates the correlation field key with value in the
+// context of req, and returns the new *http.Request, with the updated
+// context. Baggage carries fields such as tenant or experiment identifiers,
+// which RequestLogger records alongside its own keys.
+func SetBaggage(req *http.Request, key, value string) *http.Request {
+	return req.WithContext(ContextWithBaggage(req.Context(), key, value))
+}
+
+// Baggage returns the correlation fields associated with req. The returned
+// map must not be modified.
+func Baggage(req *http.Request) map[string]string {
+	return BaggageFromContext(req.Context())
+}
+
+// ContextWithBaggage returns a copy of ctx in which the correlation field
+// key is associated with value.
+func ContextWithBaggage(ctx context.Context, key, value string) context.Context {
+	old := BaggageFromContext(ctx)
+	bag := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		bag[k] = v
+	}
+	bag[key] = value
+	return context.WithValue(ctx, baggageKey, bag)
+}
+
+// BaggageFromContext returns the correlation fields stored in ctx. The
+// returned map must not be modified.
+func BaggageFromContext(ctx context.Context) map[string]string {
+	bag, _ := ctx.Value(baggageKey).(map[string]string)
+	return bag
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestBaggage(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if bag := httpx.Baggage(req); len(bag) != 0 {
+		t.Fatalf("Baggage on fresh request == %v", bag)
+	}
+
+	r1 := httpx.SetBaggage(req, "tenant", "acme")
+	r2 := httpx.SetBaggage(r1, "experiment", "blue")
+
+	if bag := httpx.Baggage(r1); len(bag) != 1 || bag["tenant"] != "acme" {
+		t.Errorf("Baggage(r1) == %v", bag)
+	}
+	bag := httpx.Baggage(r2)
+	if len(bag) != 2 || bag["tenant"] != "acme" || bag["experiment"] != "blue" {
+		t.Errorf("Baggage(r2) == %v", bag)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+)
+
+// WithUser returns a shallow copy of req, with the name of the
+// authenticated user stored in its context. RequestLogger records it
+// under the "user" key.
+func WithUser(req *http.Request, user string) *http.Request {
+	return req.WithContext(ContextWithUser(req.Context(), user))
+}
+
+// User returns the name of the authenticated user stored in the context
+// of req, or the empty string.
+func User(req *http.Request) string {
+	return UserFromContext(req.Context())
+}
+
+// ContextWithUser returns a copy of ctx which stores the name of the
+// authenticated user.
+func ContextWithUser(ctx context.Context, user string) context.Context {
+	return contextWithString(ctx, userKey, user)
+}
+
+// UserFromContext returns the name of the authenticated user stored in
+// ctx, or the empty string.
+func UserFromContext(ctx context.Context) string {
+	return stringFromContext(ctx, userKey)
+}
+
+// StaticCredentials returns a credential validation function for
+// BasicAuth, which accepts the passwords in creds, keyed by user name.
+// Passwords are compared in constant time, and unknown users take as long
+// to reject as wrong passwords.
+func StaticCredentials(creds map[string]string) func(user, password string) bool {
+	hashes := make(map[string][sha256.Size]byte, len(creds))
+	for user, password := range creds {
+		hashes[user] = sha256.Sum256([]byte(password))
+	}
+	return func(user, password string) bool {
+		want, ok := hashes[user]
+		got := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && ok
+	}
+}
+
+// BasicAuth returns a handler which requires HTTP basic authentication
+// for each request, checking credentials using valid, then calls next
+// with the user name stored using WithUser. Requests without valid
+// credentials are rejected with 401 Unauthorized, and a WWW-Authenticate
+// header which names realm.
+//
+// valid must take the same time regardless of where the credentials
+// differ, as the functions returned by StaticCredentials do.
+func BasicAuth(realm string, valid func(user, password string) bool, next http.Handler) http.Handler {
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok || !valid(user, password) {
+			Annotate(req, "auth", "rejected")
+			w.Header().Set("WWW-Authenticate", challenge)
+			writeProblem(w, http.StatusUnauthorized, "")
+			return
+		}
+		next.ServeHTTP(w, WithUser(req, user))
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"acln.ro/httpx"
+	"acln.ro/log"
+)
+
+func TestBasicAuth(t *testing.T) {
+	valid := httpx.StaticCredentials(map[string]string{"alice": "secret"})
+	h := httpx.BasicAuth("admin", valid, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(httpx.User(req)))
+	}))
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		noAuth   bool
+		status   int
+	}{
+		{name: "valid", user: "alice", password: "secret", status: http.StatusOK},
+		{name: "wrong password", user: "alice", password: "guess", status: http.StatusUnauthorized},
+		{name: "unknown user", user: "bob", password: "secret", status: http.StatusUnauthorized},
+		{name: "empty password for unknown user", user: "bob", status: http.StatusUnauthorized},
+		{name: "missing", noAuth: true, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status == %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusOK {
+				if got := rec.Body.String(); got != tt.user {
+					t.Errorf("User == %q, want %q", got, tt.user)
+				}
+				return
+			}
+			want := `Basic realm="admin", charset="UTF-8"`
+			if got := rec.Header().Get("WWW-Authenticate"); got != want {
+				t.Errorf("WWW-Authenticate == %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerUser(t *testing.T) {
+	req := httpx.WithUser(httptest.NewRequest("GET", "/", nil), "alice")
+	kv := httpx.RequestLoggerOptions{Fields: []string{"user"}}.KV(req)
+	if want := (log.KV{"user": "alice"}); !reflect.DeepEqual(kv, want) {
+		t.Errorf("KV == %v, want %v", kv, want)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A BatchItem is a sub-request of a batch request.
+type BatchItem struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// A BatchResult is the response to a BatchItem. JSON response bodies are
+// embedded as they are. Other bodies are embedded as JSON strings.
+type BatchResult struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// DefaultBatchHeaders lists the headers which BatchHandler copies from
+// the batch request to each sub-request by default.
+var DefaultBatchHeaders = []string{"Authorization", "Cookie"}
+
+// BatchHandler serves batch requests: a POST request whose body is a JSON
+// array of BatchItem values is executed as a series of sub-requests against
+// Handler, in-process, and answered with a JSON array of the corresponding
+// BatchResult values, in order.
+//
+// Sub-requests share the context of the batch request, and therefore any
+// authentication state stored in it, as well as the headers listed in
+// SharedHeaders. Nested batch requests are rejected.
+type BatchHandler struct {
+	// Handler serves the sub-requests.
+	Handler http.Handler
+
+	// MaxItems limits the number of sub-requests per batch. If zero,
+	// the limit is 100.
+	MaxItems int
+
+	// Parallelism limits the number of sub-requests executing
+	// concurrently. If zero, sub-requests execute sequentially.
+	Parallelism int
+
+	// SharedHeaders lists the headers copied from the batch request to
+	// each sub-request. If nil, DefaultBatchHeaders is used.
+	SharedHeaders []string
+}
+
+func (bh *BatchHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		AllowMethods(w, req, http.MethodPost)
+		return
+	}
+	if req.Context().Value(batchKey) != nil {
+		http.Error(w, "nested batch requests are not allowed", http.StatusBadRequest)
+		return
+	}
+	var items []BatchItem
+	if err := json.NewDecoder(req.Body).Decode(&items); err != nil {
+		if IsBodyTooLarge(err) {
+			http.Error(w, "batch request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "malformed batch request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	max := bh.MaxItems
+	if max == 0 {
+		max = 100
+	}
+	if len(items) > max {
+		msg := fmt.Sprintf("too many batch items: %d, limit is %d", len(items), max)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+	for i, item := range items {
+		if !strings.HasPrefix(item.Path, "/") {
+			msg := fmt.Sprintf("batch item %d: path must begin with /", i)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	parallelism := bh.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]BatchResult, len(items))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = bh.serveItem(req, items[i])
+		}(i)
+	}
+	wg.Wait()
+
+	WriteJSON(w, http.StatusOK, results)
+}
+
+func (bh *BatchHandler) serveItem(outer *http.Request, item BatchItem) (res BatchResult) {
+	method := item.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	sub, err := http.NewRequest(method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		return BatchResult{Status: http.StatusBadRequest}
+	}
+	sub.RequestURI = item.Path
+	sub = sub.WithContext(context.WithValue(outer.Context(), batchKey, true))
+	sub.RemoteAddr = outer.RemoteAddr
+	sub.Host = outer.Host
+	sub.TLS = outer.TLS
+	for k, v := range item.Headers {
+		sub.Header.Set(k, v)
+	}
+	shared := bh.SharedHeaders
+	if shared == nil {
+		shared = DefaultBatchHeaders
+	}
+	for _, k := range shared {
+		if v, ok := outer.Header[http.CanonicalHeaderKey(k)]; ok {
+			sub.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	if len(item.Body) > 0 && sub.Header.Get("Content-Type") == "" {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+
+	rb := newResponseBuffer()
+	defer func() {
+		if val := recover(); val != nil {
+			res = BatchResult{Status: http.StatusInternalServerError}
+		}
+	}()
+	bh.Handler.ServeHTTP(rb, sub)
+
+	body := rb.body.Bytes()
+	// Like net/http, sniff the Content-Type of bodies which lack one.
+	if _, ok := rb.header["Content-Type"]; !ok && len(body) > 0 {
+		rb.header.Set("Content-Type", http.DetectContentType(body))
+	}
+	res.Status = rb.code
+	if len(rb.header) > 0 {
+		res.Headers = make(map[string]string, len(rb.header))
+		for k := range rb.header {
+			res.Headers[k] = rb.header.Get(k)
+		}
+	}
+	if len(body) == 0 {
+		return res
+	}
+	mt, _, _ := mime.ParseMediaType(rb.header.Get("Content-Type"))
+	if (mt == "application/json" || strings.HasSuffix(mt, "+json")) && json.Valid(body) {
+		res.Body = json.RawMessage(body)
+	} else {
+		res.Body, _ = json.Marshal(string(body))
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestBatchHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"auth": req.Header.Get("Authorization"),
+		})
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(w, req.Body)
+	})
+	bh := &httpx.BatchHandler{Handler: mux, Parallelism: 2}
+	mux.Handle("/batch", bh)
+
+	body := `[
+		{"method": "GET", "path": "/whoami"},
+		{"method": "POST", "path": "/echo", "body": "hello"},
+		{"method": "GET", "path": "/missing"},
+		{"method": "POST", "path": "/batch", "body": []}
+	]`
+	req := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	var results []httpx.BatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := []int{200, 200, 404, 400}
+	if len(results) != len(wantStatus) {
+		t.Fatalf("got %d results, want %d", len(results), len(wantStatus))
+	}
+	for i, want := range wantStatus {
+		if results[i].Status != want {
+			t.Errorf("item %d: got status %d, want %d", i, results[i].Status, want)
+		}
+	}
+	if !jsonEq(t, results[0].Body, []byte(`{"auth":"Bearer token"}`)) {
+		t.Errorf("item 0: got body %s", results[0].Body)
+	}
+	if !jsonEq(t, results[1].Body, []byte(`"\"hello\""`)) {
+		t.Errorf("item 1: got body %s", results[1].Body)
+	}
+}
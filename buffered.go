@@ -0,0 +1,168 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// DefaultBufferSize is the number of bytes a BufferedWriter buffers, if no
+// other limit is specified.
+const DefaultBufferSize = 1 << 20
+
+// BufferedWriter is an http.ResponseWriter which buffers the response,
+// including the status and the header, until it is committed, such that
+// middleware can discard a partially written response, and write another
+// one in its place.
+//
+// The response is committed by Commit or Flush, or once the body exceeds
+// the size limit of the BufferedWriter, after which the BufferedWriter
+// streams the rest of the response to the underlying ResponseWriter, and
+// can no longer be reset.
+type BufferedWriter struct {
+	w     http.ResponseWriter
+	limit int
+
+	orig        http.Header // the header of w when buffering started
+	header      http.Header
+	code        int
+	wroteHeader bool
+	buf         *bytes.Buffer
+	committed   bool
+}
+
+// NewBufferedWriter returns a BufferedWriter which buffers up to limit
+// bytes of the response body written to it, before writing it to w. If
+// limit is not positive, DefaultBufferSize is used. The header of the
+// BufferedWriter starts out as a copy of the header of w.
+func NewBufferedWriter(w http.ResponseWriter, limit int) *BufferedWriter {
+	if limit <= 0 {
+		limit = DefaultBufferSize
+	}
+	return &BufferedWriter{
+		w:      w,
+		limit:  limit,
+		orig:   w.Header().Clone(),
+		header: w.Header().Clone(),
+		code:   http.StatusOK,
+		buf:    getBuffer(),
+	}
+}
+
+// Header implements http.ResponseWriter.
+func (bw *BufferedWriter) Header() http.Header {
+	return bw.header
+}
+
+// WriteHeader implements http.ResponseWriter. Informational responses,
+// with 1xx status codes other than 101 Switching Protocols, are written
+// to the underlying ResponseWriter right away, along with the header.
+func (bw *BufferedWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		copyHeader(bw.w.Header(), bw.header)
+		bw.w.WriteHeader(code)
+		return
+	}
+	if bw.committed {
+		bw.w.WriteHeader(code)
+		return
+	}
+	if !bw.wroteHeader {
+		bw.code = code
+		bw.wroteHeader = true
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (bw *BufferedWriter) Write(b []byte) (int, error) {
+	if bw.committed {
+		return bw.w.Write(b)
+	}
+	bw.WriteHeader(http.StatusOK)
+	if bw.buf.Len()+len(b) > bw.limit {
+		if err := bw.Commit(); err != nil {
+			return 0, err
+		}
+		return bw.w.Write(b)
+	}
+	return bw.buf.Write(b)
+}
+
+// Flush implements http.Flusher. It commits the response, then flushes
+// the underlying ResponseWriter, if it supports flushing.
+func (bw *BufferedWriter) Flush() {
+	bw.Commit()
+	http.NewResponseController(bw.w).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (bw *BufferedWriter) Unwrap() http.ResponseWriter {
+	return bw.w
+}
+
+// Status returns the status code written so far, or 200 OK if none was.
+func (bw *BufferedWriter) Status() int {
+	return bw.code
+}
+
+// Committed reports whether the response has been committed.
+func (bw *BufferedWriter) Committed() bool {
+	return bw.committed
+}
+
+// Reset discards the buffered response, and restores the header to its
+// state when buffering started. It reports whether it succeeded, which
+// is the case unless the response has been committed.
+func (bw *BufferedWriter) Reset() bool {
+	if bw.committed {
+		return false
+	}
+	bw.header = bw.orig.Clone()
+	bw.code = http.StatusOK
+	bw.wroteHeader = false
+	bw.buf.Reset()
+	return true
+}
+
+// Commit writes the buffered response to the underlying ResponseWriter,
+// after which the BufferedWriter writes through to it. If nothing has
+// been written, Commit writes a 200 OK response with an empty body, as
+// net/http does when a handler returns. Commit is a no-op if the
+// response has been committed already.
+func (bw *BufferedWriter) Commit() error {
+	if bw.committed {
+		return nil
+	}
+	bw.committed = true
+	dst := bw.w.Header()
+	clear(dst)
+	copyHeader(dst, bw.header)
+	bw.header = dst
+	bw.w.WriteHeader(bw.code)
+	var err error
+	if bw.buf.Len() > 0 {
+		_, err = bw.w.Write(bw.buf.Bytes())
+	}
+	putBuffer(bw.buf)
+	bw.buf = nil
+	return err
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
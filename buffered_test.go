@@ -0,0 +1,138 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestBufferedWriterReset(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "r1")
+	bw := httpx.NewBufferedWriter(rec, 0)
+	bw.Header().Set("Content-Type", "text/csv")
+	bw.Header().Del("X-Request-ID")
+	bw.WriteHeader(http.StatusOK)
+	bw.Write([]byte("a,b,c\n"))
+	if rec.Body.Len() != 0 || bw.Committed() {
+		t.Fatal("response committed before Commit")
+	}
+	if !bw.Reset() {
+		t.Fatal("Reset failed before Commit")
+	}
+	bw.WriteHeader(http.StatusConflict)
+	bw.Write([]byte("conflict"))
+	if err := bw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if got := rec.Body.String(); got != "conflict" {
+		t.Errorf("body == %q, want %q", got, "conflict")
+	}
+	if got := rec.Header().Get("Content-Type"); got != "" {
+		t.Errorf("Content-Type == %q, want empty", got)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != "r1" {
+		t.Errorf("X-Request-ID == %q, want %q", got, "r1")
+	}
+	if bw.Reset() {
+		t.Error("Reset succeeded after Commit")
+	}
+}
+
+func TestBufferedWriterOverflow(t *testing.T) {
+	rec := httptest.NewRecorder()
+	bw := httpx.NewBufferedWriter(rec, 8)
+	bw.Header().Set("X-Partial", "yes")
+	bw.Write([]byte("12345"))
+	if bw.Committed() {
+		t.Fatal("committed below the limit")
+	}
+	bw.Write([]byte("67890"))
+	if !bw.Committed() {
+		t.Fatal("not committed above the limit")
+	}
+	if got := rec.Body.String(); got != "1234567890" {
+		t.Errorf("body == %q, want %q", got, "1234567890")
+	}
+	if got := rec.Header().Get("X-Partial"); got != "yes" {
+		t.Errorf("X-Partial == %q, want %q", got, "yes")
+	}
+	bw.Write([]byte("!"))
+	if got := rec.Body.String(); got != "1234567890!" {
+		t.Errorf("body == %q after commit", got)
+	}
+}
+
+func TestBufferedWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	bw := httpx.NewBufferedWriter(rec, 0)
+	bw.Write([]byte("event"))
+	bw.Flush()
+	if !rec.Flushed || rec.Body.String() != "event" {
+		t.Errorf("Flushed == %t, body == %q", rec.Flushed, rec.Body.String())
+	}
+}
+
+func TestErrorMapperBufferSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		body   string
+		status int
+	}{
+		{"buffered", 64, "partial", http.StatusConflict},
+		{"overflow", 4, "partial", http.StatusOK},
+		{"unbuffered", 0, "partial", http.StatusOK},
+	}
+	for _, tt := range tests {
+		m := &httpx.ErrorMapper{BufferSize: tt.size}
+		h := m.Handler(func(w http.ResponseWriter, req *http.Request) error {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(tt.body))
+			return httpx.ErrConflict
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status == %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		partial := strings.HasPrefix(rec.Body.String(), tt.body)
+		if want := tt.status == http.StatusOK; partial != want {
+			t.Errorf("%s: body == %q", tt.name, rec.Body.String())
+		}
+	}
+}
+
+func TestErrorMapperBufferSizeSuccess(t *testing.T) {
+	m := &httpx.ErrorMapper{BufferSize: 64}
+	h := m.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if rec.Code != http.StatusCreated || rec.Body.String() != "ok" {
+		t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusCreated, "ok")
+	}
+}
@@ -0,0 +1,555 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"acln.ro/log"
+	"github.com/felixge/httpsnoop"
+)
+
+// EventCachePurged is the kind of the events published when entries are
+// purged from a ResponseCache.
+const EventCachePurged EventKind = "cache_purged"
+
+// ResponseCache defaults.
+const (
+	DefaultCacheMaxSize      = 64 << 20
+	DefaultCacheMaxEntrySize = 1 << 20
+)
+
+// ResponseCache is an in-memory cache of responses to GET and HEAD
+// requests, keyed by the request URI and the values of selected request
+// headers. The zero value is a usable cache, which stores responses only
+// for as long as their Cache-Control header allows. Once the cache grows
+// past MaxSize, the least recently used entries are evicted.
+//
+// Responses are stored if they have status 200, 203, 301, 404 or 410,
+// and carry no Set-Cookie header. The Cache-Control directives of the
+// response are honored: no-store, no-cache and private responses are not
+// stored, and s-maxage and max-age, in this order, set their time to
+// live. stale-while-revalidate allows serving stale responses, while
+// they are refreshed in the background. Responses which vary by request
+// headers other than VaryHeaders are not stored.
+//
+// Requests which carry credentials, in the Authorization or Cookie
+// headers, are neither served from the cache, nor are their responses
+// stored, unless the header is listed in VaryHeaders, such that one
+// user is never served the response meant for another. As per RFC 9111,
+// section 3.5, responses to requests with an Authorization header are
+// stored nevertheless if they are marked public, or carry s-maxage or
+// must-revalidate.
+//
+// Range requests are served from cached 200 OK responses, as by
+// http.ServeContent, with 206 Partial Content, or 416 Range Not
+// Satisfiable, honoring If-Range. On a miss, they are served by the next
+// handler, and its response is only stored if it is complete.
+//
+// Responses to POST, PUT, PATCH and DELETE requests which are not errors
+// purge the entries for the same host and path, as per RFC 9111, section
+// 4.4.
+type ResponseCache struct {
+	// TTL is the time to live of responses which do not specify one.
+	// If zero, such responses are not stored.
+	TTL time.Duration
+
+	// MaxSize bounds the total size of the cached bodies, and
+	// MaxEntrySize the size of each. If zero, DefaultCacheMaxSize and
+	// DefaultCacheMaxEntrySize are used.
+	MaxSize      int
+	MaxEntrySize int
+
+	// VaryHeaders lists the request headers whose values select
+	// between cached responses, such as Accept or Accept-Encoding.
+	VaryHeaders []string
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	size    int
+}
+
+type cacheEntry struct {
+	key    string
+	host   string
+	path   string
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+	ttl    time.Duration
+	stale  time.Duration // stale-while-revalidate
+
+	revalidating bool
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return now.Sub(e.stored)
+}
+
+// Handler returns a handler which serves requests from the cache, or by
+// means of next, storing the responses it may. Whether a request is
+// served from the cache is annotated under the "cache" key, as "hit",
+// "stale", "miss", or "bypass" for requests which carry credentials.
+func (c *ResponseCache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			c.serveMutating(next, w, req)
+			return
+		}
+		key := c.key(req)
+		auth, cookie := c.credentials(req.Header)
+		bypass := requestNoCache(req.Header)
+		if !bypass && !auth && !cookie {
+			if e, stale, revalidate := c.lookup(key); e != nil {
+				if stale {
+					Annotate(req, "cache", "stale")
+				} else {
+					Annotate(req, "cache", "hit")
+				}
+				if revalidate {
+					go c.revalidate(next, req, e)
+				}
+				e.serve(w, req)
+				return
+			}
+		}
+		if auth || cookie {
+			Annotate(req, "cache", "bypass")
+		} else {
+			Annotate(req, "cache", "miss")
+		}
+		if req.Method == http.MethodHead || cookie {
+			next.ServeHTTP(w, req)
+			return
+		}
+		cw := &cacheWriter{w: w, max: c.maxEntrySize()}
+		next.ServeHTTP(cw.wrap(), req)
+		if auth && !sharedAuthorized(cw.header.Get("Cache-Control")) {
+			return
+		}
+		if !cw.overflow && !strings.Contains(req.Header.Get("Cache-Control"), "no-store") {
+			c.store(key, req, cw.status(), cw.header, cw.body.Bytes())
+		}
+	})
+}
+
+func (c *ResponseCache) serveMutating(next http.Handler, w http.ResponseWriter, req *http.Request) {
+	if !isMutating(req.Method) {
+		next.ServeHTTP(w, req)
+		return
+	}
+	status := http.StatusOK
+	w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				if code >= 200 && status == http.StatusOK {
+					status = code
+				}
+				next(code)
+			}
+		},
+	})
+	next.ServeHTTP(w, req)
+	if status < 400 {
+		match := func(e *cacheEntry) bool { return e.host == req.Host && e.path == req.URL.Path }
+		if n := c.purge(match); n > 0 {
+			Events.Publish(requestEvent(EventCachePurged, req, log.KV{"purged": n}))
+		}
+	}
+}
+
+// key computes the cache key of req. GET and HEAD requests share keys.
+func (c *ResponseCache) key(req *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(req.Host)
+	sb.WriteString(req.URL.RequestURI())
+	for _, name := range c.VaryHeaders {
+		sb.WriteByte(0)
+		sb.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+// credentials reports whether the Authorization and Cookie headers are
+// present in h, and not part of the cache key.
+func (c *ResponseCache) credentials(h http.Header) (auth, cookie bool) {
+	keyed := func(name string) bool {
+		for _, vh := range c.VaryHeaders {
+			if strings.EqualFold(vh, name) {
+				return true
+			}
+		}
+		return false
+	}
+	auth = h.Get("Authorization") != "" && !keyed("Authorization")
+	cookie = h.Get("Cookie") != "" && !keyed("Cookie")
+	return auth, cookie
+}
+
+// sharedAuthorized reports whether the Cache-Control header of a
+// response allows a shared cache to store it, although the request
+// carried an Authorization header.
+func sharedAuthorized(cc string) bool {
+	for _, directive := range strings.Split(cc, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "public", "s-maxage", "must-revalidate":
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the entry for key, and whether it is stale. Stale entries
+// are returned while they may be revalidated in the background, and
+// revalidate is set for the first such lookup. Other stale entries are
+// removed.
+func (c *ResponseCache) lookup(key string) (e *cacheEntry, stale, revalidate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+	e = elem.Value.(*cacheEntry)
+	age := e.age(time.Now())
+	switch {
+	case age < e.ttl:
+		c.lru.MoveToFront(elem)
+		return e, false, false
+	case age < e.ttl+e.stale:
+		revalidate = !e.revalidating
+		e.revalidating = true
+		return e, true, revalidate
+	default:
+		c.remove(elem)
+		return nil, false, false
+	}
+}
+
+// revalidate refreshes the stale entry e, by serving a copy of req using
+// next, in the background. Since there is no response to report it in,
+// a panic in next is logged using the request-scoped logger, if any, and
+// published as an EventPanicRecovered event.
+func (c *ResponseCache) revalidate(next http.Handler, req *http.Request, e *cacheEntry) {
+	sub := req.Clone(context.WithoutCancel(req.Context()))
+	sub.Method = http.MethodGet
+	rb := newResponseBuffer()
+	defer func() {
+		if v := recover(); v != nil {
+			buf := make([]byte, maxPanicStack)
+			stack := buf[:runtime.Stack(buf, false)]
+			value := fmt.Sprint(v)
+			if logger := Logger(sub); logger != nil {
+				logger.Error(log.KV{
+					"panic": value,
+					"stack": string(stack),
+					"cache": "revalidate",
+				})
+			}
+			Events.Publish(requestEvent(EventPanicRecovered, sub, log.KV{
+				"panic": value,
+				"cache": "revalidate",
+			}))
+		}
+		c.mu.Lock()
+		e.revalidating = false
+		c.mu.Unlock()
+	}()
+	next.ServeHTTP(rb, sub)
+	if rb.body.Len() <= c.maxEntrySize() {
+		c.store(e.key, sub, rb.code, rb.header, rb.body.Bytes())
+	}
+}
+
+// store stores the response, if it is cacheable.
+func (c *ResponseCache) store(key string, req *http.Request, status int, header http.Header, body []byte) {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return
+	}
+	if header.Get("Set-Cookie") != "" || !c.varies(header) {
+		return
+	}
+	ttl, stale, ok := responseTTL(header.Get("Cache-Control"))
+	if !ok {
+		return
+	}
+	if ttl < 0 {
+		ttl = c.TTL
+	}
+	if ttl <= 0 && stale <= 0 {
+		return
+	}
+	e := &cacheEntry{
+		key:    key,
+		host:   req.Host,
+		path:   req.URL.Path,
+		status: status,
+		header: header.Clone(),
+		body:   copyBytes(body),
+		stored: time.Now(),
+		ttl:    ttl,
+		stale:  stale,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.lru = list.New()
+		c.entries = make(map[string]*list.Element)
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += len(e.body)
+	for c.size > c.maxSize() && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// varies reports whether the response, sent with header, varies only by
+// the request headers listed in c.VaryHeaders.
+func (c *ResponseCache) varies(header http.Header) bool {
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			found := false
+			for _, vh := range c.VaryHeaders {
+				if strings.EqualFold(name, vh) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Purge removes the entries for path, for all hosts, and reports their
+// number.
+func (c *ResponseCache) Purge(path string) int {
+	return c.purgeEvent(func(e *cacheEntry) bool { return e.path == path }, log.KV{"path": path})
+}
+
+// PurgePrefix removes the entries for paths with the specified prefix,
+// and reports their number.
+func (c *ResponseCache) PurgePrefix(prefix string) int {
+	return c.purgeEvent(func(e *cacheEntry) bool { return strings.HasPrefix(e.path, prefix) }, log.KV{"prefix": prefix})
+}
+
+// PurgeAll removes all entries, and reports their number.
+func (c *ResponseCache) PurgeAll() int {
+	return c.purgeEvent(func(*cacheEntry) bool { return true }, log.KV{})
+}
+
+func (c *ResponseCache) purgeEvent(match func(*cacheEntry) bool, fields log.KV) int {
+	n := c.purge(match)
+	fields["purged"] = n
+	Events.Publish(Event{Kind: EventCachePurged, Time: time.Now(), Fields: fields})
+	return n
+}
+
+func (c *ResponseCache) purge(match func(*cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	n := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if match(elem.Value.(*cacheEntry)) {
+			c.remove(elem)
+			n++
+		}
+		elem = next
+	}
+	return n
+}
+
+// Len returns the number of entries in the cache.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Len()
+}
+
+func (c *ResponseCache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= len(e.body)
+}
+
+func (c *ResponseCache) maxSize() int {
+	if c.MaxSize == 0 {
+		return DefaultCacheMaxSize
+	}
+	return c.MaxSize
+}
+
+func (c *ResponseCache) maxEntrySize() int {
+	if c.MaxEntrySize == 0 {
+		return DefaultCacheMaxEntrySize
+	}
+	return c.MaxEntrySize
+}
+
+// serve writes the cached response to w, with an Age header. Range
+// requests for complete responses are served by http.ServeContent.
+func (e *cacheEntry) serve(w http.ResponseWriter, req *http.Request) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = v
+	}
+	h.Set("Age", strconv.FormatInt(int64(e.age(time.Now())/time.Second), 10))
+	if e.status == http.StatusOK && req.Header.Get("Range") != "" {
+		h.Del("Content-Length")
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(e.body))
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.status)
+	if req.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// requestNoCache reports whether the request directs caches not to serve
+// it from storage.
+func requestNoCache(h http.Header) bool {
+	cc := h.Get("Cache-Control")
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store") || h.Get("Pragma") == "no-cache"
+}
+
+// responseTTL parses the Cache-Control header of a response. It returns
+// the time to live of the response, or -1 if the header does not specify
+// one, the time for which it may be revalidated in the background, and
+// whether it may be stored at all.
+func responseTTL(cc string) (ttl, stale time.Duration, ok bool) {
+	ttl = -1
+	shared := false
+	for _, directive := range strings.Split(cc, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		seconds := func() time.Duration {
+			n, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || n < 0 {
+				return 0
+			}
+			return time.Duration(n) * time.Second
+		}
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0, 0, false
+		case "s-maxage":
+			ttl, shared = seconds(), true
+		case "max-age":
+			if !shared {
+				ttl = seconds()
+			}
+		case "stale-while-revalidate":
+			stale = seconds()
+		}
+	}
+	return ttl, stale, true
+}
+
+// cacheWriter passes a response through, and captures a copy of it, up to
+// a maximum size.
+type cacheWriter struct {
+	w        http.ResponseWriter
+	max      int
+	code     int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (cw *cacheWriter) wrap() http.ResponseWriter {
+	return httpsnoop.Wrap(cw.w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				cw.writeHeader(code)
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				cw.writeHeader(http.StatusOK)
+				cw.capture(b)
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				cw.writeHeader(http.StatusOK)
+				return next(io.TeeReader(src, writerFunc(func(b []byte) (int, error) {
+					cw.capture(b)
+					return len(b), nil
+				})))
+			}
+		},
+	})
+}
+
+func (cw *cacheWriter) writeHeader(code int) {
+	if cw.header != nil || code < 200 {
+		return
+	}
+	cw.code = code
+	cw.header = cw.w.Header().Clone()
+}
+
+func (cw *cacheWriter) capture(b []byte) {
+	if cw.overflow {
+		return
+	}
+	if cw.body.Len()+len(b) > cw.max {
+		cw.overflow = true
+		cw.body = bytes.Buffer{}
+		return
+	}
+	cw.body.Write(b)
+}
+
+// status returns the status of the response. Handlers which write nothing
+// respond with 200 OK.
+func (cw *cacheWriter) status() int {
+	if cw.header == nil {
+		cw.writeHeader(http.StatusOK)
+	}
+	return cw.code
+}
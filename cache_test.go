@@ -0,0 +1,368 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+// countingHandler responds with the number of times it was called.
+func countingHandler(calls *int32, cc string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		if cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		w.Header().Set("Vary", req.Header.Get("X-Vary"))
+		w.Write([]byte(strconv.Itoa(int(n))))
+	})
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		cache  *httpx.ResponseCache
+		cc     string
+		header map[string]string
+		cached bool
+	}{
+		{name: "max-age", cache: new(httpx.ResponseCache), cc: "max-age=60", cached: true},
+		{name: "default ttl", cache: &httpx.ResponseCache{TTL: time.Minute}, cached: true},
+		{name: "no ttl", cache: new(httpx.ResponseCache)},
+		{name: "no-store", cache: &httpx.ResponseCache{TTL: time.Minute}, cc: "no-store"},
+		{name: "private", cache: new(httpx.ResponseCache), cc: "private, max-age=60"},
+		{name: "s-maxage zero", cache: new(httpx.ResponseCache), cc: "max-age=60, s-maxage=0"},
+		{
+			name:   "request no-cache",
+			cache:  new(httpx.ResponseCache),
+			cc:     "max-age=60",
+			header: map[string]string{"Cache-Control": "no-cache"},
+		},
+		{
+			name:   "unsupported vary",
+			cache:  new(httpx.ResponseCache),
+			cc:     "max-age=60",
+			header: map[string]string{"X-Vary": "Cookie"},
+		},
+		{
+			name:   "supported vary",
+			cache:  &httpx.ResponseCache{VaryHeaders: []string{"Accept"}},
+			cc:     "max-age=60",
+			header: map[string]string{"X-Vary": "Accept"},
+			cached: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			h := tt.cache.Handler(countingHandler(&calls, tt.cc))
+			var bodies []string
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("GET", "/a", nil)
+				for k, v := range tt.header {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				bodies = append(bodies, rec.Body.String())
+			}
+			if cached := bodies[1] == "1"; cached != tt.cached {
+				t.Errorf("cached == %t, want %t (bodies %q)", cached, tt.cached, bodies)
+			}
+		})
+	}
+}
+
+func TestResponseCacheVaryHeaders(t *testing.T) {
+	var calls int32
+	c := &httpx.ResponseCache{VaryHeaders: []string{"Accept"}}
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	for _, accept := range []string{"text/html", "application/json", "text/html"} {
+		req := httptest.NewRequest("GET", "/a", nil)
+		req.Header.Set("Accept", accept)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestResponseCacheHead(t *testing.T) {
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("HEAD", "/a", nil))
+	if calls != 1 || rec.Body.Len() != 0 {
+		t.Errorf("calls == %d, body == %q", calls, rec.Body.String())
+	}
+	if rec.Header().Get("Age") == "" || rec.Header().Get("Content-Length") != "1" {
+		t.Errorf("header == %v", rec.Header())
+	}
+}
+
+func TestResponseCachePurge(t *testing.T) {
+	sub := httpx.Events.Subscribe(4, httpx.EventCachePurged)
+	defer sub.Close()
+
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	for _, path := range []string{"/a", "/a?x=1", "/b"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/a", nil))
+	if n := c.Len(); n != 1 {
+		t.Errorf("after POST: %d entries, want 1", n)
+	}
+	select {
+	case ev := <-sub.C:
+		if ev.Fields["purged"] != 2 || ev.Path != "/a" {
+			t.Errorf("got event %+v", ev)
+		}
+	default:
+		t.Error("no event published")
+	}
+	if n := c.PurgePrefix("/"); n != 1 {
+		t.Errorf("PurgePrefix purged %d entries, want 1", n)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	var calls int32
+	c := &httpx.ResponseCache{MaxSize: 2}
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	for _, path := range []string{"/a", "/b", "/c"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("%d entries, want 2", n)
+	}
+}
+
+func TestResponseCacheStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(countingHandler(&calls, "max-age=0, stale-while-revalidate=60"))
+	serve := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/a", nil))
+		return rec.Body.String()
+	}
+	// max-age=0 is not cacheable on its own, but is with
+	// stale-while-revalidate.
+	serve()
+	if got := serve(); got != "1" {
+		t.Fatalf("stale response == %q, want %q", got, "1")
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := serve(); got != "2" {
+		t.Errorf("revalidated response == %q, want %q", got, "2")
+	}
+}
+
+func TestResponseCacheCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		cache  *httpx.ResponseCache
+		cc     string
+		header string
+		value  string
+		cached bool
+	}{
+		{name: "authorization", cache: &httpx.ResponseCache{TTL: time.Minute}, header: "Authorization", value: "Bearer alice"},
+		{name: "authorization max-age", cache: new(httpx.ResponseCache), cc: "max-age=60", header: "Authorization", value: "Bearer alice"},
+		{name: "authorization public", cache: new(httpx.ResponseCache), cc: "public, max-age=60", header: "Authorization", value: "Bearer alice", cached: true},
+		{name: "authorization s-maxage", cache: new(httpx.ResponseCache), cc: "s-maxage=60", header: "Authorization", value: "Bearer alice", cached: true},
+		{name: "cookie", cache: &httpx.ResponseCache{TTL: time.Minute}, header: "Cookie", value: "session=alice"},
+		{name: "cookie public", cache: new(httpx.ResponseCache), cc: "public, max-age=60", header: "Cookie", value: "session=alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			h := tt.cache.Handler(countingHandler(&calls, tt.cc))
+			// A request with credentials, then one without.
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set(tt.header, tt.value)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/me", nil))
+			if cached := rec.Body.String() == "1"; cached != tt.cached {
+				t.Errorf("cached == %t, want %t", cached, tt.cached)
+			}
+		})
+	}
+}
+
+func TestResponseCacheCredentialedLookup(t *testing.T) {
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+	req := httptest.NewRequest("GET", "/a", nil)
+	req.Header.Set("Authorization", "Bearer bob")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Body.String(); got != "2" {
+		t.Errorf("credentialed request served %q from the cache", got)
+	}
+}
+
+func TestResponseCacheCredentialsVary(t *testing.T) {
+	var calls int32
+	c := &httpx.ResponseCache{VaryHeaders: []string{"Authorization"}}
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	serve := func(auth string) string {
+		req := httptest.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	if a, b, a2 := serve("alice"), serve("bob"), serve("alice"); a != "1" || b != "2" || a2 != "1" {
+		t.Errorf("got %q, %q, %q, want \"1\", \"2\", \"1\"", a, b, a2)
+	}
+}
+
+func TestResponseCacheRevalidatePanic(t *testing.T) {
+	sub := httpx.Events.Subscribe(1, httpx.EventPanicRecovered)
+	defer sub.Close()
+
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			panic("revalidation failed")
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		w.Write([]byte("1"))
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+	}
+	select {
+	case ev := <-sub.C:
+		if ev.Fields["panic"] != "revalidation failed" || ev.Path != "/a" {
+			t.Errorf("got event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event published")
+	}
+}
+
+func TestResponseCacheRange(t *testing.T) {
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("0123456789"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/r", nil))
+
+	tests := []struct {
+		rng, ifRange string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", "", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"bytes=-3", `"v1"`, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=2-5", `"v0"`, http.StatusOK, "0123456789", ""},
+		{"bytes=20-", "", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/r", nil)
+		req.Header.Set("Range", tt.rng)
+		if tt.ifRange != "" {
+			req.Header.Set("If-Range", tt.ifRange)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status == %d, want %d", tt.rng, tt.ifRange, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s %s: body == %q, want %q", tt.rng, tt.ifRange, rec.Body.String(), tt.body)
+		}
+		if got := rec.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s %s: Content-Range == %q, want %q", tt.rng, tt.ifRange, got, tt.contentRange)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+}
+
+func TestResponseCacheRangeMiss(t *testing.T) {
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, req, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	req := httptest.NewRequest("GET", "/r", nil)
+	req.Header.Set("Range", "bytes=0-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "01" {
+		t.Errorf("got %d %q, want 206 %q", rec.Code, rec.Body.String(), "01")
+	}
+	// The partial response is not stored, so a full request reaches
+	// the handler.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/r", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Errorf("got %d %q, want 200 with the full body", rec.Code, rec.Body.String())
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("handler called %d times, want 2", n)
+	}
+}
+
+func TestResponseCachePurgeHost(t *testing.T) {
+	var calls int32
+	c := new(httpx.ResponseCache)
+	h := c.Handler(countingHandler(&calls, "max-age=60"))
+	for _, host := range []string{"a.example", "b.example"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://"+host+"/p", nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "http://a.example/p", nil))
+	if n := c.Len(); n != 1 {
+		t.Fatalf("after PUT: %d entries, want 1", n)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://b.example/p", nil))
+	if got := rec.Body.String(); got != "2" {
+		t.Errorf("other host: body == %q, want the cached %q", got, "2")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// A CachePolicy describes how responses may be cached, and is rendered as
+// the Cache-Control, Expires and Vary response headers. CachePolicy values
+// are declared once per route, instead of setting headers in handlers:
+//
+//	mux.Handle("/static/", httpx.CachePolicy{MaxAge: 24 * time.Hour, Public: true, Immutable: true}.Handler(static))
+//	mux.Handle("/account", httpx.CachePolicy{Private: true, NoCache: true}.Handler(account))
+type CachePolicy struct {
+	// MaxAge is the time for which responses are fresh. SharedMaxAge,
+	// if not zero, overrides it for shared caches. Both are rounded
+	// down to whole seconds.
+	MaxAge       time.Duration
+	SharedMaxAge time.Duration
+
+	// Public allows shared caches to store responses which would not be
+	// stored otherwise, such as responses to authenticated requests.
+	// Private restricts storing responses to private caches.
+	Public  bool
+	Private bool
+
+	// NoCache requires caches to revalidate responses before each use,
+	// and NoStore forbids storing responses at all. MustRevalidate
+	// forbids using stale responses.
+	NoCache        bool
+	NoStore        bool
+	MustRevalidate bool
+
+	// Immutable declares that responses do not change while fresh, such
+	// that clients do not revalidate them on reload.
+	Immutable bool
+
+	// StaleWhileRevalidate and StaleIfError are the times for which
+	// stale responses may be used while revalidating them in the
+	// background, and when revalidation fails, as specified by RFC 5861.
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+
+	// Vary lists the request headers which select between
+	// representations.
+	Vary []string
+}
+
+// String returns the value of the Cache-Control header for p.
+func (p CachePolicy) String() string {
+	var directives []string
+	add := func(set bool, directive string) {
+		if set {
+			directives = append(directives, directive)
+		}
+	}
+	seconds := func(directive string, d time.Duration) {
+		if d > 0 {
+			directives = append(directives, directive+"="+strconv.FormatInt(int64(d/time.Second), 10))
+		}
+	}
+	add(p.Public, "public")
+	add(p.Private, "private")
+	add(p.NoCache, "no-cache")
+	add(p.NoStore, "no-store")
+	if !p.NoStore {
+		seconds("max-age", p.MaxAge)
+		seconds("s-maxage", p.SharedMaxAge)
+	}
+	add(p.MustRevalidate, "must-revalidate")
+	add(p.Immutable, "immutable")
+	seconds("stale-while-revalidate", p.StaleWhileRevalidate)
+	seconds("stale-if-error", p.StaleIfError)
+	return strings.Join(directives, ", ")
+}
+
+// Apply sets the Cache-Control and Expires headers in h according to p,
+// and adds the headers in p.Vary to the Vary header. Expires is set for
+// the benefit of HTTP/1.0 caches, if MaxAge is set.
+func (p CachePolicy) Apply(h http.Header) {
+	if cc := p.String(); cc != "" {
+		h.Set("Cache-Control", cc)
+	}
+	switch {
+	case p.NoStore || p.NoCache:
+		h.Set("Expires", "0")
+	case p.MaxAge > 0:
+		h.Set("Expires", time.Now().Add(p.MaxAge).UTC().Format(http.TimeFormat))
+	}
+	for _, name := range p.Vary {
+		addVary(h, name)
+	}
+}
+
+// addVary adds name to the Vary header in h, unless it is present already.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// Handler returns a handler which serves requests using next, and applies
+// p to its responses, unless next sets Cache-Control itself. Error
+// responses, with status codes of 400 and above, are left alone.
+func (p CachePolicy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		applied := false
+		apply := func(code int) {
+			if applied || code < 200 {
+				return
+			}
+			applied = true
+			if h := w.Header(); code < 400 && h.Get("Cache-Control") == "" {
+				p.Apply(h)
+			}
+		}
+		ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					apply(code)
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					apply(http.StatusOK)
+					return next(b)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					apply(http.StatusOK)
+					return next(src)
+				}
+			},
+			Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					apply(http.StatusOK)
+					next()
+				}
+			},
+		})
+		next.ServeHTTP(ww, req)
+		apply(http.StatusOK)
+	})
+}
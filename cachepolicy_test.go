@@ -0,0 +1,108 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestCachePolicyString(t *testing.T) {
+	tests := []struct {
+		policy httpx.CachePolicy
+		want   string
+	}{
+		{httpx.CachePolicy{}, ""},
+		{httpx.CachePolicy{MaxAge: time.Hour, Public: true, Immutable: true}, "public, max-age=3600, immutable"},
+		{httpx.CachePolicy{Private: true, NoCache: true}, "private, no-cache"},
+		{httpx.CachePolicy{NoStore: true, MaxAge: time.Hour}, "no-store"},
+		{
+			httpx.CachePolicy{MaxAge: 90 * time.Second, SharedMaxAge: time.Hour, StaleWhileRevalidate: time.Minute, StaleIfError: 1500 * time.Millisecond},
+			"max-age=90, s-maxage=3600, stale-while-revalidate=60, stale-if-error=1",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.String(); got != tt.want {
+			t.Errorf("%+v: String() == %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestCachePolicyHandler(t *testing.T) {
+	p := httpx.CachePolicy{MaxAge: time.Minute, Vary: []string{"Accept", "Accept-Language"}}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		cc      string
+		vary    []string
+	}{
+		{
+			name:    "applied",
+			handler: func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) },
+			cc:      "max-age=60",
+			vary:    []string{"Accept", "Accept-Language"},
+		},
+		{
+			name: "existing vary",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Vary", "accept")
+			},
+			cc:   "max-age=60",
+			vary: []string{"accept", "Accept-Language"},
+		},
+		{
+			name: "set by handler",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Cache-Control", "no-cache")
+				w.Write([]byte("ok"))
+			},
+			cc: "no-cache",
+		},
+		{
+			name: "error",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				http.NotFound(w, req)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			p.Handler(tt.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if got := rec.Header().Get("Cache-Control"); got != tt.cc {
+				t.Errorf("Cache-Control == %q, want %q", got, tt.cc)
+			}
+			got := rec.Header().Values("Vary")
+			if len(got) != len(tt.vary) {
+				t.Fatalf("Vary == %q, want %q", got, tt.vary)
+			}
+			for i := range got {
+				if got[i] != tt.vary[i] {
+					t.Errorf("Vary == %q, want %q", got, tt.vary)
+				}
+			}
+			if tt.cc == "max-age=60" {
+				exp, err := http.ParseTime(rec.Header().Get("Expires"))
+				if err != nil || exp.Before(time.Now()) {
+					t.Errorf("Expires == %q", rec.Header().Get("Expires"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrClientGone is the cancellation cause of requests whose client went
+// away before the response was complete.
+var ErrClientGone = errors.New("httpx: client disconnected")
+
+// CancelCauseHandler returns a handler which makes the context of each
+// request cancelable with a cause, then calls next. Middleware such as
+// deadlines and load shedders cancel requests using CancelRequest, and
+// downstream code retrieves the cause using context.Cause or CancelCause,
+// rather than seeing a bare context.Canceled.
+//
+// If the client goes away while next is running, the request is canceled
+// with ErrClientGone.
+func CancelCauseHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithRequestState(req)
+		st := stateOf(req)
+		// The context of the handler is detached from the cancellation
+		// of the parent, which is propagated below along with its cause:
+		// otherwise, the handler could observe the cancellation before
+		// the cause is recorded.
+		parent := req.Context()
+		base := context.WithoutCancel(parent)
+		if deadline, ok := parent.Deadline(); ok {
+			var stop context.CancelFunc
+			base, stop = context.WithDeadline(base, deadline)
+			defer stop()
+		}
+		ctx, cancel := context.WithCancelCause(base)
+		st.mu.Lock()
+		st.cancel = cancel
+		st.mu.Unlock()
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-parent.Done():
+				cause := context.Cause(parent)
+				if cause == context.Canceled {
+					cause = ErrClientGone
+				}
+				CancelRequest(req, cause)
+			case <-done:
+			}
+		}()
+		defer cancel(nil)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// CancelRequest cancels the context of req, installed by
+// CancelCauseHandler, with the specified cause. The first cause recorded
+// for a request is kept. If req carries no request state, CancelRequest
+// is a no-op.
+func CancelRequest(req *http.Request, cause error) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	if st.cancelCause == nil {
+		st.cancelCause = cause
+	}
+	cancel := st.cancel
+	st.mu.Unlock()
+	if cancel != nil {
+		cancel(cause)
+	}
+}
+
+// CancelCause returns the reason for which the context of req was
+// canceled, or nil if it was not. Causes recorded by CancelRequest take
+// precedence over the cause of the context of req itself.
+func CancelCause(req *http.Request) error {
+	if st := stateOf(req); st != nil {
+		st.mu.Lock()
+		cause := st.cancelCause
+		st.mu.Unlock()
+		if cause != nil {
+			return cause
+		}
+	}
+	if req.Context().Err() == nil {
+		return nil
+	}
+	return context.Cause(req.Context())
+}
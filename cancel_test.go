@@ -0,0 +1,82 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestCancelRequest(t *testing.T) {
+	errShed := errors.New("shed: overloaded")
+	var cause error
+	h := httpx.CancelCauseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.CancelRequest(req, errShed)
+		httpx.CancelRequest(req, errors.New("too late"))
+		<-req.Context().Done()
+		cause = context.Cause(req.Context())
+	}))
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if cause != errShed {
+		t.Errorf("context.Cause == %v, want %v", cause, errShed)
+	}
+	if s.CancelCause != errShed {
+		t.Errorf("Summary.CancelCause == %v, want %v", s.CancelCause, errShed)
+	}
+	if got := s.KV()["cancel_cause"]; got != errShed.Error() {
+		t.Errorf("KV cancel_cause == %v, want %q", got, errShed.Error())
+	}
+}
+
+func TestCancelCauseClientGone(t *testing.T) {
+	causes := make(chan error, 1)
+	srv := httptest.NewServer(httpx.CancelCauseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		causes <- httpx.CancelCause(req)
+	})))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatal("request succeeded, want client cancellation")
+	}
+	select {
+	case cause := <-causes:
+		if cause != httpx.ErrClientGone {
+			t.Errorf("CancelCause == %v, want %v", cause, httpx.ErrClientGone)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not canceled")
+	}
+}
+
+func TestCancelCauseNotCanceled(t *testing.T) {
+	h := httpx.CancelCauseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if s.CancelCause != nil {
+		t.Errorf("CancelCause == %v, want nil", s.CancelCause)
+	}
+}
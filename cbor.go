@@ -0,0 +1,443 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CBORCodec encodes and decodes values as CBOR (RFC 8949) documents.
+//
+// Values map to CBOR as they do to JSON, by way of encoding/json: struct
+// tags and json.Marshaler implementations are honored, integers are
+// encoded as CBOR integers, other numbers as double-precision floats, and
+// map keys are sorted. Since encoding/json encodes []byte as base64 text,
+// so does CBORCodec. When decoding, byte strings are accepted wherever
+// encoding/json accepts base64 text, tags are ignored except for bignums,
+// and documents which have no JSON equivalent, such as those with NaN,
+// or with map keys other than text and integers, are rejected.
+var CBORCodec Codec = cborCodec{}
+
+type cborCodec struct{}
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// cborIndefinite is the additional information of indefinite-length
+// items, and cborBreak the byte which terminates them.
+const (
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	g, err := marshalGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, g)
+}
+
+func appendCBOR(b []byte, g interface{}) ([]byte, error) {
+	switch g := g.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if g {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case string:
+		b = cborHead(b, cborText, uint64(len(g)))
+		return append(b, g...), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(g), 10, 64); err == nil {
+			if i >= 0 {
+				return cborHead(b, cborUint, uint64(i)), nil
+			}
+			return cborHead(b, cborNegInt, uint64(-1-i)), nil
+		}
+		if u, err := strconv.ParseUint(string(g), 10, 64); err == nil {
+			return cborHead(b, cborUint, u), nil
+		}
+		f, err := g.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xfb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case []interface{}:
+		b = cborHead(b, cborArray, uint64(len(g)))
+		for _, elem := range g {
+			var err error
+			if b, err = appendCBOR(b, elem); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = cborHead(b, cborMap, uint64(len(g)))
+		for _, k := range sortedKeys(g) {
+			b = cborHead(b, cborText, uint64(len(k)))
+			b = append(b, k...)
+			var err error
+			if b, err = appendCBOR(b, g[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("httpx: cannot encode %T as CBOR", g)
+	}
+}
+
+// cborHead appends the head of an item of the specified major type, with
+// argument n, using the shortest encoding.
+func cborHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	d := &cborDecoder{b: data}
+	g, err := d.value()
+	if err != nil {
+		return err
+	}
+	if len(d.b) > 0 {
+		return errCBORTrailing
+	}
+	return unmarshalGeneric(g, v)
+}
+
+var (
+	errCBORTruncated = errors.New("httpx: truncated CBOR document")
+	errCBORTrailing  = errors.New("httpx: trailing data after CBOR document")
+	errCBORMalformed = errors.New("httpx: malformed CBOR document")
+	errCBORDepth     = errors.New("httpx: CBOR document nested too deeply")
+)
+
+// cborDecoder decodes CBOR documents into generic values.
+type cborDecoder struct {
+	b     []byte
+	depth int
+}
+
+// head reads the head of an item. For indefinite-length items, n is
+// meaningless and indefinite is set.
+func (d *cborDecoder) head() (major, info byte, n uint64, indefinite bool, err error) {
+	if len(d.b) == 0 {
+		return 0, 0, 0, false, errCBORTruncated
+	}
+	major, info = d.b[0]>>5, d.b[0]&31
+	d.b = d.b[1:]
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	case info == cborIndefinite:
+		return major, info, 0, true, nil
+	default:
+		return 0, 0, 0, false, errCBORMalformed
+	}
+	if len(d.b) < size {
+		return 0, 0, 0, false, errCBORTruncated
+	}
+	for _, c := range d.b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	d.b = d.b[size:]
+	return major, info, n, false, nil
+}
+
+// length checks that n items of at least one byte each may follow.
+func (d *cborDecoder) length(n uint64) (int, error) {
+	if n > uint64(len(d.b)) {
+		return 0, errCBORTruncated
+	}
+	return int(n), nil
+}
+
+// breakNext consumes the break byte of an indefinite-length item, if it
+// is next.
+func (d *cborDecoder) breakNext() (bool, error) {
+	if len(d.b) == 0 {
+		return false, errCBORTruncated
+	}
+	if d.b[0] == cborBreak {
+		d.b = d.b[1:]
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *cborDecoder) value() (interface{}, error) {
+	if d.depth++; d.depth > maxGenericDepth {
+		return nil, errCBORDepth
+	}
+	defer func() { d.depth-- }()
+	major, info, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case cborNegInt:
+		return json.Number(negativeString(n)), nil
+	case cborBytes:
+		b, err := d.stringContent(cborBytes, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case cborText:
+		b, err := d.stringContent(cborText, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, errCBORMalformed
+		}
+		return string(b), nil
+	case cborArray:
+		return d.array(n, indefinite)
+	case cborMap:
+		return d.mapValue(n, indefinite)
+	case cborTag:
+		if indefinite {
+			return nil, errCBORMalformed
+		}
+		return d.tagged(n)
+	default:
+		return d.simple(info, n, indefinite)
+	}
+}
+
+// negativeString formats -1-n.
+func negativeString(n uint64) string {
+	if n == math.MaxUint64 {
+		return "-18446744073709551616"
+	}
+	return "-" + strconv.FormatUint(n+1, 10)
+}
+
+// stringContent reads the content of a byte or text string, whose head
+// has been read already, concatenating the chunks of indefinite-length
+// strings.
+func (d *cborDecoder) stringContent(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		if n > uint64(len(d.b)) {
+			return nil, errCBORTruncated
+		}
+		b := d.b[:n]
+		d.b = d.b[n:]
+		return b, nil
+	}
+	var out []byte
+	for {
+		if done, err := d.breakNext(); err != nil || done {
+			return out, err
+		}
+		m, _, n, indefinite, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || indefinite {
+			return nil, errCBORMalformed
+		}
+		chunk, err := d.stringContent(major, n, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+	}
+}
+
+func (d *cborDecoder) array(n uint64, indefinite bool) (interface{}, error) {
+	var arr []interface{}
+	if !indefinite {
+		count, err := d.length(n)
+		if err != nil {
+			return nil, err
+		}
+		arr = make([]interface{}, 0, count)
+	}
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite {
+			if done, err := d.breakNext(); err != nil {
+				return nil, err
+			} else if done {
+				break
+			}
+		}
+		elem, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, elem)
+	}
+	if arr == nil {
+		arr = []interface{}{}
+	}
+	return arr, nil
+}
+
+func (d *cborDecoder) mapValue(n uint64, indefinite bool) (interface{}, error) {
+	if !indefinite {
+		if _, err := d.length(n); err != nil {
+			return nil, err
+		}
+	}
+	m := make(map[string]interface{})
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite {
+			if done, err := d.breakNext(); err != nil {
+				return nil, err
+			} else if done {
+				break
+			}
+		}
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := genericKey(k)
+		if !ok {
+			return nil, fmt.Errorf("httpx: unsupported CBOR map key of type %T", k)
+		}
+		if m[key], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// genericKey converts a decoded map key to a JSON object key. Text and
+// integer keys are supported, as they are by encoding/json.
+func genericKey(k interface{}) (string, bool) {
+	switch k := k.(type) {
+	case string:
+		return k, true
+	case json.Number:
+		return string(k), true
+	}
+	return "", false
+}
+
+// tagged decodes the content of a tag. Bignums are converted to numbers,
+// and other tags are ignored.
+func (d *cborDecoder) tagged(tag uint64) (interface{}, error) {
+	if tag != 2 && tag != 3 {
+		return d.value()
+	}
+	major, _, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborBytes {
+		return nil, errCBORMalformed
+	}
+	b, err := d.stringContent(cborBytes, n, indefinite)
+	if err != nil {
+		return nil, err
+	}
+	z := new(big.Int).SetBytes(b)
+	if tag == 3 {
+		z.Neg(z).Sub(z, big.NewInt(1))
+	}
+	return json.Number(z.String()), nil
+}
+
+func (d *cborDecoder) simple(info byte, n uint64, indefinite bool) (interface{}, error) {
+	if indefinite {
+		// A break outside of an indefinite-length item.
+		return nil, errCBORMalformed
+	}
+	var f float64
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null, undefined
+		return nil, nil
+	case 25:
+		f = halfFloat(uint16(n))
+	case 26:
+		f = float64(math.Float32frombits(uint32(n)))
+	case 27:
+		f = math.Float64frombits(n)
+	default:
+		return nil, fmt.Errorf("httpx: unsupported CBOR simple value %d", n)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("httpx: cannot decode CBOR float %v", f)
+	}
+	return f, nil
+}
+
+// halfFloat converts an IEEE 754 half-precision float to a float64.
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
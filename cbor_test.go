@@ -0,0 +1,209 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestCBORMarshal(t *testing.T) {
+	// Vectors from RFC 8949, Appendix A.
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{0, "\x00"},
+		{23, "\x17"},
+		{24, "\x18\x18"},
+		{1000, "\x19\x03\xe8"},
+		{1000000, "\x1a\x00\x0f\x42\x40"},
+		{uint64(18446744073709551615), "\x1b\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{-1, "\x20"},
+		{-1000, "\x39\x03\xe7"},
+		{1.1, "\xfb\x3f\xf1\x99\x99\x99\x99\x99\x9a"},
+		{false, "\xf4"},
+		{true, "\xf5"},
+		{nil, "\xf6"},
+		{"", "\x60"},
+		{"ü", "\x62\xc3\xbc"},
+		{[]int{1, 2, 3}, "\x83\x01\x02\x03"},
+		{[]int{}, "\x80"},
+		{map[string]int{"b": 2, "a": 1}, "\xa2\x61a\x01\x61b\x02"},
+		{struct {
+			A int    `json:"a"`
+			B string `json:"b,omitempty"`
+		}{A: 1}, "\xa1\x61a\x01"},
+		{[]byte{1, 2}, "\x64AQI="},
+	}
+	for _, tt := range tests {
+		got, err := httpx.CBORCodec.Marshal(tt.v)
+		if err != nil {
+			t.Errorf("%#v: %v", tt.v, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%#v: got %x, want %x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestCBORUnmarshal(t *testing.T) {
+	// Vectors from RFC 8949, Appendix A.
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"\x00", float64(0)},
+		{"\x1b\x00\x00\x00\xe8\xd4\xa5\x10\x00", float64(1000000000000)},
+		{"\x39\x03\xe7", float64(-1000)},
+		{"\xf9\x3c\x00", float64(1)},
+		{"\xf9\xc4\x00", float64(-4)},
+		{"\xf9\x00\x01", 5.960464477539063e-8},
+		{"\xfa\x47\xc3\x50\x00", float64(100000)},
+		{"\xfb\x3f\xf1\x99\x99\x99\x99\x99\x9a", 1.1},
+		{"\xf4", false},
+		{"\xf6", nil},
+		{"\xf7", nil},
+		{"\x64IETF", "IETF"},
+		{"\x7f\x65strea\x64ming\xff", "streaming"},
+		{"\x43\x01\x02\x03", "AQID"},
+		{"\x5f\x42\x01\x02\x43\x03\x04\x05\xff", "AQIDBAU="},
+		{"\xc1\x1a\x51\x4b\x67\xb0", float64(1363896240)},
+		{"\x83\x01\x82\x02\x03\x82\x04\x05", []interface{}{float64(1), []interface{}{float64(2), float64(3)}, []interface{}{float64(4), float64(5)}}},
+		{"\x9f\x01\x9f\x02\xff\xff", []interface{}{float64(1), []interface{}{float64(2)}}},
+		{"\xa2\x01\x02\x03\x04", map[string]interface{}{"1": float64(2), "3": float64(4)}},
+		{"\xbf\x61a\x01\x61b\x9f\x02\x03\xff\xff", map[string]interface{}{"a": float64(1), "b": []interface{}{float64(2), float64(3)}}},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := httpx.CBORCodec.Unmarshal([]byte(tt.data), &got); err != nil {
+			t.Errorf("%x: %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%x: got %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestCBORUnmarshalBignum(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00", 0},
+		{"\xc2\x48\xff\xff\xff\xff\xff\xff\xff\xff", 18446744073709551615},
+	}
+	for _, tt := range tests {
+		var got uint64
+		err := httpx.CBORCodec.Unmarshal([]byte(tt.data), &got)
+		if tt.want == 0 {
+			// 2^64 overflows uint64.
+			if err == nil {
+				t.Errorf("%x: got %d, want error", tt.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%x: %v", tt.data, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%x: got %d, want %d", tt.data, got, tt.want)
+		}
+	}
+	var n int64
+	if err := httpx.CBORCodec.Unmarshal([]byte("\xc3\x41\x00"), &n); err != nil || n != -1 {
+		t.Errorf("negative bignum: got %d, %v, want -1", n, err)
+	}
+}
+
+func TestCBORUnmarshalInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"truncated head", "\x19\x03"},
+		{"truncated string", "\x64IE"},
+		{"oversized array", "\x9b\xff\xff\xff\xff\xff\xff\xff\xff\x00"},
+		{"trailing data", "\x00\x00"},
+		{"reserved info", "\x1c"},
+		{"stray break", "\xff"},
+		{"unterminated array", "\x9f\x01"},
+		{"mixed chunks", "\x5f\x61a\xff"},
+		{"invalid UTF-8", "\x61\xff"},
+		{"array key", "\xa1\x80\x00"},
+		{"NaN", "\xf9\x7e\x00"},
+		{"infinity", "\xf9\x7c\x00"},
+		{"simple value", "\xf0"},
+		{"deep nesting", strings.Repeat("\x81", 2000) + "\x00"},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		if err := httpx.CBORCodec.Unmarshal([]byte(tt.data), &v); err == nil {
+			t.Errorf("%s: got %#v, want error", tt.name, v)
+		}
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	type inner struct {
+		Data []byte `json:"data"`
+	}
+	type doc struct {
+		Name    string            `json:"name"`
+		Count   int64             `json:"count"`
+		Ratio   float64           `json:"ratio"`
+		Tags    []string          `json:"tags"`
+		Labels  map[string]string `json:"labels"`
+		Inner   *inner            `json:"inner"`
+		Created time.Time         `json:"created"`
+	}
+	in := doc{
+		Name:    "widget",
+		Count:   -1 << 40,
+		Ratio:   0.25,
+		Tags:    []string{"a", "b"},
+		Labels:  map[string]string{"env": "prod"},
+		Inner:   &inner{Data: []byte{0, 1, 2, 255}},
+		Created: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+	b, err := httpx.CBORCodec.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out doc
+	if err := httpx.CBORCodec.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+
+	// Byte strings decode into []byte fields.
+	var v inner
+	if err := httpx.CBORCodec.Unmarshal([]byte("\xa1\x64data\x42\x01\x02"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Data, []byte{1, 2}) {
+		t.Errorf("Data == %v, want [1 2]", v.Data)
+	}
+}
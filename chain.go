@@ -0,0 +1,132 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// Headers and cookies which correlate requests belonging to the same
+// user action.
+const (
+	// ParentRequestIDHeader carries the identifier of the request on
+	// whose behalf a request is made.
+	ParentRequestIDHeader = "X-Parent-Request-ID"
+
+	// AttemptHeader carries the attempt number of a retried request,
+	// starting from 1.
+	AttemptHeader = "X-Attempt"
+
+	// ParentRequestIDCookie carries the identifier of the request which
+	// issued a redirect, to the request which follows it. See Redirect.
+	ParentRequestIDCookie = "httpx_parent"
+)
+
+// ContextWithParentRequestID returns a copy of ctx which stores the
+// identifier of the parent request. If ctx stores a parent request
+// identifier already, ContextWithParentRequestID returns ctx.
+func ContextWithParentRequestID(ctx context.Context, id string) context.Context {
+	return contextWithString(ctx, parentRequestIDKey, id)
+}
+
+// ParentRequestIDFromContext returns the parent request identifier stored
+// in ctx, or the empty string.
+func ParentRequestIDFromContext(ctx context.Context) string {
+	return stringFromContext(ctx, parentRequestIDKey)
+}
+
+// ParentRequestID returns the identifier of the request on whose behalf
+// req was made, as recorded by RequestIDHandler.
+func ParentRequestID(req *http.Request) string {
+	return ParentRequestIDFromContext(req.Context())
+}
+
+// ContextWithAttempt returns a copy of ctx which stores the attempt number
+// n, starting from 1. Retrying clients use it to number their attempts,
+// which PropagatingTransport sends in the X-Attempt header.
+func ContextWithAttempt(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, attemptKey, n)
+}
+
+// AttemptFromContext returns the attempt number stored in ctx, or 0.
+func AttemptFromContext(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey).(int)
+	return n
+}
+
+// Attempt returns the attempt number of req, as recorded by
+// RequestIDHandler, or 0 if the request is not known to be a retry.
+func Attempt(req *http.Request) int {
+	return AttemptFromContext(req.Context())
+}
+
+// Redirect is like http.Redirect, but also records the identifier of req
+// in a short-lived cookie, such that RequestIDHandler can record it as
+// the parent of the request which follows the redirect, when the same
+// service handles it. If RequestIDHandler is configured with
+// RequestIDCodec, the identifier is encoded using the codec.
+func Redirect(w http.ResponseWriter, req *http.Request, url string, code int) {
+	if id := RequestID(req); id != "" {
+		if codec, ok := req.Context().Value(idCodecKey).(IDCodec); ok {
+			id = codec.Encode(id)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     ParentRequestIDCookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   60,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	http.Redirect(w, req, url, code)
+}
+
+// withChain records the parent request identifier and the attempt number
+// carried by an inbound request in its context. Values which fail valid
+// are ignored, as are the headers of untrusted requests. The cookie set
+// by Redirect is honored regardless, since it is carried by browsers. If
+// codec is not nil, the cookie is decoded using it, and ignored if it
+// fails to decode.
+func withChain(w http.ResponseWriter, req *http.Request, valid func(string) bool, trusted bool, codec IDCodec) *http.Request {
+	ctx := req.Context()
+	var parent string
+	if trusted {
+		parent = req.Header.Get(ParentRequestIDHeader)
+	}
+	if c, err := req.Cookie(ParentRequestIDCookie); err == nil {
+		if parent == "" {
+			parent = c.Value
+			if codec != nil {
+				if parent, err = codec.Decode(parent); err != nil {
+					parent = ""
+				}
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: ParentRequestIDCookie, Path: "/", MaxAge: -1})
+	}
+	if parent != "" && valid(parent) {
+		ctx = ContextWithParentRequestID(ctx, parent)
+	}
+	if n, err := strconv.Atoi(req.Header.Get(AttemptHeader)); trusted && err == nil && n > 0 {
+		ctx = ContextWithAttempt(ctx, n)
+	}
+	if codec != nil {
+		ctx = context.WithValue(ctx, idCodecKey, codec)
+	}
+	return withContext(req, ctx)
+}
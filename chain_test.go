@@ -0,0 +1,223 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestRedirectChain(t *testing.T) {
+	var parent string
+	h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/old" {
+			httpx.Redirect(w, req, "/new", http.StatusFound)
+			return
+		}
+		parent = httpx.ParentRequestID(req)
+	}))
+
+	req := httptest.NewRequest("GET", "/old", nil)
+	req.Header.Set("X-Request-ID", "first")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != httpx.ParentRequestIDCookie {
+		t.Fatalf("got cookies %v, want %s", cookies, httpx.ParentRequestIDCookie)
+	}
+
+	req = httptest.NewRequest("GET", "/new", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if parent != "first" {
+		t.Errorf("ParentRequestID == %q, want %q", parent, "first")
+	}
+	cookies = rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("parent cookie not expired: %v", cookies)
+	}
+}
+
+func TestRedirectChainCodec(t *testing.T) {
+	codec, err := httpx.EncryptedIDs([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parent string
+	h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/old" {
+			httpx.Redirect(w, req, "/new", http.StatusFound)
+			return
+		}
+		parent = httpx.ParentRequestID(req)
+	}), httpx.RequestIDCodec(codec))
+
+	req := httptest.NewRequest("GET", "/old", nil)
+	req.Header.Set("X-Request-ID", codec.Encode("first"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != httpx.ParentRequestIDCookie {
+		t.Fatalf("got cookies %v, want %s", cookies, httpx.ParentRequestIDCookie)
+	}
+	if cookies[0].Value == "first" {
+		t.Errorf("parent cookie holds the raw identifier")
+	}
+
+	req = httptest.NewRequest("GET", "/new", nil)
+	req.AddCookie(cookies[0])
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if parent != "first" {
+		t.Errorf("ParentRequestID == %q, want %q", parent, "first")
+	}
+
+	req = httptest.NewRequest("GET", "/new", nil)
+	req.AddCookie(&http.Cookie{Name: httpx.ParentRequestIDCookie, Value: "first"})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if parent != "" {
+		t.Errorf("ParentRequestID == %q for a raw cookie, want %q", parent, "")
+	}
+}
+
+func TestInboundChainHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		parent  string
+		attempt string
+
+		wantParent  string
+		wantAttempt int
+	}{
+		{name: "none"},
+		{name: "valid", parent: "p-1", attempt: "3", wantParent: "p-1", wantAttempt: 3},
+		{name: "invalid parent", parent: "p 1", attempt: "2", wantAttempt: 2},
+		{name: "invalid attempt", parent: "p-1", attempt: "-1", wantParent: "p-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parent string
+			var attempt int
+			h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				parent = httpx.ParentRequestID(req)
+				attempt = httpx.Attempt(req)
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.parent != "" {
+				req.Header.Set(httpx.ParentRequestIDHeader, tt.parent)
+			}
+			if tt.attempt != "" {
+				req.Header.Set(httpx.AttemptHeader, tt.attempt)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if parent != tt.wantParent {
+				t.Errorf("ParentRequestID == %q, want %q", parent, tt.wantParent)
+			}
+			if attempt != tt.wantAttempt {
+				t.Errorf("Attempt == %d, want %d", attempt, tt.wantAttempt)
+			}
+		})
+	}
+}
+
+func TestPropagatingTransportChain(t *testing.T) {
+	var id, parent, attempt string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id = req.Header.Get(httpx.DefaultRequestIDHeader)
+		parent = req.Header.Get(httpx.ParentRequestIDHeader)
+		attempt = req.Header.Get(httpx.AttemptHeader)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: httpx.PropagatingTransport(nil)}
+	inbound := httpx.WithRequestID(httptest.NewRequest("GET", "/", nil), "abc")
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantID     string
+		wantParent string
+	}{
+		{name: "propagated", wantID: "abc"},
+		{name: "explicit id", header: map[string]string{httpx.DefaultRequestIDHeader: "def"}, wantID: "def", wantParent: "abc"},
+		{
+			name: "explicit parent",
+			header: map[string]string{
+				httpx.DefaultRequestIDHeader: "def",
+				httpx.ParentRequestIDHeader:  "xyz",
+			},
+			wantID:     "def",
+			wantParent: "xyz",
+		},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		req = req.WithContext(httpx.ContextWithAttempt(inbound.Context(), 2))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if id != tt.wantID {
+			t.Errorf("%s: downstream %s == %q, want %q", tt.name, httpx.DefaultRequestIDHeader, id, tt.wantID)
+		}
+		if parent != tt.wantParent {
+			t.Errorf("%s: downstream %s == %q, want %q", tt.name, httpx.ParentRequestIDHeader, parent, tt.wantParent)
+		}
+		if attempt != "2" {
+			t.Errorf("%s: downstream %s == %q, want %q", tt.name, httpx.AttemptHeader, attempt, "2")
+		}
+	}
+}
+
+func TestInboundChainHeadersUntrusted(t *testing.T) {
+	tp, err := httpx.ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote      string
+		wantParent  string
+		wantAttempt int
+	}{
+		{"10.1.2.3:1234", "p-1", 3},
+		{"203.0.113.7:1234", "", 0},
+	}
+	for _, tt := range tests {
+		var parent string
+		var attempt int
+		h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			parent = httpx.ParentRequestID(req)
+			attempt = httpx.Attempt(req)
+		}), httpx.RequestIDTrustedProxies(tp))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set(httpx.ParentRequestIDHeader, "p-1")
+		req.Header.Set(httpx.AttemptHeader, "3")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if parent != tt.wantParent || attempt != tt.wantAttempt {
+			t.Errorf("%s: got parent %q, attempt %d, want %q, %d", tt.remote, parent, attempt, tt.wantParent, tt.wantAttempt)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ChainStep records the run of one middleware wrapped by Traced.
+type ChainStep struct {
+	// Name is the name passed to Traced. The final handler, reached by
+	// the innermost traced middleware, is recorded as "(handler)".
+	Name string
+
+	// Duration is the total time spent in the middleware, including the
+	// time spent in the rest of the chain.
+	Duration time.Duration
+
+	// Self is the time spent in the middleware itself, excluding the
+	// rest of the chain.
+	Self time.Duration
+
+	// CalledNext reports whether the middleware called the next handler.
+	// A middleware which returned without calling it short-circuited the
+	// chain.
+	CalledNext bool
+
+	// Wrote reports whether the middleware wrote the response header.
+	Wrote bool
+}
+
+// String returns a compact representation of the step, such as
+// "auth 1.2ms !" for a middleware which short-circuited the chain, or
+// "(handler) 10ms *" for the final handler, having written the response.
+func (cs ChainStep) String() string {
+	s := fmt.Sprintf("%s %v", cs.Name, cs.Duration)
+	if !cs.CalledNext && cs.Name != chainHandlerName {
+		s += " !"
+	}
+	if cs.Wrote {
+		s += " *"
+	}
+	return s
+}
+
+const chainHandlerName = "(handler)"
+
+// DebugChainHeader, if not empty, is the response header into which debug
+// builds write the names of the traced middleware which ran before the
+// response was written, such as "access, auth, (handler)*". The writer is
+// marked with "*". It must not be set in production.
+var DebugChainHeader = ""
+
+// ChainTrace returns the steps recorded by Traced for req, in the order in
+// which the middleware were entered. Steps are complete only once the
+// outermost traced middleware has returned. In release builds, ChainTrace
+// always returns nil.
+func ChainTrace(req *http.Request) []ChainStep {
+	st := stateOf(req)
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	ct := st.chain
+	st.mu.Unlock()
+	if ct == nil {
+		return nil
+	}
+	return ct.snapshot()
+}
+
+// chainTrace is the per-request record kept by Traced.
+type chainTrace struct {
+	mu     sync.Mutex
+	steps  []ChainStep
+	frames []*chainFrame
+	wrote  bool
+}
+
+// chainFrame tracks a traced middleware which is currently running.
+type chainFrame struct {
+	idx    int
+	inNext bool
+	next   time.Duration
+}
+
+func (ct *chainTrace) snapshot() []ChainStep {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	steps := make([]ChainStep, len(ct.steps))
+	copy(steps, ct.steps)
+	return steps
+}
+
+// header returns the value of DebugChainHeader.
+func (ct *chainTrace) header() string {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	names := make([]string, len(ct.steps))
+	for i, cs := range ct.steps {
+		names[i] = cs.Name
+		if cs.Wrote {
+			names[i] += "*"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// A ChainLog keeps the chain traces of the most recent requests.
+type ChainLog struct {
+	mu      sync.Mutex
+	size    int
+	entries []ChainLogEntry
+}
+
+// A ChainLogEntry is the chain trace of a request kept by a ChainLog.
+type ChainLogEntry struct {
+	Time      time.Time
+	Method    string
+	Path      string
+	RequestID string
+	Steps     []ChainStep
+}
+
+// NewChainLog returns a ChainLog which keeps the traces of the last size
+// requests.
+func NewChainLog(size int) *ChainLog {
+	return &ChainLog{size: size}
+}
+
+// RecentChains is the ChainLog into which debug builds record the chain
+// traces of requests served by Traced middleware.
+var RecentChains = NewChainLog(100)
+
+// Add adds e to the log, evicting the oldest entry if the log is full.
+func (cl *ChainLog) Add(e ChainLogEntry) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.size <= 0 {
+		return
+	}
+	if len(cl.entries) == cl.size {
+		copy(cl.entries, cl.entries[1:])
+		cl.entries = cl.entries[:len(cl.entries)-1]
+	}
+	cl.entries = append(cl.entries, e)
+}
+
+// Entries returns the entries in the log, most recent first.
+func (cl *ChainLog) Entries() []ChainLogEntry {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	entries := make([]ChainLogEntry, len(cl.entries))
+	for i, e := range cl.entries {
+		entries[len(entries)-1-i] = e
+	}
+	return entries
+}
+
+// ServeHTTP renders the entries in the log as plain text, one request per
+// line, most recent first. It serves the /debug/requests page.
+func (cl *ChainLog) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !DebugBuild {
+		fmt.Fprintln(w, "chain tracing is disabled: not a debug build")
+		return
+	}
+	for _, e := range cl.Entries() {
+		steps := make([]string, len(e.Steps))
+		for i, cs := range e.Steps {
+			steps[i] = cs.String()
+		}
+		fmt.Fprintf(w, "%s %s %s %s: %s\n", e.Time.Format(time.RFC3339Nano),
+			e.RequestID, e.Method, e.Path, strings.Join(steps, " > "))
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build httpxdebug
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DebugBuild reports whether the package was built with the httpxdebug
+// build tag, which enables chain tracing.
+const DebugBuild = true
+
+// Traced wraps mw such that, in debug builds, each run records a ChainStep
+// named name: how long the middleware took, whether it called the next
+// handler, and whether it wrote the response. The steps can be retrieved
+// using ChainTrace, and the outermost traced middleware adds them to
+// RecentChains once the request completes.
+//
+// Handlers which are not themselves wrapped by Traced, between traced
+// middleware or at the end of the chain, are recorded as "(handler)".
+//
+// In release builds, Traced returns mw unchanged.
+func Traced(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		_, traced := next.(tracedHandler)
+		probe := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ct, f := chainFrameOf(req)
+			var hf *chainFrame
+			start := time.Now()
+			ct.mu.Lock()
+			f.inNext = true
+			ct.steps[f.idx].CalledNext = true
+			if !traced {
+				hf = &chainFrame{idx: len(ct.steps)}
+				ct.steps = append(ct.steps, ChainStep{Name: chainHandlerName})
+				ct.frames = append(ct.frames, hf)
+			}
+			ct.mu.Unlock()
+
+			next.ServeHTTP(w, req)
+
+			d := time.Since(start)
+			ct.mu.Lock()
+			f.inNext = false
+			f.next += d
+			if hf != nil {
+				ct.frames = ct.frames[:len(ct.frames)-1]
+				ct.steps[hf.idx].Duration = d
+				ct.steps[hf.idx].Self = d
+			}
+			ct.mu.Unlock()
+		})
+		h := mw(probe)
+		return tracedHandler(func(w http.ResponseWriter, req *http.Request) {
+			req = WithRequestState(req)
+			st := stateOf(req)
+			st.mu.Lock()
+			ct := st.chain
+			outer := ct == nil
+			if outer {
+				ct = new(chainTrace)
+				st.chain = ct
+			}
+			st.mu.Unlock()
+
+			var finish func()
+			if outer {
+				w, finish = beforeWrite(w, func() {
+					ct.markWriter()
+					if DebugChainHeader != "" {
+						w.Header().Set(DebugChainHeader, ct.header())
+					}
+				})
+			}
+
+			ct.mu.Lock()
+			f := &chainFrame{idx: len(ct.steps)}
+			ct.steps = append(ct.steps, ChainStep{Name: name})
+			ct.frames = append(ct.frames, f)
+			ct.mu.Unlock()
+
+			start := time.Now()
+			h.ServeHTTP(w, withChainFrame(req, f))
+			d := time.Since(start)
+
+			ct.mu.Lock()
+			ct.frames = ct.frames[:len(ct.frames)-1]
+			ct.steps[f.idx].Duration = d
+			ct.steps[f.idx].Self = d - f.next
+			ct.mu.Unlock()
+
+			if outer {
+				finish()
+				RecentChains.Add(ChainLogEntry{
+					Time:      start,
+					Method:    req.Method,
+					Path:      Path(req),
+					RequestID: RequestID(req),
+					Steps:     ct.snapshot(),
+				})
+			}
+		})
+	}
+}
+
+// tracedHandler is the type of the handlers returned by Traced
+// middleware, which record their own steps.
+type tracedHandler func(http.ResponseWriter, *http.Request)
+
+func (h tracedHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h(w, req)
+}
+
+func withChainFrame(req *http.Request, f *chainFrame) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), chainFrameKey, f))
+}
+
+func chainFrameOf(req *http.Request) (*chainTrace, *chainFrame) {
+	f, _ := req.Context().Value(chainFrameKey).(*chainFrame)
+	st := stateOf(req)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.chain, f
+}
+
+// markWriter marks the innermost running step which is not waiting for
+// the rest of the chain as the writer of the response.
+func (ct *chainTrace) markWriter() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.wrote {
+		return
+	}
+	ct.wrote = true
+	for i := len(ct.frames) - 1; i >= 0; i-- {
+		if f := ct.frames[i]; !f.inNext {
+			ct.steps[f.idx].Wrote = true
+			return
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build !httpxdebug
+
+package httpx
+
+import "net/http"
+
+// DebugBuild reports whether the package was built with the httpxdebug
+// build tag, which enables chain tracing.
+const DebugBuild = false
+
+// Traced wraps mw such that, in debug builds, each run records a ChainStep
+// named name. See the debug build of Traced for details.
+//
+// In release builds, which is to say without the httpxdebug build tag,
+// Traced returns mw unchanged.
+func Traced(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return mw
+}
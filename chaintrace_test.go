@@ -0,0 +1,128 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func passThrough(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req)
+	})
+}
+
+func denyAll(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	})
+}
+
+func TestTraced(t *testing.T) {
+	var steps []httpx.ChainStep
+	final := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	outer := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req)
+			steps = httpx.ChainTrace(req)
+		})
+	}
+	tests := []struct {
+		name string
+		mw   func(http.Handler) http.Handler
+		want []string
+	}{
+		{"pass", passThrough, []string{"outer", "inner", "(handler) *"}},
+		{"deny", denyAll, []string{"outer", "inner ! *"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps = nil
+			h := httpx.Traced("outer", outer)(httpx.Traced("inner", tt.mw)(final))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			if !httpx.DebugBuild {
+				if steps != nil {
+					t.Fatalf("got steps %v in release build", steps)
+				}
+				return
+			}
+			// The outer step is still running when it takes the
+			// snapshot, so only the other steps are complete.
+			if len(steps) != len(tt.want) {
+				t.Fatalf("got %d steps, want %d", len(steps), len(tt.want))
+			}
+			for i, cs := range steps {
+				got := cs.Name
+				if !cs.CalledNext && cs.Name != "(handler)" {
+					got += " !"
+				}
+				if cs.Wrote {
+					got += " *"
+				}
+				if got != tt.want[i] {
+					t.Errorf("step %d == %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDebugChainHeader(t *testing.T) {
+	httpx.DebugChainHeader = "X-Debug-Chain"
+	defer func() { httpx.DebugChainHeader = "" }()
+
+	h := httpx.Traced("a", passThrough)(httpx.Traced("b", denyAll)(http.NotFoundHandler()))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	want := ""
+	if httpx.DebugBuild {
+		want = "a, b*"
+	}
+	if got := rec.Header().Get("X-Debug-Chain"); got != want {
+		t.Errorf("X-Debug-Chain == %q, want %q", got, want)
+	}
+}
+
+func TestChainLog(t *testing.T) {
+	cl := httpx.NewChainLog(2)
+	for _, p := range []string{"/a", "/b", "/c"} {
+		cl.Add(httpx.ChainLogEntry{
+			Method: "GET",
+			Path:   p,
+			Steps:  []httpx.ChainStep{{Name: "auth", Wrote: true}},
+		})
+	}
+	entries := cl.Entries()
+	if len(entries) != 2 || entries[0].Path != "/c" || entries[1].Path != "/b" {
+		t.Fatalf("got entries %v, want /c and /b", entries)
+	}
+	rec := httptest.NewRecorder()
+	cl.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/requests", nil))
+	body := rec.Body.String()
+	if httpx.DebugBuild {
+		if !strings.Contains(body, "GET /c: auth 0s ! *") {
+			t.Errorf("body %q does not describe /c", body)
+		}
+	} else if !strings.Contains(body, "not a debug build") {
+		t.Errorf("body %q does not report a release build", body)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPPolicy configures how ClientIP resolves the address of the
+// client which originated a request.
+type ClientIPPolicy struct {
+	// Trusted lists the proxies whose forwarding headers are believed.
+	// If empty, forwarding headers are ignored, and ClientIP returns
+	// the address in req.RemoteAddr.
+	Trusted TrustedProxies
+
+	// Header names the forwarding header set by the trusted proxies:
+	// "X-Forwarded-For", "Forwarded" or "X-Real-IP". If empty,
+	// "X-Forwarded-For" is used. Only one header is consulted, since
+	// clients may forge the headers which the proxies do not set.
+	Header string
+}
+
+// ClientIP returns the address of the client which originated req. If
+// an address was stored using WithClientIP, ClientIP returns it.
+// Otherwise, it resolves the address according to policy.
+//
+// Starting from req.RemoteAddr, ClientIP walks the chain of addresses
+// recorded in the forwarding header from right to left, for as long as
+// the address at hand belongs to a trusted proxy. ClientIP returns nil
+// only if req.RemoteAddr is not a valid address.
+func ClientIP(req *http.Request, policy ClientIPPolicy) net.IP {
+	if ip := storedClientIP(req.Context()); ip != nil {
+		return ip
+	}
+	return policy.resolve(req)
+}
+
+// WithClientIP returns a shallow copy of req, with the client address
+// resolved according to policy stored in its context. RequestLogger
+// records the stored address under the "remote_addr" key.
+func WithClientIP(req *http.Request, policy ClientIPPolicy) *http.Request {
+	ip := policy.resolve(req)
+	if ip == nil {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), clientIPKey, ip))
+}
+
+// ClientIPHandler returns a handler which stores the client address of
+// each request using WithClientIP, then calls next.
+func ClientIPHandler(next http.Handler, policy ClientIPPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, WithClientIP(req, policy))
+	})
+}
+
+func storedClientIP(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(clientIPKey).(net.IP)
+	return ip
+}
+
+func (p ClientIPPolicy) resolve(req *http.Request) net.IP {
+	ip := remoteIP(req.RemoteAddr)
+	if ip == nil || !p.Trusted.Contains(ip) {
+		return ip
+	}
+	var hops []string
+	switch http.CanonicalHeaderKey(p.Header) {
+	case "", "X-Forwarded-For":
+		hops = splitHops(req.Header.Values("X-Forwarded-For"))
+	case "Forwarded":
+		hops = forwardedFor(req.Header.Values("Forwarded"))
+	case "X-Real-Ip":
+		hops = splitHops(req.Header.Values("X-Real-IP"))
+		if len(hops) > 1 {
+			hops = hops[len(hops)-1:]
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hopIP(hops[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !p.Trusted.Contains(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// hopIP parses a hop recorded by a forwarding header. Hops are plain
+// addresses, optionally followed by a port, with IPv6 addresses optionally
+// enclosed in brackets.
+func hopIP(hop string) net.IP {
+	if ip := remoteIP(hop); ip != nil {
+		return ip
+	}
+	if strings.HasPrefix(hop, "[") && strings.HasSuffix(hop, "]") {
+		return net.ParseIP(hop[1 : len(hop)-1])
+	}
+	return nil
+}
+
+// splitHops splits the comma-separated lists in vals.
+func splitHops(vals []string) []string {
+	var hops []string
+	for _, v := range vals {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the "for" parameters of the elements of the
+// Forwarded headers in vals, as described by RFC 7239. Elements without
+// a "for" parameter yield an empty hop.
+func forwardedFor(vals []string) []string {
+	var hops []string
+	for _, elem := range splitHops(vals) {
+		hop := ""
+		for _, pair := range strings.Split(elem, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(k, "for") {
+				hop = strings.Trim(v, `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
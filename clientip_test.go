@@ -0,0 +1,119 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := httpx.ParseTrustedProxies("10.0.0.0/8", "2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		header string
+		remote string
+		values map[string]string
+		want   string
+	}{
+		{
+			name:   "untrusted peer",
+			remote: "192.0.2.1:1234",
+			values: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want:   "192.0.2.1",
+		},
+		{
+			name:   "no header",
+			remote: "10.0.0.1:1234",
+			want:   "10.0.0.1",
+		},
+		{
+			name:   "x-forwarded-for",
+			remote: "10.0.0.1:1234",
+			values: map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7, 10.0.0.2"},
+			want:   "198.51.100.7",
+		},
+		{
+			name:   "all trusted",
+			remote: "10.0.0.1:1234",
+			values: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:   "10.0.0.3",
+		},
+		{
+			name:   "garbage hop",
+			remote: "10.0.0.1:1234",
+			values: map[string]string{"X-Forwarded-For": "198.51.100.7, bogus, 10.0.0.2"},
+			want:   "10.0.0.2",
+		},
+		{
+			name:   "forwarded",
+			header: "Forwarded",
+			remote: "10.0.0.1:1234",
+			values: map[string]string{"Forwarded": `for=198.51.100.7;proto=https, for="[2001:db8::1]:443"`},
+			want:   "198.51.100.7",
+		},
+		{
+			name:   "forwarded ignores x-forwarded-for",
+			header: "Forwarded",
+			remote: "10.0.0.1:1234",
+			values: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want:   "10.0.0.1",
+		},
+		{
+			name:   "x-real-ip",
+			header: "X-Real-IP",
+			remote: "10.0.0.1:1234",
+			values: map[string]string{"X-Real-IP": "198.51.100.7"},
+			want:   "198.51.100.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.values {
+				req.Header.Set(k, v)
+			}
+			policy := httpx.ClientIPPolicy{Trusted: trusted, Header: tt.header}
+			if ip := httpx.ClientIP(req, policy); ip.String() != tt.want {
+				t.Errorf("ClientIP == %v, want %s", ip, tt.want)
+			}
+			req = httpx.WithClientIP(req, policy)
+			if ip := httpx.ClientIP(req, httpx.ClientIPPolicy{}); ip.String() != tt.want {
+				t.Errorf("stored ClientIP == %v, want %s", ip, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPLogged(t *testing.T) {
+	trusted, err := httpx.ParseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	req = httpx.WithClientIP(req, httpx.ClientIPPolicy{Trusted: trusted})
+	kv := httpx.RequestLoggerOptions{Fields: []string{"remote_addr"}}.KV(req)
+	if got := kv["remote_addr"]; got != "198.51.100.7" {
+		t.Errorf("remote_addr == %v, want %q", got, "198.51.100.7")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// CoalesceHeaders lists the request headers which, in addition to the
+// method, host and request URI, distinguish requests coalesced by
+// Coalesce, unless a key function is specified. Requests on behalf of
+// different users are never coalesced.
+var CoalesceHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Authorization",
+	"Cookie",
+}
+
+// Coalesce returns a handler which collapses concurrent GET requests with
+// the same key into a single call to next, whose response is sent to all
+// of them. This protects expensive handlers from thundering herds, such
+// as the one which follows the expiry of a popular cache entry.
+//
+// If key is nil, requests are keyed by their method, host, request URI
+// and the values of CoalesceHeaders. Requests for which key returns the
+// empty string are not coalesced.
+//
+// The responses of coalesced requests are buffered in their entirety.
+// The call to next is not canceled when the request which initiated it
+// is: it is canceled only when all the requests which wait for it are.
+// Requests which were served the response of another are annotated with
+// "coalesced" set to true.
+func Coalesce(next http.Handler, key func(req *http.Request) string) http.Handler {
+	if key == nil {
+		key = coalesceKey
+	}
+	g := &coalesceGroup{calls: make(map[string]*coalesceCall)}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			next.ServeHTTP(w, req)
+			return
+		}
+		k := key(req)
+		if k == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		c, leader := g.join(req.Context(), k)
+		if leader {
+			go g.run(k, c, next, req)
+		} else {
+			Annotate(req, "coalesced", true)
+		}
+		select {
+		case <-c.done:
+		case <-req.Context().Done():
+			g.leave(k, c)
+			return
+		}
+		if c.panic != nil {
+			if leader {
+				SetError(req, c.panic)
+			}
+			writeProblem(w, http.StatusInternalServerError, "")
+			return
+		}
+		dst := w.Header()
+		for k, v := range c.rb.header {
+			dst[k] = append([]string(nil), v...)
+		}
+		w.WriteHeader(c.rb.code)
+		w.Write(c.rb.body.Bytes())
+	})
+}
+
+func coalesceKey(req *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(req.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(req.Host)
+	sb.WriteString(req.URL.RequestURI())
+	for _, name := range CoalesceHeaders {
+		sb.WriteByte(0)
+		sb.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+type coalesceGroup struct {
+	mu    sync.Mutex
+	calls map[string]*coalesceCall
+}
+
+// A coalesceCall is a call to the next handler, shared by the requests
+// which wait for it.
+type coalesceCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int // guarded by coalesceGroup.mu
+	done    chan struct{}
+
+	// rb and panic are set before done is closed.
+	rb    *responseBuffer
+	panic *PanicError
+}
+
+// join joins the call for k, creating it if necessary, in which case
+// leader is set.
+func (g *coalesceGroup) join(ctx context.Context, k string) (c *coalesceCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[k]; ok {
+		c.waiters++
+		return c, false
+	}
+	c = &coalesceCall{waiters: 1, done: make(chan struct{})}
+	c.ctx, c.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	g.calls[k] = c
+	return c, true
+}
+
+// leave removes a waiter from the call c for k, and cancels the call if
+// it was the last, such that later requests start afresh.
+func (g *coalesceGroup) leave(k string, c *coalesceCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c.waiters--
+	if c.waiters == 0 {
+		c.cancel()
+		if g.calls[k] == c {
+			delete(g.calls, k)
+		}
+	}
+}
+
+func (g *coalesceGroup) run(k string, c *coalesceCall, next http.Handler, req *http.Request) {
+	defer func() {
+		if val := recover(); val != nil {
+			// The call runs on its own goroutine, out of reach of
+			// Recover, and the panic is reported to the request
+			// which initiated the call instead.
+			c.panic = &PanicError{Value: val, Stack: debug.Stack()}
+		}
+		g.mu.Lock()
+		if g.calls[k] == c {
+			delete(g.calls, k)
+		}
+		g.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+	c.rb = newResponseBuffer()
+	next.ServeHTTP(c.rb, req.WithContext(c.ctx))
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := httpx.Coalesce(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("X-Answer", "42")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("done"))
+	}), nil)
+
+	const n = 5
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+		}(recs[i])
+	}
+	// Let the requests join the call, then finish it.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusAccepted || rec.Body.String() != "done" || rec.Header().Get("X-Answer") != "42" {
+			t.Errorf("response %d: %d %q %v", i, rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+}
+
+func TestCoalesceDistinctUsers(t *testing.T) {
+	var calls int32
+	var started sync.WaitGroup
+	started.Add(2)
+	release := make(chan struct{})
+	h := httpx.Coalesce(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		started.Done()
+		<-release
+	}), nil)
+	var wg sync.WaitGroup
+	for _, auth := range []string{"Bearer a", "Bearer b"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", auth)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	started.Wait()
+	close(release)
+	wg.Wait()
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestCoalescePanic(t *testing.T) {
+	h := httpx.Coalesce(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}), nil)
+	req := httpx.WithRequestState(httptest.NewRequest("GET", "/", nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	var pe *httpx.PanicError
+	if err := httpx.RequestError(req); !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Errorf("Err == %v, want the panic", err)
+	}
+}
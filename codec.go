@@ -0,0 +1,378 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Codec marshals and unmarshals values in a media type. Codecs from
+// third-party packages, whose Marshal and Unmarshal functions are
+// typically shaped like those of encoding/json, can be adapted using
+// CodecFuncs.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// CodecFuncs adapts a pair of functions to the Codec interface:
+//
+//	httpx.RegisterCodec("application/cbor", httpx.CodecFuncs{
+//		MarshalFunc:   cbor.Marshal,
+//		UnmarshalFunc: cbor.Unmarshal,
+//	})
+type CodecFuncs struct {
+	MarshalFunc   func(v interface{}) ([]byte, error)
+	UnmarshalFunc func(data []byte, v interface{}) error
+}
+
+// Marshal calls cf.MarshalFunc.
+func (cf CodecFuncs) Marshal(v interface{}) ([]byte, error) {
+	return cf.MarshalFunc(v)
+}
+
+// Unmarshal calls cf.UnmarshalFunc.
+func (cf CodecFuncs) Unmarshal(data []byte, v interface{}) error {
+	return cf.UnmarshalFunc(data, v)
+}
+
+// JSONCodec encodes values as WriteJSON does, and decodes them using
+// package encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+// XMLCodec encodes values as WriteXML does, and decodes them as
+// DecodeXML does.
+var XMLCodec Codec = xmlCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if IndentJSON {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(xml.Header), b...), '\n'), nil
+}
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	return decodeXML(data, "", v)
+}
+
+func (xmlCodec) unmarshalCharset(data []byte, charset string, v interface{}) error {
+	return decodeXML(data, charset, v)
+}
+
+// charsetUnmarshaler is implemented by the codecs in this package for
+// which the charset parameter of the request is meaningful. It decodes
+// data in the character encoding named by charset, if not empty.
+type charsetUnmarshaler interface {
+	unmarshalCharset(data []byte, charset string, v interface{}) error
+}
+
+// maxGenericDepth bounds the nesting of the documents decoded by the
+// binary codecs, which are decoded recursively.
+const maxGenericDepth = 1000
+
+// marshalGeneric converts v to the generic form produced by decoding its
+// JSON encoding into an interface{} value, with numbers as json.Number.
+// The binary codecs encode the generic form, such that values map to
+// their documents as they do to JSON, honoring struct tags and
+// json.Marshaler implementations.
+func marshalGeneric(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var g interface{}
+	if err := dec.Decode(&g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// unmarshalGeneric stores the generic value g, as decoded by a binary
+// codec, in v, by way of its JSON encoding.
+func unmarshalGeneric(g, v interface{}) error {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// sortedKeys returns the keys of m in sorted order, such that the binary
+// codecs produce deterministic output.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DefaultMaxBodyBytes is the limit on the size of request bodies decoded
+// by Decode, if no other limit is specified.
+const DefaultMaxBodyBytes = 1 << 20
+
+// Codecs is a registry of codecs, keyed by media type, used to negotiate
+// the encoding of responses, and to decode requests. The zero value is
+// an empty registry, ready to use. Codecs is safe for concurrent use.
+type Codecs struct {
+	mu     sync.RWMutex
+	types  []string // in order of registration
+	full   map[string]string
+	codecs map[string]Codec
+}
+
+// DefaultCodecs is the registry used by Respond, Decode and
+// RegisterCodec. It contains, in this order, JSONCodec under
+// "application/json", XMLCodec under "application/xml; charset=utf-8",
+// CBORCodec under "application/cbor" and MessagePackCodec under
+// "application/msgpack".
+var DefaultCodecs = NewCodecs()
+
+// NewCodecs returns a registry containing the codecs in DefaultCodecs.
+func NewCodecs() *Codecs {
+	cs := new(Codecs)
+	cs.Register("application/json", JSONCodec)
+	cs.Register("application/xml; charset=utf-8", XMLCodec)
+	cs.Register("application/cbor", CBORCodec)
+	cs.Register("application/msgpack", MessagePackCodec)
+	return cs
+}
+
+// Register registers c for mediaType, replacing any codec registered for
+// it already. mediaType is the Content-Type of responses encoded by c,
+// and may include parameters, which are ignored when negotiating. When
+// clients express no preference, responses are encoded using the codec
+// registered first. Register panics if mediaType is malformed.
+func (cs *Codecs) Register(mediaType string, c Codec) {
+	mt, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		panic("httpx: invalid media type " + strconv.Quote(mediaType))
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.codecs == nil {
+		cs.full = make(map[string]string)
+		cs.codecs = make(map[string]Codec)
+	}
+	if _, ok := cs.codecs[mt]; !ok {
+		cs.types = append(cs.types, mt)
+	}
+	cs.full[mt] = mediaType
+	cs.codecs[mt] = c
+}
+
+// RegisterCodec registers c for mediaType in DefaultCodecs.
+func RegisterCodec(mediaType string, c Codec) {
+	DefaultCodecs.Register(mediaType, c)
+}
+
+// lookup returns the codec for mt. Failing an exact match, a media type
+// with a structured syntax suffix, such as "application/problem+json",
+// is served by the codec for the suffix, as per RFC 6839.
+func (cs *Codecs) lookup(mt string) (Codec, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if c, ok := cs.codecs[mt]; ok {
+		return c, true
+	}
+	if i := strings.LastIndexByte(mt, '+'); i >= 0 {
+		c, ok := cs.codecs["application/"+mt[i+1:]]
+		return c, ok
+	}
+	return nil, false
+}
+
+// Respond writes the encoding of v as the response to req, with the
+// specified status, using the codec whose media type is preferred by the
+// Accept header of req. The Content-Type and Content-Length headers are
+// set, and Accept is added to the Vary header.
+//
+// If the client accepts none of the registered media types, Respond
+// responds with 406 Not Acceptable and a problem details body, and
+// returns an error which wraps ErrNotAcceptable. Like WriteJSON, Respond
+// encodes v in its entirety before anything is written, and responds
+// with 500 Internal Server Error if encoding fails.
+func (cs *Codecs) Respond(w http.ResponseWriter, req *http.Request, status int, v interface{}) error {
+	addVary(w.Header(), "Accept")
+	cs.mu.RLock()
+	mt := negotiateMediaType(req.Header.Get("Accept"), cs.types)
+	c, full := cs.codecs[mt], cs.full[mt]
+	cs.mu.RUnlock()
+	if c == nil {
+		writeProblem(w, http.StatusNotAcceptable, "")
+		return ErrNotAcceptable
+	}
+	b, err := c.Marshal(v)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "")
+		return err
+	}
+	h := w.Header()
+	h.Set("Content-Type", full)
+	h.Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+// Respond calls DefaultCodecs.Respond.
+func Respond(w http.ResponseWriter, req *http.Request, status int, v interface{}) error {
+	return DefaultCodecs.Respond(w, req, status, v)
+}
+
+// Decode decodes the request body of req into v, using the codec
+// registered for its Content-Type. Bodies larger than limit bytes are
+// rejected with an *http.MaxBytesError, for which IsBodyTooLarge reports
+// true. If limit is not positive, DefaultMaxBodyBytes is used.
+//
+// Requests with media types for which no codec is registered are
+// rejected with an error which wraps ErrUnsupportedMediaType. Errors
+// returned by the codec are wrapped along with ErrBadRequest, such that
+// ErrorMapper maps either to the appropriate client error response.
+func (cs *Codecs) Decode(req *http.Request, v interface{}, limit int64) error {
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	mt, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%w %q", ErrUnsupportedMediaType, req.Header.Get("Content-Type"))
+	}
+	c, ok := cs.lookup(mt)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnsupportedMediaType, mt)
+	}
+	b, err := readBody(req, limit)
+	if err != nil {
+		return err
+	}
+	if cu, ok := c.(charsetUnmarshaler); ok {
+		return cu.unmarshalCharset(b, params["charset"], v)
+	}
+	if err := c.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+	return nil
+}
+
+// Decode calls DefaultCodecs.Decode.
+func Decode(req *http.Request, v interface{}, limit int64) error {
+	return DefaultCodecs.Decode(req, v, limit)
+}
+
+// readBody reads the body of req, up to limit bytes.
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	if req.ContentLength > limit {
+		return nil, &http.MaxBytesError{Limit: limit}
+	}
+	if req.Body == nil {
+		return nil, fmt.Errorf("%w: empty request body", ErrBadRequest)
+	}
+	return io.ReadAll(http.MaxBytesReader(nil, req.Body, limit))
+}
+
+// negotiateMediaType returns the media type from types with the highest
+// q-value in the Accept header, or the empty string if the client accepts
+// none. Each type is weighed by the most specific media range matching
+// it, and ties are broken by the order of types. If accept is empty, the
+// first type is returned.
+func negotiateMediaType(accept string, types []string) string {
+	if strings.TrimSpace(accept) == "" {
+		if len(types) == 0 {
+			return ""
+		}
+		return types[0]
+	}
+	type mediaRange struct {
+		typ, sub string
+		q        float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		typ, sub, ok := strings.Cut(strings.ToLower(strings.TrimSpace(name)), "/")
+		if !ok {
+			continue
+		}
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					weight = f
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{typ, sub, weight})
+	}
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, t := range types {
+		typ, sub, _ := strings.Cut(t, "/")
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.typ == typ && r.sub == sub:
+				s = 2
+			case r.typ == typ && r.sub == "*":
+				s = 1
+			case r.typ == "*" && r.sub == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = t, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+// upperCodec is a toy codec for a custom media type.
+var upperCodec = httpx.CodecFuncs{
+	MarshalFunc: func(v interface{}) ([]byte, error) {
+		return []byte(strings.ToUpper(v.(string))), nil
+	},
+	UnmarshalFunc: func(data []byte, v interface{}) error {
+		*v.(*string) = strings.ToLower(string(data))
+		return nil
+	},
+}
+
+func TestRespond(t *testing.T) {
+	cs := httpx.NewCodecs()
+	cs.Register("text/x-upper", upperCodec)
+	tests := []struct {
+		accept string
+		status int
+		ctype  string
+		body   string
+	}{
+		{"", http.StatusOK, "application/json", "\"hi\"\n"},
+		{"*/*", http.StatusOK, "application/json", "\"hi\"\n"},
+		{"application/xml", http.StatusOK, "application/xml; charset=utf-8", ""},
+		{"text/x-upper", http.StatusOK, "text/x-upper", "HI"},
+		{"application/cbor", http.StatusOK, "application/cbor", "\x62hi"},
+		{"application/msgpack", http.StatusOK, "application/msgpack", "\xa2hi"},
+		{"text/*;q=0.9, application/json;q=0.5", http.StatusOK, "text/x-upper", "HI"},
+		{"application/*;q=0.2, application/xml;q=0, */*;q=0.1", http.StatusOK, "application/json", "\"hi\"\n"},
+		{"image/png", http.StatusNotAcceptable, "application/problem+json", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+		err := cs.Respond(rec, req, http.StatusOK, "hi")
+		if rec.Code != tt.status {
+			t.Errorf("Accept %q: status == %d, want %d", tt.accept, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusNotAcceptable && !errors.Is(err, httpx.ErrNotAcceptable) {
+			t.Errorf("Accept %q: got error %v, want ErrNotAcceptable", tt.accept, err)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("Accept %q: Content-Type == %q, want %q", tt.accept, got, tt.ctype)
+		}
+		if got := rec.Body.String(); tt.body != "" && got != tt.body {
+			t.Errorf("Accept %q: body == %q, want %q", tt.accept, got, tt.body)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("Accept %q: Vary == %q, want %q", tt.accept, got, "Accept")
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cs := httpx.NewCodecs()
+	cs.Register("text/x-upper", upperCodec)
+	tests := []struct {
+		ctype string
+		body  string
+		want  string
+		err   error
+	}{
+		{ctype: "application/json", body: `"hi"`, want: "hi"},
+		{ctype: "application/merge-patch+json", body: `"hi"`, want: "hi"},
+		{ctype: "application/xml; charset=iso-8859-1", body: "<s>caf\xe9</s>", want: "café"},
+		{ctype: "text/x-upper", body: "HI", want: "hi"},
+		{ctype: "application/json", body: `{`, err: httpx.ErrBadRequest},
+		{ctype: "application/cbor", body: "\x62hi", want: "hi"},
+		{ctype: "application/example+cbor", body: "\x62hi", want: "hi"},
+		{ctype: "application/msgpack", body: "\xa2hi", want: "hi"},
+		{ctype: "application/cbor", body: "\x63hi", err: httpx.ErrBadRequest},
+		{ctype: "application/x-unknown", body: "hi", err: httpx.ErrUnsupportedMediaType},
+		{ctype: "", body: "hi", err: httpx.ErrUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.ctype)
+		var got string
+		err := cs.Decode(req, &got, 0)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: got error %v, want %v", tt.ctype, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.ctype, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeJSONSyntaxError(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"a":}`))
+	req.Header.Set("Content-Type", "application/json")
+	var v map[string]int
+	err := httpx.Decode(req, &v, 0)
+	var se *json.SyntaxError
+	if !errors.As(err, &se) {
+		t.Errorf("got error %v, want *json.SyntaxError", err)
+	}
+}
+
+func TestCodecFuncsRegistration(t *testing.T) {
+	// A stub CBOR codec, as adapted from a third-party package, which
+	// only handles text strings shorter than 24 bytes.
+	stub := httpx.CodecFuncs{
+		MarshalFunc: func(v interface{}) ([]byte, error) {
+			s := v.(string)
+			return append([]byte{0x60 | byte(len(s))}, s...), nil
+		},
+		UnmarshalFunc: func(data []byte, v interface{}) error {
+			if len(data) == 0 || int(data[0]^0x60) != len(data)-1 {
+				return errors.New("bad stub CBOR")
+			}
+			*v.(*string) = string(data[1:])
+			return nil
+		},
+	}
+	cs := new(httpx.Codecs)
+	cs.Register("application/json", httpx.JSONCodec)
+	cs.Register("application/cbor", stub)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/cbor, application/json;q=0.5")
+	rec := httptest.NewRecorder()
+	if err := cs.Respond(rec, req, http.StatusOK, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.Header().Get("Content-Type"), "application/cbor"; got != want {
+		t.Errorf("Content-Type == %q, want %q", got, want)
+	}
+	if got, want := rec.Body.String(), "\x62hi"; got != want {
+		t.Errorf("body == %q, want %q", got, want)
+	}
+
+	for _, ctype := range []string{"application/cbor", "application/example+cbor"} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("\x62hi"))
+		req.Header.Set("Content-Type", ctype)
+		var got string
+		if err := cs.Decode(req, &got, 0); err != nil {
+			t.Errorf("%q: %v", ctype, err)
+			continue
+		}
+		if got != "hi" {
+			t.Errorf("%q: got %q, want %q", ctype, got, "hi")
+		}
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("\x63hi"))
+	req.Header.Set("Content-Type", "application/cbor")
+	var got string
+	if err := cs.Decode(req, &got, 0); !errors.Is(err, httpx.ErrBadRequest) {
+		t.Errorf("got error %v, want ErrBadRequest", err)
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// A Compressor is a reusable streaming encoder, such as *gzip.Writer.
+// Encoders from third-party packages, such as brotli and zstd, typically
+// implement Compressor as well.
+type Compressor interface {
+	io.Writer
+
+	// Close flushes any pending data, and writes the trailer of the
+	// stream, without closing the underlying writer.
+	Close() error
+
+	// Flush flushes pending data to the underlying writer.
+	Flush() error
+
+	// Reset discards the state of the Compressor, and makes it write to
+	// w, so that it can be reused.
+	Reset(w io.Writer)
+}
+
+// An Encoding is a content coding, as negotiated by the Accept-Encoding
+// and Content-Encoding headers, along with a pool of its compressors.
+type Encoding struct {
+	name string
+	pool sync.Pool
+}
+
+// NewEncoding returns the Encoding called name, such as "br" or "zstd",
+// whose compressors are created by newCompressor.
+func NewEncoding(name string, newCompressor func() Compressor) *Encoding {
+	e := &Encoding{name: strings.ToLower(name)}
+	e.pool.New = func() interface{} { return newCompressor() }
+	return e
+}
+
+// Name returns the name of the content coding.
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// GzipEncoding returns the "gzip" Encoding, at the specified compression
+// level, as defined by package compress/gzip. Invalid levels select the
+// default compression level.
+func GzipEncoding(level int) *Encoding {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		level = gzip.DefaultCompression
+	}
+	return NewEncoding("gzip", func() Compressor {
+		zw, _ := gzip.NewWriterLevel(io.Discard, level)
+		return zw
+	})
+}
+
+// DeflateEncoding returns the "deflate" Encoding, at the specified
+// compression level, as defined by package compress/zlib. As per RFC 9110,
+// section 8.4.1.2, the "deflate" coding is the zlib format, rather than
+// raw DEFLATE. Invalid levels select the default compression level.
+func DeflateEncoding(level int) *Encoding {
+	if _, err := zlib.NewWriterLevel(io.Discard, level); err != nil {
+		level = zlib.DefaultCompression
+	}
+	return NewEncoding("deflate", func() Compressor {
+		zw, _ := zlib.NewWriterLevel(io.Discard, level)
+		return zw
+	})
+}
+
+func (e *Encoding) get(w io.Writer) Compressor {
+	c := e.pool.Get().(Compressor)
+	c.Reset(w)
+	return c
+}
+
+func (e *Encoding) put(c Compressor) {
+	c.Reset(io.Discard)
+	e.pool.Put(c)
+}
+
+var defaultEncodings = []*Encoding{
+	GzipEncoding(gzip.DefaultCompression),
+	DeflateEncoding(zlib.DefaultCompression),
+}
+
+// DefaultCompressMinSize is the minimum size of the responses compressed
+// by Compression, unless configured otherwise.
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressTypes lists the media types compressed by Compression,
+// unless configured otherwise. Entries ending in "/" match all subtypes.
+var DefaultCompressTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/problem+json",
+	"application/x-ndjson",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Compression configures the compression of responses, negotiated using
+// the Accept-Encoding request header.
+type Compression struct {
+	// Encodings lists the supported encodings, in order of preference,
+	// which breaks ties between encodings the client accepts equally. If
+	// nil, gzip and deflate are supported, in this order.
+	Encodings []*Encoding
+
+	// MinSize is the size below which responses are not compressed. If
+	// zero, DefaultCompressMinSize is used.
+	MinSize int
+
+	// Types lists the media types to compress. If nil,
+	// DefaultCompressTypes is used.
+	Types []string
+}
+
+// Handler returns a handler which serves requests using next, and
+// compresses the responses which are at least MinSize bytes long, and
+// whose Content-Type is one of Types, with the encoding preferred by the
+// client. Responses which set a Content-Encoding already are left alone.
+// Compressed responses lose their Content-Length header, and strong ETags
+// are weakened. The Vary header of all responses includes
+// Accept-Encoding.
+//
+// The first MinSize bytes of each response are buffered, to decide
+// whether to compress it. Flushing the response ends the buffering early.
+// Instrumentation outside of Handler, such as ServeInstrumented, reports
+// the number of compressed bytes written. The number of bytes before
+// compression is annotated under the "uncompressed" key.
+func (c Compression) Handler(next http.Handler) http.Handler {
+	encodings := c.Encodings
+	if encodings == nil {
+		encodings = defaultEncodings
+	}
+	minSize := c.MinSize
+	if minSize == 0 {
+		minSize = DefaultCompressMinSize
+	}
+	types := c.Types
+	if types == nil {
+		types = DefaultCompressTypes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+		enc := negotiateEncoding(req.Header.Get("Accept-Encoding"), encodings)
+		if enc == nil || req.Method == http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+		cw := &compressWriter{w: w, enc: enc, minSize: minSize, types: types, status: http.StatusOK}
+		defer func() {
+			cw.close()
+			if cw.compressed {
+				Annotate(req, "uncompressed", cw.uncompressed)
+			}
+		}()
+		next.ServeHTTP(cw.wrap(), req)
+	})
+}
+
+// negotiateEncoding returns the encoding with the highest q-value in the
+// Accept-Encoding header, or nil if the client accepts none.
+func negotiateEncoding(accept string, encodings []*Encoding) *Encoding {
+	if accept == "" {
+		return nil
+	}
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+	var (
+		best  *Encoding
+		bestQ float64
+	)
+	for _, e := range encodings {
+		w, ok := q[e.name]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > bestQ {
+			best, bestQ = e, w
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the beginning of a response, then either
+// compresses the rest of it, or passes it through unchanged.
+type compressWriter struct {
+	w       http.ResponseWriter
+	enc     *Encoding
+	minSize int
+	types   []string
+
+	status       int
+	wroteHeader  bool // by the handler
+	decided      bool
+	compressed   bool
+	c            Compressor
+	buf          bytes.Buffer
+	uncompressed int64
+}
+
+func (cw *compressWriter) wrap() http.ResponseWriter {
+	return httpsnoop.Wrap(cw.w, httpsnoop.Hooks{
+		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return cw.writeHeader
+		},
+		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return cw.write
+		},
+		ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				return io.Copy(writerFunc(cw.write), src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				cw.decide(false)
+				if cw.c != nil {
+					cw.c.Flush()
+				}
+				next()
+			}
+		},
+	})
+}
+
+func (cw *compressWriter) writeHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		cw.w.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	if !bodyAllowed(code) {
+		cw.decide(true)
+	}
+}
+
+func (cw *compressWriter) write(b []byte) (int, error) {
+	cw.writeHeader(http.StatusOK)
+	cw.uncompressed += int64(len(b))
+	if !cw.decided {
+		cw.buf.Write(b)
+		if cw.buf.Len() < cw.minSize {
+			return len(b), nil
+		}
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.c != nil {
+		return cw.c.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+// decide decides whether to compress the response, writes the header, and
+// the buffered part of the body. If final is true, the buffer holds the
+// entire body.
+func (cw *compressWriter) decide(final bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	h := cw.w.Header()
+	if cw.shouldCompress(h, final) {
+		cw.compressed = true
+		h.Set("Content-Encoding", cw.enc.name)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.c = cw.enc.get(cw.w)
+	}
+	cw.w.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.c != nil {
+		_, err = cw.c.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.w.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+func (cw *compressWriter) shouldCompress(h http.Header, final bool) bool {
+	if !bodyAllowed(cw.status) || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if final && cw.buf.Len() < cw.minSize {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(cw.buf.Bytes())
+		h.Set("Content-Type", ct)
+	}
+	return hasMediaType(ct, cw.types)
+}
+
+// hasMediaType reports whether the media type of the Content-Type ct is
+// one of types. Entries of types ending in "/" match all subtypes.
+func hasMediaType(ct string, types []string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if mt == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// close completes the response.
+func (cw *compressWriter) close() {
+	cw.decide(true)
+	if cw.c != nil {
+		cw.c.Close()
+		cw.enc.put(cw.c)
+		cw.c = nil
+	}
+}
+
+// writerFunc is an io.Writer implemented by a function.
+type writerFunc func([]byte) (int, error)
+
+func (fn writerFunc) Write(b []byte) (int, error) {
+	return fn(b)
+}
+
+// bodyAllowed reports whether a response with the specified status may
+// carry a body.
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified && code >= 200
+}
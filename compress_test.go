@@ -0,0 +1,139 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestCompression(t *testing.T) {
+	long := strings.Repeat("hello, world\n", 200)
+	tests := []struct {
+		name     string
+		accept   string
+		ctype    string
+		body     string
+		encoding string
+	}{
+		{"gzip", "gzip, deflate", "text/plain", long, "gzip"},
+		{"preference", "deflate;q=1, gzip;q=0.5", "text/plain", long, "deflate"},
+		{"wildcard", "*", "application/json", long, "gzip"},
+		{"refused", "gzip;q=0", "text/plain", long, ""},
+		{"none", "", "text/plain", long, ""},
+		{"short", "gzip", "text/plain", "short", ""},
+		{"type", "gzip", "image/png", long, ""},
+		{"sniffed", "gzip", "", "<html>" + long, "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := httpx.Compression{}.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if tt.ctype != "" {
+					w.Header().Set("Content-Type", tt.ctype)
+				}
+				w.Header().Set("ETag", `"v1"`)
+				io.WriteString(w, tt.body[:len(tt.body)/2])
+				io.WriteString(w, tt.body[len(tt.body)/2:])
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			s := httpx.ServeInstrumented(h, rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding == %q, want %q", got, tt.encoding)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary == %q, want Accept-Encoding", got)
+			}
+			if tt.encoding == "" {
+				if rec.Body.String() != tt.body {
+					t.Errorf("uncompressed body altered")
+				}
+				return
+			}
+			if got := rec.Header().Get("ETag"); got != `W/"v1"` {
+				t.Errorf("ETag == %q, want a weak ETag", got)
+			}
+			if s.Written != int64(rec.Body.Len()) || s.Written >= int64(len(tt.body)) {
+				t.Errorf("Summary.Written == %d, want the compressed size %d", s.Written, rec.Body.Len())
+			}
+			if got := s.Annotations["uncompressed"]; got != int64(len(tt.body)) {
+				t.Errorf("uncompressed == %v, want %d", got, len(tt.body))
+			}
+			// The response decodes as a request body, as the
+			// codings are the same.
+			var got string
+			dh := httpx.DecompressRequests(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				b, err := io.ReadAll(req.Body)
+				if err != nil {
+					t.Errorf("reading decompressed body: %v", err)
+				}
+				got = string(b)
+			}), 0)
+			dreq := httptest.NewRequest("POST", "/", bytes.NewReader(rec.Body.Bytes()))
+			dreq.Header.Set("Content-Encoding", tt.encoding)
+			drec := httptest.NewRecorder()
+			dh.ServeHTTP(drec, dreq)
+			if drec.Code != http.StatusOK || got != tt.body {
+				t.Errorf("decompressed body does not match: status %d", drec.Code)
+			}
+		})
+	}
+}
+
+func TestCompressionFlush(t *testing.T) {
+	h := httpx.Compression{}.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !rec.Flushed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("flushed %v with encoding %q, want a flushed gzip stream", rec.Flushed, rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != "data: 1\n\n" {
+		t.Errorf("got body %q", b)
+	}
+}
+
+func TestCompressionNoBody(t *testing.T) {
+	h := httpx.Compression{}.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Errorf("got %d %q with %d bytes, want a bare 304", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"acln.ro/log"
+)
+
+// A ConcurrencyOption configures ConcurrencyLimit.
+type ConcurrencyOption func(*concurrencyConfig)
+
+type concurrencyConfig struct {
+	queue  time.Duration
+	key    func(req *http.Request) string
+	keyMax int
+}
+
+// ConcurrencyQueue configures ConcurrencyLimit to wait for up to timeout
+// for a slot to become available, rather than rejecting requests as soon
+// as the limit is reached.
+func ConcurrencyQueue(timeout time.Duration) ConcurrencyOption {
+	return func(cfg *concurrencyConfig) {
+		cfg.queue = timeout
+	}
+}
+
+// ConcurrencyPerKey configures ConcurrencyLimit to also limit the number
+// of concurrent requests with the same key to max, so that one slow
+// endpoint cannot use up the global limit. The key is computed before the
+// request is served, typically from the method and path. Requests for which
+// key returns the empty string are only subject to the global limit.
+func ConcurrencyPerKey(key func(req *http.Request) string, max int) ConcurrencyOption {
+	return func(cfg *concurrencyConfig) {
+		cfg.key = key
+		cfg.keyMax = max
+	}
+}
+
+// ConcurrencyLimit returns a handler which serves at most max requests
+// concurrently using next. Requests beyond the limit are rejected with
+// 503 Service Unavailable and a problem details body, annotated with
+// "shed" set to "concurrency", and published as EventRequestShed events.
+func ConcurrencyLimit(next http.Handler, max int, opts ...ConcurrencyOption) http.Handler {
+	cfg := new(concurrencyConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	global := make(semaphore, max)
+	var (
+		mu   sync.Mutex
+		keys = make(map[string]*keyedSemaphore)
+	)
+	acquireKey := func(key string) *keyedSemaphore {
+		mu.Lock()
+		defer mu.Unlock()
+		ks, ok := keys[key]
+		if !ok {
+			ks = &keyedSemaphore{sem: make(semaphore, cfg.keyMax)}
+			keys[key] = ks
+		}
+		ks.refs++
+		return ks
+	}
+	releaseKey := func(key string, ks *keyedSemaphore) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ks.refs--; ks.refs == 0 {
+			delete(keys, key)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var timeout <-chan time.Time
+		if cfg.queue > 0 {
+			t := time.NewTimer(cfg.queue)
+			defer t.Stop()
+			timeout = t.C
+		}
+		done := req.Context().Done()
+		// The per-key slot is acquired first, such that requests
+		// queued behind a slow key do not hold global slots.
+		if cfg.key != nil {
+			if key := cfg.key(req); key != "" {
+				ks := acquireKey(key)
+				defer releaseKey(key, ks)
+				if !ks.sem.acquire(timeout, done) {
+					shedConcurrency(w, req)
+					return
+				}
+				defer ks.sem.release()
+			}
+		}
+		if !global.acquire(timeout, done) {
+			shedConcurrency(w, req)
+			return
+		}
+		defer global.release()
+		next.ServeHTTP(w, req)
+	})
+}
+
+func shedConcurrency(w http.ResponseWriter, req *http.Request) {
+	Annotate(req, "shed", "concurrency")
+	Events.Publish(requestEvent(EventRequestShed, req, log.KV{"reason": "concurrency"}))
+	writeProblem(w, http.StatusServiceUnavailable, "too many concurrent requests")
+}
+
+// semaphore is a counting semaphore.
+type semaphore chan struct{}
+
+// acquire acquires a slot. If none is available immediately, it waits
+// until one is, or until timeout or done is ready. A nil timeout channel
+// means not to wait at all.
+func (s semaphore) acquire(timeout <-chan time.Time, done <-chan struct{}) bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	if timeout == nil {
+		return false
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	<-s
+}
+
+// keyedSemaphore is a semaphore shared by the requests with the same key,
+// which is discarded once no request refers to it.
+type keyedSemaphore struct {
+	sem  semaphore
+	refs int
+}
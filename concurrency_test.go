@@ -0,0 +1,145 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+// blockingHandler blocks requests until released, and signals when each
+// request starts.
+type blockingHandler struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan string, 16), release: make(chan struct{})}
+}
+
+func (bh *blockingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	bh.started <- req.URL.Path
+	<-bh.release
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	bh := newBlockingHandler()
+	h := httpx.ConcurrencyLimit(bh, 2, httpx.ConcurrencyPerKey(func(req *http.Request) string {
+		return req.URL.Path
+	}, 1))
+
+	var wg sync.WaitGroup
+	serve := func(path string) {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	wg.Add(1)
+	go serve("/slow")
+	<-bh.started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("same key: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	wg.Add(1)
+	go serve("/fast")
+	<-bh.started
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("global limit: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	close(bh.release)
+	wg.Wait()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after release: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	bh := newBlockingHandler()
+	h := httpx.ConcurrencyLimit(bh, 1, httpx.ConcurrencyQueue(time.Minute))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			codes[i] = rec.Code
+		}(i)
+	}
+	<-bh.started
+	bh.release <- struct{}{}
+	<-bh.started
+	bh.release <- struct{}{}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: got status %d, want %d", i, code, http.StatusOK)
+		}
+	}
+}
+
+func TestConcurrencyQueuePerKey(t *testing.T) {
+	bh := newBlockingHandler()
+	h := httpx.ConcurrencyLimit(bh, 2,
+		httpx.ConcurrencyQueue(time.Minute),
+		httpx.ConcurrencyPerKey(func(req *http.Request) string {
+			return req.URL.Path
+		}, 1),
+	)
+
+	var wg sync.WaitGroup
+	serve := func(path string) {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	wg.Add(1)
+	go serve("/slow")
+	<-bh.started
+	// Queue requests for the slow key. They must not hold global slots
+	// while they wait.
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go serve("/slow")
+	}
+	time.Sleep(20 * time.Millisecond)
+	wg.Add(1)
+	go serve("/fast")
+	select {
+	case path := <-bh.started:
+		if path != "/fast" {
+			t.Errorf("started %s, want /fast", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request for another key starved by requests queued for /slow")
+	}
+	close(bh.release)
+	wg.Wait()
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ConnStats are the connection statistics recorded by a ConnTracker.
+type ConnStats struct {
+	// Open is the number of open connections, Idle the number of those
+	// waiting for a new request, and Active the number of those reading
+	// or serving a request. New connections which have not sent any
+	// data yet are open, but neither idle nor active.
+	Open   int64
+	Idle   int64
+	Active int64
+
+	// Accepted counts the connections accepted, Closed those closed,
+	// and Hijacked those taken over by handlers, such as WebSockets.
+	Accepted int64
+	Closed   int64
+	Hijacked int64
+}
+
+// ConnTracker records connection-level statistics of an http.Server, by
+// means of its ConnState hook:
+//
+//	ct := new(httpx.ConnTracker)
+//	srv := &http.Server{Handler: h, ConnState: ct.ConnState}
+//
+// An open count which grows over time, while the request rate does not,
+// reveals leaked connections. The zero value is ready to use.
+type ConnTracker struct {
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+	stats  ConnStats
+}
+
+// ConnState records the transition of c into state. It has the signature
+// of http.Server.ConnState. To combine it with another hook, call both
+// from a function literal.
+func (ct *ConnTracker) ConnState(c net.Conn, state http.ConnState) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.states == nil {
+		ct.states = make(map[net.Conn]http.ConnState)
+	}
+	prev, ok := ct.states[c]
+	if ok {
+		ct.adjust(prev, -1)
+	}
+	switch state {
+	case http.StateNew:
+		ct.stats.Accepted++
+		ct.stats.Open++
+	case http.StateClosed, http.StateHijacked:
+		if state == http.StateClosed {
+			ct.stats.Closed++
+		} else {
+			ct.stats.Hijacked++
+		}
+		ct.stats.Open--
+		delete(ct.states, c)
+		return
+	}
+	ct.states[c] = state
+	ct.adjust(state, 1)
+}
+
+func (ct *ConnTracker) adjust(state http.ConnState, delta int64) {
+	switch state {
+	case http.StateIdle:
+		ct.stats.Idle += delta
+	case http.StateActive:
+		ct.stats.Active += delta
+	}
+}
+
+// Stats returns the statistics recorded so far.
+func (ct *ConnTracker) Stats() ConnStats {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.stats
+}
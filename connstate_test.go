@@ -0,0 +1,57 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestConnTracker(t *testing.T) {
+	var ct httpx.ConnTracker
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c, d := net.Pipe()
+	defer c.Close()
+	defer d.Close()
+
+	transitions := []struct {
+		conn  net.Conn
+		state http.ConnState
+	}{
+		{a, http.StateNew},
+		{a, http.StateActive},
+		{c, http.StateNew},
+		{c, http.StateActive},
+		{a, http.StateIdle},
+		{c, http.StateHijacked},
+	}
+	for _, tr := range transitions {
+		ct.ConnState(tr.conn, tr.state)
+	}
+	want := httpx.ConnStats{Open: 1, Idle: 1, Accepted: 2, Hijacked: 1}
+	if got := ct.Stats(); got != want {
+		t.Errorf("Stats() == %+v, want %+v", got, want)
+	}
+	ct.ConnState(a, http.StateClosed)
+	want = httpx.ConnStats{Accepted: 2, Closed: 1, Hijacked: 1}
+	if got := ct.Stats(); got != want {
+		t.Errorf("after close: Stats() == %+v, want %+v", got, want)
+	}
+}
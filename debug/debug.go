@@ -0,0 +1,202 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// Package debug serves the standard debug endpoints of a service.
+//
+// Like any package importing net/http/pprof and expvar, it registers
+// their handlers on http.DefaultServeMux, which is why the endpoints are
+// not part of package httpx itself: only programs which import this
+// package expose them.
+package debug
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"path"
+
+	"acln.ro/httpx"
+)
+
+// An Option configures Handler.
+type Option func(*config)
+
+type config struct {
+	routes   httpx.Routes
+	inFlight *httpx.InFlight
+	panics   *httpx.PanicStats
+	user     string
+	password string
+	auth     bool
+}
+
+// Routes configures Handler to serve the route table rs.
+func Routes(rs httpx.Routes) Option {
+	return func(cfg *config) {
+		cfg.routes = rs
+	}
+}
+
+// InFlight configures Handler to serve the requests registered by f.
+func InFlight(f *httpx.InFlight) Option {
+	return func(cfg *config) {
+		cfg.inFlight = f
+	}
+}
+
+// Panics configures Handler to serve the panics recorded by ps.
+func Panics(ps *httpx.PanicStats) Option {
+	return func(cfg *config) {
+		cfg.panics = ps
+	}
+}
+
+// BasicAuth configures Handler to require HTTP basic authentication
+// using the specified credentials.
+func BasicAuth(user, password string) Option {
+	return func(cfg *config) {
+		cfg.user = user
+		cfg.password = password
+		cfg.auth = true
+	}
+}
+
+// Handler returns a handler which serves the standard debug endpoints of
+// a service, routed by means of httpx.Shift:
+//
+//	/pprof/     the net/http/pprof profiles
+//	/vars       the expvar variables
+//	/routes     the route table, see Routes
+//	/inflight   the requests in flight, see InFlight
+//	/panics     the recent panics, see Panics
+//	/requests   the chain traces in httpx.RecentChains, in debug builds
+//
+// The root of the subtree renders an index of the endpoints. Handler is
+// typically reached by shifting a "debug" segment, so that the endpoints
+// are served under /debug/.
+func Handler(opts ...Option) http.Handler {
+	cfg := new(config)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if cfg.auth && !cfg.authorized(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Path == "" {
+			redirectSlash(w, req)
+			return
+		}
+		switch httpx.Shift(req) {
+		case "":
+			cfg.index(w)
+		case "pprof":
+			servePprof(w, req)
+		case "vars":
+			expvar.Handler().ServeHTTP(w, req)
+		case "routes":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, rs := range cfg.routes {
+				fmt.Fprintln(w, rs)
+			}
+		case "inflight":
+			if cfg.inFlight == nil {
+				http.NotFound(w, req)
+				return
+			}
+			cfg.inFlight.ServeHTTP(w, req)
+		case "panics":
+			if cfg.panics == nil {
+				http.NotFound(w, req)
+				return
+			}
+			cfg.panics.ServeHTTP(w, req)
+		case "requests":
+			httpx.RecentChains.ServeHTTP(w, req)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+}
+
+func (cfg *config) authorized(req *http.Request) bool {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	u := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.user))
+	p := subtle.ConstantTimeCompare([]byte(password), []byte(cfg.password))
+	return u&p == 1
+}
+
+func (cfg *config) index(w http.ResponseWriter) {
+	links := []string{"pprof/", "vars", "routes"}
+	if cfg.inFlight != nil {
+		links = append(links, "inflight")
+	}
+	if cfg.panics != nil {
+		links = append(links, "panics")
+	}
+	links = append(links, "requests")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<!DOCTYPE html>\n<title>debug</title>\n<ul>")
+	for _, l := range links {
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a>\n", l, l)
+	}
+	fmt.Fprintln(w, "</ul>")
+}
+
+// servePprof serves the pprof endpoints, with the "pprof" segment shifted
+// already.
+func servePprof(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "" {
+		redirectSlash(w, req)
+		return
+	}
+	switch name := httpx.Shift(req); name {
+	case "":
+		pprof.Index(w, req)
+	case "cmdline":
+		pprof.Cmdline(w, req)
+	case "profile":
+		pprof.Profile(w, req)
+	case "symbol":
+		pprof.Symbol(w, req)
+	case "trace":
+		pprof.Trace(w, req)
+	default:
+		pprof.Handler(name).ServeHTTP(w, req)
+	}
+}
+
+// redirectSlash redirects a request for a directory-like endpoint to the
+// same path with a trailing slash, so that relative links resolve
+// correctly. The Location is relative, since the path of the request may
+// have been shifted already.
+func redirectSlash(w http.ResponseWriter, req *http.Request) {
+	loc := "/"
+	if u, err := url.ParseRequestURI(req.RequestURI); err == nil && u.Path != "" && u.Path != "/" {
+		loc = path.Base(u.Path) + "/"
+		if u.RawQuery != "" {
+			loc += "?" + u.RawQuery
+		}
+	}
+	w.Header().Set("Location", loc)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package debug_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+	"acln.ro/httpx/debug"
+)
+
+func TestHandler(t *testing.T) {
+	routes := httpx.Routes{
+		{Pattern: "/users/{id}"},
+		{Method: "POST", Pattern: "/users"},
+	}
+	dh := debug.Handler(debug.Routes(routes), debug.InFlight(new(httpx.InFlight)))
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if httpx.Shift(req) != "debug" {
+			http.NotFound(w, req)
+			return
+		}
+		dh.ServeHTTP(w, req)
+	})
+	tests := []struct {
+		path     string
+		code     int
+		contains string
+		location string
+	}{
+		{"/debug", http.StatusMovedPermanently, "", "debug/"},
+		{"/debug/", http.StatusOK, `href="pprof/"`, ""},
+		{"/debug/pprof", http.StatusMovedPermanently, "", "pprof/"},
+		{"/debug/pprof/", http.StatusOK, "goroutine", ""},
+		{"/debug/pprof/cmdline", http.StatusOK, "", ""},
+		{"/debug/pprof/goroutine?debug=1", http.StatusOK, "goroutine profile", ""},
+		{"/debug/vars", http.StatusOK, `"memstats"`, ""},
+		{"/debug/routes", http.StatusOK, "GET /users/{id}\nPOST /users\n", ""},
+		{"/debug/inflight", http.StatusOK, "0 requests in flight", ""},
+		{"/debug/requests", http.StatusOK, "", ""},
+		{"/debug/panics", http.StatusNotFound, "", ""},
+		{"/debug/nope", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.code)
+		}
+		if !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("%s: body does not contain %q", tt.path, tt.contains)
+		}
+		if got := rec.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: Location == %q, want %q", tt.path, got, tt.location)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := debug.Handler(debug.BasicAuth("ops", "s3cret"))
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		code     int
+	}{
+		{"none", "", "", http.StatusUnauthorized},
+		{"wrong", "ops", "guess", http.StatusUnauthorized},
+		{"right", "ops", "s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/vars", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("got status %d, want %d", rec.Code, tt.code)
+			}
+			if tt.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DecompressRequests returns a handler which decodes request bodies sent
+// with a gzip or deflate Content-Encoding, then calls next. The Content-
+// Encoding and Content-Length headers are removed from decoded requests,
+// and ContentLength is set to -1.
+//
+// At most maxSize bytes are decoded: beyond that, reads of the body fail
+// with an *http.MaxBytesError, which guards against decompression bombs.
+// If maxSize is zero or less, decoded bodies are unlimited.
+//
+// Requests with other content codings are rejected with 415 Unsupported
+// Media Type, and requests whose body does not start with a valid header
+// with 400 Bad Request.
+func DecompressRequests(next http.Handler, maxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ce := req.Header.Get("Content-Encoding")
+		if ce == "" || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		codings := strings.Split(ce, ",")
+		var body io.ReadCloser = req.Body
+		// Codings are listed in the order in which they were applied.
+		for i := len(codings) - 1; i >= 0; i-- {
+			var err error
+			switch strings.ToLower(strings.TrimSpace(codings[i])) {
+			case "identity":
+				continue
+			case "gzip", "x-gzip":
+				body, err = newDecoder(body, func(r io.Reader) (io.ReadCloser, error) {
+					return gzip.NewReader(r)
+				})
+			case "deflate":
+				body, err = newDecoder(body, zlib.NewReader)
+			default:
+				writeProblem(w, http.StatusUnsupportedMediaType, "unsupported content encoding "+codings[i])
+				return
+			}
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, "malformed "+strings.TrimSpace(codings[i])+" body")
+				return
+			}
+		}
+		if maxSize > 0 {
+			body = &limitedBody{ReadCloser: body, limit: maxSize, left: maxSize}
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+		req.ContentLength = -1
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Content-Length")
+		next.ServeHTTP(w, req)
+	})
+}
+
+// decoder closes both the decoding reader and the underlying body.
+type decoder struct {
+	io.ReadCloser
+	under io.Closer
+}
+
+func newDecoder(body io.ReadCloser, newReader func(io.Reader) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	r, err := newReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{ReadCloser: r, under: body}, nil
+}
+
+func (d *decoder) Close() error {
+	d.ReadCloser.Close()
+	return d.under.Close()
+}
+
+// limitedBody fails with an *http.MaxBytesError once more than limit
+// bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	left  int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.left < 0 {
+		return 0, &http.MaxBytesError{Limit: lb.limit}
+	}
+	// Read one byte beyond the limit, to tell bodies of exactly limit
+	// bytes from longer ones.
+	if int64(len(p)) > lb.left+1 {
+		p = p[:lb.left+1]
+	}
+	n, err := lb.ReadCloser.Read(p)
+	lb.left -= int64(n)
+	if lb.left < 0 {
+		n += int(lb.left)
+		return n, &http.MaxBytesError{Limit: lb.limit}
+	}
+	return n, err
+}
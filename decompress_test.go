@@ -0,0 +1,100 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func gzipBytes(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, s)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func zlibBytes(s string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	io.WriteString(zw, s)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDecompressRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		code     int
+		want     string
+		tooLarge bool
+	}{
+		{"plain", "", []byte("hello"), http.StatusOK, "hello", false},
+		{"gzip", "gzip", gzipBytes("hello"), http.StatusOK, "hello", false},
+		{"deflate", "deflate", zlibBytes("hello"), http.StatusOK, "hello", false},
+		{"exact", "gzip", gzipBytes(strings.Repeat("a", 16)), http.StatusOK, strings.Repeat("a", 16), false},
+		{"bomb", "gzip", gzipBytes(strings.Repeat("a", 1<<20)), http.StatusOK, strings.Repeat("a", 16), true},
+		{"unsupported", "br", []byte("x"), http.StatusUnsupportedMediaType, "", false},
+		{"malformed", "gzip", []byte("not gzip"), http.StatusBadRequest, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				got []byte
+				err error
+			)
+			h := httpx.DecompressRequests(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Header.Get("Content-Encoding") != "" {
+					t.Errorf("Content-Encoding not removed")
+				}
+				got, err = io.ReadAll(req.Body)
+			}), 16)
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var mbe *http.MaxBytesError
+			if tt.tooLarge != errors.As(err, &mbe) {
+				t.Errorf("read error %v, want MaxBytesError: %v", err, tt.tooLarge)
+			}
+			if !tt.tooLarge && err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got body %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ServeDownload serves content as an attachment named name, which clients
+// save rather than display. It sets the Content-Disposition header, as
+// specified by RFC 6266, with an ASCII fallback filename, and the full
+// name encoded as specified by RFC 8187. The Content-Type is inferred from
+// the extension of name, or else from the content, unless it is set
+// already.
+//
+// The rest is delegated to http.ServeContent, which handles Range,
+// If-Modified-Since and related request headers, using modtime if it is
+// not the zero time.
+func ServeDownload(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		name = "download"
+	}
+	h := w.Header()
+	h.Set("Content-Disposition", ContentDisposition("attachment", name))
+	if h.Get("Content-Type") == "" {
+		if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+			h.Set("Content-Type", ct)
+		}
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	// ServeContent infers the content type from name, which must
+	// therefore not be passed along, lest it sniffs a type for names
+	// without a known extension, rather than the content.
+	http.ServeContent(w, req, "", modtime, content)
+}
+
+// ContentDisposition formats a Content-Disposition header value with the
+// specified disposition type, "attachment" or "inline", and filename.
+// Names which are not plain ASCII are sent in the filename* parameter,
+// with an ASCII approximation in the filename parameter, for clients
+// which do not support RFC 8187.
+func ContentDisposition(disposition, filename string) string {
+	fallback := asciiFilename(filename)
+	v := disposition + `; filename="` + fallback + `"`
+	if fallback != filename {
+		v += "; filename*=UTF-8''" + encodeRFC8187(filename)
+	}
+	return v
+}
+
+// asciiFilename replaces the characters of name which cannot appear in a
+// quoted filename parameter as understood by all clients.
+func asciiFilename(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\' || r == '%':
+			sb.WriteByte('_')
+		case r < 0x20 || r == 0x7f:
+			continue
+		case r >= utf8.RuneSelf:
+			sb.WriteByte('_')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// encodeRFC8187 percent-encodes s for use in an extended parameter value,
+// in which only the attr-char set is allowed verbatim.
+func encodeRFC8187(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		const hex = "0123456789ABCDEF"
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&15])
+	}
+	return sb.String()
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{`a "quoted" name.txt`, `attachment; filename="a _quoted_ name.txt"; filename*=UTF-8''a%20%22quoted%22%20name.txt`},
+		{"naïve résumé.txt", `attachment; filename="na_ve r_sum_.txt"; filename*=UTF-8''na%C3%AFve%20r%C3%A9sum%C3%A9.txt`},
+		{"100%.txt", `attachment; filename="100_.txt"; filename*=UTF-8''100%25.txt`},
+	}
+	for _, tt := range tests {
+		if got := httpx.ContentDisposition("attachment", tt.name); got != tt.want {
+			t.Errorf("ContentDisposition(%q) == %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeDownload(t *testing.T) {
+	modtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name        string
+		rangeHeader string
+		status      int
+		ctype       string
+		body        string
+		disposition string
+	}{
+		{
+			name:        "../../etc/data.csv",
+			status:      http.StatusOK,
+			ctype:       "text/csv; charset=utf-8",
+			body:        "hello, world",
+			disposition: `attachment; filename="data.csv"`,
+		},
+		{
+			name:        "noext",
+			rangeHeader: "bytes=0-4",
+			status:      http.StatusPartialContent,
+			ctype:       "text/plain; charset=utf-8",
+			body:        "hello",
+			disposition: `attachment; filename="noext"`,
+		},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		httpx.ServeDownload(rec, req, tt.name, modtime, strings.NewReader("hello, world"))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status == %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s: Content-Type == %q, want %q", tt.name, got, tt.ctype)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%s: Content-Disposition == %q, want %q", tt.name, got, tt.disposition)
+		}
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("%s: body == %q, want %q", tt.name, got, tt.body)
+		}
+		if got := rec.Header().Get("Last-Modified"); got != modtime.Format(http.TimeFormat) {
+			t.Errorf("%s: Last-Modified == %q", tt.name, got)
+		}
+	}
+}
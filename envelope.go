@@ -0,0 +1,94 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An Envelope is the standard shape of JSON responses served by Enveloped.
+type Envelope struct {
+	Data json.RawMessage        `json:"data"`
+	Meta map[string]interface{} `json:"meta"`
+}
+
+// Enveloped returns a handler which wraps successful JSON responses
+// produced by next into an Envelope. The "meta" object records the
+// "request_id" and "duration" of the request, the notices attached using
+// AddNotice, if any, and the fields set using SetMeta, such as pagination
+// information.
+//
+// Enveloping is opt-in: it applies only to the handler trees wrapped by
+// Enveloped. Responses which are not successful JSON responses are passed
+// through unmodified.
+func Enveloped(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		req = WithRequestState(req)
+		rb := newResponseBuffer()
+		next.ServeHTTP(rb, req)
+
+		mt, _, _ := mime.ParseMediaType(rb.header.Get("Content-Type"))
+		body := rb.body.Bytes()
+		if rb.code/100 != 2 || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) || !json.Valid(body) {
+			rb.sendTo(w)
+			return
+		}
+
+		meta := make(map[string]interface{})
+		st := stateOf(req)
+		st.mu.Lock()
+		for k, v := range st.meta {
+			meta[k] = v
+		}
+		st.mu.Unlock()
+		if id := RequestID(req); id != "" {
+			meta["request_id"] = id
+		}
+		if notices := Notices(req); len(notices) > 0 {
+			meta["notices"] = notices
+		}
+		meta["duration"] = time.Since(start).String()
+
+		env, err := json.Marshal(Envelope{Data: body, Meta: meta})
+		if err != nil {
+			rb.sendTo(w)
+			return
+		}
+		rb.header.Del("Content-Length")
+		rb.body.Reset()
+		rb.body.Write(env)
+		rb.sendTo(w)
+	})
+}
+
+// SetMeta sets a field of the "meta" object of the response envelope,
+// e.g. "pagination". It is a no-op if req carries no request state.
+func SetMeta(req *http.Request, key string, value interface{}) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.meta == nil {
+		st.meta = make(map[string]interface{})
+	}
+	st.meta[key] = value
+}
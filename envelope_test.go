@@ -0,0 +1,62 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestEnveloped(t *testing.T) {
+	h := httpx.Enveloped(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/error" {
+			http.Error(w, "nope", http.StatusBadRequest)
+			return
+		}
+		httpx.SetMeta(req, "pagination", map[string]int{"page": 2})
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `[1,2,3]`)
+	}))
+
+	req := httpx.WithRequestID(httptest.NewRequest("GET", "/", nil), "abc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var env struct {
+		Data []int `json:"data"`
+		Meta struct {
+			RequestID  string         `json:"request_id"`
+			Duration   string         `json:"duration"`
+			Pagination map[string]int `json:"pagination"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Data) != 3 || env.Meta.RequestID != "abc" || env.Meta.Duration == "" || env.Meta.Pagination["page"] != 2 {
+		t.Fatalf("unexpected envelope %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/error", nil))
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "nope\n" {
+		t.Fatalf("error response modified: %d %q", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// DefaultETagMaxSize is the default size of the largest response for
+// which ETag computes an entity tag.
+const DefaultETagMaxSize = 1 << 20
+
+// DefaultETagSkipTypes lists the media types of streaming responses, for
+// which ETag computes no entity tags, unless configured otherwise.
+var DefaultETagSkipTypes = []string{
+	"application/x-ndjson",
+	"text/event-stream",
+}
+
+// ETag configures the computation of entity tags for responses, and the
+// evaluation of conditional GET requests.
+type ETag struct {
+	// Weak configures ETag to compute weak entity tags, for handlers
+	// whose responses are semantically, but not byte-for-byte,
+	// equivalent across requests.
+	Weak bool
+
+	// MaxSize is the size of the largest response which is buffered to
+	// compute its entity tag. If zero, DefaultETagMaxSize is used.
+	MaxSize int
+
+	// SkipTypes lists the media types for which no entity tag is
+	// computed. Entries ending in "/" match all subtypes. If nil,
+	// DefaultETagSkipTypes is used.
+	SkipTypes []string
+}
+
+// Handler returns a handler which serves GET and HEAD requests using
+// next, and sets the ETag header of 200 OK responses which do not set one
+// already, to a hash of the body. Responses are buffered for the purpose,
+// up to MaxSize bytes: larger responses, and responses which are flushed,
+// are streamed without an entity tag.
+//
+// If the response carries an entity tag, whether computed or set by next,
+// and it matches If-None-Match, or its Last-Modified time matches
+// If-Modified-Since, Handler responds with 304 Not Modified instead.
+//
+// In combination with Compression, Handler must be applied first, for
+// the entity tag to identify the uncompressed representation.
+func (e ETag) Handler(next http.Handler) http.Handler {
+	maxSize := e.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultETagMaxSize
+	}
+	skip := e.SkipTypes
+	if skip == nil {
+		skip = DefaultETagSkipTypes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ew := &etagWriter{
+			w:       w,
+			req:     req,
+			weak:    e.Weak,
+			maxSize: maxSize,
+			skip:    skip,
+			status:  http.StatusOK,
+		}
+		next.ServeHTTP(ew.wrap(), req)
+		ew.decide(true)
+	})
+}
+
+// etagWriter buffers a response to compute its entity tag, then writes it,
+// or a 304 Not Modified response in its stead.
+type etagWriter struct {
+	w       http.ResponseWriter
+	req     *http.Request
+	weak    bool
+	maxSize int
+	skip    []string
+
+	status      int
+	wroteHeader bool // by the handler
+	decided     bool
+	discard     bool // the response is 304 Not Modified
+	buf         bytes.Buffer
+}
+
+func (ew *etagWriter) wrap() http.ResponseWriter {
+	return httpsnoop.Wrap(ew.w, httpsnoop.Hooks{
+		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return ew.writeHeader
+		},
+		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return ew.write
+		},
+		ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				return io.Copy(writerFunc(ew.write), src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				ew.decide(false)
+				if !ew.discard {
+					next()
+				}
+			}
+		},
+	})
+}
+
+func (ew *etagWriter) writeHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		ew.w.WriteHeader(code)
+		return
+	}
+	ew.wroteHeader = true
+	ew.status = code
+	if code != http.StatusOK || hasMediaType(ew.w.Header().Get("Content-Type"), ew.skip) {
+		ew.decide(false)
+	}
+}
+
+func (ew *etagWriter) write(b []byte) (int, error) {
+	ew.writeHeader(http.StatusOK)
+	if ew.discard {
+		return len(b), nil
+	}
+	if ew.decided {
+		return ew.w.Write(b)
+	}
+	ew.buf.Write(b)
+	if ew.buf.Len() > ew.maxSize {
+		if err := ew.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide computes the entity tag of the response, if final is true, and
+// the buffer holds the entire body. It then evaluates the conditional
+// request headers, and writes either the response, or 304 Not Modified.
+func (ew *etagWriter) decide(final bool) error {
+	if ew.decided {
+		return nil
+	}
+	ew.decided = true
+	h := ew.w.Header()
+	if ew.status != http.StatusOK {
+		return ew.flushBuffer()
+	}
+	// The body of a response to a HEAD request is empty, and hashing it
+	// would not identify the representation.
+	if final && ew.req.Method != http.MethodHead && h.Get("ETag") == "" {
+		sum := sha256.Sum256(ew.buf.Bytes())
+		etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+		if ew.weak {
+			etag = "W/" + etag
+		}
+		h.Set("ETag", etag)
+	}
+	if notModified(ew.req, h) {
+		ew.discard = true
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		ew.w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	return ew.flushBuffer()
+}
+
+func (ew *etagWriter) flushBuffer() error {
+	ew.w.WriteHeader(ew.status)
+	if ew.buf.Len() == 0 {
+		return nil
+	}
+	_, err := ew.w.Write(ew.buf.Bytes())
+	ew.buf.Reset()
+	return err
+}
+
+// notModified evaluates If-None-Match, or, in its absence,
+// If-Modified-Since, against the validators in h, as specified by RFC
+// 9110, section 13.2.2.
+func notModified(req *http.Request, h http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := h.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || weakETag(tag) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lm.After(ims)
+}
+
+// weakETag strips the weakness indicator from etag, for weak comparison.
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestETag(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	lastModified := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		etag    httpx.ETag
+		method  string
+		header  map[string]string // request header
+		handler func(w http.ResponseWriter)
+		status  int
+		tagged  bool
+		body    string
+	}{
+		{
+			name:    "tagged",
+			handler: func(w http.ResponseWriter) { w.Write([]byte(body)) },
+			status:  http.StatusOK,
+			tagged:  true,
+			body:    body,
+		},
+		{
+			name:    "error",
+			handler: func(w http.ResponseWriter) { http.Error(w, "nope", http.StatusNotFound) },
+			status:  http.StatusNotFound,
+			body:    "nope\n",
+		},
+		{
+			name:    "too large",
+			etag:    httpx.ETag{MaxSize: 10},
+			handler: func(w http.ResponseWriter) { w.Write([]byte(body)) },
+			status:  http.StatusOK,
+			body:    body,
+		},
+		{
+			name: "skipped type",
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(body))
+			},
+			status: http.StatusOK,
+			body:   body,
+		},
+		{
+			name: "flushed",
+			handler: func(w http.ResponseWriter) {
+				w.Write([]byte(body))
+				w.(http.Flusher).Flush()
+			},
+			status: http.StatusOK,
+			body:   body,
+		},
+		{
+			name:    "post",
+			method:  "POST",
+			handler: func(w http.ResponseWriter) { w.Write([]byte(body)) },
+			status:  http.StatusOK,
+			body:    body,
+		},
+		{
+			name: "last modified",
+			header: map[string]string{
+				"If-Modified-Since": lastModified.Add(time.Hour).Format(http.TimeFormat),
+			},
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+				w.Write([]byte(body))
+			},
+			status: http.StatusNotModified,
+			tagged: true,
+		},
+		{
+			name:   "handler tag matches weakly",
+			header: map[string]string{"If-None-Match": `"v0", W/"v1"`},
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("ETag", `"v1"`)
+				w.Write([]byte(body))
+			},
+			status: http.StatusNotModified,
+			tagged: true,
+		},
+		{
+			name:   "handler tag does not match",
+			header: map[string]string{"If-None-Match": `"v0"`},
+			handler: func(w http.ResponseWriter) {
+				w.Header().Set("ETag", `"v1"`)
+				w.Write([]byte(body))
+			},
+			status: http.StatusOK,
+			tagged: true,
+			body:   body,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.etag.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				tt.handler(w)
+			}))
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status == %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("ETag") != ""; got != tt.tagged {
+				t.Errorf("tagged == %t, want %t", got, tt.tagged)
+			}
+			if got := rec.Body.String(); got != tt.body {
+				t.Errorf("body == %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestETagConditionalGET(t *testing.T) {
+	for _, weak := range []bool{false, true} {
+		h := httpx.ETag{Weak: weak}.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		etag := rec.Header().Get("ETag")
+		if strings.HasPrefix(etag, "W/") != weak {
+			t.Errorf("weak %t: ETag == %q", weak, etag)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("weak %t: status == %d, want %d", weak, rec.Code, http.StatusNotModified)
+		}
+		if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+			t.Errorf("weak %t: 304 response carries a body or content type", weak)
+		}
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Errorf("weak %t: 304 ETag == %q, want %q", weak, got, etag)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"acln.ro/log"
+)
+
+// An EventKind identifies the type of an Event. The set of kinds is
+// stable: kinds may be added, but are never renamed.
+type EventKind string
+
+// Event kinds emitted by this package.
+const (
+	EventReadOnlyEnabled  EventKind = "read_only_enabled"
+	EventReadOnlyDisabled EventKind = "read_only_disabled"
+	EventRequestShed      EventKind = "request_shed"
+)
+
+// An Event is a machine-readable record of something notable that
+// happened while serving requests.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+
+	// RequestID, Method and Path describe the request which caused the
+	// event, if any.
+	RequestID string
+	Method    string
+	Path      string
+
+	// Fields holds additional, kind-specific information.
+	Fields log.KV
+}
+
+func requestEvent(kind EventKind, req *http.Request, fields log.KV) Event {
+	path := Path(req)
+	if path == "" {
+		path = req.URL.Path
+	}
+	return Event{
+		Kind:      kind,
+		Time:      time.Now(),
+		RequestID: RequestID(req),
+		Method:    req.Method,
+		Path:      path,
+		Fields:    fields,
+	}
+}
+
+// An EventBus delivers events to subscribers. The zero value is ready to
+// use. Publishing never blocks: events which do not fit in the buffer of a
+// subscriber are dropped, and counted.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Events is the EventBus to which the middleware in this package publish
+// their events.
+var Events = new(EventBus)
+
+// A Subscription receives events from an EventBus.
+type Subscription struct {
+	// C delivers the events. It is closed by Close.
+	C <-chan Event
+
+	c       chan Event
+	bus     *EventBus
+	kinds   map[EventKind]bool
+	dropped uint64
+}
+
+// Subscribe returns a Subscription which buffers up to buffer events of
+// the specified kinds. If no kinds are specified, the subscription
+// receives events of all kinds.
+func (b *EventBus) Subscribe(buffer int, kinds ...EventKind) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c, bus: b}
+	if len(kinds) > 0 {
+		s.kinds = make(map[EventKind]bool)
+		for _, k := range kinds {
+			s.kinds[k] = true
+		}
+	}
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish delivers e to all interested subscribers. If e.Time is zero,
+// it is set to the current time.
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if s.kinds != nil && !s.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of events which were dropped because the
+// buffer of s was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes s and closes s.C. Events which are buffered already
+// can still be received.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
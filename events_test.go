@@ -0,0 +1,77 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestEventBus(t *testing.T) {
+	var bus httpx.EventBus
+	all := bus.Subscribe(8)
+	defer all.Close()
+	shed := bus.Subscribe(1, httpx.EventRequestShed)
+	defer shed.Close()
+
+	bus.Publish(httpx.Event{Kind: httpx.EventReadOnlyEnabled})
+	bus.Publish(httpx.Event{Kind: httpx.EventRequestShed, Path: "/a"})
+	bus.Publish(httpx.Event{Kind: httpx.EventRequestShed, Path: "/b"})
+
+	if n := len(all.C); n != 3 {
+		t.Errorf("all: got %d buffered events, want 3", n)
+	}
+	e := <-shed.C
+	if e.Kind != httpx.EventRequestShed || e.Path != "/a" || e.Time.IsZero() {
+		t.Errorf("shed: got %+v, want the first shed event, with a time", e)
+	}
+	if n := shed.Dropped(); n != 1 {
+		t.Errorf("shed: Dropped() == %d, want 1", n)
+	}
+
+	shed.Close()
+	bus.Publish(httpx.Event{Kind: httpx.EventRequestShed})
+	if _, ok := <-shed.C; ok {
+		t.Errorf("shed: received an event after Close")
+	}
+}
+
+func TestShedStaleEvent(t *testing.T) {
+	sub := httpx.Events.Subscribe(1, httpx.EventRequestShed)
+	defer sub.Close()
+
+	h := httpx.ShedStale(http.NotFoundHandler(), time.Second)
+	req := httptest.NewRequest("GET", "/slow", nil)
+	start := time.Now().Add(-time.Minute).UnixMilli()
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(start, 10))
+	h.ServeHTTP(httptest.NewRecorder(), httpx.WithRequestID(req, "r1"))
+
+	select {
+	case e := <-sub.C:
+		if e.RequestID != "r1" || e.Path != "/slow" {
+			t.Errorf("got %+v, want r1 /slow", e)
+		}
+		if d, _ := e.Fields["queue_delay"].(time.Duration); d < time.Minute {
+			t.Errorf("queue_delay == %v, want at least a minute", d)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrBodyDeferred is returned by reads from the body of a request whose
+// body was deferred by DeferBody, and not yet released.
+var ErrBodyDeferred = errors.New("httpx: request body read before release")
+
+// DeferBody returns a handler which defers the body of requests which
+// carry the "Expect: 100-continue" header, then calls next.
+//
+// The net/http server sends the 100 Continue interim response when the
+// request body is first read. Reads from a deferred body fail with
+// ErrBodyDeferred, without reading from the connection, until the body is
+// released using ReleaseBody or AllowBody. Placing DeferBody in front of
+// authentication or limit middleware, and ReleaseBody after them, ensures
+// that clients do not upload large bodies only to be rejected.
+func DeferBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !expectsContinue(req) || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		db := &deferredBody{ReadCloser: req.Body}
+		ctx := context.WithValue(req.Context(), deferredBodyKey, db)
+		req = req.WithContext(ctx)
+		req.Body = db
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ReleaseBody returns a handler which releases the body deferred by
+// DeferBody, then calls next.
+func ReleaseBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		AllowBody(req)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// AllowBody releases the body of req, deferred by DeferBody. If the body
+// was not deferred, AllowBody is a no-op.
+func AllowBody(req *http.Request) {
+	if db, ok := req.Context().Value(deferredBodyKey).(*deferredBody); ok {
+		atomic.StoreInt32(&db.released, 1)
+	}
+}
+
+func expectsContinue(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// deferredBody fails reads until it is released.
+type deferredBody struct {
+	io.ReadCloser
+	released int32
+}
+
+func (db *deferredBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&db.released) == 0 {
+		return 0, ErrBodyDeferred
+	}
+	return db.ReadCloser.Read(p)
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestDeferBody(t *testing.T) {
+	var body string
+	var readErr error
+	read := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, err := io.ReadAll(req.Body)
+		body, readErr = string(b), err
+	})
+	tests := []struct {
+		name    string
+		h       http.Handler
+		expect  string
+		want    string
+		wantErr error
+	}{
+		{name: "released", h: httpx.DeferBody(httpx.ReleaseBody(read)), expect: "100-continue", want: "data"},
+		{name: "not released", h: httpx.DeferBody(read), expect: "100-continue", wantErr: httpx.ErrBodyDeferred},
+		{name: "no expectation", h: httpx.DeferBody(read), want: "data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, readErr = "", nil
+			req := httptest.NewRequest("PUT", "/", strings.NewReader("data"))
+			if tt.expect != "" {
+				req.Header.Set("Expect", tt.expect)
+			}
+			tt.h.ServeHTTP(httptest.NewRecorder(), req)
+			if body != tt.want || readErr != tt.wantErr {
+				t.Errorf("got body %q, error %v, want %q, %v", body, readErr, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeferBodyRejected(t *testing.T) {
+	var uploaded bool
+	srv := httptest.NewServer(httpx.DeferBody(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			// The body is deferred: a buggy middleware which reads
+			// it anyway does not trigger the 100 Continue.
+			if _, err := io.ReadAll(req.Body); err == nil {
+				uploaded = true
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		httpx.AllowBody(req)
+		io.Copy(io.Discard, req.Body)
+	})))
+	defer srv.Close()
+
+	req, err := http.NewRequest("PUT", srv.URL, strings.NewReader(strings.Repeat("x", 1<<16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Expect", "100-continue")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if uploaded {
+		t.Error("deferred body was read before release")
+	}
+}
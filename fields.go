@@ -0,0 +1,166 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SelectFields returns a handler which prunes JSON responses produced by
+// next to the set of fields requested by the client in the "fields" query
+// parameter, e.g. "?fields=id,name,address.city". Nested fields are
+// separated by dots. When the response is an array, the selection applies
+// to each of its elements.
+//
+// If allow is not nil, clients may only select the fields it lists, or
+// fields nested under them. Requests for other fields are rejected with
+// status 400.
+//
+// Requests without a "fields" parameter, and responses which are not
+// successful JSON responses, are passed through unmodified. Selected
+// responses are buffered, decoded and re-encoded. Since the selection
+// changes the representation, the entity tag of a selected response, if
+// any, is replaced by a weak one, derived from the original and from the
+// selected fields.
+func SelectFields(next http.Handler, allow []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		param := req.URL.Query().Get("fields")
+		if param == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		var fields []string
+		for _, f := range strings.Split(param, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		for _, f := range fields {
+			if allow != nil && !fieldAllowed(f, allow) {
+				http.Error(w, "field "+strconv.Quote(f)+" cannot be selected", http.StatusBadRequest)
+				return
+			}
+		}
+
+		rb := newResponseBuffer()
+		next.ServeHTTP(rb, req)
+
+		mt, _, _ := mime.ParseMediaType(rb.header.Get("Content-Type"))
+		if rb.code/100 != 2 || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+			rb.sendTo(w)
+			return
+		}
+		pruned, err := PruneJSON(rb.body.Bytes(), fields)
+		if err != nil {
+			rb.sendTo(w)
+			return
+		}
+		rb.header.Del("Content-Length")
+		if etag := rb.header.Get("ETag"); etag != "" {
+			rb.header.Set("ETag", selectedETag(etag, fields))
+		}
+		rb.body.Reset()
+		rb.body.Write(pruned)
+		rb.sendTo(w)
+	})
+}
+
+// selectedETag derives the weak entity tag of the selection of fields
+// from a representation whose entity tag is etag.
+func selectedETag(etag string, fields []string) string {
+	h := sha256.New()
+	h.Write([]byte(weakETag(etag)))
+	for _, f := range fields {
+		h.Write([]byte{0})
+		h.Write([]byte(f))
+	}
+	return `W/"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// PruneJSON returns the JSON document doc, pruned to the specified fields.
+// Fields are expressed as dot-separated paths, as for SelectFields.
+func PruneJSON(doc []byte, fields []string) ([]byte, error) {
+	var v interface{}
+	if err := decodeJSON(doc, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(pruneValue(v, fieldTree(fields)))
+}
+
+// fieldSet is a tree of selected fields. A nil fieldSet selects
+// everything.
+type fieldSet map[string]fieldSet
+
+func fieldTree(fields []string) fieldSet {
+	root := make(fieldSet)
+	for _, f := range fields {
+		node := root
+		parts := strings.Split(strings.TrimSpace(f), ".")
+		for i, part := range parts {
+			child, ok := node[part]
+			if ok && child == nil {
+				break // selected in full already
+			}
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if !ok {
+				child = make(fieldSet)
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return root
+}
+
+func pruneValue(v interface{}, set fieldSet) interface{} {
+	if set == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(set))
+		for k, sub := range set {
+			if val, ok := v[k]; ok {
+				out[k] = pruneValue(val, sub)
+			}
+		}
+		return out
+	case []interface{}:
+		for i := range v {
+			v[i] = pruneValue(v[i], set)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func fieldAllowed(field string, allow []string) bool {
+	for _, a := range allow {
+		if field == a || strings.HasPrefix(field, a+".") {
+			return true
+		}
+	}
+	return false
+}
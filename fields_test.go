@@ -0,0 +1,93 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestPruneJSON(t *testing.T) {
+	tests := []struct {
+		doc    string
+		fields []string
+		want   string
+	}{
+		{`{"a":1,"b":2}`, []string{"a"}, `{"a":1}`},
+		{`{"a":{"x":1,"y":2},"b":2}`, []string{"a.x"}, `{"a":{"x":1}}`},
+		{`{"a":{"x":1,"y":2},"b":2}`, []string{"a.x", "a"}, `{"a":{"x":1,"y":2}}`},
+		{`[{"a":1,"b":2},{"a":3,"b":4}]`, []string{"b"}, `[{"b":2},{"b":4}]`},
+		{`{"items":[{"a":1,"b":2}]}`, []string{"items.a"}, `{"items":[{"a":1}]}`},
+		{`{"a":1}`, []string{"missing"}, `{}`},
+	}
+	for _, tt := range tests {
+		got, err := httpx.PruneJSON([]byte(tt.doc), tt.fields)
+		if err != nil {
+			t.Errorf("PruneJSON(%s, %v): %v", tt.doc, tt.fields, err)
+			continue
+		}
+		if !jsonEq(t, got, []byte(tt.want)) {
+			t.Errorf("PruneJSON(%s, %v) == %s, want %s", tt.doc, tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	h := httpx.SelectFields(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, `{"id":1,"name":"alice","secret":"x"}`)
+	}), []string{"id", "name"})
+
+	tests := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"", http.StatusOK, `{"id":1,"name":"alice","secret":"x"}`},
+		{"?fields=name", http.StatusOK, `{"name":"alice"}`},
+		{"?fields=id,%20name", http.StatusOK, `{"id":1,"name":"alice"}`},
+		{"?fields=secret", http.StatusBadRequest, ""},
+	}
+	etags := make(map[string]string)
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: got status %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && !jsonEq(t, rec.Body.Bytes(), []byte(tt.body)) {
+			t.Errorf("%q: got body %s, want %s", tt.query, rec.Body, tt.body)
+		}
+		if tt.status == http.StatusOK {
+			etag := rec.Header().Get("ETag")
+			if tt.query != "" && !strings.HasPrefix(etag, "W/") {
+				t.Errorf("%q: ETag == %q, want a weak ETag", tt.query, etag)
+			}
+			for q, other := range etags {
+				if other == etag {
+					t.Errorf("%q and %q share the ETag %q", tt.query, q, etag)
+				}
+			}
+			etags[tt.query] = etag
+		}
+	}
+}
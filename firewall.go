@@ -0,0 +1,173 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"acln.ro/log"
+)
+
+// A FirewallRule allows requests for a path prefix.
+type FirewallRule struct {
+	// Methods lists the allowed methods. If empty, all methods are
+	// allowed.
+	Methods []string
+
+	// Prefix is the allowed path prefix. Prefixes match whole path
+	// segments: "/api" matches "/api" and "/api/users", but not
+	// "/apix".
+	Prefix string
+}
+
+func (r FirewallRule) matchPath(p string) bool {
+	return hasPathPrefix(p, r.Prefix)
+}
+
+// hasPathPrefix reports whether the path p starts with the whole
+// segments of prefix.
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// cleanPath returns path.Clean(p), preserving a trailing slash. The empty
+// path is returned unchanged.
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	cp := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cp != "/" {
+		cp += "/"
+	}
+	return cp
+}
+
+// withCleanPath returns req if its path is clean, and a shallow copy of
+// req with the cleaned path otherwise, such that handlers which make
+// decisions based on the path agree with those downstream.
+func withCleanPath(req *http.Request) *http.Request {
+	cp := cleanPath(req.URL.Path)
+	if cp == req.URL.Path {
+		return req
+	}
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Path = cp
+	u.RawPath = ""
+	r.URL = &u
+	return r
+}
+
+// Firewall is a handler which only passes requests allowed by an explicit
+// list of rules to the next handler. Requests for paths which no rule
+// allows are rejected with 404 Not Found, and requests which use methods
+// not allowed for the path are rejected with 405 Method Not Allowed.
+//
+// Firewall is useful as the first line in front of legacy handler trees.
+// Paths are matched in their cleaned form, so "/public/../admin" does not
+// match the "/public" prefix, and allowed requests are passed to the next
+// handler with the cleaned path, such that it serves the path which the
+// rules allowed, rather than the one in the request line. The rules can be replaced at any time, for
+// lockdown modes driven by configuration reloads.
+type Firewall struct {
+	next   http.Handler
+	logger *log.Logger
+	rules  atomic.Value // of []FirewallRule
+}
+
+// NewFirewall returns a Firewall which passes the requests allowed by
+// rules to next. If logger is not nil, rejected requests are logged.
+func NewFirewall(next http.Handler, logger *log.Logger, rules ...FirewallRule) *Firewall {
+	fw := &Firewall{next: next, logger: logger}
+	fw.SetRules(rules)
+	return fw
+}
+
+// SetRules replaces the rules of the firewall. It is safe to call SetRules
+// concurrently with ServeHTTP.
+func (fw *Firewall) SetRules(rules []FirewallRule) {
+	fw.rules.Store(append([]FirewallRule(nil), rules...))
+}
+
+// Rules returns the current rules of the firewall.
+func (fw *Firewall) Rules() []FirewallRule {
+	return append([]FirewallRule(nil), fw.rules.Load().([]FirewallRule)...)
+}
+
+func (fw *Firewall) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req = withCleanPath(req)
+	p := req.URL.Path
+	if p == "" {
+		p = "/"
+	}
+	var (
+		pathAllowed bool
+		allow       []string
+	)
+	for _, r := range fw.rules.Load().([]FirewallRule) {
+		if !r.matchPath(p) {
+			continue
+		}
+		pathAllowed = true
+		if len(r.Methods) == 0 {
+			fw.next.ServeHTTP(w, req)
+			return
+		}
+		for _, m := range r.Methods {
+			if m == req.Method {
+				fw.next.ServeHTTP(w, req)
+				return
+			}
+			allow = append(allow, m)
+		}
+	}
+	if !pathAllowed {
+		fw.reject(req, http.StatusNotFound)
+		http.NotFound(w, req)
+		return
+	}
+	sort.Strings(allow)
+	w.Header().Set("Allow", strings.Join(dedupSorted(allow), ", "))
+	fw.reject(req, http.StatusMethodNotAllowed)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+func (fw *Firewall) reject(req *http.Request, status int) {
+	Annotate(req, "firewall", "rejected")
+	if fw.logger != nil {
+		RequestLogger(fw.logger, req).Info(log.KV{
+			"firewall": "rejected",
+			"status":   status,
+		})
+	}
+}
+
+// dedupSorted removes adjacent duplicates from the sorted slice s.
+func dedupSorted(s []string) []string {
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
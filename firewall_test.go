@@ -0,0 +1,103 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestFirewall(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	fw := httpx.NewFirewall(ok, nil,
+		httpx.FirewallRule{Prefix: "/public"},
+		httpx.FirewallRule{Prefix: "/api/", Methods: []string{"GET", "HEAD"}},
+		httpx.FirewallRule{Prefix: "/api/upload", Methods: []string{"POST"}},
+	)
+	tests := []struct {
+		method, path string
+		want         int
+		wantAllow    string
+	}{
+		{"GET", "/public", http.StatusOK, ""},
+		{"DELETE", "/public/x", http.StatusOK, ""},
+		{"GET", "/publicity", http.StatusNotFound, ""},
+		{"GET", "/public/../admin", http.StatusNotFound, ""},
+		{"GET", "/api/users", http.StatusOK, ""},
+		{"PUT", "/api/users", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"POST", "/api/upload", http.StatusOK, ""},
+		{"PUT", "/api/upload", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"GET", "/", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.URL.Path = tt.path
+		fw.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+		if allow := rec.Header().Get("Allow"); allow != tt.wantAllow {
+			t.Errorf("%s %s: Allow == %q, want %q", tt.method, tt.path, allow, tt.wantAllow)
+		}
+	}
+}
+
+func TestFirewallSetRules(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	fw := httpx.NewFirewall(ok, nil, httpx.FirewallRule{Prefix: "/"})
+	fw.SetRules([]httpx.FirewallRule{{Prefix: "/healthz"}})
+
+	rec := httptest.NewRecorder()
+	fw.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("after lockdown: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rules := fw.Rules(); len(rules) != 1 || rules[0].Prefix != "/healthz" {
+		t.Errorf("Rules == %v", rules)
+	}
+}
+
+func TestFirewallCleanPath(t *testing.T) {
+	// The next handler routes on the raw path, as Shift does.
+	var served []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served = append(served, req.URL.Path)
+	})
+	fw := httpx.NewFirewall(next, nil, httpx.FirewallRule{Prefix: "/public"})
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/admin/../public/x", "/public/x"},
+		{"/public//x/./y/", "/public/x/y/"},
+		{"/public/x", "/public/x"},
+	}
+	for _, tt := range tests {
+		served = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = tt.path
+		fw.ServeHTTP(httptest.NewRecorder(), req)
+		if len(served) != 1 || served[0] != tt.want {
+			t.Errorf("%s: next served %q, want %q", tt.path, served, tt.want)
+		}
+		if req.URL.Path != tt.path {
+			t.Errorf("%s: original request modified to %q", tt.path, req.URL.Path)
+		}
+	}
+}
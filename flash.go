@@ -0,0 +1,132 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// FlashCookie is the name of the cookie used by FlashHandler.
+const FlashCookie = "flash"
+
+// A Flash is a one-time message addressed to the user, typically displayed
+// on the page following a redirect.
+type Flash struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+type flashState struct {
+	in       []Flash
+	out      []Flash
+	consumed bool
+}
+
+// FlashHandler returns a handler which stores flash messages in a cookie
+// signed with key, then calls next. Messages added by AddFlash are
+// available to the next request from the same client, which typically
+// follows a redirect, and are removed once consumed by ConsumeFlashes.
+//
+// The key should be at least 32 bytes long, and must be kept secret.
+func FlashHandler(next http.Handler, key []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		state := new(flashState)
+		if c, err := req.Cookie(FlashCookie); err == nil {
+			state.in = decodeFlashes(c.Value, key)
+		}
+		w, finish := beforeWrite(w, func() {
+			switch {
+			case len(state.out) > 0:
+				http.SetCookie(w, &http.Cookie{
+					Name:     FlashCookie,
+					Value:    encodeFlashes(state.out, key),
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			case state.consumed && len(state.in) > 0:
+				http.SetCookie(w, &http.Cookie{
+					Name:   FlashCookie,
+					Path:   "/",
+					MaxAge: -1,
+				})
+			}
+		})
+		ctx := context.WithValue(req.Context(), flashKey, state)
+		next.ServeHTTP(w, req.WithContext(ctx))
+		finish()
+	})
+}
+
+// AddFlash adds a flash message for the next request. It must be called
+// before the response header is written. If req was not served by
+// FlashHandler, AddFlash is a no-op.
+func AddFlash(req *http.Request, level, msg string) {
+	state, ok := req.Context().Value(flashKey).(*flashState)
+	if !ok {
+		return
+	}
+	state.out = append(state.out, Flash{Level: level, Message: msg})
+}
+
+// ConsumeFlashes returns the flash messages added by the previous request,
+// and marks them as consumed, such that they are not seen again.
+func ConsumeFlashes(req *http.Request) []Flash {
+	state, ok := req.Context().Value(flashKey).(*flashState)
+	if !ok {
+		return nil
+	}
+	state.consumed = true
+	return state.in
+}
+
+func encodeFlashes(flashes []Flash, key []byte) string {
+	b, _ := json.Marshal(flashes)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + sign(payload, key)
+}
+
+func decodeFlashes(value string, key []byte) []Flash {
+	idx := strings.LastIndexByte(value, '.')
+	if idx == -1 {
+		return nil
+	}
+	payload, sig := value[:idx], value[idx+1:]
+	if !hmac.Equal([]byte(sig), []byte(sign(payload, key))) {
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+	var flashes []Flash
+	if err := json.Unmarshal(b, &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
+
+// sign returns the base64 encoded HMAC-SHA256 of payload under key.
+func sign(payload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestFlash(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	var got []httpx.Flash
+	h := httpx.FlashHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/submit":
+			httpx.AddFlash(req, "info", "saved")
+			http.Redirect(w, req, "/show", http.StatusSeeOther)
+		case "/show":
+			got = httpx.ConsumeFlashes(req)
+		}
+	}), key)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/submit", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != httpx.FlashCookie {
+		t.Fatalf("got cookies %v, want one flash cookie", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/show", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if len(got) != 1 || got[0] != (httpx.Flash{Level: "info", Message: "saved"}) {
+		t.Fatalf("ConsumeFlashes == %v", got)
+	}
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Fatalf("consumed flash cookie was not cleared: %v", cleared)
+	}
+
+	// A tampered cookie yields no messages.
+	req = httptest.NewRequest("GET", "/show", nil)
+	cookies[0].Value = "x" + cookies[0].Value
+	req.AddCookie(cookies[0])
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if len(got) != 0 {
+		t.Fatalf("tampered cookie: ConsumeFlashes == %v", got)
+	}
+}
@@ -1,8 +1,8 @@
 module acln.ro/httpx
 
-go 1.12
+go 1.21
 
 require (
 	acln.ro/log v0.2.0
-	github.com/felixge/httpsnoop v1.0.0
+	github.com/felixge/httpsnoop v1.0.4
 )
@@ -0,0 +1,167 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"acln.ro/log"
+)
+
+// Errors which handlers return to have them mapped to client error
+// responses by an ErrorMapper. They are typically wrapped, such that the
+// message of the wrapping error, which is sent to the client as the
+// detail of the problem, says more:
+//
+//	return fmt.Errorf("user %q: %w", name, httpx.ErrNotFound)
+//
+// For this reason, and unlike other errors in this package, their
+// messages are not prefixed with "httpx:".
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+
+	ErrNotAcceptable        = errors.New("not acceptable")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// errorStatus maps the sentinel errors to status codes.
+var errorStatus = []struct {
+	err    error
+	status int
+}{
+	{ErrBadRequest, http.StatusBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrForbidden, http.StatusForbidden},
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+	{ErrNotAcceptable, http.StatusNotAcceptable},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType},
+	{ErrTimeout, http.StatusServiceUnavailable},
+}
+
+// A HandlerE is an http.Handler which may fail, by returning an error.
+// Its ServeHTTP method maps errors to responses using DefaultErrorMapper.
+type HandlerE func(w http.ResponseWriter, req *http.Request) error
+
+func (h HandlerE) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	DefaultErrorMapper.Handler(h).ServeHTTP(w, req)
+}
+
+// An ErrorMapper converts the errors returned by HandlerE values to
+// responses, in a single place, such that errors are reported
+// consistently across an API.
+type ErrorMapper struct {
+	// Map, if not nil, is consulted first, and maps err to a problem.
+	// If it returns nil, the default mapping applies.
+	Map func(err error) *Problem
+
+	// Logger, if not nil, is the base of the request-scoped loggers,
+	// as created by RequestLogger, with which errors mapped to server
+	// error responses are logged, if the request context stores no
+	// logger, as stored by AccessLog or WithLogger.
+	Logger *log.Logger
+
+	// BufferSize, if positive, is the number of bytes of the response
+	// body which are buffered, by means of a BufferedWriter, such that
+	// if the handler fails after starting to write a response, the
+	// partial response is replaced by the problem. Responses larger
+	// than BufferSize are streamed, as if BufferSize were zero.
+	BufferSize int
+}
+
+// DefaultErrorMapper is the ErrorMapper used by HandlerE.ServeHTTP.
+var DefaultErrorMapper = new(ErrorMapper)
+
+// Problem maps err to a problem. By default, a *Problem in the chain of
+// err is used as is, and the sentinel errors in this package map to their
+// respective status codes, with the message of err as the detail, as do
+// errors for which IsBodyTooLarge is true. All other errors map to 500
+// Internal Server Error, with no detail, such that their messages, which
+// may reveal internals, are not sent to clients.
+func (m *ErrorMapper) Problem(err error) *Problem {
+	if m.Map != nil {
+		if p := m.Map(err); p != nil {
+			return p
+		}
+	}
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	for _, es := range errorStatus {
+		if errors.Is(err, es.err) {
+			return NewProblem(es.status, err.Error())
+		}
+	}
+	if IsBodyTooLarge(err) {
+		return NewProblem(http.StatusRequestEntityTooLarge, "")
+	}
+	return NewProblem(http.StatusInternalServerError, "")
+}
+
+// Handler returns an http.Handler which calls h, and maps the errors it
+// returns to problem details responses, written by WriteProblem. Errors
+// are recorded using SetError. Errors mapped to server error responses
+// are logged using the request logger, unless the request was canceled by the client. If h has
+// written the response header by the time it fails, and the response
+// cannot be discarded, as configured by BufferSize, the error is only
+// recorded and logged.
+func (m *ErrorMapper) Handler(h HandlerE) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var (
+			bw    *BufferedWriter
+			wrote bool
+		)
+		ww := w
+		if m.BufferSize > 0 {
+			bw = NewBufferedWriter(w, m.BufferSize)
+			defer bw.Commit()
+			ww = bw
+		} else {
+			ww, _ = beforeWrite(w, func() { wrote = true })
+		}
+		err := h(ww, req)
+		if err == nil {
+			return
+		}
+		if bw != nil {
+			wrote = !bw.Reset()
+			w = bw
+		}
+		SetError(req, err)
+		p := m.Problem(err)
+		if p.Status >= 500 && !errors.Is(req.Context().Err(), context.Canceled) {
+			logger := Logger(req)
+			if logger == nil && m.Logger != nil {
+				logger = RequestLogger(m.Logger, req)
+			}
+			if logger != nil {
+				logger.Error(log.KV{
+					"error":  err.Error(),
+					"status": p.Status,
+				})
+			}
+		}
+		if !wrote {
+			WriteProblem(w, req, p)
+		}
+	})
+}
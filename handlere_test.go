@@ -0,0 +1,116 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+	"acln.ro/log"
+)
+
+func TestErrorMapper(t *testing.T) {
+	errCustom := errors.New("custom")
+	var buf bytes.Buffer
+	m := &httpx.ErrorMapper{
+		Map: func(err error) *httpx.Problem {
+			if errors.Is(err, errCustom) {
+				return httpx.NewProblem(http.StatusTeapot, "short and stout")
+			}
+			return nil
+		},
+		Logger: log.New(&buf),
+	}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		detail string
+		logged bool
+	}{
+		{name: "nil", status: http.StatusOK},
+		{name: "not found", err: fmt.Errorf("user %q: %w", "bob", httpx.ErrNotFound), status: http.StatusNotFound, detail: `user "bob": not found`},
+		{name: "unauthorized", err: httpx.ErrUnauthorized, status: http.StatusUnauthorized, detail: "unauthorized"},
+		{name: "media type", err: fmt.Errorf("%w %q", httpx.ErrUnsupportedMediaType, "text/csv"), status: http.StatusUnsupportedMediaType, detail: `unsupported media type "text/csv"`},
+		{name: "problem", err: fmt.Errorf("wrapped: %w", httpx.NewProblem(http.StatusConflict, "stale")), status: http.StatusConflict, detail: "stale"},
+		{name: "custom", err: errCustom, status: http.StatusTeapot, detail: "short and stout"},
+		{name: "internal", err: errors.New("db password is hunter2"), status: http.StatusInternalServerError, logged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			h := m.Handler(func(w http.ResponseWriter, req *http.Request) error {
+				return tt.err
+			})
+			req := httpx.WithRequestState(httptest.NewRequest("GET", "/", nil))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status == %d, want %d", rec.Code, tt.status)
+			}
+			if got := httpx.RequestError(req); got != tt.err {
+				t.Errorf("RequestError == %v, want %v", got, tt.err)
+			}
+			if tt.err != nil {
+				var p httpx.Problem
+				if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+					t.Fatal(err)
+				}
+				if p.Detail != tt.detail {
+					t.Errorf("detail == %q, want %q", p.Detail, tt.detail)
+				}
+			}
+			if logged := buf.Len() > 0; logged != tt.logged {
+				t.Errorf("logged == %t, want %t: %q", logged, tt.logged, buf.String())
+			}
+		})
+	}
+}
+
+func TestHandlerEAfterWrite(t *testing.T) {
+	h := httpx.HandlerE(func(w http.ResponseWriter, req *http.Request) error {
+		w.Write([]byte("partial"))
+		return errors.New("broken pipe")
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandlerERequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := httpx.HandlerE(func(w http.ResponseWriter, req *http.Request) error {
+		return errors.New("db unavailable")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(httpx.ContextWithLogger(req.Context(), log.New(&buf)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(buf.String(), "db unavailable") {
+		t.Errorf("error not logged with the request logger: %q", buf.String())
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHealthTimeout is the time limit of a health check, unless
+// configured otherwise.
+const DefaultHealthTimeout = 5 * time.Second
+
+// Health is a registry of named health checks. It serves liveness and
+// readiness endpoints, and tracks whether the service is shutting down.
+// The zero value is ready to use, and reports ready.
+type Health struct {
+	// Timeout is the time limit of each check. If zero,
+	// DefaultHealthTimeout is used.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	live     []*healthCheck
+	ready    []*healthCheck
+	draining bool
+}
+
+type healthCheck struct {
+	name    string
+	check   func(ctx context.Context) error
+	running int32 // 1 while a run of check is in flight
+}
+
+// errHealthCheckRunning is the error of checks whose previous run has not
+// returned yet.
+var errHealthCheckRunning = errors.New("previous run still in progress")
+
+// Live registers a liveness check. A failing liveness check indicates
+// that the process is wedged, and should be restarted. Liveness checks
+// are also readiness checks.
+func (h *Health) Live(name string, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.live = append(h.live, &healthCheck{name: name, check: check})
+}
+
+// Ready registers a readiness check. A failing readiness check indicates
+// that the service cannot serve requests for now, e.g. because a
+// database is unreachable.
+func (h *Health) Ready(name string, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = append(h.ready, &healthCheck{name: name, check: check})
+}
+
+// Drain marks the service as shutting down. From then on, the readiness
+// endpoint reports failure, so that load balancers stop routing new
+// requests to the service, while in-flight requests complete. Drain is
+// typically called before http.Server.Shutdown.
+func (h *Health) Drain() {
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+}
+
+// A HealthReport is the result of running health checks.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of a single health check.
+type HealthCheck struct {
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// OK reports whether all checks passed.
+func (hr HealthReport) OK() bool {
+	return hr.Status == "ok"
+}
+
+// CheckLive runs the liveness checks concurrently.
+func (h *Health) CheckLive(ctx context.Context) HealthReport {
+	h.mu.Lock()
+	checks := append([]*healthCheck(nil), h.live...)
+	h.mu.Unlock()
+	return h.run(ctx, checks, false)
+}
+
+// CheckReady runs the liveness and readiness checks concurrently. While
+// draining, the report fails, and the checks are not run.
+func (h *Health) CheckReady(ctx context.Context) HealthReport {
+	h.mu.Lock()
+	checks := append(append([]*healthCheck(nil), h.live...), h.ready...)
+	draining := h.draining
+	h.mu.Unlock()
+	return h.run(ctx, checks, draining)
+}
+
+func (h *Health) run(ctx context.Context, checks []*healthCheck, draining bool) HealthReport {
+	if draining {
+		return HealthReport{Status: "draining"}
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]HealthCheck, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	hr := HealthReport{Status: "ok", Checks: make(map[string]HealthCheck)}
+	for i, c := range checks {
+		if results[i].Status != "ok" {
+			hr.Status = "fail"
+		}
+		hr.Checks[c.name] = results[i]
+	}
+	return hr
+}
+
+// runHealthCheck runs c, giving up once ctx is done, even if c does not
+// observe ctx. A check which does not observe ctx keeps running after
+// that, so until it returns, c is not run again, and is reported as
+// failing, so that a wedged check does not leak a goroutine per probe.
+func runHealthCheck(ctx context.Context, c *healthCheck) HealthCheck {
+	start := time.Now()
+	var err error
+	if atomic.CompareAndSwapInt32(&c.running, 0, 1) {
+		done := make(chan error, 1)
+		go func() {
+			defer atomic.StoreInt32(&c.running, 0)
+			done <- c.check(ctx)
+		}()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	} else {
+		err = errHealthCheckRunning
+	}
+	hc := HealthCheck{Status: "ok", Duration: time.Since(start)}
+	if err != nil {
+		hc.Status, hc.Error = "fail", err.Error()
+	}
+	return hc
+}
+
+// LiveHandler returns a handler which serves the /healthz endpoint. It
+// runs the liveness checks, and responds with the HealthReport as JSON,
+// with status 200 OK if all checks passed, or 503 Service Unavailable
+// otherwise.
+func (h *Health) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeHealthReport(w, h.CheckLive(req.Context()))
+	})
+}
+
+// ReadyHandler returns a handler which serves the /readyz endpoint, like
+// LiveHandler, but using CheckReady.
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeHealthReport(w, h.CheckReady(req.Context()))
+	})
+}
+
+// ServeHTTP serves the /healthz and /readyz endpoints by means of Shift,
+// and responds with 404 Not Found to other requests.
+func (h *Health) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch Shift(req) {
+	case "healthz":
+		h.LiveHandler().ServeHTTP(w, req)
+	case "readyz":
+		h.ReadyHandler().ServeHTTP(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func writeHealthReport(w http.ResponseWriter, hr HealthReport) {
+	CachePolicy{NoStore: true}.Apply(w.Header())
+	status := http.StatusOK
+	if !hr.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	WriteJSON(w, status, hr)
+}
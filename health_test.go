@@ -0,0 +1,124 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestHealth(t *testing.T) {
+	h := &httpx.Health{Timeout: 50 * time.Millisecond}
+	h.Live("loop", func(context.Context) error { return nil })
+	dbErr := errors.New("connection refused")
+	var dbDown bool
+	h.Ready("db", func(context.Context) error {
+		if dbDown {
+			return dbErr
+		}
+		return nil
+	})
+	h.Ready("cache", func(ctx context.Context) error {
+		return ctx.Err()
+	})
+
+	get := func(path string) (int, httpx.HealthReport) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var hr httpx.HealthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &hr); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return rec.Code, hr
+	}
+
+	if code, hr := get("/readyz"); code != http.StatusOK || len(hr.Checks) != 3 {
+		t.Errorf("readyz: got %d with %d checks, want 200 with 3", code, len(hr.Checks))
+	}
+	dbDown = true
+	code, hr := get("/readyz")
+	if code != http.StatusServiceUnavailable || hr.Checks["db"].Error != dbErr.Error() {
+		t.Errorf("readyz with db down: got %d %+v, want 503 with the db error", code, hr)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz with db down: got %d, want 200", code)
+	}
+	dbDown = false
+	h.Drain()
+	if code, hr := get("/readyz"); code != http.StatusServiceUnavailable || hr.Status != "draining" {
+		t.Errorf("readyz while draining: got %d %q, want 503 draining", code, hr.Status)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz while draining: got %d, want 200", code)
+	}
+}
+
+func TestHealthTimeout(t *testing.T) {
+	h := &httpx.Health{Timeout: 10 * time.Millisecond}
+	block := make(chan struct{})
+	defer close(block)
+	h.Live("wedged", func(context.Context) error {
+		<-block
+		return nil
+	})
+	hr := h.CheckLive(context.Background())
+	if hr.OK() {
+		t.Fatal("wedged check passed")
+	}
+	if got, want := hr.Checks["wedged"].Error, context.DeadlineExceeded.Error(); got != want {
+		t.Errorf("Error == %q, want %q", got, want)
+	}
+}
+
+func TestHealthWedgedNotRerun(t *testing.T) {
+	h := &httpx.Health{Timeout: 10 * time.Millisecond}
+	block := make(chan struct{})
+	returned := make(chan struct{})
+	var runs int32
+	h.Live("wedged", func(context.Context) error {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			<-block
+			defer close(returned)
+		}
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		if hr := h.CheckLive(context.Background()); hr.OK() {
+			t.Fatalf("probe %d: wedged check passed", i)
+		}
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("wedged check ran %d times, want 1", n)
+	}
+
+	// Once the previous run returns, the check runs again.
+	close(block)
+	<-returned
+	deadline := time.Now().Add(time.Second)
+	for !h.CheckLive(context.Background()).OK() {
+		if time.Now().After(deadline) {
+			t.Fatal("check did not recover")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
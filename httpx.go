@@ -19,6 +19,7 @@ package httpx
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 	"strings"
 	"time"
@@ -61,8 +62,28 @@ func shift(path string) (seg string, rest string) {
 type key int
 
 const (
-	pathKey      key = 0
-	requestIDKey key = 1
+	pathKey               key = 0
+	requestIDKey          key = 1
+	selfTestKey           key = 2
+	traceKey              key = 3
+	untrustedRequestIDKey key = 4
+	flashKey              key = 5
+	correlationIDKey      key = 6
+	batchKey              key = 7
+	baggageKey            key = 8
+	stateKey              key = 9
+	parentRequestIDKey    key = 10
+	attemptKey            key = 11
+	propagatedKey         key = 12
+	loggerKey             key = 13
+	clientIPKey           key = 14
+	deferredBodyKey       key = 15
+	mountKey              key = 16
+	chainFrameKey         key = 17
+	userKey               key = 18
+	claimsKey             key = 19
+	sessionKey            key = 20
+	idCodecKey            key = 21
 )
 
 // WithPath stores req.URL.Path in the context associated with req, and
@@ -73,72 +94,154 @@ const (
 // If the request context stores a path already, WithPath is a no-op
 // and returns req.
 func WithPath(req *http.Request) *http.Request {
-	ctx := req.Context()
-	if ctx.Value(pathKey) != nil {
-		return req
-	}
-	vctx := context.WithValue(ctx, pathKey, req.URL.Path)
-	return req.WithContext(vctx)
+	return withContext(req, ContextWithPath(req.Context(), req.URL.Path))
 }
 
 // Path returns the original URL.Path associated with req. If the context
 // associated with req does not store a path, Path returns the empty string.
 func Path(req *http.Request) string {
-	val := req.Context().Value(pathKey)
-	if val == nil {
-		return ""
-	}
-	return val.(string)
+	return PathFromContext(req.Context())
+}
+
+// ContextWithPath returns a copy of ctx which stores path. If ctx stores
+// a path already, ContextWithPath returns ctx.
+func ContextWithPath(ctx context.Context, path string) context.Context {
+	return contextWithString(ctx, pathKey, path)
+}
+
+// PathFromContext returns the path stored in ctx, or the empty string.
+func PathFromContext(ctx context.Context) string {
+	return stringFromContext(ctx, pathKey)
 }
 
 // WithRequestID assigns an identifier to an HTTP request, if one is not
 // assigned already.
 func WithRequestID(req *http.Request, id string) *http.Request {
-	ctx := req.Context()
-	val := ctx.Value(requestIDKey)
-	if val != nil {
-		return req
-	}
-	return req.WithContext(context.WithValue(ctx, requestIDKey, id))
+	return withContext(req, ContextWithRequestID(req.Context(), id))
 }
 
 // RequestID returns the identifier associated with the request.
 func RequestID(req *http.Request) string {
-	val := req.Context().Value(requestIDKey)
+	return RequestIDFromContext(req.Context())
+}
+
+// ContextWithRequestID returns a copy of ctx which stores the request
+// identifier id. If ctx stores a request identifier already,
+// ContextWithRequestID returns ctx.
+//
+// ContextWithRequestID is useful where no *http.Request is at hand,
+// such as in background jobs started on behalf of a request.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return contextWithString(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request identifier stored in ctx,
+// or the empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	return stringFromContext(ctx, requestIDKey)
+}
+
+// WithCorrelationID assigns a correlation identifier to an HTTP request, if
+// one is not assigned already. Unlike the request identifier, which names
+// a single hop, the correlation identifier names the whole operation a
+// request is part of, and is shared by all requests made on its behalf.
+func WithCorrelationID(req *http.Request, id string) *http.Request {
+	return withContext(req, ContextWithCorrelationID(req.Context(), id))
+}
+
+// CorrelationID returns the correlation identifier associated with the
+// request.
+func CorrelationID(req *http.Request) string {
+	return CorrelationIDFromContext(req.Context())
+}
+
+// ContextWithCorrelationID returns a copy of ctx which stores the
+// correlation identifier id. If ctx stores a correlation identifier
+// already, ContextWithCorrelationID returns ctx.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return contextWithString(ctx, correlationIDKey, id)
+}
+
+// CorrelationIDFromContext returns the correlation identifier stored in
+// ctx, or the empty string.
+func CorrelationIDFromContext(ctx context.Context) string {
+	return stringFromContext(ctx, correlationIDKey)
+}
+
+func contextWithString(ctx context.Context, k key, s string) context.Context {
+	if ctx.Value(k) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, k, s)
+}
+
+func stringFromContext(ctx context.Context, k key) string {
+	val := ctx.Value(k)
 	if val == nil {
 		return ""
 	}
 	return val.(string)
 }
 
+// withContext is like req.WithContext, but returns req itself if its
+// context is ctx already.
+func withContext(req *http.Request, ctx context.Context) *http.Request {
+	if ctx == req.Context() {
+		return req
+	}
+	return req.WithContext(ctx)
+}
+
 // RequestLogger returns a logger scoped to the specified request. The logger
-// records the "method", "path", "remote_addr" and "user_agent" keys. If present,
-// it also records the "request_id" key.
+// records the "method", "path", "remote_addr" and "user_agent" keys. If
+// present, it also records the "request_id", "correlation_id",
+// "parent_request_id" and "attempt" keys, and the "trace_id" and "span_id"
+// keys of the trace context. Fields set using SetBaggage are recorded as
+// well, unless they collide with any of the keys above. To record a
+// different set of fields, use RequestLoggerOptions.
 func RequestLogger(base *log.Logger, req *http.Request) *log.Logger {
-	kv := log.KV{
-		"method":      req.Method,
-		"path":        Path(req),
-		"remote_addr": req.RemoteAddr,
-	}
-	if ua := req.UserAgent(); ua != "" {
-		kv["user_agent"] = ua
-	}
-	if id := RequestID(req); id != "" {
-		kv["request_id"] = id
-	}
-	return base.WithKV(kv)
+	return base.WithKV(requestKV(req))
+}
+
+// requestKV returns the key-value pairs recorded by RequestLogger.
+func requestKV(req *http.Request) log.KV {
+	return RequestLoggerOptions{}.KV(req)
 }
 
 // ServeInstrumented instruments w, wraps h, and calls the wrapped handler
 // with the instrumented http.ResponseWriter and the specified *http.Request.
 // It returns a summary of the request.
+//
+// ServeInstrumented installs request state using WithRequestState, and
+// records the "handler", "first_byte" and "last_byte" marks in the timeline
+// of the request.
 func ServeInstrumented(h http.Handler, w http.ResponseWriter, req *http.Request) Summary {
-	m := httpsnoop.CaptureMetrics(h, w, req)
-	return Summary{
-		Status:   m.Code,
-		Duration: m.Duration,
-		Written:  m.Written,
+	req = WithRequestState(req)
+	rec := newRecorder(req)
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.WithContext(req.Context())
+		req.Body = &countingReader{ReadCloser: req.Body, n: &rec.read}
+	}
+	addMarkAt(req, "handler", rec.start)
+	h.ServeHTTP(httpsnoop.Wrap(w, rec.hooks()), req)
+	if rec.wroteHeader {
+		addMarkAt(req, "first_byte", rec.firstByte)
+	}
+	if !rec.lastByte.IsZero() {
+		addMarkAt(req, "last_byte", rec.lastByte)
 	}
+	s := rec.summary()
+	s.Timeline = RequestTimeline(req)
+	s.Err = RequestError(req)
+	s.Annotations = Annotations(req)
+	s.UpstreamFailure = RequestUpstreamFailure(req)
+	s.CancelCause = CancelCause(req)
+	s.Route = Route(req)
+	s.ServerTiming = requestTimings(req)
+	if t, ok := RequestStart(req); ok {
+		s.QueueDelay = queueDelay(t, rec.start)
+	}
+	return s
 }
 
 // Summary is a summary of an HTTP server response.
@@ -147,21 +250,126 @@ type Summary struct {
 	// if no status was written explicitly.
 	Status int
 
+	// Route is the route pattern of the request, as recorded by
+	// SetRoute, or the empty string.
+	Route string
+
 	// Duration measures the duration of the request.
 	Duration time.Duration
 
+	// QueueDelay measures the time between the moment an upstream proxy
+	// received the request, as reported by RequestStart, and the moment
+	// the request reached the handler. It is zero if no proxy reported
+	// the time.
+	QueueDelay time.Duration
+
+	// TimeToFirstByte measures the time until the response header was
+	// written, and WriteDuration the time from then until the handler
+	// returned. Together, they separate slow handlers from slow
+	// streaming. Both are zero if the handler wrote nothing.
+	TimeToFirstByte time.Duration
+	WriteDuration   time.Duration
+
 	// Written typically counts the number of bytes written to the HTTP
 	// response body.
 	Written int64
+
+	// BytesRead counts the number of bytes of the request body consumed
+	// by the handler. Comparing it against the Content-Length of the
+	// request detects handlers which do not drain request bodies.
+	BytesRead int64
+
+	// Timeline holds the marks recorded while serving the request.
+	Timeline Timeline
+
+	// Proto is the protocol version of the request, e.g. "HTTP/1.1"
+	// or "HTTP/2.0".
+	Proto string
+
+	// TLSVersion and CipherSuite describe the TLS connection on which
+	// the request was received, as the constants in package crypto/tls.
+	// They are zero for requests received over plain HTTP.
+	TLSVersion  uint16
+	CipherSuite uint16
+
+	// Err is the error recorded by the handler using SetError, if any.
+	Err error
+
+	// Annotations holds the fields recorded using Annotate.
+	Annotations log.KV
+
+	// UpstreamFailure classifies the failure of the upstream on whose
+	// behalf the request was served, if any, as recorded by proxying
+	// handlers such as SignedUpstream.
+	UpstreamFailure UpstreamFailure
+
+	// CancelCause is the reason for which the request context was
+	// canceled, if it was, as reported by CancelCause.
+	CancelCause error
+
+	// ServerTiming holds the metrics recorded using Timing.
+	ServerTiming ServerTimings
 }
 
 // KV returns key-value pairs representing the Summary, suitable for logging
-// using a acln.ro/log.Logger. The "status", "duration" and "written" keys
-// are used.
+// using a acln.ro/log.Logger. The "status", "duration", "written" and "read"
+// keys are used. The route, if known, is recorded under the "route" key.
+// The queueing delay, if known, is recorded under the
+// "queue_delay" key. If the handler wrote a response, the time to first byte
+// and the write duration are recorded under the "ttfb" and "write_duration"
+// keys. If the timeline is not empty, it is recorded under the
+// "timeline" key. The protocol version is recorded under the "proto" key,
+// and, for TLS connections, the names of the TLS version and cipher suite
+// under the "tls_version" and "cipher_suite" keys. For responses with a
+// 5xx status, the recorded error, if any, is recorded under the "error" key.
+// Upstream failures are recorded under the "upstream_failure" key, and
+// cancellation causes under the "cancel_cause" key. Timing metrics are
+// recorded under the "server_timing" key, in the Server-Timing format.
+// Annotations are recorded as well, unless they collide with any of the
+// keys above.
 func (s Summary) KV() log.KV {
-	return log.KV{
+	kv := log.KV{
 		"status":   s.Status,
 		"duration": s.Duration,
 		"written":  s.Written,
+		"read":     s.BytesRead,
+	}
+	if s.Route != "" {
+		kv["route"] = s.Route
+	}
+	if s.QueueDelay > 0 {
+		kv["queue_delay"] = s.QueueDelay
+	}
+	if s.TimeToFirstByte > 0 {
+		kv["ttfb"] = s.TimeToFirstByte
+		kv["write_duration"] = s.WriteDuration
+	}
+	if len(s.Timeline) > 0 {
+		kv["timeline"] = s.Timeline.String()
+	}
+	if s.Proto != "" {
+		kv["proto"] = s.Proto
+	}
+	if s.TLSVersion != 0 {
+		kv["tls_version"] = tls.VersionName(s.TLSVersion)
+		kv["cipher_suite"] = tls.CipherSuiteName(s.CipherSuite)
+	}
+	if s.Err != nil && s.Status >= 500 {
+		kv["error"] = s.Err.Error()
+	}
+	if s.UpstreamFailure != "" {
+		kv["upstream_failure"] = string(s.UpstreamFailure)
+	}
+	if s.CancelCause != nil {
+		kv["cancel_cause"] = s.CancelCause.Error()
+	}
+	if len(s.ServerTiming) > 0 {
+		kv["server_timing"] = s.ServerTiming.String()
+	}
+	for k, v := range s.Annotations {
+		if _, ok := kv[k]; !ok {
+			kv[k] = v
+		}
 	}
+	return kv
 }
@@ -15,7 +15,9 @@
 package httpx_test
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
@@ -92,3 +94,66 @@ func TestContext(t *testing.T) {
 		t.Fatalf("Path after Shift returned %q, want %q", p, path)
 	}
 }
+
+func TestCorrelationID(t *testing.T) {
+	req, err := http.NewRequest("", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := httpx.CorrelationID(req); id != "" {
+		t.Fatalf("CorrelationID: got %q on request with no correlation ID", id)
+	}
+
+	req = httpx.WithRequestID(req, "hop")
+	req = httpx.WithCorrelationID(req, "operation")
+	if id := httpx.CorrelationID(req); id != "operation" {
+		t.Fatalf("CorrelationID == %q, want %q", id, "operation")
+	}
+	if id := httpx.RequestID(req); id != "hop" {
+		t.Fatalf("RequestID == %q, want %q", id, "hop")
+	}
+
+	old := req
+	new := httpx.WithCorrelationID(old, "other")
+	if old != new {
+		t.Fatalf("different requests with correlation ID present")
+	}
+}
+
+func TestContextVariants(t *testing.T) {
+	ctx := context.Background()
+	ctx = httpx.ContextWithRequestID(ctx, "id")
+	ctx = httpx.ContextWithPath(ctx, "/a/b")
+	ctx = httpx.ContextWithCorrelationID(ctx, "op")
+
+	if same := httpx.ContextWithRequestID(ctx, "other"); same != ctx {
+		t.Fatal("ContextWithRequestID replaced an existing identifier")
+	}
+
+	req, err := http.NewRequest("", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	if id := httpx.RequestID(req); id != "id" {
+		t.Errorf("RequestID == %q, want %q", id, "id")
+	}
+	if p := httpx.Path(req); p != "/a/b" {
+		t.Errorf("Path == %q, want %q", p, "/a/b")
+	}
+	if id := httpx.CorrelationID(req); id != "op" {
+		t.Errorf("CorrelationID == %q, want %q", id, "op")
+	}
+	if id := httpx.RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("RequestIDFromContext on empty context == %q", id)
+	}
+}
+
+func TestNoDefaultServeMuxHandlers(t *testing.T) {
+	for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+		req := httptest.NewRequest("GET", path, nil)
+		if _, pattern := http.DefaultServeMux.Handler(req); pattern != "" {
+			t.Errorf("%s is registered on http.DefaultServeMux as %q", path, pattern)
+		}
+	}
+}
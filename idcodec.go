@@ -0,0 +1,108 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// An IDCodec transforms request identifiers into the form exposed in
+// headers, and back. Codecs prevent internal identifiers, such as
+// sequential counters, from leaking to clients, and let servers verify
+// that inbound identifiers were issued by them.
+type IDCodec interface {
+	// Encode encodes an identifier for external use.
+	Encode(id string) string
+
+	// Decode decodes and verifies an externally supplied identifier.
+	Decode(s string) (id string, err error)
+}
+
+// ErrBadID is returned by the IDCodec implementations in this package when
+// an identifier fails to decode or verify.
+var ErrBadID = errors.New("httpx: malformed or forged identifier")
+
+// SignedIDs returns an IDCodec which appends an HMAC-SHA256 signature to
+// identifiers. Signed identifiers are not secret, but cannot be forged
+// without knowledge of key.
+func SignedIDs(key []byte) IDCodec {
+	return signedIDs{key: key}
+}
+
+type signedIDs struct {
+	key []byte
+}
+
+func (c signedIDs) Encode(id string) string {
+	return id + "." + sign(id, c.key)
+}
+
+func (c signedIDs) Decode(s string) (string, error) {
+	idx := strings.LastIndexByte(s, '.')
+	if idx == -1 {
+		return "", ErrBadID
+	}
+	id, sig := s[:idx], s[idx+1:]
+	if !hmac.Equal([]byte(sig), []byte(sign(id, c.key))) {
+		return "", ErrBadID
+	}
+	return id, nil
+}
+
+// EncryptedIDs returns an IDCodec which encrypts identifiers using AES-GCM,
+// making them opaque to clients. The key must be 16, 24 or 32 bytes long.
+func EncryptedIDs(key []byte) (IDCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return encryptedIDs{aead: aead}, nil
+}
+
+type encryptedIDs struct {
+	aead cipher.AEAD
+}
+
+func (c encryptedIDs) Encode(id string) string {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("httpx: crypto/rand: " + err.Error())
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(id), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func (c encryptedIDs) Decode(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < c.aead.NonceSize() {
+		return "", ErrBadID
+	}
+	nonce, sealed := b[:c.aead.NonceSize()], b[c.aead.NonceSize():]
+	id, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", ErrBadID
+	}
+	return string(id), nil
+}
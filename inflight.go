@@ -0,0 +1,111 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An InFlightRequest describes a request which is being served.
+type InFlightRequest struct {
+	Method    string
+	Path      string
+	RequestID string
+	Start     time.Time
+}
+
+// InFlight is a registry of the requests which are currently being served
+// by its Handler. The zero value is ready to use.
+type InFlight struct {
+	mu   sync.Mutex
+	seq  uint64
+	reqs map[uint64]InFlightRequest
+}
+
+// Handler returns a handler which registers each request for as long as
+// next is serving it. Handler should be installed after the middleware
+// which assigns request identifiers, so that they can be recorded.
+func (f *InFlight) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := Path(req)
+		if path == "" {
+			path = req.URL.Path
+		}
+		r := InFlightRequest{
+			Method:    req.Method,
+			Path:      path,
+			RequestID: RequestID(req),
+			Start:     time.Now(),
+		}
+		f.mu.Lock()
+		if f.reqs == nil {
+			f.reqs = make(map[uint64]InFlightRequest)
+		}
+		f.seq++
+		id := f.seq
+		f.reqs[id] = r
+		f.mu.Unlock()
+		defer func() {
+			f.mu.Lock()
+			delete(f.reqs, id)
+			f.mu.Unlock()
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Requests returns the requests which are currently in flight, oldest
+// first.
+func (f *InFlight) Requests() []InFlightRequest {
+	f.mu.Lock()
+	reqs := make([]InFlightRequest, 0, len(f.reqs))
+	for _, r := range f.reqs {
+		reqs = append(reqs, r)
+	}
+	f.mu.Unlock()
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].Start.Before(reqs[j].Start)
+	})
+	return reqs
+}
+
+// Len returns the number of requests which are currently in flight.
+func (f *InFlight) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.reqs)
+}
+
+// ServeHTTP renders the requests which are currently in flight as plain
+// text, one per line, oldest first, along with how long they have been
+// running for.
+func (f *InFlight) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reqs := f.Requests()
+	now := time.Now()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%d requests in flight\n", len(reqs))
+	for _, r := range reqs {
+		id := r.RequestID
+		if id == "" {
+			id = "-"
+		}
+		fmt.Fprintf(w, "%s %v %s %s %s\n", r.Start.Format(time.RFC3339Nano),
+			now.Sub(r.Start).Round(time.Millisecond), id, r.Method, r.Path)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestInFlight(t *testing.T) {
+	var f httpx.InFlight
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := f.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("POST", "/upload", nil)
+		h.ServeHTTP(httptest.NewRecorder(), httpx.WithRequestID(req, "r1"))
+		close(done)
+	}()
+	<-started
+
+	reqs := f.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests in flight, want 1", len(reqs))
+	}
+	if r := reqs[0]; r.Method != "POST" || r.Path != "/upload" || r.RequestID != "r1" {
+		t.Errorf("got %+v, want POST /upload r1", r)
+	}
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/inflight", nil))
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "1 requests in flight\n") || !strings.Contains(body, "r1 POST /upload") {
+		t.Errorf("body == %q, want the request listed", body)
+	}
+
+	close(release)
+	<-done
+	if n := f.Len(); n != 0 {
+		t.Errorf("Len() == %d after the request completed, want 0", n)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// recorder records the metrics which make up a Summary, by means of
+// httpsnoop hooks.
+type recorder struct {
+	start       time.Time
+	status      int
+	wroteHeader bool
+	written     int64
+	read        int64
+	firstByte   time.Time
+	lastByte    time.Time
+	proto       string
+	tls         *tls.ConnectionState
+}
+
+func newRecorder(req *http.Request) *recorder {
+	return &recorder{
+		start:  time.Now(),
+		status: http.StatusOK,
+		proto:  req.Proto,
+		tls:    req.TLS,
+	}
+}
+
+func (r *recorder) writeHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+		r.firstByte = time.Now()
+	}
+}
+
+func (r *recorder) wrote(n int64) {
+	r.writeHeader(http.StatusOK)
+	r.written += n
+	r.lastByte = time.Now()
+}
+
+func (r *recorder) hooks() httpsnoop.Hooks {
+	return httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				next(code)
+				r.writeHeader(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				n, err := next(b)
+				r.wrote(int64(n))
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				n, err := next(src)
+				r.wrote(n)
+				return n, err
+			}
+		},
+	}
+}
+
+func (r *recorder) summary() Summary {
+	now := time.Now()
+	s := Summary{
+		Status:    r.status,
+		Duration:  now.Sub(r.start),
+		Written:   r.written,
+		BytesRead: r.read,
+		Proto:     r.proto,
+	}
+	if r.wroteHeader {
+		s.TimeToFirstByte = r.firstByte.Sub(r.start)
+		s.WriteDuration = now.Sub(r.firstByte)
+	}
+	if r.tls != nil {
+		s.TLSVersion = r.tls.Version
+		s.CipherSuite = r.tls.CipherSuite
+	}
+	return s
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	*cr.n += int64(n)
+	return n, err
+}
+
+// InstrumentedWriter is an http.ResponseWriter which carries the identifier
+// of the request it responds to, and records a Summary of the response
+// as it is being written.
+//
+// InstrumentedWriter is useful for low-level error paths which only have
+// access to the ResponseWriter, such as httpsnoop hooks. See
+// ResponseRequestID and ResponseSummary.
+type InstrumentedWriter struct {
+	http.ResponseWriter
+
+	w   http.ResponseWriter
+	id  string
+	rec *recorder
+}
+
+// NewInstrumentedWriter wraps w into an InstrumentedWriter which carries
+// the identifier associated with req.
+func NewInstrumentedWriter(w http.ResponseWriter, req *http.Request) *InstrumentedWriter {
+	rec := newRecorder(req)
+	return &InstrumentedWriter{
+		ResponseWriter: httpsnoop.Wrap(w, rec.hooks()),
+		w:              w,
+		id:             RequestID(req),
+		rec:            rec,
+	}
+}
+
+// RequestID returns the identifier of the request.
+func (iw *InstrumentedWriter) RequestID() string {
+	return iw.id
+}
+
+// Summary returns a summary of the response written so far.
+func (iw *InstrumentedWriter) Summary() Summary {
+	return iw.rec.summary()
+}
+
+// Flush implements http.Flusher. If the underlying ResponseWriter does
+// not implement http.Flusher, Flush is a no-op.
+func (iw *InstrumentedWriter) Flush() {
+	if f, ok := iw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. If the underlying ResponseWriter does
+// not implement http.Hijacker, Hijack returns an error.
+func (iw *InstrumentedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := iw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("httpx: underlying ResponseWriter does not implement http.Hijacker")
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (iw *InstrumentedWriter) Unwrap() http.ResponseWriter {
+	return iw.w
+}
+
+// ResponseRequestID returns the identifier of the request w responds to,
+// if w is an InstrumentedWriter, or wraps one. Wrapping ResponseWriters
+// are traversed by means of an Unwrap method, as used by
+// http.ResponseController, which the wrappers of this package, made
+// using httpsnoop.Wrap, implement.
+func ResponseRequestID(w http.ResponseWriter) string {
+	if iw := findInstrumentedWriter(w); iw != nil {
+		return iw.RequestID()
+	}
+	return ""
+}
+
+// ResponseSummary returns a summary of the response written to w so far,
+// if w is an InstrumentedWriter, or wraps one.
+func ResponseSummary(w http.ResponseWriter) (Summary, bool) {
+	if iw := findInstrumentedWriter(w); iw != nil {
+		return iw.Summary(), true
+	}
+	return Summary{}, false
+}
+
+func findInstrumentedWriter(w http.ResponseWriter) *InstrumentedWriter {
+	for w != nil {
+		if iw, ok := w.(*InstrumentedWriter); ok {
+			return iw
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestServeInstrumentedBytesRead(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.CopyN(io.Discard, req.Body, 3)
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader("abcdef"))
+	body := req.Body
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), req)
+	if s.BytesRead != 3 {
+		t.Errorf("BytesRead == %d, want 3", s.BytesRead)
+	}
+	if req.Body != body {
+		t.Error("ServeInstrumented modified the caller's request")
+	}
+}
+
+func TestServeInstrumented(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       http.HandlerFunc
+		status  int
+		written int64
+	}{
+		{"empty", func(w http.ResponseWriter, req *http.Request) {}, http.StatusOK, 0},
+		{"write", func(w http.ResponseWriter, req *http.Request) {
+			io.WriteString(w, "hello")
+		}, http.StatusOK, 5},
+		{"status", func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.Copy(w, strings.NewReader("short"))
+		}, http.StatusTeapot, 5},
+	}
+	for _, tt := range tests {
+		s := httpx.ServeInstrumented(tt.h, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if s.Status != tt.status || s.Written != tt.written {
+			t.Errorf("%s: got status %d, written %d, want %d, %d",
+				tt.name, s.Status, s.Written, tt.status, tt.written)
+		}
+	}
+}
+
+func TestServeInstrumentedConnection(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), req)
+	if s.Proto != "HTTP/1.1" {
+		t.Errorf("Proto == %q, want %q", s.Proto, "HTTP/1.1")
+	}
+	if _, ok := s.KV()["tls_version"]; ok {
+		t.Error("tls_version recorded for plain HTTP request")
+	}
+
+	req = httptest.NewRequest("GET", "https://example.com/", nil)
+	req.Proto = "HTTP/2.0"
+	req.TLS.Version = tls.VersionTLS12
+	req.TLS.CipherSuite = tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	s = httpx.ServeInstrumented(h, httptest.NewRecorder(), req)
+	if s.Proto != "HTTP/2.0" || s.TLSVersion != tls.VersionTLS12 {
+		t.Errorf("got Proto %q, TLSVersion %#x, want %q, %#x", s.Proto, s.TLSVersion, "HTTP/2.0", tls.VersionTLS12)
+	}
+	kv := s.KV()
+	if v := kv["tls_version"]; v != "TLS 1.2" {
+		t.Errorf("tls_version == %v, want %q", v, "TLS 1.2")
+	}
+	if v := kv["cipher_suite"]; v != "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" {
+		t.Errorf("cipher_suite == %v, want %q", v, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	}
+}
+
+func TestServeInstrumentedTimeToFirstByte(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(w, "a")
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "b")
+	})
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if s.TimeToFirstByte < 10*time.Millisecond {
+		t.Errorf("TimeToFirstByte == %v, want at least 10ms", s.TimeToFirstByte)
+	}
+	if s.WriteDuration < 20*time.Millisecond {
+		t.Errorf("WriteDuration == %v, want at least 20ms", s.WriteDuration)
+	}
+	if s.TimeToFirstByte+s.WriteDuration > s.Duration {
+		t.Errorf("TimeToFirstByte %v + WriteDuration %v exceed Duration %v",
+			s.TimeToFirstByte, s.WriteDuration, s.Duration)
+	}
+
+	empty := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	s = httpx.ServeInstrumented(empty, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if s.TimeToFirstByte != 0 || s.WriteDuration != 0 {
+		t.Errorf("got TimeToFirstByte %v, WriteDuration %v for empty response, want 0", s.TimeToFirstByte, s.WriteDuration)
+	}
+}
+
+func TestInstrumentedWriter(t *testing.T) {
+	req := httpx.WithRequestID(httptest.NewRequest("GET", "/", nil), "abc")
+	iw := httpx.NewInstrumentedWriter(httptest.NewRecorder(), req)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// The writer seen by the handler is wrapped by the middleware of
+	// this package.
+	var w http.ResponseWriter
+	var flushErr error
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		w = rw
+		rw.WriteHeader(http.StatusBadGateway)
+		io.WriteString(rw, "oops")
+		flushErr = http.NewResponseController(rw).Flush()
+	})
+	h := httpx.Timeout(httpx.Compression{}.Handler(inner), time.Second)
+	httpx.ServeInstrumented(h, iw, req)
+	if flushErr != nil {
+		t.Errorf("Flush through the middleware: %v", flushErr)
+	}
+
+	if id := httpx.ResponseRequestID(w); id != "abc" {
+		t.Errorf("ResponseRequestID == %q, want %q", id, "abc")
+	}
+	s, ok := httpx.ResponseSummary(w)
+	if !ok {
+		t.Fatal("ResponseSummary found no InstrumentedWriter")
+	}
+	if s.Status != http.StatusBadGateway || s.Written == 0 {
+		t.Errorf("got status %d, written %d, want %d, and a body", s.Status, s.Written, http.StatusBadGateway)
+	}
+	if id := httpx.ResponseRequestID(httptest.NewRecorder()); id != "" {
+		t.Errorf("ResponseRequestID on plain writer == %q", id)
+	}
+}
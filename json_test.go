@@ -0,0 +1,97 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctype  string
+		indent bool
+		v      interface{}
+		status int
+		wantCT string
+		body   string
+		err    bool
+	}{
+		{
+			name:   "object",
+			v:      map[string]int{"a": 1},
+			status: http.StatusCreated,
+			wantCT: "application/json",
+			body:   "{\"a\":1}\n",
+		},
+		{
+			name:   "content type set",
+			ctype:  "application/vnd.api+json",
+			v:      []int{1},
+			status: http.StatusOK,
+			wantCT: "application/vnd.api+json",
+			body:   "[1]\n",
+		},
+		{
+			name:   "indented",
+			indent: true,
+			v:      map[string]int{"a": 1},
+			status: http.StatusOK,
+			wantCT: "application/json",
+			body:   "{\n  \"a\": 1\n}\n",
+		},
+		{
+			name:   "unencodable",
+			v:      map[string]interface{}{"c": make(chan int)},
+			status: http.StatusInternalServerError,
+			wantCT: httpx.ProblemType,
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpx.IndentJSON = tt.indent
+			defer func() { httpx.IndentJSON = false }()
+			rec := httptest.NewRecorder()
+			if tt.ctype != "" {
+				rec.Header().Set("Content-Type", tt.ctype)
+			}
+			err := httpx.WriteJSON(rec, tt.status, tt.v)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v", err)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status == %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantCT {
+				t.Errorf("Content-Type == %q, want %q", got, tt.wantCT)
+			}
+			if tt.err {
+				return
+			}
+			if got := rec.Body.String(); got != tt.body {
+				t.Errorf("body == %q, want %q", got, tt.body)
+			}
+			if got, want := rec.Header().Get("Content-Length"), len(tt.body); got != strconv.Itoa(want) {
+				t.Errorf("Content-Length == %q, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,666 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA384 and crypto.SHA512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by VerifyJWT. Errors which describe the reason a token
+// was rejected wrap one of them.
+var (
+	ErrInvalidToken      = errors.New("httpx: invalid token")
+	ErrInsufficientScope = errors.New("httpx: insufficient scope")
+)
+
+// Claims are the claims of a verified JSON Web Token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+
+	// Scopes are the scopes granted to the token, from the
+	// space-separated "scope" claim, or the "scp" array.
+	Scopes []string
+
+	raw json.RawMessage
+}
+
+// Decode decodes the claims set into v, for access to claims which are
+// not registered.
+func (c *Claims) Decode(v interface{}) error {
+	return json.Unmarshal(c.raw, v)
+}
+
+// HasScope reports whether the token was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// ContextWithClaims returns a copy of ctx which stores c.
+func ContextWithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, c)
+}
+
+// ClaimsFromContext returns the claims stored in ctx, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(*Claims)
+	return c, ok
+}
+
+// TokenClaims returns the claims of the token verified by RequireJWT for
+// req, if any.
+func TokenClaims(req *http.Request) (*Claims, bool) {
+	return ClaimsFromContext(req.Context())
+}
+
+// A KeyProvider provides the keys with which the signatures of tokens are
+// verified, by key ID and algorithm. Keys are []byte for the HMAC
+// algorithms, and *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+// for the others. Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error)
+}
+
+// StaticKeys is a KeyProvider backed by a fixed set of keys, by key ID.
+// The key stored under the empty key ID is used for tokens which do not
+// name a key ID.
+type StaticKeys map[string]crypto.PublicKey
+
+// Key implements KeyProvider.
+func (sk StaticKeys) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	if k, ok := sk[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// DefaultJWKSRefresh is the interval at which a JWKS refreshes its keys by
+// default.
+const DefaultJWKSRefresh = time.Hour
+
+// DefaultJWKSTimeout is the default timeout of JWKS fetches.
+const DefaultJWKSTimeout = 10 * time.Second
+
+// jwksMinRefresh bounds the rate at which a JWKS refreshes its keys on
+// encountering an unknown key ID, which may be due to key rotation.
+const jwksMinRefresh = 30 * time.Second
+
+// maxJWKSBytes bounds the size of the key sets fetched by a JWKS.
+const maxJWKSBytes = 1 << 20
+
+// JWKS is a KeyProvider which fetches a JSON Web Key Set from a URL, and
+// caches it. The set is refreshed periodically, and when a token names a
+// key ID which is not in the set, at most once every 30 seconds. If a
+// refresh fails, the keys fetched previously continue to be used.
+//
+// At most one fetch is in flight at a time. Fetches are independent of
+// the contexts passed to Key: callers which need a key which is not in
+// the set wait for the fetch, or until their context is done, while
+// periodic refreshes happen in the background.
+type JWKS struct {
+	// URL is the URL of the key set.
+	URL string
+
+	// Client fetches the key set. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Refresh is the interval at which the key set is refreshed. If
+	// zero, DefaultJWKSRefresh is used.
+	Refresh time.Duration
+
+	// Timeout bounds the duration of each fetch. If zero,
+	// DefaultJWKSTimeout is used.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	inflight *jwksFetch
+}
+
+// A jwksFetch is a fetch of the key set, shared by the callers which
+// wait for it.
+type jwksFetch struct {
+	done chan struct{}
+	err  error // valid once done is closed
+}
+
+// Key implements KeyProvider.
+func (j *JWKS) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	refresh := j.Refresh
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	k, ok := j.keys[kid]
+	age := time.Since(j.fetched)
+	var f *jwksFetch
+	if j.keys == nil || age >= refresh || (!ok && age >= jwksMinRefresh) {
+		f = j.startFetch()
+	}
+	j.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if f == nil {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	j.mu.Lock()
+	k, ok = j.keys[kid]
+	fetchedAny := j.keys != nil
+	j.mu.Unlock()
+	if !fetchedAny {
+		return nil, f.err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return k, nil
+}
+
+// startFetch starts fetching the key set, unless a fetch is in flight
+// already, and returns the fetch. j.mu must be held.
+func (j *JWKS) startFetch() *jwksFetch {
+	if j.inflight != nil {
+		return j.inflight
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	j.inflight = f
+	timeout := j.Timeout
+	if timeout <= 0 {
+		timeout = DefaultJWKSTimeout
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		keys, err := j.fetch(ctx)
+		cancel()
+		j.mu.Lock()
+		j.fetched = time.Now()
+		if err == nil {
+			j.keys = keys
+		}
+		j.inflight = nil
+		f.err = err
+		j.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", j.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("httpx: fetching JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("httpx: fetching JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	body := io.LimitReader(resp.Body, maxJWKSBytes)
+	if err := json.NewDecoder(body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("httpx: decoding JWKS: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// A jwk is a JSON Web Key, as defined by RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, fmt.Errorf("httpx: bad RSA exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("httpx: unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("httpx: EC key not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("httpx: unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("httpx: unsupported key type %q", k.Kty)
+	}
+}
+
+// A JWTOption configures the validation of tokens.
+type JWTOption func(*jwtConfig)
+
+type jwtConfig struct {
+	realm    string
+	issuer   string
+	audience string
+	scopes   []string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// JWTRealm sets the realm named in WWW-Authenticate challenges.
+func JWTRealm(realm string) JWTOption {
+	return func(cfg *jwtConfig) {
+		cfg.realm = realm
+	}
+}
+
+// JWTIssuer requires tokens to be issued by iss.
+func JWTIssuer(iss string) JWTOption {
+	return func(cfg *jwtConfig) {
+		cfg.issuer = iss
+	}
+}
+
+// JWTAudience requires tokens to be intended for aud.
+func JWTAudience(aud string) JWTOption {
+	return func(cfg *jwtConfig) {
+		cfg.audience = aud
+	}
+}
+
+// JWTScopes requires tokens to be granted all the specified scopes.
+// RequireJWT rejects tokens which are not with 403 Forbidden.
+func JWTScopes(scopes ...string) JWTOption {
+	return func(cfg *jwtConfig) {
+		cfg.scopes = append(cfg.scopes, scopes...)
+	}
+}
+
+// JWTLeeway sets the clock skew tolerated when validating the times in
+// tokens.
+func JWTLeeway(d time.Duration) JWTOption {
+	return func(cfg *jwtConfig) {
+		cfg.leeway = d
+	}
+}
+
+// VerifyJWT verifies the signature of the compact serialized JSON Web
+// Token, using a key provided by keys, then validates its claims, and
+// returns them.
+//
+// The "exp" and "nbf" claims are validated when they are present. The
+// "iss" and "aud" claims are validated as configured by JWTIssuer and
+// JWTAudience. Unsecured tokens, using the "none" algorithm, are always
+// rejected.
+func VerifyJWT(ctx context.Context, token string, keys KeyProvider, opts ...JWTOption) (*Claims, error) {
+	cfg := newJWTConfig(opts)
+	return cfg.verify(ctx, token, keys)
+}
+
+func newJWTConfig(opts []JWTOption) *jwtConfig {
+	cfg := &jwtConfig{now: time.Now}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func (cfg *jwtConfig) verify(ctx context.Context, token string, keys KeyProvider) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	b64 := base64.RawURLEncoding
+	hb, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	key, err := keys.Key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	signed := token[:len(parts[0])+1+len(parts[1])]
+	if err := verifySignature(header.Alg, key, []byte(signed), sig); err != nil {
+		return nil, err
+	}
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	c, err := parseClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+	return c, cfg.validate(c)
+}
+
+func (cfg *jwtConfig) validate(c *Claims) error {
+	now := cfg.now()
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt.Add(cfg.leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if !c.NotBefore.IsZero() && now.Add(cfg.leeway).Before(c.NotBefore) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if cfg.issuer != "" && c.Issuer != cfg.issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if cfg.audience != "" && !slices.Contains(c.Audience, cfg.audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	for _, scope := range cfg.scopes {
+		if !c.HasScope(scope) {
+			return fmt.Errorf("%w: %s", ErrInsufficientScope, scope)
+		}
+	}
+	return nil
+}
+
+func parseClaims(payload []byte) (*Claims, error) {
+	var raw struct {
+		Iss   string          `json:"iss"`
+		Sub   string          `json:"sub"`
+		Aud   json.RawMessage `json:"aud"`
+		Exp   *float64        `json:"exp"`
+		Nbf   *float64        `json:"nbf"`
+		Iat   *float64        `json:"iat"`
+		Jti   string          `json:"jti"`
+		Scope string          `json:"scope"`
+		Scp   []string        `json:"scp"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	c := &Claims{
+		Issuer:    raw.Iss,
+		Subject:   raw.Sub,
+		ExpiresAt: numericDate(raw.Exp),
+		NotBefore: numericDate(raw.Nbf),
+		IssuedAt:  numericDate(raw.Iat),
+		ID:        raw.Jti,
+		Scopes:    append(strings.Fields(raw.Scope), raw.Scp...),
+		raw:       payload,
+	}
+	if len(raw.Aud) > 0 {
+		var aud string
+		if err := json.Unmarshal(raw.Aud, &aud); err == nil {
+			c.Audience = []string{aud}
+		} else if err := json.Unmarshal(raw.Aud, &c.Audience); err != nil {
+			return nil, fmt.Errorf("%w: malformed audience", ErrInvalidToken)
+		}
+	}
+	return c, nil
+}
+
+func numericDate(secs *float64) time.Time {
+	if secs == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(*secs*float64(time.Second)))
+}
+
+// A jwtAlg describes a signature algorithm: its family, its hash, and,
+// for ECDSA, the size of its curve.
+type jwtAlg struct {
+	family string
+	hash   crypto.Hash
+	curve  int
+}
+
+var jwtAlgs = map[string]jwtAlg{
+	"HS256": {"HS", crypto.SHA256, 0},
+	"HS384": {"HS", crypto.SHA384, 0},
+	"HS512": {"HS", crypto.SHA512, 0},
+	"RS256": {"RS", crypto.SHA256, 0},
+	"RS384": {"RS", crypto.SHA384, 0},
+	"RS512": {"RS", crypto.SHA512, 0},
+	"PS256": {"PS", crypto.SHA256, 0},
+	"PS384": {"PS", crypto.SHA384, 0},
+	"PS512": {"PS", crypto.SHA512, 0},
+	"ES256": {"ES", crypto.SHA256, 256},
+	"ES384": {"ES", crypto.SHA384, 384},
+	"ES512": {"ES", crypto.SHA512, 521},
+	"EdDSA": {"EdDSA", 0, 0},
+}
+
+// verifySignature verifies sig over signed. The type of key must match
+// the family of alg, such that public keys are never used as HMAC
+// secrets.
+func verifySignature(name string, key crypto.PublicKey, signed, sig []byte) error {
+	alg, ok := jwtAlgs[name]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, name)
+	}
+	mismatch := fmt.Errorf("%w: key does not match algorithm %q", ErrInvalidToken, name)
+	valid := false
+	switch alg.family {
+	case "HS":
+		k, ok := key.([]byte)
+		if !ok {
+			return mismatch
+		}
+		mac := hmac.New(alg.hash.New, k)
+		mac.Write(signed)
+		valid = hmac.Equal(mac.Sum(nil), sig)
+	case "RS", "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return mismatch
+		}
+		digest := hashSum(alg.hash, signed)
+		if alg.family == "RS" {
+			valid = rsa.VerifyPKCS1v15(k, alg.hash, digest, sig) == nil
+		} else {
+			valid = rsa.VerifyPSS(k, alg.hash, digest, sig, nil) == nil
+		}
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve.Params().BitSize != alg.curve {
+			return mismatch
+		}
+		size := (alg.curve + 7) / 8
+		if len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			valid = ecdsa.Verify(k, hashSum(alg.hash, signed), r, s)
+		}
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return mismatch
+		}
+		valid = ed25519.Verify(k, signed, sig)
+	}
+	if !valid {
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	return nil
+}
+
+func hashSum(h crypto.Hash, b []byte) []byte {
+	d := h.New()
+	d.Write(b)
+	return d.Sum(nil)
+}
+
+// RequireJWT returns a handler which requires each request to carry a
+// bearer token in the Authorization header, verifies it using VerifyJWT,
+// then calls next with the claims stored in the request context, and the
+// subject of the token stored using WithUser.
+//
+// Requests without a token, or with an invalid one, are rejected with 401
+// Unauthorized. Requests with tokens which do not grant the scopes
+// configured by JWTScopes are rejected with 403 Forbidden. In both cases,
+// the WWW-Authenticate header carries a challenge, as specified by RFC
+// 6750.
+func RequireJWT(keys KeyProvider, next http.Handler, opts ...JWTOption) http.Handler {
+	cfg := newJWTConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, ok := bearerToken(req)
+		if !ok {
+			cfg.challenge(w, http.StatusUnauthorized, "", "")
+			return
+		}
+		c, err := cfg.verify(req.Context(), token, keys)
+		if err != nil {
+			Annotate(req, "auth", "rejected")
+			switch {
+			case errors.Is(err, ErrInsufficientScope):
+				cfg.challenge(w, http.StatusForbidden, "insufficient_scope", "")
+			case errors.Is(err, ErrInvalidToken):
+				desc := strings.TrimPrefix(err.Error(), ErrInvalidToken.Error()+": ")
+				cfg.challenge(w, http.StatusUnauthorized, "invalid_token", desc)
+			default:
+				// The key provider failed: the token may well be
+				// valid, and the client is not to blame.
+				SetError(req, err)
+				writeProblem(w, http.StatusServiceUnavailable, "")
+			}
+			return
+		}
+		ctx := ContextWithClaims(req.Context(), c)
+		if c.Subject != "" {
+			ctx = ContextWithUser(ctx, c.Subject)
+		}
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func (cfg *jwtConfig) challenge(w http.ResponseWriter, status int, code, desc string) {
+	params := []string{}
+	if cfg.realm != "" {
+		params = append(params, "realm="+strconv.Quote(cfg.realm))
+	}
+	if code != "" {
+		params = append(params, "error="+strconv.Quote(code))
+	}
+	if desc != "" {
+		params = append(params, "error_description="+strconv.Quote(desc))
+	}
+	if code == "insufficient_scope" && len(cfg.scopes) > 0 {
+		params = append(params, "scope="+strconv.Quote(strings.Join(cfg.scopes, " ")))
+	}
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeProblem(w, status, desc)
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
@@ -0,0 +1,304 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+var b64 = base64.RawURLEncoding
+
+func jwtSigningInput(header, claims map[string]interface{}) string {
+	hb, _ := json.Marshal(header)
+	cb, _ := json.Marshal(claims)
+	return b64.EncodeToString(hb) + "." + b64.EncodeToString(cb)
+}
+
+func signHS256(secret []byte, claims map[string]interface{}) string {
+	input := jwtSigningInput(map[string]interface{}{"alg": "HS256", "typ": "JWT"}, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + b64.EncodeToString(mac.Sum(nil))
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	input := jwtSigningInput(map[string]interface{}{"alg": "ES256", "kid": kid}, claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + b64.EncodeToString(sig)
+}
+
+func TestRequireJWT(t *testing.T) {
+	secret := []byte("secret")
+	keys := httpx.StaticKeys{"": secret}
+	h := httpx.RequireJWT(keys, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c, ok := httpx.TokenClaims(req)
+		if !ok {
+			t.Error("no claims in context")
+			return
+		}
+		var extra struct {
+			Tenant string `json:"tenant"`
+		}
+		if err := c.Decode(&extra); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(httpx.User(req) + " " + extra.Tenant))
+	}), httpx.JWTRealm("api"), httpx.JWTIssuer("issuer"), httpx.JWTAudience("api"), httpx.JWTScopes("read"))
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{
+		"iss":    "issuer",
+		"aud":    []string{"other", "api"},
+		"sub":    "alice",
+		"exp":    now + 60,
+		"scope":  "read write",
+		"tenant": "acme",
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := make(map[string]interface{})
+		for k, v := range valid {
+			c[k] = v
+		}
+		c[k] = v
+		return c
+	}
+	unsecured := jwtSigningInput(map[string]interface{}{"alg": "none"}, valid) + "."
+
+	tests := []struct {
+		name      string
+		auth      string
+		status    int
+		challenge string
+	}{
+		{name: "valid", auth: "Bearer " + signHS256(secret, valid), status: http.StatusOK},
+		{name: "missing", status: http.StatusUnauthorized, challenge: `Bearer realm="api"`},
+		{name: "not bearer", auth: "Basic YTpi", status: http.StatusUnauthorized, challenge: `Bearer realm="api"`},
+		{
+			name:      "expired",
+			auth:      "Bearer " + signHS256(secret, with("exp", now-60)),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="expired"`,
+		},
+		{
+			name:      "not valid yet",
+			auth:      "Bearer " + signHS256(secret, with("nbf", now+60)),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="not valid yet"`,
+		},
+		{
+			name:      "wrong issuer",
+			auth:      "Bearer " + signHS256(secret, with("iss", "mallory")),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="wrong issuer"`,
+		},
+		{
+			name:      "wrong audience",
+			auth:      "Bearer " + signHS256(secret, with("aud", "other")),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="wrong audience"`,
+		},
+		{
+			name:      "bad signature",
+			auth:      "Bearer " + signHS256([]byte("guess"), valid),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="bad signature"`,
+		},
+		{
+			name:      "unsecured",
+			auth:      "Bearer " + unsecured,
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="unsupported algorithm \"none\""`,
+		},
+		{
+			name:      "insufficient scope",
+			auth:      "Bearer " + signHS256(secret, with("scope", "write")),
+			status:    http.StatusForbidden,
+			challenge: `Bearer realm="api", error="insufficient_scope", scope="read"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status == %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("WWW-Authenticate == %q, want %q", got, tt.challenge)
+			}
+			if tt.status == http.StatusOK {
+				if got, want := rec.Body.String(), "alice acme"; got != want {
+					t.Errorf("body == %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyJWTKeyConfusion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An HMAC token must not verify against a public key.
+	token := signHS256([]byte("public key bytes"), map[string]interface{}{"sub": "alice"})
+	_, err = httpx.VerifyJWT(context.Background(), token, httpx.StaticKeys{"": &key.PublicKey})
+	if !errors.Is(err, httpx.ErrInvalidToken) {
+		t.Errorf("got error %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "k1",
+				"use": "sig",
+				"crv": "P-256",
+				"x":   b64.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y":   b64.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	jwks := &httpx.JWKS{URL: srv.URL, Client: srv.Client()}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		token := signES256(t, key, "k1", map[string]interface{}{"sub": "alice"})
+		c, err := httpx.VerifyJWT(ctx, token, jwks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Subject != "alice" {
+			t.Errorf("Subject == %q, want %q", c.Subject, "alice")
+		}
+	}
+	token := signES256(t, key, "k2", map[string]interface{}{"sub": "alice"})
+	if _, err := httpx.VerifyJWT(ctx, token, jwks); !errors.Is(err, httpx.ErrInvalidToken) {
+		t.Errorf("unknown key: got error %v, want ErrInvalidToken", err)
+	}
+	if fetches != 1 {
+		t.Errorf("fetched JWKS %d times, want 1", fetches)
+	}
+}
+
+func TestJWKSSharedFetch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		started <- struct{}{}
+		<-release
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "k1",
+				"crv": "P-256",
+				"x":   b64.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y":   b64.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			}},
+		})
+	}))
+	defer srv.Close()
+	jwks := &httpx.JWKS{URL: srv.URL, Client: srv.Client()}
+
+	// The first caller gives up while the fetch is in flight. This
+	// neither blocks it, nor cancels the fetch.
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := jwks.Key(ctx, "k1", "ES256")
+		errc <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: got error %v, want context.Canceled", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k, err := jwks.Key(context.Background(), "k1", "ES256")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !key.PublicKey.Equal(k) {
+				t.Errorf("got key %v, want %v", k, key.PublicKey)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched JWKS %d times, want 1", n)
+	}
+}
+
+func TestJWKSTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, `{"keys":[],"padding":"`+strings.Repeat("x", 2<<20)+`"}`)
+	}))
+	defer srv.Close()
+	jwks := &httpx.JWKS{URL: srv.URL, Client: srv.Client()}
+	if _, err := jwks.Key(context.Background(), "k1", "ES256"); err == nil {
+		t.Error("got nil error for oversized key set")
+	}
+}
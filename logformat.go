@@ -0,0 +1,210 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"acln.ro/log"
+)
+
+// An AccessLogEntry describes a request recorded by AccessLogWriter.
+type AccessLogEntry struct {
+	// Time is the time at which the request completed.
+	Time time.Time
+
+	// Request is the request, as seen by the middleware.
+	Request *http.Request
+
+	// URI is the request URI, as in the request line, with the query
+	// parameters configured by AccessLogFields redacted. Formatters
+	// record it instead of the URI of Request. If empty, the URI of
+	// Request is used, redacted as by the zero RequestLoggerOptions.
+	URI string
+
+	// Summary summarizes the response.
+	Summary Summary
+
+	// Fields combines the request fields, as configured by
+	// AccessLogFields, with the keys of Summary.KV, and those recorded
+	// for slow requests. See AccessLogSlow.
+	Fields log.KV
+}
+
+// A Formatter formats access log entries.
+type Formatter interface {
+	// Format writes e to w, as a single line, including the trailing
+	// newline.
+	Format(w io.Writer, e *AccessLogEntry) error
+}
+
+// FormatterFunc is an adapter to allow the use of ordinary functions as
+// formatters.
+type FormatterFunc func(w io.Writer, e *AccessLogEntry) error
+
+// Format calls f(w, e).
+func (f FormatterFunc) Format(w io.Writer, e *AccessLogEntry) error {
+	return f(w, e)
+}
+
+// JSONFormat formats entries as JSON objects, one per line. The entry time
+// is recorded under the "time" key, in RFC 3339 format. Durations are
+// recorded using their string form, such as "1.5ms", and errors using
+// their message.
+var JSONFormat Formatter = FormatterFunc(formatJSON)
+
+// LogfmtFormat formats entries as logfmt lines: space-separated key=value
+// pairs, sorted by key after the leading "time" key. Values which contain
+// spaces, quotes or equal signs are quoted.
+var LogfmtFormat Formatter = FormatterFunc(formatLogfmt)
+
+// CombinedFormat formats entries in the NCSA combined log format used by
+// the Apache HTTP server:
+//
+//	host - user [time] "method uri proto" status bytes "referer" "user-agent"
+//
+// The host is the client address stored by WithClientIP, if any, and the
+// user is the user name carried by basic authentication credentials.
+// The URI is AccessLogEntry.URI, with sensitive query parameters
+// redacted.
+var CombinedFormat Formatter = FormatterFunc(formatCombined)
+
+func formatCombined(w io.Writer, e *AccessLogEntry) error {
+	req := e.Request
+	host := ""
+	if ip := storedClientIP(req.Context()); ip != nil {
+		host = ip.String()
+	} else if host, _, _ = net.SplitHostPort(req.RemoteAddr); host == "" {
+		host = req.RemoteAddr
+	}
+	user, _, _ := req.BasicAuth()
+	uri := e.URI
+	if uri == "" {
+		uri = RequestLoggerOptions{}.redactedURI(req)
+	}
+	size := "-"
+	if e.Summary.Written > 0 {
+		size = strconv.FormatInt(e.Summary.Written, 10)
+	}
+	_, err := fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		dash(host),
+		dash(combinedEscape(user)),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		combinedEscape(req.Method), combinedEscape(uri), combinedEscape(req.Proto),
+		e.Summary.Status,
+		size,
+		dash(combinedEscape(req.Referer())),
+		dash(combinedEscape(req.UserAgent())))
+	return err
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// combinedEscape escapes quotes, backslashes and non-printable bytes in s,
+// as the Apache HTTP server does.
+func combinedEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func formatJSON(w io.Writer, e *AccessLogEntry) error {
+	obj := make(map[string]interface{}, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		obj[k] = plainValue(v)
+	}
+	obj["time"] = e.Time.Format(time.RFC3339Nano)
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func formatLogfmt(w io.Writer, e *AccessLogEntry) error {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		if k != "time" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("time=")
+	sb.WriteString(e.Time.Format(time.RFC3339Nano))
+	for _, k := range keys {
+		sb.WriteByte(' ')
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(logfmtValue(plainValue(e.Fields[k])))
+	}
+	sb.WriteByte('\n')
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// plainValue converts values which have no natural encoding in log
+// formats to strings.
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []string:
+		s = strings.Join(v, ",")
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestCombinedFormat(t *testing.T) {
+	req := httptest.NewRequest("GET", "/a?token=secret&b=c", nil)
+	req.RemoteAddr = "192.0.2.1:5678"
+	req.SetBasicAuth("frank", "pw")
+	req.Header.Set("Referer", "http://example.com/start.html")
+	req.Header.Set("User-Agent", `Mozilla/4.08 "quoted"`)
+
+	tests := []struct {
+		name    string
+		written int64
+		want    string
+	}{
+		{
+			name:    "body",
+			written: 2326,
+			want:    `192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a?token=[REDACTED]&b=c HTTP/1.1" 200 2326 "http://example.com/start.html" "Mozilla/4.08 \"quoted\""` + "\n",
+		},
+		{
+			name: "no body",
+			want: `192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a?token=[REDACTED]&b=c HTTP/1.1" 200 - "http://example.com/start.html" "Mozilla/4.08 \"quoted\""` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		e := &httpx.AccessLogEntry{
+			Time:    time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+			Request: req,
+			Summary: httpx.Summary{Status: 200, Written: tt.written},
+		}
+		var buf bytes.Buffer
+		if err := httpx.CombinedFormat.Format(&buf, e); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCombinedFormatRedactQuery(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	var buf bytes.Buffer
+	opts := httpx.RequestLoggerOptions{RedactQuery: []string{"api_key"}}
+	lh := httpx.AccessLogWriter(&buf, httpx.CombinedFormat, h, httpx.AccessLogFields(opts))
+	lh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a?api_key=secret&b=c", nil))
+	if got, want := buf.String(), `"GET /a?api_key=[REDACTED]&b=c HTTP/1.1"`; !strings.Contains(got, want) {
+		t.Errorf("entry %q does not contain %q", got, want)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("entry %q records the secret parameter", buf.String())
+	}
+}
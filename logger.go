@@ -0,0 +1,266 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"acln.ro/log"
+)
+
+// DefaultRequestLogFields lists the fields recorded by RequestLogger.
+var DefaultRequestLogFields = []string{
+	"baggage",
+	"method",
+	"path",
+	"remote_addr",
+	"user_agent",
+	"request_id",
+	"correlation_id",
+	"parent_request_id",
+	"attempt",
+	"trace_id",
+	"span_id",
+	"user",
+}
+
+// Redacted replaces the values of sensitive query parameters and headers
+// in log entries.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactedQuery lists the query parameters redacted by default.
+var DefaultRedactedQuery = []string{
+	"access_token",
+	"api_key",
+	"password",
+	"secret",
+	"token",
+}
+
+// DefaultRedactedHeaders lists the headers redacted by default.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+}
+
+// RequestLoggerOptions configures the fields recorded by request-scoped
+// loggers.
+type RequestLoggerOptions struct {
+	// Fields lists the fields to record, in the order in which they take
+	// precedence, lowest first. If nil, DefaultRequestLogFields is used.
+	//
+	// The supported fields are "method", "path", "remote_addr",
+	// "user_agent", "request_id", "correlation_id", "parent_request_id",
+	// "attempt", "trace_id", "span_id", "user", "host", "referer",
+	// "proto" and "query". If a client IP address was stored using WithClientIP,
+	// "remote_addr" records it instead of req.RemoteAddr. The pseudo-field
+	// "baggage" stands for the fields set using SetBaggage. Fields of the
+	// form "header:Name" record the named request header under the key
+	// "header_name", e.g. "header:X-Forwarded-For" is recorded as
+	// "header_x_forwarded_for". Optional fields which are empty for a
+	// given request are not recorded.
+	Fields []string
+
+	// ExtraKV, if not nil, is called for each request, and the key-value
+	// pairs it returns are recorded as well, taking precedence over
+	// Fields.
+	ExtraKV func(req *http.Request) log.KV
+
+	// RedactQuery lists the query parameters whose values are replaced
+	// by Redacted in the "query" field. Names are case-insensitive.
+	// If nil, DefaultRedactedQuery is used.
+	RedactQuery []string
+
+	// RedactHeaders lists the headers whose values are replaced by
+	// Redacted in "header:Name" fields. If nil, DefaultRedactedHeaders
+	// is used.
+	RedactHeaders []string
+}
+
+// WithLogger returns a shallow copy of req, with logger stored in its
+// context. Middleware typically constructs the request-scoped logger once,
+// by means of RequestLogger, and stores it using WithLogger, so that
+// handlers further down the tree can retrieve it using Logger.
+func WithLogger(req *http.Request, logger *log.Logger) *http.Request {
+	return req.WithContext(ContextWithLogger(req.Context(), logger))
+}
+
+// Logger returns the logger stored in the context of req, or nil.
+func Logger(req *http.Request) *log.Logger {
+	return LoggerFromContext(req.Context())
+}
+
+// ContextWithLogger returns a copy of ctx which stores logger.
+func ContextWithLogger(ctx context.Context, logger *log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// LoggerFromContext returns the logger stored in ctx, or nil.
+func LoggerFromContext(ctx context.Context) *log.Logger {
+	logger, _ := ctx.Value(loggerKey).(*log.Logger)
+	return logger
+}
+
+// Logger returns a logger scoped to the specified request, which records
+// the configured fields.
+func (o RequestLoggerOptions) Logger(base *log.Logger, req *http.Request) *log.Logger {
+	return base.WithKV(o.KV(req))
+}
+
+// KV returns the key-value pairs recorded for req.
+func (o RequestLoggerOptions) KV(req *http.Request) log.KV {
+	fields := o.Fields
+	if fields == nil {
+		fields = DefaultRequestLogFields
+	}
+	kv := log.KV{}
+	for _, f := range fields {
+		o.addField(kv, req, f)
+	}
+	if o.ExtraKV != nil {
+		for k, v := range o.ExtraKV(req) {
+			kv[k] = v
+		}
+	}
+	return kv
+}
+
+func (o RequestLoggerOptions) addField(kv log.KV, req *http.Request, field string) {
+	set := func(v string) {
+		if v != "" {
+			kv[field] = v
+		}
+	}
+	switch field {
+	case "baggage":
+		for k, v := range Baggage(req) {
+			kv[k] = v
+		}
+	case "method":
+		kv[field] = req.Method
+	case "path":
+		kv[field] = Path(req)
+	case "remote_addr":
+		if ip := storedClientIP(req.Context()); ip != nil {
+			kv[field] = ip.String()
+		} else {
+			kv[field] = req.RemoteAddr
+		}
+	case "user_agent":
+		set(req.UserAgent())
+	case "request_id":
+		set(RequestID(req))
+	case "correlation_id":
+		set(CorrelationID(req))
+	case "parent_request_id":
+		set(ParentRequestID(req))
+	case "attempt":
+		if n := Attempt(req); n > 0 {
+			kv[field] = n
+		}
+	case "trace_id", "span_id":
+		if tc, ok := Trace(req); ok {
+			if field == "trace_id" {
+				set(tc.TraceID)
+			} else {
+				set(tc.SpanID)
+			}
+		}
+	case "user":
+		set(User(req))
+	case "host":
+		set(req.Host)
+	case "referer":
+		set(req.Referer())
+	case "proto":
+		set(req.Proto)
+	case "query":
+		set(o.redactQuery(req.URL.RawQuery))
+	default:
+		if name := strings.TrimPrefix(field, "header:"); name != field {
+			if v := req.Header.Get(name); v != "" {
+				if o.redactedHeader(name) {
+					v = Redacted
+				}
+				kv[headerLogKey(name)] = v
+			}
+		}
+	}
+}
+
+// redactedURI returns the request URI of req, as in the request line, with
+// sensitive query parameters redacted.
+func (o RequestLoggerOptions) redactedURI(req *http.Request) string {
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	if path, query, ok := strings.Cut(uri, "?"); ok {
+		uri = path + "?" + o.redactQuery(query)
+	}
+	return uri
+}
+
+// redactQuery replaces the values of sensitive parameters in query,
+// preserving the order of the parameters.
+func (o RequestLoggerOptions) redactQuery(query string) string {
+	redact := o.RedactQuery
+	if redact == nil {
+		redact = DefaultRedactedQuery
+	}
+	if query == "" || len(redact) == 0 {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, p := range params {
+		raw := p
+		if idx := strings.IndexByte(p, '='); idx != -1 {
+			raw = p[:idx]
+		}
+		name, err := url.QueryUnescape(raw)
+		if err != nil {
+			name = raw
+		}
+		for _, r := range redact {
+			if strings.EqualFold(name, r) {
+				params[i] = raw + "=" + Redacted
+				break
+			}
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func (o RequestLoggerOptions) redactedHeader(name string) bool {
+	redact := o.RedactHeaders
+	if redact == nil {
+		redact = DefaultRedactedHeaders
+	}
+	for _, r := range redact {
+		if strings.EqualFold(name, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerLogKey returns the log key for a request header.
+func headerLogKey(name string) string {
+	return "header_" + strings.Replace(strings.ToLower(name), "-", "_", -1)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"acln.ro/httpx"
+	"acln.ro/log"
+)
+
+func TestRequestLoggerOptions(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/a?b=c", nil)
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req = httpx.WithPath(req)
+	req = httpx.SetBaggage(req, "tenant", "acme")
+
+	tests := []struct {
+		name string
+		opts httpx.RequestLoggerOptions
+		want log.KV
+	}{
+		{
+			name: "default",
+			want: log.KV{
+				"tenant":      "acme",
+				"method":      "GET",
+				"path":        "/a",
+				"remote_addr": "192.0.2.1:1234",
+			},
+		},
+		{
+			name: "custom",
+			opts: httpx.RequestLoggerOptions{
+				Fields: []string{"method", "host", "referer", "query", "header:X-Forwarded-For"},
+				ExtraKV: func(req *http.Request) log.KV {
+					return log.KV{"method": "overridden", "extra": 1}
+				},
+			},
+			want: log.KV{
+				"method":                 "overridden",
+				"host":                   "example.com",
+				"referer":                "http://example.com/",
+				"query":                  "b=c",
+				"header_x_forwarded_for": "192.0.2.1",
+				"extra":                  1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.KV(req); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLoggerRedaction(t *testing.T) {
+	req := httptest.NewRequest("GET", "/a?user=bob&Token=s3cr3t&x=1&password", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	req.Header.Set("X-Api-Key", "k3y")
+	req.Header.Set("Accept", "text/plain")
+
+	fields := []string{"query", "header:Authorization", "header:X-Api-Key", "header:Accept"}
+	tests := []struct {
+		name string
+		opts httpx.RequestLoggerOptions
+		want log.KV
+	}{
+		{
+			name: "default",
+			opts: httpx.RequestLoggerOptions{Fields: fields},
+			want: log.KV{
+				"query":                "user=bob&Token=[REDACTED]&x=1&password=[REDACTED]",
+				"header_authorization": "[REDACTED]",
+				"header_x_api_key":     "k3y",
+				"header_accept":        "text/plain",
+			},
+		},
+		{
+			name: "custom",
+			opts: httpx.RequestLoggerOptions{
+				Fields:        fields,
+				RedactQuery:   []string{"user"},
+				RedactHeaders: []string{"X-API-Key"},
+			},
+			want: log.KV{
+				"query":                "user=[REDACTED]&Token=s3cr3t&x=1&password",
+				"header_authorization": "Bearer s3cr3t",
+				"header_x_api_key":     "[REDACTED]",
+				"header_accept":        "text/plain",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.KV(req); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if l := httpx.Logger(req); l != nil {
+		t.Fatalf("Logger on fresh request == %p, want nil", l)
+	}
+	logger := new(log.Logger)
+	req = httpx.WithLogger(req, logger)
+	if l := httpx.Logger(req); l != logger {
+		t.Errorf("Logger == %p, want %p", l, logger)
+	}
+}
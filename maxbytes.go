@@ -0,0 +1,114 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// MaxBytes returns a handler which limits request bodies to n bytes, by
+// means of http.MaxBytesReader, then calls next. Requests whose
+// Content-Length exceeds n are rejected with 413 Content Too Large before
+// next is called.
+//
+// If reading the body fails because it exceeds n bytes, and next responds
+// with an error status, as it would after failing to decode the body,
+// the response is replaced by a 413 problem details object. The rejection
+// is annotated under the "body_too_large" key, with the limit as the value.
+func MaxBytes(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > n {
+			rejectBodyTooLarge(w, req, n)
+			return
+		}
+		if req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		mb := &maxBytesBody{ReadCloser: http.MaxBytesReader(w, req.Body, n)}
+		req = req.WithContext(req.Context())
+		req.Body = mb
+		replaced := false
+		ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if code >= 400 && mb.exceeded() && !replaced {
+						replaced = true
+						rejectBodyTooLarge(w, req, n)
+						return
+					}
+					if !replaced {
+						next(code)
+					}
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					if replaced {
+						return len(b), nil
+					}
+					return next(b)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					if replaced {
+						return io.Copy(io.Discard, src)
+					}
+					return next(src)
+				}
+			},
+		})
+		next.ServeHTTP(ww, req)
+	})
+}
+
+func rejectBodyTooLarge(w http.ResponseWriter, req *http.Request, n int64) {
+	Annotate(req, "body_too_large", n)
+	writeProblem(w, http.StatusRequestEntityTooLarge, "the request body exceeds "+strconv.FormatInt(n, 10)+" bytes")
+}
+
+// IsBodyTooLarge reports whether err, or any error it wraps, reports a
+// request body which exceeds the limit set by MaxBytes or
+// http.MaxBytesReader.
+func IsBodyTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
+
+// maxBytesBody records whether reading the body failed because of the
+// limit.
+type maxBytesBody struct {
+	io.ReadCloser
+	tooLarge int32
+}
+
+func (mb *maxBytesBody) Read(p []byte) (int, error) {
+	n, err := mb.ReadCloser.Read(p)
+	if err != nil && IsBodyTooLarge(err) {
+		atomic.StoreInt32(&mb.tooLarge, 1)
+	}
+	return n, err
+}
+
+func (mb *maxBytesBody) exceeded() bool {
+	return atomic.LoadInt32(&mb.tooLarge) == 1
+}
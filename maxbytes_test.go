@@ -0,0 +1,73 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestMaxBytes(t *testing.T) {
+	decode := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var v map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&v); err != nil {
+			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, v["k"])
+	})
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"small", `{"k":"v"}`, false, http.StatusOK},
+		{"content length", `{"k":"` + strings.Repeat("v", 64) + `"}`, false, http.StatusRequestEntityTooLarge},
+		{"chunked", `{"k":"` + strings.Repeat("v", 64) + `"}`, true, http.StatusRequestEntityTooLarge},
+		{"malformed", `{`, true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			s := httpx.ServeInstrumented(httpx.MaxBytes(32, decode), rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.code)
+			}
+			if tt.code != http.StatusRequestEntityTooLarge {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != httpx.ProblemType {
+				t.Errorf("Content-Type == %q, want %q", ct, httpx.ProblemType)
+			}
+			if strings.Contains(rec.Body.String(), "bad request") {
+				t.Errorf("body %q includes the response of the handler", rec.Body.String())
+			}
+			if got := s.Annotations["body_too_large"]; got != int64(32) {
+				t.Errorf("body_too_large == %v, want 32", got)
+			}
+		})
+	}
+}
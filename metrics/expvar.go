@@ -0,0 +1,238 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package metrics
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+// maxSamples bounds the number of latency samples kept per route and per
+// slot of an Aggregator.
+const maxSamples = 1024
+
+// aggregatorSlots is the number of slots into which the window of an
+// Aggregator is divided.
+const aggregatorSlots = 6
+
+// Aggregator maintains rolling request counts and latency quantiles per
+// route, over a sliding window. It implements expvar.Var, so it can be
+// published using expvar.Publish, for basic observability without
+// Prometheus:
+//
+//	agg := metrics.NewAggregator(time.Minute)
+//	expvar.Publish("http", agg)
+//	http.ListenAndServe(addr, agg.Handler(h))
+//
+// Quantiles are computed over a bounded sample of the latencies in the
+// window, and are therefore approximate for busy routes.
+type Aggregator struct {
+	// Route computes the route of a request, after it has been served.
+	// Routes must have low cardinality. If Route is nil, the route
+	// recorded by httpx.SetRoute is used. If the route is the
+	// empty string, requests are aggregated under "*".
+	Route func(req *http.Request) string
+
+	window time.Duration
+
+	mu    sync.Mutex
+	slots [aggregatorSlots]slot
+}
+
+type slot struct {
+	start  time.Time
+	routes map[string]*slotRoute
+}
+
+type slotRoute struct {
+	count     int64
+	byClass   [6]int64 // indexed by status / 100
+	samples   []time.Duration
+	sampleSeq int64 // observations offered to the reservoir
+}
+
+// NewAggregator creates an Aggregator over the specified window.
+func NewAggregator(window time.Duration) *Aggregator {
+	return &Aggregator{window: window}
+}
+
+// Handler returns a handler which serves requests using next, and
+// aggregates their summaries.
+func (a *Aggregator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = httpx.WithRequestState(req)
+		s := httpx.ServeInstrumented(next, w, req)
+		route := s.Route
+		if a.Route != nil {
+			route = a.Route(req)
+		}
+		a.Observe(route, s)
+	})
+}
+
+// Observe aggregates s under route.
+func (a *Aggregator) Observe(route string, s httpx.Summary) {
+	if route == "" {
+		route = "*"
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sl := a.current()
+	sr, ok := sl.routes[route]
+	if !ok {
+		sr = new(slotRoute)
+		sl.routes[route] = sr
+	}
+	sr.observe(s)
+}
+
+func (sr *slotRoute) observe(s httpx.Summary) {
+	sr.count++
+	if class := s.Status / 100; class >= 1 && class <= 5 {
+		sr.byClass[class]++
+	}
+	// Reservoir sampling keeps a uniform sample of the latencies.
+	sr.sampleSeq++
+	if len(sr.samples) < maxSamples {
+		sr.samples = append(sr.samples, s.Duration)
+	} else if i := rand.Int63n(sr.sampleSeq); i < maxSamples {
+		sr.samples[i] = s.Duration
+	}
+}
+
+// current returns the slot for the current time, recycling stale slots.
+// a.mu must be held.
+func (a *Aggregator) current() *slot {
+	width := a.window / aggregatorSlots
+	if width <= 0 {
+		width = 1
+	}
+	start := time.Now().Truncate(width)
+	sl := &a.slots[(start.UnixNano()/int64(width))%aggregatorSlots]
+	if !sl.start.Equal(start) {
+		sl.start = start
+		sl.routes = make(map[string]*slotRoute)
+	}
+	return sl
+}
+
+// RouteStats are the statistics aggregated for a route.
+type RouteStats struct {
+	Count int64 `json:"count"`
+
+	// Status counts responses by class, keyed by "2xx", "4xx" and so on.
+	Status map[string]int64 `json:"status"`
+
+	// P50, P90 and P99 are latency quantiles, in seconds.
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// Stats returns the statistics aggregated over the window, by route.
+func (a *Aggregator) Stats() map[string]RouteStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cutoff := time.Now().Add(-a.window)
+	type merged struct {
+		count   int64
+		byClass [6]int64
+		samples []time.Duration
+	}
+	routes := make(map[string]*merged)
+	for i := range a.slots {
+		sl := &a.slots[i]
+		if sl.routes == nil || !sl.start.After(cutoff) {
+			continue
+		}
+		for route, sr := range sl.routes {
+			m, ok := routes[route]
+			if !ok {
+				m = new(merged)
+				routes[route] = m
+			}
+			m.count += sr.count
+			for c, n := range sr.byClass {
+				m.byClass[c] += n
+			}
+			m.samples = append(m.samples, sr.samples...)
+		}
+	}
+	stats := make(map[string]RouteStats, len(routes))
+	for route, m := range routes {
+		stats[route] = routeStats(m.count, m.byClass, m.samples)
+	}
+	return stats
+}
+
+// routeStats computes RouteStats from the counts and latency samples of a
+// route. It sorts samples in place.
+func routeStats(count int64, byClass [6]int64, samples []time.Duration) RouteStats {
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	rs := RouteStats{
+		Count:  count,
+		Status: make(map[string]int64),
+		P50:    quantile(samples, 0.50),
+		P90:    quantile(samples, 0.90),
+		P99:    quantile(samples, 0.99),
+	}
+	for c, n := range byClass {
+		if n > 0 {
+			rs.Status[strconv.Itoa(c)+"xx"] = n
+		}
+	}
+	return rs
+}
+
+// ErrorRate returns the fraction of responses with 5xx status codes.
+func (rs RouteStats) ErrorRate() float64 {
+	if rs.Count == 0 {
+		return 0
+	}
+	return float64(rs.Status["5xx"]) / float64(rs.Count)
+}
+
+// String implements expvar.Var. It returns the statistics as a JSON object,
+// along with the window.
+func (a *Aggregator) String() string {
+	b, _ := json.Marshal(struct {
+		Window string                `json:"window"`
+		Routes map[string]RouteStats `json:"routes"`
+	}{a.window.String(), a.Stats()})
+	return string(b)
+}
+
+// quantile returns the q-quantile of the sorted samples, in seconds, using
+// the nearest rank method.
+func quantile(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Seconds()
+}
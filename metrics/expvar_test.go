@@ -0,0 +1,78 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package metrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+	"acln.ro/httpx/metrics"
+)
+
+var _ expvar.Var = (*metrics.Aggregator)(nil)
+
+func TestAggregator(t *testing.T) {
+	agg := metrics.NewAggregator(time.Minute)
+	for i := 1; i <= 100; i++ {
+		status := http.StatusOK
+		if i%10 == 0 {
+			status = http.StatusInternalServerError
+		}
+		agg.Observe("/users/{id}", httpx.Summary{Status: status, Duration: time.Duration(i) * time.Millisecond})
+	}
+	agg.Observe("", httpx.Summary{Status: http.StatusNotFound})
+
+	stats := agg.Stats()
+	rs := stats["/users/{id}"]
+	if rs.Count != 100 || rs.Status["2xx"] != 90 || rs.Status["5xx"] != 10 {
+		t.Errorf("got %+v", rs)
+	}
+	if rs.P50 != 0.05 || rs.P90 != 0.09 || rs.P99 != 0.099 {
+		t.Errorf("got quantiles %v, %v, %v, want 0.05, 0.09, 0.099", rs.P50, rs.P90, rs.P99)
+	}
+	if stats["*"].Count != 1 {
+		t.Errorf("unrouted requests: got %+v", stats["*"])
+	}
+
+	var v struct {
+		Window string
+		Routes map[string]metrics.RouteStats
+	}
+	if err := json.Unmarshal([]byte(agg.String()), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Window != "1m0s" || v.Routes["/users/{id}"].Count != 100 {
+		t.Errorf("String == %s", agg.String())
+	}
+}
+
+func TestAggregatorWindow(t *testing.T) {
+	agg := metrics.NewAggregator(60 * time.Millisecond)
+	agg.Route = func(*http.Request) string { return "/x" }
+	h := agg.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+	if n := agg.Stats()["/x"].Count; n != 1 {
+		t.Fatalf("got count %d, want 1", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := agg.Stats()["/x"].Count; n != 0 {
+		t.Errorf("after the window: got count %d, want 0", n)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// Package metrics records HTTP server metrics, and exposes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"acln.ro/httpx"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the buckets
+// of the request duration histogram.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the buckets of the
+// response size histogram.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7, 1e8}
+
+// An Option configures a Metrics.
+type Option func(*Metrics)
+
+// Namespace configures the prefix of the metric names, e.g. "myapp"
+// yields "myapp_http_requests_total". By default, there is no prefix.
+func Namespace(ns string) Option {
+	return func(m *Metrics) {
+		if ns != "" {
+			m.prefix = ns + "_"
+		}
+	}
+}
+
+// DurationBuckets configures the buckets of the request duration
+// histogram, in seconds.
+func DurationBuckets(buckets []float64) Option {
+	return func(m *Metrics) {
+		m.durationBuckets = buckets
+	}
+}
+
+// SizeBuckets configures the buckets of the response size histogram, in
+// bytes.
+func SizeBuckets(buckets []float64) Option {
+	return func(m *Metrics) {
+		m.sizeBuckets = buckets
+	}
+}
+
+// RouteLabel configures the function which computes the value of the
+// "route" label of a request, after it has been served. Route values must
+// have low cardinality: route patterns, rather than paths. By default,
+// the route recorded by httpx.SetRoute is used.
+func RouteLabel(route func(req *http.Request) string) Option {
+	return func(m *Metrics) {
+		m.route = route
+	}
+}
+
+// Metrics records metrics about the requests served by its middleware.
+// Metrics implements http.Handler, serving the metrics in the Prometheus
+// text exposition format, typically at /metrics.
+//
+// The metrics are:
+//
+//	http_requests_total               counter   method, status, route
+//	http_request_duration_seconds     histogram method, status, route
+//	http_response_size_bytes          histogram method, status, route
+//	http_requests_in_flight           gauge
+//	http_upstream_failures_total      counter   failure, route
+//
+// Connection pools exported using Pool, and servers exported using Conns,
+// add their own metrics.
+//
+// The status label holds the class of the response status, such as "2xx".
+// Methods other than the standard ones are labeled "other".
+type Metrics struct {
+	prefix          string
+	durationBuckets []float64
+	sizeBuckets     []float64
+	route           func(*http.Request) string
+
+	inFlight int64
+
+	mu       sync.Mutex
+	requests map[labels]*series
+	failures map[failureLabels]int64
+	pools    []pool
+	servers  []server
+}
+
+type labels struct {
+	method, status, route string
+}
+
+type failureLabels struct {
+	failure, route string
+}
+
+type series struct {
+	count    int64
+	duration histogram
+	size     histogram
+}
+
+type pool struct {
+	name string
+	pt   *httpx.PoolTransport
+}
+
+type server struct {
+	name string
+	ct   *httpx.ConnTracker
+}
+
+// New creates a Metrics.
+func New(opts ...Option) *Metrics {
+	m := &Metrics{
+		durationBuckets: DefaultDurationBuckets,
+		sizeBuckets:     DefaultSizeBuckets,
+		route:           httpx.Route,
+		requests:        make(map[labels]*series),
+		failures:        make(map[failureLabels]int64),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Handler returns a handler which serves requests using next, and records
+// metrics about them.
+func (m *Metrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&m.inFlight, 1)
+		req = httpx.WithRequestState(req)
+		s := httpx.ServeInstrumented(next, w, req)
+		atomic.AddInt64(&m.inFlight, -1)
+		m.observe(req, s)
+	})
+}
+
+func (m *Metrics) observe(req *http.Request, s httpx.Summary) {
+	l := labels{
+		method: method(req.Method),
+		status: strconv.Itoa(s.Status/100) + "xx",
+		route:  m.route(req),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ser, ok := m.requests[l]
+	if !ok {
+		ser = &series{
+			duration: newHistogram(m.durationBuckets),
+			size:     newHistogram(m.sizeBuckets),
+		}
+		m.requests[l] = ser
+	}
+	ser.count++
+	ser.duration.observe(s.Duration.Seconds())
+	ser.size.observe(float64(s.Written))
+	if s.UpstreamFailure != "" {
+		m.failures[failureLabels{failure: string(s.UpstreamFailure), route: l.route}]++
+	}
+}
+
+// Pool exports the connection pool statistics recorded by pt, labeled
+// with the upstream address and with name, which distinguishes transports.
+func (m *Metrics) Pool(name string, pt *httpx.PoolTransport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools = append(m.pools, pool{name: name, pt: pt})
+}
+
+// Conns exports the connection statistics recorded by ct, labeled with
+// name, which distinguishes servers. The metrics are:
+//
+//	http_server_connections_open            gauge   server
+//	http_server_connections                 gauge   server, state
+//	http_server_connections_accepted_total  counter server
+//	http_server_connections_closed_total    counter server, reason
+//
+// The state label is "idle" or "active", and the reason label is "closed"
+// or "hijacked". Accept and close rates are computed from the counters.
+func (m *Metrics) Conns(name string, ct *httpx.ConnTracker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, server{name: name, ct: ct})
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w, in the Prometheus text exposition
+// format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	m.write(&sb)
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func (m *Metrics) write(sb *strings.Builder) {
+	m.mu.Lock()
+	keys := make([]labels, 0, len(m.requests))
+	for l := range m.requests {
+		keys = append(keys, l)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	name := m.prefix + "http_requests_total"
+	header(sb, name, "counter", "Total number of HTTP requests served.")
+	for _, l := range keys {
+		fmt.Fprintf(sb, "%s{%s} %d\n", name, l.String(), m.requests[l].count)
+	}
+	name = m.prefix + "http_request_duration_seconds"
+	header(sb, name, "histogram", "Duration of HTTP requests, in seconds.")
+	for _, l := range keys {
+		m.requests[l].duration.write(sb, name, l.String())
+	}
+	name = m.prefix + "http_response_size_bytes"
+	header(sb, name, "histogram", "Size of HTTP response bodies, in bytes.")
+	for _, l := range keys {
+		m.requests[l].size.write(sb, name, l.String())
+	}
+
+	name = m.prefix + "http_requests_in_flight"
+	header(sb, name, "gauge", "Number of HTTP requests being served.")
+	fmt.Fprintf(sb, "%s %d\n", name, atomic.LoadInt64(&m.inFlight))
+
+	fkeys := make([]failureLabels, 0, len(m.failures))
+	for l := range m.failures {
+		fkeys = append(fkeys, l)
+	}
+	sort.Slice(fkeys, func(i, j int) bool {
+		if fkeys[i].route != fkeys[j].route {
+			return fkeys[i].route < fkeys[j].route
+		}
+		return fkeys[i].failure < fkeys[j].failure
+	})
+	name = m.prefix + "http_upstream_failures_total"
+	header(sb, name, "counter", "Total number of upstream failures, by class.")
+	for _, l := range fkeys {
+		fmt.Fprintf(sb, "%s{failure=%s,route=%s} %d\n", name, quote(l.failure), quote(l.route), m.failures[l])
+	}
+	pools := append([]pool(nil), m.pools...)
+	servers := append([]server(nil), m.servers...)
+	m.mu.Unlock()
+
+	if len(pools) > 0 {
+		writePools(sb, m.prefix, pools)
+	}
+	if len(servers) > 0 {
+		writeServers(sb, m.prefix, servers)
+	}
+}
+
+func writeServers(sb *strings.Builder, prefix string, servers []server) {
+	stats := make([]httpx.ConnStats, len(servers))
+	for i, s := range servers {
+		stats[i] = s.ct.Stats()
+	}
+	name := prefix + "http_server_connections_open"
+	header(sb, name, "gauge", "Number of open server connections.")
+	for i, s := range servers {
+		fmt.Fprintf(sb, "%s{server=%s} %d\n", name, quote(s.name), stats[i].Open)
+	}
+	name = prefix + "http_server_connections"
+	header(sb, name, "gauge", "Number of server connections, by state.")
+	for i, s := range servers {
+		fmt.Fprintf(sb, "%s{server=%s,state=\"active\"} %d\n", name, quote(s.name), stats[i].Active)
+		fmt.Fprintf(sb, "%s{server=%s,state=\"idle\"} %d\n", name, quote(s.name), stats[i].Idle)
+	}
+	name = prefix + "http_server_connections_accepted_total"
+	header(sb, name, "counter", "Total number of server connections accepted.")
+	for i, s := range servers {
+		fmt.Fprintf(sb, "%s{server=%s} %d\n", name, quote(s.name), stats[i].Accepted)
+	}
+	name = prefix + "http_server_connections_closed_total"
+	header(sb, name, "counter", "Total number of server connections closed or hijacked.")
+	for i, s := range servers {
+		fmt.Fprintf(sb, "%s{server=%s,reason=\"closed\"} %d\n", name, quote(s.name), stats[i].Closed)
+		fmt.Fprintf(sb, "%s{server=%s,reason=\"hijacked\"} %d\n", name, quote(s.name), stats[i].Hijacked)
+	}
+}
+
+func (l labels) String() string {
+	return "method=" + quote(l.method) + ",status=" + quote(l.status) + ",route=" + quote(l.route)
+}
+
+func writePools(sb *strings.Builder, prefix string, pools []pool) {
+	type poolHost struct {
+		labels string
+		stats  httpx.HostPoolStats
+	}
+	var hosts []poolHost
+	for _, p := range pools {
+		for addr, hs := range p.pt.Stats() {
+			hosts = append(hosts, poolHost{
+				labels: "transport=" + quote(p.name) + ",host=" + quote(addr),
+				stats:  hs,
+			})
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].labels < hosts[j].labels })
+
+	name := prefix + "http_client_connections_total"
+	header(sb, name, "counter", "Total number of client requests, by connection reuse.")
+	for _, h := range hosts {
+		fmt.Fprintf(sb, "%s{%s,reused=\"false\"} %d\n", name, h.labels, h.stats.NewConns)
+		fmt.Fprintf(sb, "%s{%s,reused=\"true\"} %d\n", name, h.labels, h.stats.ReusedConns)
+	}
+	name = prefix + "http_client_connections_open"
+	header(sb, name, "gauge", "Number of open client connections.")
+	for _, h := range hosts {
+		fmt.Fprintf(sb, "%s{%s} %d\n", name, h.labels, h.stats.Open)
+	}
+	name = prefix + "http_client_connections_idle"
+	header(sb, name, "gauge", "Approximate number of idle client connections.")
+	for _, h := range hosts {
+		fmt.Fprintf(sb, "%s{%s} %d\n", name, h.labels, h.stats.Idle)
+	}
+	name = prefix + "http_client_tls_handshake_seconds"
+	header(sb, name, "histogram", "Duration of client TLS handshakes, in seconds.")
+	for _, h := range hosts {
+		hist := fromHistogram(h.stats.TLSHandshake)
+		hist.write(sb, name, h.labels)
+	}
+}
+
+func header(sb *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// method normalizes request methods, to bound the cardinality of the
+// method label.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect,
+		http.MethodOptions, http.MethodTrace:
+		return m
+	}
+	return "other"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote quotes a label value, as required by the exposition format.
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// histogram is a histogram with cumulative exposition.
+type histogram struct {
+	bounds []float64
+	counts []int64 // not cumulative; len(bounds)+1
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func fromHistogram(h httpx.Histogram) histogram {
+	bounds := make([]float64, len(h.Buckets))
+	for i, b := range h.Buckets {
+		bounds[i] = b.Seconds()
+	}
+	return histogram{bounds: bounds, counts: h.Counts, sum: h.Sum.Seconds(), count: h.Count}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(sb *strings.Builder, name, labels string) {
+	var cum int64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(sb, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(b), cum)
+	}
+	fmt.Fprintf(sb, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(sb, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(sb, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
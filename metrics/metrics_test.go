@@ -0,0 +1,124 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+	"acln.ro/httpx/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New(
+		metrics.Namespace("app"),
+		metrics.RouteLabel(func(req *http.Request) string { return "/users/{id}" }),
+	)
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" {
+			httpx.SetUpstreamFailure(req, httpx.UpstreamTimeout)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.WriteString(w, strings.Repeat("x", 150))
+	}))
+	for _, method := range []string{"GET", "GET", "DELETE", "BREW"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/users/1", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type == %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`# TYPE app_http_requests_total counter`,
+		`app_http_requests_total{method="GET",status="2xx",route="/users/{id}"} 2`,
+		`app_http_requests_total{method="DELETE",status="5xx",route="/users/{id}"} 1`,
+		`app_http_requests_total{method="other",status="2xx",route="/users/{id}"} 1`,
+		`app_http_response_size_bytes_bucket{method="GET",status="2xx",route="/users/{id}",le="100"} 0`,
+		`app_http_response_size_bytes_bucket{method="GET",status="2xx",route="/users/{id}",le="1000"} 2`,
+		`app_http_response_size_bytes_sum{method="GET",status="2xx",route="/users/{id}"} 300`,
+		`app_http_request_duration_seconds_count{method="GET",status="2xx",route="/users/{id}"} 2`,
+		`app_http_requests_in_flight 0`,
+		`app_http_upstream_failures_total{failure="timeout",route="/users/{id}"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("exposition does not contain %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+func TestMetricsPool(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	pt := httpx.NewPoolTransport(nil)
+	defer pt.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: pt}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	m := metrics.New()
+	m.Pool("default", pt)
+	var sb strings.Builder
+	if _, err := m.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `http_client_connections_total{transport="default",host="` + srv.Listener.Addr().String() + `",reused="false"} 1`
+	if !strings.Contains(sb.String(), want+"\n") {
+		t.Errorf("exposition does not contain %q:\n%s", want, sb.String())
+	}
+}
+
+func TestMetricsConns(t *testing.T) {
+	ct := new(httpx.ConnTracker)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	srv.Config.ConnState = ct.ConnState
+	srv.Start()
+	tr := &http.Transport{}
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tr.CloseIdleConnections()
+	srv.Close()
+
+	m := metrics.New()
+	m.Conns("public", ct)
+	var sb strings.Builder
+	if _, err := m.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`http_server_connections_open{server="public"} 0`,
+		`http_server_connections_accepted_total{server="public"} 1`,
+		`http_server_connections_closed_total{server="public",reason="closed"} 1`,
+	} {
+		if !strings.Contains(sb.String(), want+"\n") {
+			t.Errorf("exposition does not contain %q:\n%s", want, sb.String())
+		}
+	}
+}
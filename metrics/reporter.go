@@ -0,0 +1,149 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+// A Report holds the statistics aggregated by a Reporter over one window.
+type Report struct {
+	Start time.Time
+	End   time.Time
+
+	// Total aggregates all requests in the window.
+	Total RouteStats
+
+	// Routes aggregates requests by route. Requests without a route are
+	// aggregated under "*", as by Aggregator.
+	Routes map[string]RouteStats
+}
+
+// Reporter aggregates summaries over consecutive, non-overlapping windows,
+// and passes the statistics of each window to a callback, for pushing
+// metrics to backends which Metrics and Aggregator do not support.
+// Windows with no requests are reported as well.
+type Reporter struct {
+	// Route computes the route of a request, like Aggregator.Route.
+	Route func(req *http.Request) string
+
+	report func(Report)
+	ticker *time.Ticker
+	done   chan struct{}
+	once   sync.Once
+
+	// flushMu serializes calls to report, without blocking Observe.
+	flushMu sync.Mutex
+
+	mu     sync.Mutex
+	start  time.Time
+	routes map[string]*slotRoute
+}
+
+// NewReporter returns a Reporter which calls report with the statistics of
+// each window, from a separate goroutine. Calls to report are serialized.
+// Stop must be called to release the resources of the Reporter.
+func NewReporter(window time.Duration, report func(Report)) *Reporter {
+	r := &Reporter{
+		report: report,
+		ticker: time.NewTicker(window),
+		done:   make(chan struct{}),
+		start:  time.Now(),
+		routes: make(map[string]*slotRoute),
+	}
+	go r.loop()
+	return r
+}
+
+func (r *Reporter) loop() {
+	for {
+		select {
+		case <-r.ticker.C:
+			r.Flush()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Handler returns a handler which serves requests using next, and
+// aggregates their summaries.
+func (r *Reporter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = httpx.WithRequestState(req)
+		s := httpx.ServeInstrumented(next, w, req)
+		route := s.Route
+		if r.Route != nil {
+			route = r.Route(req)
+		}
+		r.Observe(route, s)
+	})
+}
+
+// Observe aggregates s under route, in the current window.
+func (r *Reporter) Observe(route string, s httpx.Summary) {
+	if route == "" {
+		route = "*"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sr, ok := r.routes[route]
+	if !ok {
+		sr = new(slotRoute)
+		r.routes[route] = sr
+	}
+	sr.observe(s)
+}
+
+// Flush ends the current window early, and reports it.
+func (r *Reporter) Flush() {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	start, routes := r.start, r.routes
+	end := time.Now()
+	r.start, r.routes = end, make(map[string]*slotRoute)
+	r.mu.Unlock()
+
+	rep := Report{Start: start, End: end, Routes: make(map[string]RouteStats)}
+	var (
+		count   int64
+		byClass [6]int64
+		samples []time.Duration
+	)
+	for route, sr := range routes {
+		count += sr.count
+		for c, n := range sr.byClass {
+			byClass[c] += n
+		}
+		samples = append(samples, sr.samples...)
+		rep.Routes[route] = routeStats(sr.count, sr.byClass, sr.samples)
+	}
+	rep.Total = routeStats(count, byClass, samples)
+	r.report(rep)
+}
+
+// Stop stops the Reporter, and reports the final, partial window.
+func (r *Reporter) Stop() {
+	r.once.Do(func() {
+		r.ticker.Stop()
+		close(r.done)
+		r.Flush()
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package metrics_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+	"acln.ro/httpx/metrics"
+)
+
+func TestReporter(t *testing.T) {
+	reports := make(chan metrics.Report, 4)
+	r := metrics.NewReporter(time.Hour, func(rep metrics.Report) {
+		reports <- rep
+	})
+	defer r.Stop()
+
+	for i := 0; i < 8; i++ {
+		r.Observe("/users/{id}", httpx.Summary{Status: http.StatusOK, Duration: time.Duration(i+1) * time.Millisecond})
+	}
+	r.Observe("/users/{id}", httpx.Summary{Status: http.StatusBadGateway, Duration: time.Second})
+	r.Observe("", httpx.Summary{Status: http.StatusNotFound, Duration: time.Millisecond})
+	r.Flush()
+
+	rep := <-reports
+	if !rep.End.After(rep.Start) {
+		t.Errorf("window [%v, %v] is empty", rep.Start, rep.End)
+	}
+	if rep.Total.Count != 10 {
+		t.Errorf("Total.Count == %d, want 10", rep.Total.Count)
+	}
+	users := rep.Routes["/users/{id}"]
+	if users.Count != 9 || users.Status["5xx"] != 1 {
+		t.Errorf("got %+v for /users/{id}, want 9 requests with one 5xx", users)
+	}
+	if got, want := users.ErrorRate(), 1.0/9; got != want {
+		t.Errorf("ErrorRate() == %v, want %v", got, want)
+	}
+	if got := users.P99; got != 1 {
+		t.Errorf("P99 == %v, want 1", got)
+	}
+	if n := rep.Routes["*"].Count; n != 1 {
+		t.Errorf("got %d requests without a route, want 1", n)
+	}
+
+	r.Stop()
+	if rep := <-reports; rep.Total.Count != 0 || len(rep.Routes) != 0 {
+		t.Errorf("final report %+v is not empty", rep)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Mounts hosts several applications under path prefixes, within a single
+// handler tree. Each application is served with the prefix stripped from
+// the request path, behind its own middleware chain, and with its own
+// handler for requests which match no route.
+//
+// The zero Mounts is ready for use. Mounts must not be modified while
+// serving requests.
+type Mounts struct {
+	// NotFound handles requests which match no mount. If nil,
+	// http.NotFound is used.
+	NotFound http.Handler
+
+	mounts []*mount
+}
+
+// A MountOption configures a mounted application.
+type MountOption func(*mount)
+
+// MountMiddleware configures the middleware chain of a mounted application.
+// Middleware are applied in order, such that the first one sees requests
+// first.
+func MountMiddleware(mw ...func(http.Handler) http.Handler) MountOption {
+	return func(m *mount) {
+		m.middleware = append(m.middleware, mw...)
+	}
+}
+
+// MountNotFound configures the handler which NotFound calls for requests
+// served by a mounted application. Nested applications inherit the
+// handler of the enclosing application, unless they configure their own.
+func MountNotFound(h http.Handler) MountOption {
+	return func(m *mount) {
+		m.notFound = h
+	}
+}
+
+type mount struct {
+	prefix     string
+	segments   []string
+	handler    http.Handler
+	middleware []func(http.Handler) http.Handler
+	notFound   http.Handler
+}
+
+// Mount mounts app under prefix, e.g. "/admin" or "/api/v2". Requests
+// for the prefix itself, and for paths below it, are served by app, with
+// the prefix stripped from req.URL.Path by whole segments, as if by
+// repeated calls to Shift. When prefixes overlap, the longest one wins.
+//
+// Before stripping the prefix, Mount stores the original path using
+// WithPath. The prefix of the application serving a request is reported
+// by MountPrefix. Mount records the route of each request as the prefix
+// followed by "/*", which the application refines using SetRoute.
+func (ms *Mounts) Mount(prefix string, app http.Handler, opts ...MountOption) {
+	m := &mount{prefix: "/" + strings.Trim(prefix, "/")}
+	for _, seg := range strings.Split(strings.Trim(prefix, "/"), "/") {
+		if seg != "" {
+			m.segments = append(m.segments, seg)
+		}
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.handler = app
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		m.handler = m.middleware[i](m.handler)
+	}
+	ms.mounts = append(ms.mounts, m)
+	sort.SliceStable(ms.mounts, func(i, j int) bool {
+		return len(ms.mounts[i].segments) > len(ms.mounts[j].segments)
+	})
+}
+
+func (ms *Mounts) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, m := range ms.mounts {
+		rest, ok := m.match(req.URL.Path)
+		if !ok {
+			continue
+		}
+		req = WithPath(req)
+		mi := &mountInfo{
+			prefix:   joinMountPrefix(MountPrefix(req), m.prefix),
+			notFound: m.notFound,
+		}
+		if parent, ok := req.Context().Value(mountKey).(*mountInfo); ok && mi.notFound == nil {
+			mi.notFound = parent.notFound
+		}
+		req = req.WithContext(context.WithValue(req.Context(), mountKey, mi))
+		u := *req.URL
+		u.Path = rest
+		u.RawPath = ""
+		req.URL = &u
+		SetRoute(req, "/*")
+		m.handler.ServeHTTP(w, req)
+		return
+	}
+	if ms.NotFound != nil {
+		ms.NotFound.ServeHTTP(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+// match reports whether path is served by m, and returns the remainder of
+// the path after the prefix.
+func (m *mount) match(path string) (rest string, ok bool) {
+	rest = path
+	for _, seg := range m.segments {
+		var got string
+		got, rest = shift(rest)
+		if got != seg {
+			return "", false
+		}
+	}
+	return rest, true
+}
+
+type mountInfo struct {
+	prefix   string
+	notFound http.Handler
+}
+
+func joinMountPrefix(parent, prefix string) string {
+	if parent == "" || parent == "/" {
+		return prefix
+	}
+	if prefix == "/" {
+		return parent
+	}
+	return parent + prefix
+}
+
+// MountPrefix returns the full path prefix of the mounted application
+// serving req, including the prefixes of enclosing mounts, or the empty
+// string if req is not served by a mounted application.
+func MountPrefix(req *http.Request) string {
+	if mi, ok := req.Context().Value(mountKey).(*mountInfo); ok {
+		return mi.prefix
+	}
+	return ""
+}
+
+// NotFound replies to req with the handler configured for the mounted
+// application serving req using MountNotFound, or with http.NotFound if
+// there is none.
+func NotFound(w http.ResponseWriter, req *http.Request) {
+	if mi, ok := req.Context().Value(mountKey).(*mountInfo); ok && mi.notFound != nil {
+		mi.notFound.ServeHTTP(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestMounts(t *testing.T) {
+	echo := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/missing" {
+				httpx.NotFound(w, req)
+				return
+			}
+			io.WriteString(w, name+" "+httpx.MountPrefix(req)+" "+req.URL.Path+" "+httpx.Path(req))
+		})
+	}
+	header := func(v string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Add("X-Chain", v)
+				next.ServeHTTP(w, req)
+			})
+		}
+	}
+	teapot := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	var inner httpx.Mounts
+	inner.Mount("/v2", echo("v2"))
+
+	var ms httpx.Mounts
+	ms.Mount("/api", echo("api"), httpx.MountMiddleware(header("a"), header("b")), httpx.MountNotFound(teapot))
+	ms.Mount("/api/admin", echo("admin"))
+	ms.Mount("/nested", &inner, httpx.MountNotFound(teapot))
+
+	tests := []struct {
+		path      string
+		want      string
+		wantCode  int
+		wantChain []string
+	}{
+		{path: "/api", want: "api /api  /api", wantChain: []string{"a", "b"}},
+		{path: "/api/users/1", want: "api /api /users/1 /api/users/1", wantChain: []string{"a", "b"}},
+		{path: "/api/admin/x", want: "admin /api/admin /x /api/admin/x"},
+		{path: "/apix", wantCode: http.StatusNotFound},
+		{path: "/api/missing", wantCode: http.StatusTeapot, wantChain: []string{"a", "b"}},
+		{path: "/api/admin/missing", wantCode: http.StatusNotFound},
+		{path: "/nested/v2/y", want: "v2 /nested/v2 /y /nested/v2/y"},
+		{path: "/nested/v2/missing", wantCode: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.path, nil)
+		ms.ServeHTTP(rec, req)
+		if tt.wantCode == 0 {
+			tt.wantCode = http.StatusOK
+		}
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.wantCode)
+			continue
+		}
+		if tt.want != "" && rec.Body.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, rec.Body.String(), tt.want)
+		}
+		if chain := rec.Header()["X-Chain"]; strings.Join(chain, ",") != strings.Join(tt.wantChain, ",") {
+			t.Errorf("%s: middleware chain %v, want %v", tt.path, chain, tt.wantChain)
+		}
+		if req.URL.Path != tt.path {
+			t.Errorf("%s: Mounts modified the caller's URL", tt.path)
+		}
+	}
+}
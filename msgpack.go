@@ -0,0 +1,380 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// MessagePackCodec encodes and decodes values as MessagePack documents.
+//
+// As with CBORCodec, values map to MessagePack as they do to JSON: struct
+// tags and json.Marshaler implementations are honored, integers are
+// encoded in the smallest format which holds them, other numbers as
+// float64, and map keys are sorted. When decoding, bin values are
+// accepted wherever encoding/json accepts base64 text, and timestamps
+// are converted to RFC 3339 text, as accepted by time.Time. Other
+// extension types are rejected.
+var MessagePackCodec Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	g, err := marshalGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, g)
+}
+
+func appendMsgpack(b []byte, g interface{}) ([]byte, error) {
+	switch g := g.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if g {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case string:
+		return append(msgpackStrHead(b, len(g)), g...), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(g), 10, 64); err == nil {
+			return msgpackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(g), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), u), nil
+		}
+		f, err := g.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f)), nil
+	case []interface{}:
+		b = msgpackContainerHead(b, 0x90, 0xdc, len(g))
+		for _, elem := range g {
+			var err error
+			if b, err = appendMsgpack(b, elem); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = msgpackContainerHead(b, 0x80, 0xde, len(g))
+		for _, k := range sortedKeys(g) {
+			b = append(msgpackStrHead(b, len(k)), k...)
+			var err error
+			if b, err = appendMsgpack(b, g[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("httpx: cannot encode %T as MessagePack", g)
+	}
+}
+
+// msgpackInt appends i in the smallest integer format which holds it.
+func msgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f, i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func msgpackStrHead(b []byte, n int) []byte {
+	switch {
+	case n < 32:
+		return append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+}
+
+// msgpackContainerHead appends the head of an array or map of n
+// elements, given the fix and 16-bit formats of the container type. The
+// 32-bit format always follows the 16-bit one.
+func msgpackContainerHead(b []byte, fix, format16 byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, format16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, format16+1), uint32(n))
+	}
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	d := &msgpackDecoder{b: data}
+	g, err := d.value()
+	if err != nil {
+		return err
+	}
+	if len(d.b) > 0 {
+		return errMsgpackTrailing
+	}
+	return unmarshalGeneric(g, v)
+}
+
+var (
+	errMsgpackTruncated = errors.New("httpx: truncated MessagePack document")
+	errMsgpackTrailing  = errors.New("httpx: trailing data after MessagePack document")
+	errMsgpackMalformed = errors.New("httpx: malformed MessagePack document")
+	errMsgpackDepth     = errors.New("httpx: MessagePack document nested too deeply")
+)
+
+// msgpackDecoder decodes MessagePack documents into generic values.
+type msgpackDecoder struct {
+	b     []byte
+	depth int
+}
+
+// next consumes and returns the next n bytes.
+func (d *msgpackDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)) {
+		return nil, errMsgpackTruncated
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of the specified size.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) value() (interface{}, error) {
+	if d.depth++; d.depth > maxGenericDepth {
+		return nil, errMsgpackDepth
+	}
+	defer func() { d.depth-- }()
+	if len(d.b) == 0 {
+		return nil, errMsgpackTruncated
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	switch {
+	case c <= 0x7f:
+		return json.Number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(c)))), nil
+	case c&0xf0 == 0x80:
+		return d.mapValue(uint64(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.array(uint64(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.str(uint64(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		n, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(float64(math.Float32frombits(uint32(n))))
+	case 0xcb:
+		n, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(math.Float64frombits(n))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the size of the integer.
+		shift := 64 - 8*size
+		i := int64(n<<shift) >> shift
+		return json.Number(strconv.FormatInt(i, 10)), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(n)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(n)
+	default: // 0xc1 is never used
+		return nil, errMsgpackMalformed
+	}
+}
+
+func msgpackFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("httpx: cannot decode MessagePack float %v", f)
+	}
+	return f, nil
+}
+
+func (d *msgpackDecoder) str(n uint64) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, errMsgpackMalformed
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) array(n uint64) (interface{}, error) {
+	// Each element takes at least one byte.
+	if n > uint64(len(d.b)) {
+		return nil, errMsgpackTruncated
+	}
+	arr := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		elem, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, elem)
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) mapValue(n uint64) (interface{}, error) {
+	if n > uint64(len(d.b)) {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]interface{})
+	for i := uint64(0); i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := genericKey(k)
+		if !ok {
+			return nil, fmt.Errorf("httpx: unsupported MessagePack map key of type %T", k)
+		}
+		if m[key], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// msgpackTimestamp is the extension type of timestamps.
+const msgpackTimestamp = -1
+
+// ext decodes an extension value of n bytes, whose type follows.
+func (d *msgpackDecoder) ext(n uint64) (interface{}, error) {
+	typ, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != msgpackTimestamp {
+		return nil, fmt.Errorf("httpx: unsupported MessagePack extension type %d", int8(typ[0]))
+	}
+	var t time.Time
+	switch len(data) {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		t = time.Unix(int64(v&(1<<34-1)), int64(v>>34))
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		t = time.Unix(sec, int64(nsec))
+	default:
+		return nil, errMsgpackMalformed
+	}
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
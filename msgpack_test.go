@@ -0,0 +1,166 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestMessagePackMarshal(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{0, "\x00"},
+		{127, "\x7f"},
+		{128, "\xcc\x80"},
+		{65535, "\xcd\xff\xff"},
+		{65536, "\xce\x00\x01\x00\x00"},
+		{int64(1) << 32, "\xcf\x00\x00\x00\x01\x00\x00\x00\x00"},
+		{uint64(18446744073709551615), "\xcf\xff\xff\xff\xff\xff\xff\xff\xff"},
+		{-1, "\xff"},
+		{-32, "\xe0"},
+		{-33, "\xd0\xdf"},
+		{-129, "\xd1\xff\x7f"},
+		{-32769, "\xd2\xff\xff\x7f\xff"},
+		{int64(-1) << 40, "\xd3\xff\xff\xff\x00\x00\x00\x00\x00"},
+		{1.5, "\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00"},
+		{nil, "\xc0"},
+		{false, "\xc2"},
+		{true, "\xc3"},
+		{"hi", "\xa2hi"},
+		{strings.Repeat("x", 32), "\xd9\x20" + strings.Repeat("x", 32)},
+		{[]int{1, 2}, "\x92\x01\x02"},
+		{make([]int, 16), "\xdc\x00\x10" + strings.Repeat("\x00", 16)},
+		{map[string]bool{"b": false, "a": true}, "\x82\xa1a\xc3\xa1b\xc2"},
+	}
+	for _, tt := range tests {
+		got, err := httpx.MessagePackCodec.Marshal(tt.v)
+		if err != nil {
+			t.Errorf("%#v: %v", tt.v, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%#v: got %x, want %x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestMessagePackUnmarshal(t *testing.T) {
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"\x05", float64(5)},
+		{"\xfb", float64(-5)},
+		{"\xcd\x01\x00", float64(256)},
+		{"\xd0\x80", float64(-128)},
+		{"\xd1\x80\x00", float64(-32768)},
+		{"\xd2\xff\xff\xff\xfe", float64(-2)},
+		{"\xca\x3f\xc0\x00\x00", 1.5},
+		{"\xc0", nil},
+		{"\xc3", true},
+		{"\xda\x00\x02hi", "hi"},
+		{"\xc4\x03\x01\x02\x03", "AQID"},
+		{"\x93\x01\xa1a\xc0", []interface{}{float64(1), "a", nil}},
+		{"\xde\x00\x01\xa1k\x90", map[string]interface{}{"k": []interface{}{}}},
+		{"\x81\x07\x08", map[string]interface{}{"7": float64(8)}},
+		{"\xd6\xff\x5c\xa1\xfd\x40", "2019-04-01T12:00:00Z"},
+		{"\xd7\xff\x00\x00\x00\x04\x5c\xa1\xfd\x40", "2019-04-01T12:00:00.000000001Z"},
+		{"\xc7\x0c\xff\x00\x00\x00\x02\x00\x00\x00\x00\x5c\xa1\xfd\x40", "2019-04-01T12:00:00.000000002Z"},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := httpx.MessagePackCodec.Unmarshal([]byte(tt.data), &got); err != nil {
+			t.Errorf("%x: %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%x: got %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMessagePackUnmarshalInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"never used", "\xc1"},
+		{"truncated integer", "\xcd\x01"},
+		{"truncated string", "\xa3hi"},
+		{"oversized array", "\xdd\xff\xff\xff\xff\x00"},
+		{"trailing data", "\x00\x00"},
+		{"invalid UTF-8", "\xa1\xff"},
+		{"array key", "\x81\x90\x00"},
+		{"NaN", "\xcb\x7f\xf8\x00\x00\x00\x00\x00\x01"},
+		{"unknown extension", "\xd4\x01\x00"},
+		{"bad timestamp", "\xc7\x03\xff\x00\x00\x00"},
+		{"deep nesting", strings.Repeat("\x91", 2000) + "\x00"},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		if err := httpx.MessagePackCodec.Unmarshal([]byte(tt.data), &v); err == nil {
+			t.Errorf("%s: got %#v, want error", tt.name, v)
+		}
+	}
+}
+
+func TestMessagePackRoundTrip(t *testing.T) {
+	type doc struct {
+		Name    string         `json:"name"`
+		Count   int64          `json:"count"`
+		Ratio   float64        `json:"ratio"`
+		Data    []byte         `json:"data"`
+		Counts  map[string]int `json:"counts"`
+		Created time.Time      `json:"created"`
+	}
+	in := doc{
+		Name:    "widget",
+		Count:   -1 << 40,
+		Ratio:   0.25,
+		Data:    []byte{0, 1, 2, 255},
+		Counts:  map[string]int{"a": 1, "b": 300},
+		Created: time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+	b, err := httpx.MessagePackCodec.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out doc
+	if err := httpx.MessagePackCodec.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+
+	// Timestamps decode into time.Time fields.
+	var ts struct {
+		Created time.Time `json:"created"`
+	}
+	if err := httpx.MessagePackCodec.Unmarshal([]byte("\x81\xa7created\xd6\xff\x5c\xa1\xfd\x40"), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Created.Equal(in.Created) {
+		t.Errorf("Created == %v, want %v", ts.Created, in.Created)
+	}
+}
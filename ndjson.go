@@ -0,0 +1,124 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// NDJSONType is the media type of newline-delimited JSON.
+const NDJSONType = "application/x-ndjson"
+
+// DefaultNDJSONFlushInterval is the default interval at which an
+// NDJSONEncoder flushes records to the client.
+const DefaultNDJSONFlushInterval = 100 * time.Millisecond
+
+// An NDJSONEncoder streams newline-delimited JSON records as a response,
+// for endpoints which serve large result sets without buffering them.
+//
+// Records are flushed to the client periodically, rather than one by
+// one, such that fast producers make full use of the connection.
+// Writes block while the client does not keep up: each record must be
+// written within WriteTimeout, lest the encoder gives up on the client.
+type NDJSONEncoder struct {
+	// FlushInterval is the interval at which records are flushed. If
+	// zero, DefaultNDJSONFlushInterval is used. If negative, each
+	// record is flushed as it is encoded.
+	FlushInterval time.Duration
+
+	// WriteTimeout, if not zero, bounds the time it takes to write each
+	// record, for servers which support write deadlines.
+	WriteTimeout time.Duration
+
+	w         http.ResponseWriter
+	req       *http.Request
+	rc        *http.ResponseController
+	n         int
+	lastFlush time.Time
+	err       error
+}
+
+// NewNDJSONEncoder returns an NDJSONEncoder which writes the response to
+// req to w. It sets the Content-Type header, but does not write the
+// header, so the handler may still fail with an error response until the
+// first record is encoded.
+func NewNDJSONEncoder(w http.ResponseWriter, req *http.Request) *NDJSONEncoder {
+	h := w.Header()
+	h.Set("Content-Type", NDJSONType)
+	h.Del("Content-Length")
+	return &NDJSONEncoder{
+		w:         w,
+		req:       req,
+		rc:        http.NewResponseController(w),
+		lastFlush: time.Now(),
+	}
+}
+
+// Encode writes the JSON encoding of v as a record. If the client has
+// gone away, or a previous record failed to be written, Encode returns
+// the error, and the producer should stop. Records which fail to encode
+// are not written at all, and do not end the stream.
+func (e *NDJSONEncoder) Encode(v interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.req.Context().Err(); err != nil {
+		e.err = err
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if e.WriteTimeout > 0 {
+		// Servers which do not support deadlines report
+		// http.ErrNotSupported, and the deadline is moot.
+		e.rc.SetWriteDeadline(time.Now().Add(e.WriteTimeout))
+	}
+	if _, err := e.w.Write(append(b, '\n')); err != nil {
+		e.err = err
+		return err
+	}
+	e.n++
+	interval := e.FlushInterval
+	if interval == 0 {
+		interval = DefaultNDJSONFlushInterval
+	}
+	if time.Since(e.lastFlush) >= interval {
+		return e.Flush()
+	}
+	return nil
+}
+
+// Flush flushes the records written so far to the client. ResponseWriters
+// which cannot be flushed are left to flush when the handler returns.
+func (e *NDJSONEncoder) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.lastFlush = time.Now()
+	if err := e.rc.Flush(); err != nil && err != http.ErrNotSupported {
+		e.err = err
+		return err
+	}
+	return nil
+}
+
+// Count returns the number of records written.
+func (e *NDJSONEncoder) Count() int {
+	return e.n
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestNDJSONEncoder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	enc := httpx.NewNDJSONEncoder(rec, httptest.NewRequest("GET", "/", nil))
+	enc.FlushInterval = -1
+	for _, v := range []interface{}{1, "two", map[string]int{"three": 3}} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Encode(make(chan int)); err == nil {
+		t.Error("encoded a channel")
+	}
+	if err := enc.Encode(4); err != nil {
+		t.Errorf("after encoding error: %v", err)
+	}
+	want := "1\n\"two\"\n{\"three\":3}\n4\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body == %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); got != httpx.NDJSONType {
+		t.Errorf("Content-Type == %q, want %q", got, httpx.NDJSONType)
+	}
+	if !rec.Flushed {
+		t.Error("records not flushed")
+	}
+	if n := enc.Count(); n != 4 {
+		t.Errorf("Count == %d, want 4", n)
+	}
+}
+
+func TestNDJSONEncoderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	enc := httpx.NewNDJSONEncoder(rec, req)
+	enc.Encode(1)
+	cancel()
+	if err := enc.Encode(2); err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if got := rec.Body.String(); got != "1\n" {
+		t.Errorf("body == %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// NoticeHeader is the response header into which NoticeHandler serializes
+// notices.
+const NoticeHeader = "X-Notice"
+
+// Common notice kinds.
+const (
+	NoticeDeprecated = "deprecated"
+	NoticeThrottled  = "throttled"
+	NoticePartial    = "partial"
+)
+
+// A Notice is a machine-readable message attached to a response, such as
+// a deprecation, a throttling warning, or an indication that the result
+// is partial.
+type Notice struct {
+	// Kind classifies the notice, e.g. NoticeDeprecated.
+	Kind string `json:"kind"`
+
+	// Message is a human-readable description.
+	Message string `json:"message,omitempty"`
+
+	// Link optionally points to documentation about the notice.
+	Link string `json:"link,omitempty"`
+}
+
+// String returns the header serialization of the notice, of the form
+//
+//	kind; message="..."; link="..."
+func (n Notice) String() string {
+	s := n.Kind
+	if n.Message != "" {
+		s += "; message=" + strconv.Quote(n.Message)
+	}
+	if n.Link != "" {
+		s += "; link=" + strconv.Quote(n.Link)
+	}
+	return s
+}
+
+// AddNotice attaches a notice to the response to req. If req was not
+// served by NoticeHandler, or another handler which installs request
+// state, AddNotice is a no-op.
+func AddNotice(req *http.Request, n Notice) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	st.notices = append(st.notices, n)
+	st.mu.Unlock()
+}
+
+// Notices returns the notices attached to the response to req so far.
+func Notices(req *http.Request) []Notice {
+	st := stateOf(req)
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]Notice(nil), st.notices...)
+}
+
+// NoticeHandler returns a handler which calls next, and serializes the
+// notices attached by means of AddNotice into the X-Notice response
+// header, one value per notice. Notices must be attached before the
+// response header is written.
+func NoticeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithRequestState(req)
+		w, finish := beforeWrite(w, func() {
+			for _, n := range Notices(req) {
+				w.Header().Add(NoticeHeader, n.String())
+			}
+		})
+		next.ServeHTTP(w, req)
+		finish()
+	})
+}
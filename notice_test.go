@@ -0,0 +1,43 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestNoticeHandler(t *testing.T) {
+	h := httpx.NoticeHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.AddNotice(req, httpx.Notice{
+			Kind:    httpx.NoticeDeprecated,
+			Message: "use /v2/users",
+		})
+		httpx.AddNotice(req, httpx.Notice{Kind: httpx.NoticePartial})
+		io.WriteString(w, "ok")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	want := []string{`deprecated; message="use /v2/users"`, "partial"}
+	if got := rec.Header()[httpx.NoticeHeader]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s %q, want %q", httpx.NoticeHeader, got, want)
+	}
+}
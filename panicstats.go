@@ -0,0 +1,122 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A PanicRecord describes a panic which occurred while serving a request.
+type PanicRecord struct {
+	Time      time.Time
+	Route     string
+	RequestID string
+	Value     string
+	Stack     []byte
+}
+
+// PanicStats counts panics by route, and retains the most recent of them,
+// along with their stacks, which makes crash loops diagnosable without
+// scraping logs. The zero value is ready to use, and retains
+// DefaultPanicRetention records.
+type PanicStats struct {
+	// Retain is the number of records to retain. If zero,
+	// DefaultPanicRetention is used.
+	Retain int
+
+	mu     sync.Mutex
+	counts map[string]int64
+	recent []PanicRecord
+}
+
+// DefaultPanicRetention is the number of records retained by PanicStats,
+// unless configured otherwise.
+const DefaultPanicRetention = 16
+
+// Record counts the panic described by r, and retains it, evicting the
+// oldest record if necessary. Requests without a route are counted under
+// "*".
+func (ps *PanicStats) Record(r PanicRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	route := r.Route
+	if route == "" {
+		route = "*"
+	}
+	retain := ps.Retain
+	if retain <= 0 {
+		retain = DefaultPanicRetention
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.counts == nil {
+		ps.counts = make(map[string]int64)
+	}
+	ps.counts[route]++
+	if len(ps.recent) >= retain {
+		n := copy(ps.recent, ps.recent[len(ps.recent)-retain+1:])
+		ps.recent = ps.recent[:n]
+	}
+	ps.recent = append(ps.recent, r)
+}
+
+// Counts returns the number of panics recorded, by route.
+func (ps *PanicStats) Counts() map[string]int64 {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	counts := make(map[string]int64, len(ps.counts))
+	for route, n := range ps.counts {
+		counts[route] = n
+	}
+	return counts
+}
+
+// Recent returns the retained records, most recent first.
+func (ps *PanicStats) Recent() []PanicRecord {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	recent := make([]PanicRecord, len(ps.recent))
+	for i, r := range ps.recent {
+		recent[len(recent)-1-i] = r
+	}
+	return recent
+}
+
+// ServeHTTP renders the counts, followed by the retained records and
+// their stacks, as plain text.
+func (ps *PanicStats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	counts := ps.Counts()
+	routes := make([]string, 0, len(counts))
+	for route := range counts {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%d %s\n", counts[route], route)
+	}
+	for _, r := range ps.Recent() {
+		id := r.RequestID
+		if id == "" {
+			id = "-"
+		}
+		fmt.Fprintf(w, "\n%s %s %s: %s\n%s", r.Time.Format(time.RFC3339Nano), id, r.Route, r.Value, r.Stack)
+	}
+}
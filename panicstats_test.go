@@ -0,0 +1,48 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestPanicStats(t *testing.T) {
+	ps := &httpx.PanicStats{Retain: 2}
+	ps.Record(httpx.PanicRecord{Route: "/a", Value: "first"})
+	ps.Record(httpx.PanicRecord{Route: "/a", Value: "second"})
+	ps.Record(httpx.PanicRecord{Value: "third", Stack: []byte("goroutine 1 [running]:\n")})
+
+	counts := ps.Counts()
+	if counts["/a"] != 2 || counts["*"] != 1 {
+		t.Errorf("Counts() == %v, want 2 for /a and 1 for *", counts)
+	}
+	recent := ps.Recent()
+	if len(recent) != 2 || recent[0].Value != "third" || recent[1].Value != "second" {
+		t.Fatalf("Recent() == %v, want third, second", recent)
+	}
+
+	rec := httptest.NewRecorder()
+	ps.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/panics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{"1 *\n2 /a\n", ": third\ngoroutine 1 [running]:"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body %q does not contain %q", body, want)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types for PATCH request bodies.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// MaxPatchBytes is the limit on the size of the patch documents read by
+// ApplyPatch. It must be set before serving requests.
+var MaxPatchBytes int64 = DefaultMaxBodyBytes
+
+// ErrPatchMediaType is returned by ApplyPatch if the request body is
+// neither a JSON Merge Patch, nor a JSON Patch.
+var ErrPatchMediaType = errors.New("httpx: unsupported patch media type")
+
+// A PatchError describes an invalid patch, or a patch operation which
+// could not be applied.
+type PatchError struct {
+	// Index is the index of the failing operation within a JSON Patch,
+	// or -1 for JSON Merge Patch documents.
+	Index int
+
+	// Op is the name of the failing operation, or "merge".
+	Op string
+
+	// Path is the JSON Pointer the operation refers to.
+	Path string
+
+	// Err describes the failure.
+	Err error
+}
+
+func (e *PatchError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("httpx: merge patch %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("httpx: patch operation %d (%s %s): %v", e.Index, e.Op, e.Path, e.Err)
+}
+
+var (
+	errPathNotFound  = errors.New("path not found")
+	errPathForbidden = errors.New("path not allowed")
+	errBadPointer    = errors.New("malformed JSON pointer")
+	errBadIndex      = errors.New("invalid array index")
+	errTestFailed    = errors.New("test failed")
+	errMissingValue  = errors.New("missing value")
+)
+
+// ApplyPatch reads a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902)
+// from the body of req, selected according to the Content-Type header,
+// and applies it to target, which must be a pointer to a value which
+// round-trips through encoding/json, such as a struct or a map.
+//
+// If allow is not nil, the patch may only modify the locations it lists,
+// or locations nested under them. Locations are expressed as JSON
+// Pointers, e.g. "/name" or "/address/city".
+//
+// Invalid patches and operations which cannot be applied are reported
+// as *PatchError values. Patch documents larger than MaxPatchBytes are
+// rejected with an *http.MaxBytesError, for which IsBodyTooLarge reports
+// true, and which ErrorMapper maps to 413 Content Too Large. If an error
+// occurs, target is not modified.
+func ApplyPatch(req *http.Request, target interface{}, allow []string) error {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || (mt != MergePatchType && mt != JSONPatchType) {
+		return ErrPatchMediaType
+	}
+	patch, err := readBody(req, MaxPatchBytes)
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	if mt == MergePatchType {
+		doc, err = mergePatch(doc, patch, allow)
+	} else {
+		doc, err = jsonPatch(doc, patch, allow)
+	}
+	if err != nil {
+		return err
+	}
+	// Decode into a fresh value, so that removed fields are reset.
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("httpx: ApplyPatch target must be a non-nil pointer")
+	}
+	fresh := reflect.New(rv.Elem().Type())
+	if err := json.Unmarshal(doc, fresh.Interface()); err != nil {
+		return err
+	}
+	rv.Elem().Set(fresh.Elem())
+	return nil
+}
+
+// MergePatch applies the JSON Merge Patch patch to the JSON document doc,
+// as specified by RFC 7396, and returns the resulting document.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	return mergePatch(doc, patch, nil)
+}
+
+func mergePatch(doc, patch []byte, allow []string) ([]byte, error) {
+	var target, p interface{}
+	if err := decodeJSON(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(patch, &p); err != nil {
+		return nil, &PatchError{Index: -1, Op: "merge", Err: err}
+	}
+	if allow != nil {
+		if path, ok := mergeAllowed(p, "", allow); !ok {
+			return nil, &PatchError{Index: -1, Op: "merge", Path: path, Err: errPathForbidden}
+		}
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]interface{})
+	if !ok {
+		tm = make(map[string]interface{})
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = mergeValue(tm[k], v)
+		}
+	}
+	return tm
+}
+
+// mergeAllowed checks every location modified by a merge patch against
+// the allowlist. It returns the first location which is not allowed.
+func mergeAllowed(patch interface{}, path string, allow []string) (string, bool) {
+	pm, ok := patch.(map[string]interface{})
+	if !ok || len(pm) == 0 {
+		return path, pathAllowed(path, allow)
+	}
+	for k, v := range pm {
+		if p, ok := mergeAllowed(v, path+"/"+escapePointer(k), allow); !ok {
+			return p, false
+		}
+	}
+	return "", true
+}
+
+// JSONPatch applies the JSON Patch patch to the JSON document doc, as
+// specified by RFC 6902, and returns the resulting document.
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	return jsonPatch(doc, patch, nil)
+}
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func jsonPatch(doc, patch []byte, allow []string) ([]byte, error) {
+	var target interface{}
+	if err := decodeJSON(doc, &target); err != nil {
+		return nil, err
+	}
+	var ops []patchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, &PatchError{Err: err}
+	}
+	for i, op := range ops {
+		var err error
+		target, err = applyOp(target, op, allow)
+		if err != nil {
+			pe := &PatchError{Index: i, Op: op.Op, Err: err}
+			if op.Path != nil {
+				pe.Path = *op.Path
+			}
+			return nil, pe
+		}
+	}
+	return json.Marshal(target)
+}
+
+func applyOp(doc interface{}, op patchOp, allow []string) (interface{}, error) {
+	if op.Path == nil {
+		return nil, errors.New("missing path")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var from []string
+	switch op.Op {
+	case "move", "copy":
+		if op.From == nil {
+			return nil, errors.New("missing from")
+		}
+		if from, err = parsePointer(*op.From); err != nil {
+			return nil, err
+		}
+	}
+	if allow != nil && op.Op != "test" {
+		if !pathAllowed(*op.Path, allow) {
+			return nil, errPathForbidden
+		}
+		if op.Op == "move" && !pathAllowed(*op.From, allow) {
+			return nil, errPathForbidden
+		}
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errMissingValue
+		}
+		if err := decodeJSON(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addValue(doc, path, value)
+	case "remove":
+		return removeValue(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		return update(doc, path, func(c interface{}, tok string) (interface{}, error) {
+			switch c := c.(type) {
+			case map[string]interface{}:
+				if _, ok := c[tok]; !ok {
+					return nil, errPathNotFound
+				}
+				c[tok] = value
+				return c, nil
+			case []interface{}:
+				i, err := arrayIndex(tok, len(c)-1)
+				if err != nil {
+					return nil, err
+				}
+				c[i] = value
+				return c, nil
+			default:
+				return nil, errPathNotFound
+			}
+		})
+	case "move":
+		if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+			return nil, errors.New("cannot move a value into one of its children")
+		}
+		v, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = removeValue(doc, from); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "copy":
+		v, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := json.Marshal(v)
+		if err := decodeJSON(b, &v); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "test":
+		v, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(v, value) {
+			return nil, errTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(c interface{}, tok string) (interface{}, error) {
+		switch c := c.(type) {
+		case map[string]interface{}:
+			c[tok] = value
+			return c, nil
+		case []interface{}:
+			if tok == "-" {
+				return append(c, value), nil
+			}
+			i, err := arrayIndex(tok, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the root document")
+	}
+	return update(doc, path, func(c interface{}, tok string) (interface{}, error) {
+		switch c := c.(type) {
+		case map[string]interface{}:
+			if _, ok := c[tok]; !ok {
+				return nil, errPathNotFound
+			}
+			delete(c, tok)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(tok, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for _, tok := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[tok]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(tok, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, errPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// update walks doc to the container holding the location named by path,
+// which must not be empty, and replaces that container by the result of
+// calling op with the container and the final reference token.
+func update(doc interface{}, path []string, op func(c interface{}, tok string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return op(doc, path[0])
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, ok := c[path[0]]
+		if !ok {
+			return nil, errPathNotFound
+		}
+		nc, err := update(child, path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c[path[0]] = nc
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(c)-1)
+		if err != nil {
+			return nil, err
+		}
+		nc, err := update(c[i], path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = nc
+		return c, nil
+	default:
+		return nil, errPathNotFound
+	}
+}
+
+// arrayIndex parses an array index reference token, which must be
+// at most max.
+func arrayIndex(tok string, max int) (int, error) {
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, errBadIndex
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || i > max {
+		return 0, errBadIndex
+	}
+	return i, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into reference tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, errBadPointer
+	}
+	toks := strings.Split(p[1:], "/")
+	for i, tok := range toks {
+		toks[i] = strings.Replace(strings.Replace(tok, "~1", "/", -1), "~0", "~", -1)
+	}
+	return toks, nil
+}
+
+func escapePointer(tok string) string {
+	return strings.Replace(strings.Replace(tok, "~", "~0", -1), "/", "~1", -1)
+}
+
+// pathAllowed reports whether path equals one of the allowed pointers,
+// or is nested under one.
+func pathAllowed(path string, allow []string) bool {
+	for _, a := range allow {
+		if path == a || strings.HasPrefix(path, a+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeJSON(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// jsonEqual compares decoded JSON values, treating numbers numerically.
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := a.Float64()
+		bf, berr := b.Float64()
+		return aerr == nil && berr == nil && af == bf
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !jsonEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7396, Appendix A.
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := httpx.MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("MergePatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !jsonEq(t, got, []byte(tt.want)) {
+			t.Errorf("MergePatch(%s, %s) == %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"qux"}]`, `{"foo":["bar","qux"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"a/b":1,"m~n":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`, `{}`},
+	}
+	for _, tt := range tests {
+		got, err := httpx.JSONPatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("JSONPatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !jsonEq(t, got, []byte(tt.want)) {
+			t.Errorf("JSONPatch(%s, %s) == %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		doc, patch string
+		index      int
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, 0},
+		{`{"foo":[]}`, `[{"op":"add","path":"/foo/-","value":1},{"op":"remove","path":"/foo/1"}]`, 1},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"frobnicate","path":"/foo"}]`, 0},
+		{`{"foo":[1]}`, `[{"op":"replace","path":"/foo/01","value":2}]`, 0},
+	}
+	for _, tt := range tests {
+		_, err := httpx.JSONPatch([]byte(tt.doc), []byte(tt.patch))
+		pe, ok := err.(*httpx.PatchError)
+		if !ok {
+			t.Errorf("JSONPatch(%s, %s): got error %v, want *PatchError", tt.doc, tt.patch, err)
+			continue
+		}
+		if pe.Index != tt.index {
+			t.Errorf("JSONPatch(%s, %s): error at index %d, want %d", tt.doc, tt.patch, pe.Index, tt.index)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+	}
+	type user struct {
+		Name    string   `json:"name"`
+		Admin   bool     `json:"admin"`
+		Address address  `json:"address"`
+		Tags    []string `json:"tags,omitempty"`
+	}
+	allow := []string{"/name", "/address", "/tags"}
+
+	tests := []struct {
+		ctype string
+		patch string
+		want  user
+		err   bool
+	}{
+		{httpx.MergePatchType, `{"name":"bob","address":{"city":"Paris"}}`,
+			user{Name: "bob", Address: address{City: "Paris"}, Tags: []string{"x"}}, false},
+		{httpx.JSONPatchType, `[{"op":"remove","path":"/tags"}]`,
+			user{Name: "alice"}, false},
+		{httpx.MergePatchType, `{"admin":true}`, user{}, true},
+		{httpx.JSONPatchType, `[{"op":"replace","path":"/admin","value":true}]`, user{}, true},
+		{"application/json", `{"name":"bob"}`, user{}, true},
+	}
+	for _, tt := range tests {
+		u := user{Name: "alice", Tags: []string{"x"}}
+		orig := u
+		req := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.patch))
+		req.Header.Set("Content-Type", tt.ctype)
+		err := httpx.ApplyPatch(req, &u, allow)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s %s: no error", tt.ctype, tt.patch)
+			}
+			if !reflect.DeepEqual(u, orig) {
+				t.Errorf("%s %s: target modified on error", tt.ctype, tt.patch)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.ctype, tt.patch, err)
+			continue
+		}
+		if !reflect.DeepEqual(u, tt.want) {
+			t.Errorf("%s %s: got %+v, want %+v", tt.ctype, tt.patch, u, tt.want)
+		}
+	}
+}
+
+func jsonEq(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var av, bv interface{}
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatal(err)
+	}
+	ab, _ := json.Marshal(av)
+	bb, _ := json.Marshal(bv)
+	return bytes.Equal(ab, bb)
+}
+
+func TestApplyPatchTooLarge(t *testing.T) {
+	defer func(n int64) { httpx.MaxPatchBytes = n }(httpx.MaxPatchBytes)
+	httpx.MaxPatchBytes = 16
+
+	var v struct {
+		Name string `json:"name"`
+	}
+	h := httpx.HandlerE(func(w http.ResponseWriter, req *http.Request) error {
+		return httpx.ApplyPatch(req, &v, nil)
+	})
+	for _, streamed := range []bool{false, true} {
+		req := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"name":"a rather long name"}`))
+		req.Header.Set("Content-Type", httpx.MergePatchType)
+		if streamed {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Content-Length %d: got status %d, want %d", req.ContentLength, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+		if v.Name != "" {
+			t.Errorf("target modified: %+v", v)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the buckets used by
+// PoolTransport to record connection latencies.
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// A Histogram is a distribution of latencies.
+type Histogram struct {
+	// Buckets holds the upper bounds of the buckets, in increasing order.
+	Buckets []time.Duration `json:"buckets"`
+
+	// Counts holds the number of observations in each bucket, such that
+	// Counts[i] counts the observations greater than Buckets[i-1], and
+	// lower than or equal to Buckets[i]. The final element counts the
+	// observations greater than all bounds.
+	Counts []int64 `json:"counts"`
+
+	// Count and Sum are the number and the sum of all observations.
+	Count int64         `json:"count"`
+	Sum   time.Duration `json:"sum"`
+}
+
+func newHistogram(buckets []time.Duration) Histogram {
+	return Histogram{Buckets: buckets, Counts: make([]int64, len(buckets)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := sort.Search(len(h.Buckets), func(i int) bool { return d <= h.Buckets[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]int64(nil), h.Counts...)
+	return h
+}
+
+// HostPoolStats are connection pool statistics for one upstream host.
+type HostPoolStats struct {
+	// NewConns and ReusedConns count the requests which were sent on new
+	// and reused connections respectively.
+	NewConns    int64 `json:"new_conns"`
+	ReusedConns int64 `json:"reused_conns"`
+
+	// Open counts the connections currently open, and InFlight the
+	// requests currently in progress.
+	Open     int64 `json:"open"`
+	InFlight int64 `json:"in_flight"`
+
+	// Idle approximates the number of idle connections in the pool, as
+	// the number of open connections not used by requests in flight. It
+	// is only accurate for HTTP/1 connections.
+	Idle int64 `json:"idle"`
+
+	// Dial and TLSHandshake are the distributions of the latencies of
+	// establishing new connections, and of their TLS handshakes.
+	Dial         Histogram `json:"dial"`
+	TLSHandshake Histogram `json:"tls_handshake"`
+}
+
+// PoolTransport is an http.RoundTripper which records connection pool
+// statistics per upstream host, to help diagnose connection churn.
+//
+// PoolTransport also implements http.Handler: it serves the statistics
+// as a JSON object keyed by upstream address, for use as a debug endpoint.
+type PoolTransport struct {
+	transport *http.Transport
+	buckets   []time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*HostPoolStats
+}
+
+// NewPoolTransport returns a PoolTransport which makes requests using a
+// clone of base. If base is nil, http.DefaultTransport is used.
+func NewPoolTransport(base *http.Transport) *PoolTransport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	pt := &PoolTransport{
+		transport: base.Clone(),
+		buckets:   DefaultLatencyBuckets,
+		hosts:     make(map[string]*HostPoolStats),
+	}
+	dial := pt.transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	pt.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		pt.update(addr, func(hs *HostPoolStats) {
+			hs.Open++
+			hs.Dial.observe(time.Since(start))
+		})
+		return &poolConn{Conn: conn, pt: pt, addr: addr}, nil
+	}
+	return pt
+}
+
+// RoundTrip implements http.RoundTripper.
+func (pt *PoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := canonicalAddr(req.URL)
+	var tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			pt.update(addr, func(hs *HostPoolStats) {
+				if info.Reused {
+					hs.ReusedConns++
+				} else {
+					hs.NewConns++
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil && !tlsStart.IsZero() {
+				d := time.Since(tlsStart)
+				pt.update(addr, func(hs *HostPoolStats) { hs.TLSHandshake.observe(d) })
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	pt.update(addr, func(hs *HostPoolStats) { hs.InFlight++ })
+	done := func() {
+		pt.update(addr, func(hs *HostPoolStats) { hs.InFlight-- })
+	}
+	resp, err := pt.transport.RoundTrip(req)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &poolBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// Stats returns a snapshot of the statistics, keyed by upstream address,
+// in host:port form.
+func (pt *PoolTransport) Stats() map[string]HostPoolStats {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	stats := make(map[string]HostPoolStats, len(pt.hosts))
+	for addr, hs := range pt.hosts {
+		s := *hs
+		s.Dial = hs.Dial.clone()
+		s.TLSHandshake = hs.TLSHandshake.clone()
+		if s.Idle = s.Open - s.InFlight; s.Idle < 0 {
+			s.Idle = 0
+		}
+		stats[addr] = s
+	}
+	return stats
+}
+
+// ServeHTTP serves the statistics returned by Stats, as JSON.
+func (pt *PoolTransport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	WriteJSON(w, http.StatusOK, pt.Stats())
+}
+
+// CloseIdleConnections closes the idle connections of the underlying
+// transport.
+func (pt *PoolTransport) CloseIdleConnections() {
+	pt.transport.CloseIdleConnections()
+}
+
+func (pt *PoolTransport) update(addr string, fn func(*HostPoolStats)) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	hs, ok := pt.hosts[addr]
+	if !ok {
+		hs = &HostPoolStats{
+			Dial:         newHistogram(pt.buckets),
+			TLSHandshake: newHistogram(pt.buckets),
+		}
+		pt.hosts[addr] = hs
+	}
+	fn(hs)
+}
+
+// canonicalAddr returns the host:port address of u, with the default
+// port of the scheme filled in, matching the address the transport dials.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// poolConn decrements the number of open connections when it is closed.
+type poolConn struct {
+	net.Conn
+	pt   *PoolTransport
+	addr string
+	once sync.Once
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(func() {
+		c.pt.update(c.addr, func(hs *HostPoolStats) { hs.Open-- })
+	})
+	return c.Conn.Close()
+}
+
+// poolBody marks the end of a request when the response body is closed.
+type poolBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *poolBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
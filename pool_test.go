@@ -0,0 +1,73 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestPoolTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	pt := httpx.NewPoolTransport(srv.Client().Transport.(*http.Transport))
+	defer pt.CloseIdleConnections()
+	client := &http.Client{Transport: pt}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, ok := pt.Stats()[u.Host]
+	if !ok {
+		t.Fatalf("no statistics for %s in %v", u.Host, pt.Stats())
+	}
+	if hs.NewConns != 1 || hs.ReusedConns != 2 {
+		t.Errorf("got %d new, %d reused connections, want 1, 2", hs.NewConns, hs.ReusedConns)
+	}
+	if hs.Open != 1 || hs.InFlight != 0 || hs.Idle != 1 {
+		t.Errorf("got %d open, %d in flight, %d idle, want 1, 0, 1", hs.Open, hs.InFlight, hs.Idle)
+	}
+	if hs.Dial.Count != 1 || hs.TLSHandshake.Count != 1 {
+		t.Errorf("got %d dials, %d handshakes, want 1, 1", hs.Dial.Count, hs.TLSHandshake.Count)
+	}
+
+	rec := httptest.NewRecorder()
+	pt.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pool", nil))
+	var stats map[string]httpx.HostPoolStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats[u.Host].ReusedConns != 2 {
+		t.Errorf("debug endpoint reports %d reused connections, want 2", stats[u.Host].ReusedConns)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RequireIfMatch returns a handler which rejects PUT, PATCH and DELETE
+// requests that do not carry an If-Match header with status 428
+// (Precondition Required), and calls next for all other requests.
+//
+// RequireIfMatch enforces optimistic concurrency control across all the
+// handlers it wraps. The handlers themselves compare the header against
+// the current version of the resource using IfMatch.
+func RequireIfMatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if req.Header.Get("If-Match") == "" {
+				http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// IfMatch checks the If-Match header of req against etag, the entity tag
+// of the current version of the resource. etag may be specified with or
+// without the surrounding quotes. An empty etag means the resource does
+// not exist.
+//
+// If the request carries no If-Match header, IfMatch returns true, unless
+// required is set, in which case it responds with status 428 (Precondition
+// Required). If the header does not match, IfMatch responds with status
+// 412 (Precondition Failed). Whenever IfMatch returns false, a response
+// has been written, and the caller must not modify the resource.
+//
+// As mandated by RFC 7232, the comparison is strong: weak entity tags
+// never match.
+func IfMatch(w http.ResponseWriter, req *http.Request, etag string, required bool) bool {
+	header := req.Header.Get("If-Match")
+	if header == "" {
+		if required {
+			http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+			return false
+		}
+		return true
+	}
+	if !matchETag(header, etag) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// matchETag performs the strong comparison of an If-Match header against
+// the current entity tag.
+func matchETag(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = quoteETag(etag)
+	if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteETag adds quotes to etag, if it does not have them already.
+func quoteETag(etag string) string {
+	if strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
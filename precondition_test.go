@@ -0,0 +1,82 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header   string
+		etag     string
+		required bool
+		ok       bool
+		status   int
+	}{
+		{"", "v1", false, true, http.StatusOK},
+		{"", "v1", true, false, http.StatusPreconditionRequired},
+		{`"v1"`, "v1", true, true, http.StatusOK},
+		{`"v1"`, `"v1"`, true, true, http.StatusOK},
+		{`"v0", "v1"`, "v1", true, true, http.StatusOK},
+		{`"v0"`, "v1", true, false, http.StatusPreconditionFailed},
+		{`W/"v1"`, "v1", true, false, http.StatusPreconditionFailed},
+		{`*`, "v1", true, true, http.StatusOK},
+		{`*`, "", true, false, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/", nil)
+		if tt.header != "" {
+			req.Header.Set("If-Match", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		ok := httpx.IfMatch(rec, req, tt.etag, tt.required)
+		if ok != tt.ok || rec.Code != tt.status {
+			t.Errorf("If-Match %q, etag %q, required %t: got %t, %d, want %t, %d",
+				tt.header, tt.etag, tt.required, ok, rec.Code, tt.ok, tt.status)
+		}
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	h := httpx.RequireIfMatch(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	tests := []struct {
+		method string
+		header string
+		status int
+	}{
+		{"GET", "", http.StatusOK},
+		{"POST", "", http.StatusOK},
+		{"PUT", "", http.StatusPreconditionRequired},
+		{"DELETE", "", http.StatusPreconditionRequired},
+		{"PATCH", `"v1"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		if tt.header != "" {
+			req.Header.Set("If-Match", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s with If-Match %q: got status %d, want %d",
+				tt.method, tt.header, rec.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ProblemType is the media type of problem details, as described by
+// RFC 9457.
+const ProblemType = "application/problem+json"
+
+// A Problem is a problem details object, as described by RFC 9457, which
+// describes an error in a machine-readable form. A *Problem is an error,
+// and may be returned as such from a HandlerE.
+type Problem struct {
+	// Type is a URI reference which identifies the type of problem. If
+	// empty, "about:blank" is used.
+	Type string
+
+	// Title is a short summary of the type of problem. If Title and
+	// Type are empty, the status text is used.
+	Title string
+
+	// Status is the status code of the response.
+	Status int
+
+	// Detail explains this occurrence of the problem, for humans.
+	Detail string
+
+	// Instance is a URI reference which identifies this occurrence of
+	// the problem.
+	Instance string
+
+	// Extensions holds additional members of the problem details
+	// object. Members which collide with the fields above are ignored.
+	Extensions map[string]interface{}
+}
+
+// NewProblem returns a Problem with the specified status and detail.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Status: status, Detail: detail}
+}
+
+// With sets the extension member key to value, and returns p.
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) Error() string {
+	msg := strconv.Itoa(p.Status) + " " + p.title()
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	return msg
+}
+
+func (p *Problem) title() string {
+	if p.Title == "" && p.Type == "" {
+		return http.StatusText(p.Status)
+	}
+	return p.Title
+}
+
+// MarshalJSON implements json.Marshaler. The extension members are
+// encoded alongside the standard members.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = "about:blank"
+	}
+	m["type"] = typ
+	m["status"] = p.Status
+	setIf := func(k, v string) {
+		if v != "" {
+			m[k] = v
+		} else {
+			delete(m, k)
+		}
+	}
+	setIf("title", p.title())
+	setIf("detail", p.Detail)
+	setIf("instance", p.Instance)
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, for clients which decode
+// problem details. Unknown members are stored in Extensions.
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*p = Problem{}
+	for k, raw := range m {
+		var err error
+		switch k {
+		case "type":
+			err = json.Unmarshal(raw, &p.Type)
+		case "title":
+			err = json.Unmarshal(raw, &p.Title)
+		case "status":
+			err = json.Unmarshal(raw, &p.Status)
+		case "detail":
+			err = json.Unmarshal(raw, &p.Detail)
+		case "instance":
+			err = json.Unmarshal(raw, &p.Instance)
+		default:
+			var v interface{}
+			err = json.Unmarshal(raw, &v)
+			p.With(k, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteProblem writes p as the response to req. If the request has an ID,
+// as set by WithRequestID, it is included in the "request_id" extension
+// member, such that clients can quote it when reporting the problem. If
+// p.Status is zero, 500 Internal Server Error is used.
+func WriteProblem(w http.ResponseWriter, req *http.Request, p *Problem) {
+	if p.Status == 0 {
+		cp := *p
+		cp.Status = http.StatusInternalServerError
+		p = &cp
+	}
+	if id := RequestID(req); id != "" {
+		if _, ok := p.Extensions["request_id"]; !ok {
+			cp := *p
+			cp.Extensions = make(map[string]interface{}, len(p.Extensions)+1)
+			for k, v := range p.Extensions {
+				cp.Extensions[k] = v
+			}
+			cp.Extensions["request_id"] = id
+			p = &cp
+		}
+	}
+	p.write(w)
+}
+
+func (p *Problem) write(w http.ResponseWriter) {
+	b, err := p.MarshalJSON()
+	if err != nil {
+		// An extension member cannot be encoded. Send the
+		// standard members only.
+		b, _ = (&Problem{Type: p.Type, Title: p.Title, Status: p.Status, Detail: p.Detail, Instance: p.Instance}).MarshalJSON()
+	}
+	b = append(b, '\n')
+	h := w.Header()
+	h.Set("Content-Type", ProblemType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(p.Status)
+	w.Write(b)
+}
+
+// writeProblem writes a problem details object with the specified status
+// and detail.
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	NewProblem(status, detail).write(w)
+}
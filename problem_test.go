@@ -0,0 +1,124 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestWriteProblem(t *testing.T) {
+	tests := []struct {
+		name    string
+		problem *httpx.Problem
+		id      string
+		status  int
+		want    map[string]interface{}
+	}{
+		{
+			name:    "defaults",
+			problem: &httpx.Problem{},
+			status:  http.StatusInternalServerError,
+			want: map[string]interface{}{
+				"type":   "about:blank",
+				"title":  "Internal Server Error",
+				"status": float64(500),
+			},
+		},
+		{
+			name: "typed",
+			problem: httpx.NewProblem(http.StatusForbidden, "not enough credit").
+				With("balance", 30).
+				With("status", "ignored"),
+			id:     "r1",
+			status: http.StatusForbidden,
+			want: map[string]interface{}{
+				"type":       "about:blank",
+				"title":      "Forbidden",
+				"status":     float64(403),
+				"detail":     "not enough credit",
+				"balance":    float64(30),
+				"request_id": "r1",
+			},
+		},
+		{
+			name: "custom type",
+			problem: &httpx.Problem{
+				Type:     "https://example.com/probs/out-of-credit",
+				Status:   http.StatusForbidden,
+				Instance: "/account/12345/msgs/abc",
+			},
+			status: http.StatusForbidden,
+			want: map[string]interface{}{
+				"type":     "https://example.com/probs/out-of-credit",
+				"status":   float64(403),
+				"instance": "/account/12345/msgs/abc",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.id != "" {
+				req = httpx.WithRequestID(req, tt.id)
+			}
+			rec := httptest.NewRecorder()
+			httpx.WriteProblem(rec, req, tt.problem)
+			if rec.Code != tt.status {
+				t.Errorf("status == %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != httpx.ProblemType {
+				t.Errorf("Content-Type == %q, want %q", got, httpx.ProblemType)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body == %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblemRoundTrip(t *testing.T) {
+	p := httpx.NewProblem(http.StatusConflict, "version mismatch").With("current", "v2")
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got httpx.Problem
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := httpx.Problem{
+		Type:       "about:blank",
+		Title:      "Conflict",
+		Status:     http.StatusConflict,
+		Detail:     "version mismatch",
+		Extensions: map[string]interface{}{"current": "v2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if msg, want := p.Error(), "409 Conflict: version mismatch"; msg != want {
+		t.Errorf("Error() == %q, want %q", msg, want)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultPropagationDeny lists the headers which Propagation never copies
+// to outbound requests, unless configured otherwise.
+var DefaultPropagationDeny = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+}
+
+// Propagation configures the headers copied from inbound requests to the
+// outbound requests made on their behalf.
+//
+// Propagation.Handler captures the headers of inbound requests in their
+// context, and Propagation.Transport sets them on outbound requests whose
+// context derives from it, so individual call sites need not remember to
+// copy each one.
+type Propagation struct {
+	// Headers lists the inbound request headers to propagate, such as
+	// "Accept-Language" or "X-Tenant".
+	Headers []string
+
+	// Deny lists headers which are never propagated, even if listed in
+	// Headers. If nil, DefaultPropagationDeny is used.
+	Deny []string
+
+	// BaggageHeader, if not empty, names the header in which the fields
+	// set using SetBaggage are sent, as a comma-separated list of
+	// key=value pairs, in the format of the W3C Baggage header. Values
+	// are percent-encoded. Fields whose keys are not valid tokens are
+	// not sent.
+	BaggageHeader string
+}
+
+// Handler returns a handler which stores the propagated headers of each
+// request in its context, then calls next.
+func (p Propagation) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := make(http.Header)
+		for _, name := range p.Headers {
+			if p.denied(name) {
+				continue
+			}
+			if vals := req.Header.Values(name); len(vals) > 0 {
+				h[http.CanonicalHeaderKey(name)] = vals
+			}
+		}
+		ctx := context.WithValue(req.Context(), propagatedKey, h)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// Transport returns an http.RoundTripper which sets the propagated headers
+// and the baggage stored in the context of outbound requests, then
+// delegates to base, wrapped by PropagatingTransport. If base is nil,
+// http.DefaultTransport is used.
+//
+// Headers which the outbound request sets explicitly are left unchanged.
+func (p Propagation) Transport(base http.RoundTripper) http.RoundTripper {
+	base = PropagatingTransport(base)
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		h, _ := req.Context().Value(propagatedKey).(http.Header)
+		var bag string
+		if p.BaggageHeader != "" && req.Header.Get(p.BaggageHeader) == "" {
+			bag = encodeBaggage(BaggageFromContext(req.Context()))
+		}
+		if len(h) == 0 && bag == "" {
+			return base.RoundTrip(req)
+		}
+		out := cloneRequest(req)
+		for name, vals := range h {
+			if p.denied(name) || len(out.Header[name]) > 0 {
+				continue
+			}
+			out.Header[name] = append([]string(nil), vals...)
+		}
+		if bag != "" {
+			out.Header.Set(p.BaggageHeader, bag)
+		}
+		return base.RoundTrip(out)
+	})
+}
+
+func (p Propagation) denied(name string) bool {
+	deny := p.Deny
+	if deny == nil {
+		deny = DefaultPropagationDeny
+	}
+	for _, d := range deny {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBaggage encodes bag as a sorted, comma-separated list of key=value
+// pairs, with values percent-encoded. Fields whose keys are not tokens are
+// skipped.
+func encodeBaggage(bag map[string]string) string {
+	if len(bag) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(bag))
+	for k := range bag {
+		if isToken(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		escapeBaggageValue(&sb, bag[k])
+	}
+	return sb.String()
+}
+
+// escapeBaggageValue writes v to sb, percent-encoding the bytes which are
+// not baggage-octets, as well as '%' and '='.
+func escapeBaggageValue(sb *strings.Builder, v string) {
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c <= ' ', c >= 0x7f, c == '"', c == ',', c == ';', c == '\\', c == '%', c == '=':
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0xf])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+// isToken reports whether s is a token, as defined by RFC 9110.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestPropagation(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}))
+	defer srv.Close()
+
+	p := httpx.Propagation{
+		Headers:       []string{"Accept-Language", "X-Tenant", "Authorization", "X-Override"},
+		BaggageHeader: "Baggage",
+	}
+	client := &http.Client{Transport: p.Transport(nil)}
+
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = httpx.WithRequestID(req, "abc")
+		req = httpx.SetBaggage(req, "experiment", "a b")
+		req = httpx.SetBaggage(req, "query", "x=1,y;z%+é")
+		req = httpx.SetBaggage(req, "bad key", "v")
+		req = httpx.SetBaggage(req, "k=v,x", "v")
+		out, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Header.Set("X-Override", "mine")
+		resp, err := client.Do(out.WithContext(req.Context()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "ro")
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Override", "theirs")
+	req.Header.Set("X-Unlisted", "x")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Accept-Language", "ro"},
+		{"X-Tenant", "acme"},
+		{"Authorization", ""},
+		{"X-Override", "mine"},
+		{"X-Unlisted", ""},
+		{"X-Request-ID", "abc"},
+		{"Baggage", "experiment=a%20b,query=x%3D1%2Cy%3Bz%25+%C3%A9"},
+	}
+	for _, tt := range tests {
+		if v := got.Get(tt.header); v != tt.want {
+			t.Errorf("downstream %s == %q, want %q", tt.header, v, tt.want)
+		}
+	}
+}
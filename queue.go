@@ -0,0 +1,97 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"acln.ro/log"
+)
+
+// RequestStartHeaders lists the headers in which upstream proxies report
+// the time at which they received a request, in order of preference.
+var RequestStartHeaders = []string{"X-Request-Start", "X-Queue-Start"}
+
+// RequestStart returns the time at which an upstream proxy received req,
+// as reported by one of the RequestStartHeaders. Values are Unix
+// timestamps, optionally prefixed by "t=", in seconds, milliseconds or
+// microseconds, as set by nginx, Heroku and similar proxies. The unit is
+// inferred from the magnitude of the value.
+func RequestStart(req *http.Request) (time.Time, bool) {
+	for _, name := range RequestStartHeaders {
+		if v := req.Header.Get(name); v != "" {
+			return parseRequestStart(v)
+		}
+	}
+	return time.Time{}, false
+}
+
+// QueueDelay returns the time elapsed since an upstream proxy received
+// req, as reported by RequestStart.
+func QueueDelay(req *http.Request) (time.Duration, bool) {
+	t, ok := RequestStart(req)
+	if !ok {
+		return 0, false
+	}
+	return queueDelay(t, time.Now()), true
+}
+
+// ShedStale returns a handler which responds with 503 Service Unavailable
+// to requests which have been queued for longer than maxAge, as reported
+// by QueueDelay, and calls next for all other requests. Clients have
+// typically given up on such requests already, so serving them only
+// delays the requests queued behind them. Shed requests are annotated,
+// and published as EventRequestShed events.
+func ShedStale(next http.Handler, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if d, ok := QueueDelay(req); ok && d > maxAge {
+			Annotate(req, "shed", "stale")
+			Events.Publish(requestEvent(EventRequestShed, req, log.KV{"queue_delay": d}))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+	var usec float64
+	switch {
+	case f >= 1e15:
+		usec = f
+	case f >= 1e12:
+		usec = f * 1e3
+	default:
+		usec = f * 1e6
+	}
+	return time.UnixMicro(int64(usec)), true
+}
+
+// queueDelay returns the time between start and now, or zero if the
+// clocks disagree and start is in the future.
+func queueDelay(start, now time.Time) time.Duration {
+	if d := now.Sub(start); d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestRequestStart(t *testing.T) {
+	want := time.Date(2020, 3, 9, 19, 36, 3, 123000000, time.UTC)
+	tests := []struct {
+		header string
+		value  string
+		ok     bool
+	}{
+		{"X-Request-Start", "t=1583782563.123", true},
+		{"X-Request-Start", "1583782563123", true},
+		{"X-Queue-Start", "t=1583782563123000", true},
+		{"X-Request-Start", "t=yesterday", false},
+		{"X-Unrelated", "1583782563", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(tt.header, tt.value)
+		got, ok := httpx.RequestStart(req)
+		if ok != tt.ok {
+			t.Errorf("%s: %s: ok == %t, want %t", tt.header, tt.value, ok, tt.ok)
+			continue
+		}
+		if ok && got.Sub(want).Abs() > time.Millisecond {
+			t.Errorf("%s: %s: got %v, want %v", tt.header, tt.value, got.UTC(), want)
+		}
+	}
+}
+
+func requestStartedAgo(d time.Duration) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	ms := time.Now().Add(-d).UnixMilli()
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(ms, 10))
+	return req
+}
+
+func TestQueueDelaySummary(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), requestStartedAgo(time.Second))
+	if s.QueueDelay < time.Second || s.QueueDelay > 2*time.Second {
+		t.Errorf("QueueDelay == %v, want about 1s", s.QueueDelay)
+	}
+	if _, ok := s.KV()["queue_delay"]; !ok {
+		t.Error("queue_delay missing from KV")
+	}
+}
+
+func TestShedStale(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	h := httpx.ShedStale(ok, 5*time.Second)
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"fresh", requestStartedAgo(time.Second), http.StatusOK},
+		{"stale", requestStartedAgo(time.Minute), http.StatusServiceUnavailable},
+		{"unknown", httptest.NewRequest("GET", "/", nil), http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, tt.req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
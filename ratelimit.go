@@ -0,0 +1,258 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"acln.ro/log"
+)
+
+// EventRateLimited is the kind of the events published by RateLimit when
+// it rejects a request.
+const EventRateLimited EventKind = "rate_limited"
+
+// A RateLimitResult is the outcome of taking a token from a Limiter.
+type RateLimitResult struct {
+	// Allowed reports whether the request is allowed.
+	Allowed bool
+
+	// Limit is the size of the quota, and Remaining the part of it which
+	// is left.
+	Limit     int
+	Remaining int
+
+	// Reset is the time until the quota is fully restored, and
+	// RetryAfter, for requests which are not allowed, the time until
+	// the next request can be allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// A Limiter decides whether requests identified by a key are allowed.
+// Implementations backed by external stores make limits global across
+// the instances of a service. Implementations must be safe for
+// concurrent use.
+type Limiter interface {
+	Take(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// TokenBucket is an in-memory Limiter which implements the token bucket
+// algorithm: each key is allowed bursts of up to burst requests, and
+// tokens are restored at the rate of rate per second.
+type TokenBucket struct {
+	rate  float64
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a TokenBucket. It panics if rate is not positive,
+// or if burst is less than 1.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if !(rate > 0) || math.IsInf(rate, 0) {
+		panic("httpx: invalid token bucket rate " + strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	if burst < 1 {
+		panic("httpx: invalid token bucket burst " + strconv.Itoa(burst))
+	}
+	return &TokenBucket{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// tokenBucketSweep is the number of calls to Take between sweeps of the
+// full buckets, which need not be stored.
+const tokenBucketSweep = 1024
+
+// Take implements Limiter.
+func (tb *TokenBucket) Take(_ context.Context, key string) (RateLimitResult, error) {
+	now := time.Now()
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.takes++; tb.takes%tokenBucketSweep == 0 {
+		tb.sweep(now)
+	}
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(tb.burst), last: now}
+		tb.buckets[key] = b
+	}
+	b.refill(now, tb.rate, tb.burst)
+	res := RateLimitResult{Limit: tb.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = tb.duration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = tb.duration(float64(tb.burst) - b.tokens)
+	return res, nil
+}
+
+// duration returns the time it takes to restore n tokens.
+func (tb *TokenBucket) duration(n float64) time.Duration {
+	d := n / tb.rate * float64(time.Second)
+	if d >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+func (tb *TokenBucket) sweep(now time.Time) {
+	for key, b := range tb.buckets {
+		if b.refill(now, tb.rate, tb.burst); b.tokens >= float64(tb.burst) {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// StoreLimiter returns a Limiter which allows limit requests per key and
+// per window, counted using store.Incr, so that, with a shared store, the
+// limit applies to all the instances of a service. It approximates a
+// token bucket using fixed windows, and therefore allows bursts of up to
+// twice the limit across the boundary of two windows. It panics if window
+// is not positive.
+func StoreLimiter(store Store, limit int, window time.Duration) Limiter {
+	if window <= 0 {
+		panic("httpx: invalid rate limit window " + window.String())
+	}
+	return &storeLimiter{store: store, limit: limit, window: window}
+}
+
+type storeLimiter struct {
+	store  Store
+	limit  int
+	window time.Duration
+}
+
+func (sl *storeLimiter) Take(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now()
+	idx := now.UnixNano() / int64(sl.window)
+	reset := time.Unix(0, (idx+1)*int64(sl.window)).Sub(now)
+	n, err := sl.store.Incr(ctx, "ratelimit:"+key+":"+strconv.FormatInt(idx, 10), 1, sl.window)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	res := RateLimitResult{
+		Allowed: n <= int64(sl.limit),
+		Limit:   sl.limit,
+		Reset:   reset,
+	}
+	if res.Allowed {
+		res.Remaining = sl.limit - int(n)
+	} else {
+		res.RetryAfter = reset
+	}
+	return res, nil
+}
+
+// A RateLimitKey computes the key by which a request is rate limited.
+// Requests for which the key is empty are not limited.
+type RateLimitKey func(req *http.Request) string
+
+// KeyByClientIP returns a RateLimitKey which limits requests by client
+// address, as computed by ClientIP.
+func KeyByClientIP(policy ClientIPPolicy) RateLimitKey {
+	return func(req *http.Request) string {
+		if ip := ClientIP(req, policy); ip != nil {
+			return "ip:" + ip.String()
+		}
+		return ""
+	}
+}
+
+// KeyByHeader returns a RateLimitKey which limits requests by the value of
+// the named header, such as an API key. Requests without the header are
+// not limited. Since the value may be a secret, the key carries a hash of
+// it, rather than the value itself, such that it is not exposed through
+// stores or events.
+func KeyByHeader(name string) RateLimitKey {
+	return func(req *http.Request) string {
+		if v := req.Header.Get(name); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return "header:" + hex.EncodeToString(sum[:16])
+		}
+		return ""
+	}
+}
+
+// RateLimit returns a handler which takes a token from l for each request,
+// using the key computed by key, and serves allowed requests using next.
+// Other requests are rejected with 429 Too Many Requests, a Retry-After
+// header and a problem details body, annotated with "rate_limited", and
+// published as EventRateLimited events.
+//
+// All limited responses carry the RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers, the latter in seconds. If l fails, the
+// request is allowed, and the error is annotated under "rate_limit_error".
+func RateLimit(l Limiter, key RateLimitKey, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		k := key(req)
+		if k == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		res, err := l.Take(req.Context(), k)
+		if err != nil {
+			Annotate(req, "rate_limit_error", err.Error())
+			next.ServeHTTP(w, req)
+			return
+		}
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+			Annotate(req, "rate_limited", true)
+			Events.Publish(requestEvent(EventRateLimited, req, log.KV{"key": k}))
+			writeProblem(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ceilSeconds rounds d up to a whole number of seconds. Negative durations
+// round to zero.
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d-1)/time.Second) + 1
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestRateLimit(t *testing.T) {
+	limiters := []struct {
+		name string
+		l    httpx.Limiter
+	}{
+		{"token bucket", httpx.NewTokenBucket(0.5, 2)},
+		{"store", httpx.StoreLimiter(httpx.NewMemoryStore(0), 2, time.Hour)},
+	}
+	for _, lt := range limiters {
+		t.Run(lt.name, func(t *testing.T) {
+			h := httpx.RateLimit(lt.l, httpx.KeyByHeader("X-API-Key"), http.NotFoundHandler())
+			do := func(apiKey string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", "/", nil)
+				if apiKey != "" {
+					req.Header.Set("X-API-Key", apiKey)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+			for i, want := range []string{"1", "0"} {
+				rec := do("k1")
+				if rec.Code != http.StatusNotFound {
+					t.Fatalf("request %d: got status %d, want %d", i, rec.Code, http.StatusNotFound)
+				}
+				if got := rec.Header().Get("RateLimit-Remaining"); got != want {
+					t.Errorf("request %d: RateLimit-Remaining == %q, want %q", i, got, want)
+				}
+			}
+			rec := do("k1")
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+			}
+			if ra, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || ra < 1 {
+				t.Errorf("Retry-After == %q, want a positive number of seconds", rec.Header().Get("Retry-After"))
+			}
+			if rec := do("k2"); rec.Code != http.StatusNotFound {
+				t.Errorf("other key: got status %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			if rec := do(""); rec.Code != http.StatusNotFound || rec.Header().Get("RateLimit-Limit") != "" {
+				t.Errorf("no key: got status %d with limit headers, want an unlimited request", rec.Code)
+			}
+		})
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	tb := httpx.NewTokenBucket(100, 1)
+	ctx := context.Background()
+	if res, _ := tb.Take(ctx, "k"); !res.Allowed {
+		t.Fatal("first request denied")
+	}
+	res, _ := tb.Take(ctx, "k")
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > 10*time.Millisecond {
+		t.Fatalf("got %+v, want a denial with a RetryAfter of at most 10ms", res)
+	}
+	time.Sleep(res.RetryAfter + time.Millisecond)
+	if res, _ := tb.Take(ctx, "k"); !res.Allowed {
+		t.Errorf("request denied after RetryAfter")
+	}
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"zero rate", func() { httpx.NewTokenBucket(0, 1) }},
+		{"negative rate", func() { httpx.NewTokenBucket(-1, 1) }},
+		{"zero burst", func() { httpx.NewTokenBucket(1, 0) }},
+		{"zero window", func() { httpx.StoreLimiter(httpx.NewMemoryStore(0), 1, 0) }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", tt.name)
+				}
+			}()
+			tt.fn()
+		}()
+	}
+}
+
+func TestKeyByHeaderHashed(t *testing.T) {
+	sub := httpx.Events.Subscribe(1, httpx.EventRateLimited)
+	defer sub.Close()
+	store := httpx.NewMemoryStore(0)
+	h := httpx.RateLimit(httpx.StoreLimiter(store, 0, time.Hour), httpx.KeyByHeader("X-API-Key"), http.NotFoundHandler())
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "sk-secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case e := <-sub.C:
+		if s := fmt.Sprint(e.Fields); strings.Contains(s, "sk-secret") {
+			t.Errorf("event fields %s expose the header value", s)
+		}
+	default:
+		t.Error("no event published")
+	}
+	key := httpx.KeyByHeader("X-API-Key")(req)
+	if strings.Contains(key, "sk-secret") {
+		t.Errorf("key %q exposes the header value", key)
+	}
+	other := httptest.NewRequest("GET", "/", nil)
+	other.Header.Set("X-API-Key", "sk-other")
+	if httpx.KeyByHeader("X-API-Key")(other) == key {
+		t.Error("different header values map to the same key")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"sync"
+
+	"acln.ro/log"
+)
+
+// ReadOnly is a handler which can be switched into read-only mode at run
+// time, during migrations or incident response. In read-only mode,
+// requests using the POST, PUT, PATCH and DELETE methods are rejected
+// with 503 Service Unavailable and a problem details body, unless their
+// path is exempt. All other requests are passed to the next handler.
+type ReadOnly struct {
+	next   http.Handler
+	exempt []string
+
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+}
+
+// NewReadOnly returns a ReadOnly which passes requests to next, and which
+// exempts the specified path prefixes from read-only mode, e.g. "/login".
+// Prefixes match whole path segments. Read-only mode is initially off.
+func NewReadOnly(next http.Handler, exempt ...string) *ReadOnly {
+	return &ReadOnly{next: next, exempt: exempt}
+}
+
+// Enable switches ro into read-only mode. The reason is reported to
+// clients in the detail of the problem. If it is empty, a generic
+// message is used. Enable publishes an EventReadOnlyEnabled event.
+func (ro *ReadOnly) Enable(reason string) {
+	if reason == "" {
+		reason = "the service is in read-only mode"
+	}
+	ro.mu.Lock()
+	ro.enabled, ro.reason = true, reason
+	ro.mu.Unlock()
+	Events.Publish(Event{Kind: EventReadOnlyEnabled, Fields: log.KV{"reason": reason}})
+}
+
+// Disable switches ro out of read-only mode, and publishes an
+// EventReadOnlyDisabled event.
+func (ro *ReadOnly) Disable() {
+	ro.mu.Lock()
+	ro.enabled, ro.reason = false, ""
+	ro.mu.Unlock()
+	Events.Publish(Event{Kind: EventReadOnlyDisabled})
+}
+
+// Enabled reports whether ro is in read-only mode.
+func (ro *ReadOnly) Enabled() bool {
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+	return ro.enabled
+}
+
+func (ro *ReadOnly) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ro.mu.RLock()
+	enabled, reason := ro.enabled, ro.reason
+	ro.mu.RUnlock()
+	if enabled && isMutating(req.Method) {
+		// Exempt requests are passed on with the cleaned path they
+		// were exempted for.
+		req = withCleanPath(req)
+		if !ro.isExempt(req) {
+			Annotate(req, "read_only", true)
+			writeProblem(w, http.StatusServiceUnavailable, reason)
+			return
+		}
+	}
+	ro.next.ServeHTTP(w, req)
+}
+
+func (ro *ReadOnly) isExempt(req *http.Request) bool {
+	p := req.URL.Path
+	if p == "" {
+		p = "/"
+	}
+	for _, prefix := range ro.exempt {
+		if hasPathPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestReadOnly(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	ro := httpx.NewReadOnly(ok, "/login")
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ro.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	if rec := serve("POST", "/orders"); rec.Code != http.StatusOK {
+		t.Fatalf("before Enable: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	ro.Enable("database migration in progress")
+	if !ro.Enabled() {
+		t.Fatal("Enabled == false after Enable")
+	}
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/orders", http.StatusOK},
+		{"HEAD", "/orders", http.StatusOK},
+		{"POST", "/orders", http.StatusServiceUnavailable},
+		{"DELETE", "/orders/1", http.StatusServiceUnavailable},
+		{"POST", "/login", http.StatusOK},
+		{"POST", "/login/../orders", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if rec := serve(tt.method, tt.path); rec.Code != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+
+	rec := serve("PUT", "/orders/1")
+	if ct := rec.Header().Get("Content-Type"); ct != httpx.ProblemType {
+		t.Errorf("Content-Type == %q, want %q", ct, httpx.ProblemType)
+	}
+	var problem struct {
+		Status int
+		Detail string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusServiceUnavailable || problem.Detail != "database migration in progress" {
+		t.Errorf("got problem %+v", problem)
+	}
+
+	ro.Disable()
+	if rec := serve("POST", "/orders"); rec.Code != http.StatusOK {
+		t.Errorf("after Disable: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestReadOnlyCleanPath(t *testing.T) {
+	var served string
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		served = req.URL.Path
+	})
+	ro := httpx.NewReadOnly(next, "/login")
+	ro.Enable("")
+	req := httptest.NewRequest("POST", "/", nil)
+	req.URL.Path = "/orders/../login"
+	rec := httptest.NewRecorder()
+	ro.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || served != "/login" {
+		t.Errorf("got status %d, next served %q, want %d, %q", rec.Code, served, http.StatusOK, "/login")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"acln.ro/log"
+)
+
+// EventPanicRecovered is the kind of the events published by Recover.
+const EventPanicRecovered EventKind = "panic_recovered"
+
+// A PanicError is the error recorded using SetError for a recovered panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("httpx: panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value, if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// A RecoverOption configures Recover.
+type RecoverOption func(*recoverConfig)
+
+type recoverConfig struct {
+	logger   *log.Logger
+	stats    *PanicStats
+	response func(w http.ResponseWriter, req *http.Request, err *PanicError)
+}
+
+// RecoverLogger configures the base logger used for requests which carry
+// no request-scoped logger stored using WithLogger, as if by
+// RequestLogger. By default, panics in such requests are not logged.
+func RecoverLogger(logger *log.Logger) RecoverOption {
+	return func(cfg *recoverConfig) {
+		cfg.logger = logger
+	}
+}
+
+// RecoverStats configures Recover to record panics in ps.
+func RecoverStats(ps *PanicStats) RecoverOption {
+	return func(cfg *recoverConfig) {
+		cfg.stats = ps
+	}
+}
+
+// RecoverResponse configures the function which writes the response to a
+// request whose handler panicked. It is only called if the handler did not
+// write anything. By default, a 500 Internal Server Error problem details
+// object is written.
+func RecoverResponse(fn func(w http.ResponseWriter, req *http.Request, err *PanicError)) RecoverOption {
+	return func(cfg *recoverConfig) {
+		cfg.response = fn
+	}
+}
+
+// maxPanicStack limits the size of the stacks captured by Recover.
+const maxPanicStack = 64 << 10
+
+// Recover returns a handler which serves requests using next, and
+// recovers from panics in next. For each panic, Recover logs the panic
+// value and stack at the Error level, using the request-scoped logger,
+// records a *PanicError using SetError, and publishes an
+// EventPanicRecovered event. If next did not write anything, Recover
+// writes a 500 Internal Server Error response. Otherwise, the response is
+// incomplete, and Recover panics with http.ErrAbortHandler, so that the
+// server aborts it, and the client does not mistake it for a complete
+// one.
+//
+// Panics with the value http.ErrAbortHandler, which abort the response on
+// purpose, are propagated to the server without further ado.
+//
+// Recover installs request state using WithRequestState, so that the
+// route recorded by the handler is visible.
+func Recover(next http.Handler, opts ...RecoverOption) http.Handler {
+	cfg := new(recoverConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.response == nil {
+		cfg.response = func(w http.ResponseWriter, _ *http.Request, _ *PanicError) {
+			writeProblem(w, http.StatusInternalServerError, "")
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithRequestState(req)
+		wrote := false
+		ww, _ := beforeWrite(w, func() { wrote = true })
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			buf := make([]byte, maxPanicStack)
+			perr := &PanicError{Value: v, Stack: buf[:runtime.Stack(buf, false)]}
+			cfg.recovered(req, perr)
+			if wrote {
+				panic(http.ErrAbortHandler)
+			}
+			cfg.response(w, req, perr)
+		}()
+		next.ServeHTTP(ww, req)
+	})
+}
+
+func (cfg *recoverConfig) recovered(req *http.Request, err *PanicError) {
+	SetError(req, err)
+	value := fmt.Sprint(err.Value)
+	logger := Logger(req)
+	if logger == nil && cfg.logger != nil {
+		logger = RequestLogger(cfg.logger, req)
+	}
+	if logger != nil {
+		logger.Error(log.KV{
+			"panic": value,
+			"stack": string(err.Stack),
+		})
+	}
+	if cfg.stats != nil {
+		cfg.stats.Record(PanicRecord{
+			Route:     Route(req),
+			RequestID: RequestID(req),
+			Value:     value,
+			Stack:     err.Stack,
+		})
+	}
+	Events.Publish(requestEvent(EventPanicRecovered, req, log.KV{"panic": value}))
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+	"acln.ro/log"
+)
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	ps := new(httpx.PanicStats)
+	sub := httpx.Events.Subscribe(1, httpx.EventPanicRecovered)
+	defer sub.Close()
+
+	boom := errors.New("boom")
+	h := httpx.Recover(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.SetRoute(req, "/boom")
+		panic(boom)
+	}), httpx.RecoverLogger(log.New(&buf)), httpx.RecoverStats(ps))
+
+	rec := httptest.NewRecorder()
+	req := httpx.WithRequestID(httptest.NewRequest("GET", "/boom", nil), "r1")
+	s := httpx.ServeInstrumented(h, rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	var perr *httpx.PanicError
+	if !errors.As(s.Err, &perr) || !errors.Is(s.Err, boom) {
+		t.Fatalf("Summary.Err == %v, want a *PanicError wrapping boom", s.Err)
+	}
+	if !bytes.Contains(perr.Stack, []byte("recover_test.go")) {
+		t.Errorf("stack does not mention the panicking handler:\n%s", perr.Stack)
+	}
+	if out := buf.String(); !strings.Contains(out, "r1") || !strings.Contains(out, "boom") {
+		t.Errorf("log %q does not record the request and the panic", out)
+	}
+	if n := ps.Counts()["/boom"]; n != 1 {
+		t.Errorf("got %d panics for /boom, want 1", n)
+	}
+	select {
+	case e := <-sub.C:
+		if e.RequestID != "r1" {
+			t.Errorf("event %+v is not for r1", e)
+		}
+	default:
+		t.Error("no event published")
+	}
+}
+
+func TestRecoverAfterWrite(t *testing.T) {
+	called := false
+	h := httpx.Recover(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	}), httpx.RecoverResponse(func(http.ResponseWriter, *http.Request, *httpx.PanicError) {
+		called = true
+	}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	var v interface{}
+	func() {
+		defer func() { v = recover() }()
+		h.ServeHTTP(rec, req)
+	}()
+	if v != http.ErrAbortHandler {
+		t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+	}
+	if rec.Code != http.StatusAccepted || called {
+		t.Errorf("got status %d, response called %v, want %d and false", rec.Code, called, http.StatusAccepted)
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	h := httpx.Recover(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
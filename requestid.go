@@ -0,0 +1,255 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultRequestIDHeader is the header used by RequestIDHandler, unless
+// configured otherwise.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// IDGenerator generates request identifiers.
+type IDGenerator interface {
+	NewID() string
+}
+
+// RandomIDs is an IDGenerator which produces 128 bit random identifiers,
+// encoded as hexadecimal strings. It is the default generator used by
+// RequestIDHandler.
+var RandomIDs IDGenerator = randomIDs{}
+
+type randomIDs struct{}
+
+func (randomIDs) NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("httpx: crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// SequentialIDs returns an IDGenerator which produces compact, monotonically
+// increasing identifiers of the form "prefix-000123". If prefix is empty,
+// the first label of the host name is used.
+//
+// SequentialIDs is intended for local development, where short identifiers
+// which are easy to grep for matter more than global uniqueness. Counters
+// restart from 1 with each new generator.
+func SequentialIDs(prefix string) IDGenerator {
+	if prefix == "" {
+		prefix = hostPrefix()
+	}
+	return &sequentialIDs{prefix: prefix}
+}
+
+type sequentialIDs struct {
+	n      uint64 // first, for alignment
+	prefix string
+}
+
+func (s *sequentialIDs) NewID() string {
+	return fmt.Sprintf("%s-%06d", s.prefix, atomic.AddUint64(&s.n, 1))
+}
+
+func hostPrefix() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "local"
+	}
+	if idx := strings.IndexByte(host, '.'); idx > 0 {
+		host = host[:idx]
+	}
+	return host
+}
+
+// ValidRequestID reports whether id is acceptable as an inbound request
+// identifier. It is the default validator used by RequestIDHandler.
+//
+// Valid identifiers are between 1 and 128 bytes long, and consist only of
+// ASCII letters, digits, and the characters "-", "_", ".", ":" and "=".
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z':
+		case 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// A RequestIDOption configures RequestIDHandler.
+type RequestIDOption func(*requestIDConfig)
+
+type requestIDConfig struct {
+	header     string
+	respHeader *string
+	gen        IDGenerator
+	valid      func(string) bool
+	trusted    TrustedProxies
+	codec      IDCodec
+}
+
+// RequestIDHeader configures the header from which inbound request
+// identifiers are read. Unless RequestIDResponseHeader is also specified,
+// the assigned identifier is echoed back in the same header.
+func RequestIDHeader(name string) RequestIDOption {
+	return func(cfg *requestIDConfig) {
+		cfg.header = http.CanonicalHeaderKey(name)
+	}
+}
+
+// RequestIDResponseHeader configures the response header into which the
+// assigned identifier is written. If name is empty, the identifier is
+// not written to the response.
+func RequestIDResponseHeader(name string) RequestIDOption {
+	return func(cfg *requestIDConfig) {
+		cfg.respHeader = &name
+	}
+}
+
+// RequestIDGenerator configures the generator used for requests which
+// do not carry a valid identifier.
+func RequestIDGenerator(gen IDGenerator) RequestIDOption {
+	return func(cfg *requestIDConfig) {
+		cfg.gen = gen
+	}
+}
+
+// RequestIDValidator configures the function used to validate inbound
+// request identifiers. Identifiers for which valid returns false are
+// replaced by freshly generated ones.
+func RequestIDValidator(valid func(id string) bool) RequestIDOption {
+	return func(cfg *requestIDConfig) {
+		cfg.valid = valid
+	}
+}
+
+// RequestIDTrustedProxies configures RequestIDHandler to accept inbound
+// request identifiers only from the specified proxies. Requests from other
+// clients are always assigned a new identifier. Their inbound identifier,
+// if valid, is preserved, and can be retrieved using UntrustedRequestID.
+func RequestIDTrustedProxies(tp TrustedProxies) RequestIDOption {
+	return func(cfg *requestIDConfig) {
+		cfg.trusted = tp
+	}
+}
+
+// RequestIDCodec configures RequestIDHandler to encode identifiers using
+// codec before writing them to the response header, and to decode inbound
+// identifiers. Inbound identifiers which fail to decode are replaced by
+// freshly generated ones. The request context always stores the decoded
+// identifier. The cookie set by Redirect is encoded and decoded likewise.
+func RequestIDCodec(codec IDCodec) RequestIDOption {
+	return func(cfg *requestIDConfig) {
+		cfg.codec = codec
+	}
+}
+
+// RequestIDHandler returns a handler which assigns an identifier to each
+// request, then calls next.
+//
+// If the request carries a valid identifier in the X-Request-ID header,
+// that identifier is used. Otherwise, a new one is generated. The
+// identifier is stored using WithRequestID, and echoed back in the
+// response header, as if by EchoRequestID.
+//
+// RequestIDHandler also records the parent request identifier, carried
+// by the X-Parent-Request-ID header or set by Redirect, and the attempt
+// number carried by the X-Attempt header. See ParentRequestID and Attempt.
+// If RequestIDTrustedProxies is set, the headers are only honored for
+// requests from trusted proxies, like X-Request-ID.
+func RequestIDHandler(next http.Handler, opts ...RequestIDOption) http.Handler {
+	cfg := &requestIDConfig{
+		header: DefaultRequestIDHeader,
+		gen:    RandomIDs,
+		valid:  ValidRequestID,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	respHeader := cfg.header
+	if cfg.respHeader != nil {
+		respHeader = *cfg.respHeader
+	}
+	if respHeader != "" {
+		next = echoRequestID(next, respHeader, cfg.codec)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(cfg.header)
+		if cfg.codec != nil && id != "" {
+			var err error
+			if id, err = cfg.codec.Decode(id); err != nil {
+				id = ""
+			}
+		}
+		trusted := cfg.trusted == nil || cfg.trusted.Trusts(req)
+		if !cfg.valid(id) {
+			id = cfg.gen.NewID()
+		} else if !trusted {
+			ctx := context.WithValue(req.Context(), untrustedRequestIDKey, id)
+			req = req.WithContext(ctx)
+			id = cfg.gen.NewID()
+		}
+		req = withChain(w, req, cfg.valid, trusted, cfg.codec)
+		next.ServeHTTP(w, WithRequestID(req, id))
+	})
+}
+
+// EchoRequestID returns a handler which writes the identifier associated
+// with the request into the specified response header, then calls next.
+// If the request has no identifier, the header is not written.
+//
+// EchoRequestID is useful for handlers which assign request identifiers
+// by other means than RequestIDHandler.
+func EchoRequestID(next http.Handler, header string) http.Handler {
+	return echoRequestID(next, header, nil)
+}
+
+func echoRequestID(next http.Handler, header string, codec IDCodec) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if id := RequestID(req); id != "" {
+			if codec != nil {
+				id = codec.Encode(id)
+			}
+			w.Header().Set(header, id)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// UntrustedRequestID returns the inbound request identifier which was
+// replaced because the request did not originate from a trusted proxy.
+// See RequestIDTrustedProxies.
+func UntrustedRequestID(req *http.Request) string {
+	id, _ := req.Context().Value(untrustedRequestIDKey).(string)
+	return id
+}
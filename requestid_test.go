@@ -0,0 +1,206 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+type constIDs string
+
+func (c constIDs) NewID() string { return string(c) }
+
+func TestRequestIDHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		want    string
+	}{
+		{"absent", "", "generated"},
+		{"valid", "abc-123", "abc-123"},
+		{"invalid", "abc 123\n", "generated"},
+	}
+	for _, tt := range tests {
+		var got string
+		h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = httpx.RequestID(req)
+		}), httpx.RequestIDGenerator(constIDs("generated")))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.inbound != "" {
+			req.Header.Set("X-Request-ID", tt.inbound)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got != tt.want {
+			t.Errorf("%s: RequestID == %q, want %q", tt.name, got, tt.want)
+		}
+		if echo := rec.Header().Get("X-Request-ID"); echo != tt.want {
+			t.Errorf("%s: response header == %q, want %q", tt.name, echo, tt.want)
+		}
+	}
+}
+
+func TestRequestIDHandlerCustomHeader(t *testing.T) {
+	var got string
+	h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = httpx.RequestID(req)
+	}), httpx.RequestIDHeader("x-correlation-id"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-ID", "xyz")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got != "xyz" {
+		t.Fatalf("RequestID == %q, want %q", got, "xyz")
+	}
+	if echo := rec.Header().Get("X-Correlation-ID"); echo != "xyz" {
+		t.Fatalf("response header == %q, want %q", echo, "xyz")
+	}
+}
+
+func TestRequestIDResponseHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []httpx.RequestIDOption
+		header string
+	}{
+		{"default", nil, "X-Request-ID"},
+		{"custom", []httpx.RequestIDOption{httpx.RequestIDResponseHeader("X-Trace")}, "X-Trace"},
+		{"disabled", []httpx.RequestIDOption{httpx.RequestIDResponseHeader("")}, ""},
+	}
+	for _, tt := range tests {
+		opts := append(tt.opts, httpx.RequestIDGenerator(constIDs("id")))
+		h := httpx.RequestIDHandler(http.NotFoundHandler(), opts...)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		for name := range rec.Header() {
+			if name == "Content-Type" || name == "X-Content-Type-Options" {
+				continue
+			}
+			if name != http.CanonicalHeaderKey(tt.header) {
+				t.Errorf("%s: unexpected response header %q", tt.name, name)
+			}
+		}
+		if tt.header != "" && rec.Header().Get(tt.header) != "id" {
+			t.Errorf("%s: %s == %q, want %q", tt.name, tt.header,
+				rec.Header().Get(tt.header), "id")
+		}
+	}
+}
+
+func TestRequestIDTrustedProxies(t *testing.T) {
+	tp, err := httpx.ParseTrustedProxies("10.0.0.0/8", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote    string
+		want      string
+		untrusted string
+	}{
+		{"10.1.2.3:4567", "inbound", ""},
+		{"192.0.2.1:4567", "inbound", ""},
+		{"192.0.2.2:4567", "generated", "inbound"},
+	}
+	for _, tt := range tests {
+		var got, untrusted string
+		h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = httpx.RequestID(req)
+			untrusted = httpx.UntrustedRequestID(req)
+		}), httpx.RequestIDGenerator(constIDs("generated")), httpx.RequestIDTrustedProxies(tp))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Request-ID", "inbound")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != tt.want {
+			t.Errorf("%s: RequestID == %q, want %q", tt.remote, got, tt.want)
+		}
+		if untrusted != tt.untrusted {
+			t.Errorf("%s: UntrustedRequestID == %q, want %q", tt.remote, untrusted, tt.untrusted)
+		}
+	}
+}
+
+func TestSequentialIDs(t *testing.T) {
+	gen := httpx.SequentialIDs("dev")
+	for _, want := range []string{"dev-000001", "dev-000002", "dev-000003"} {
+		if id := gen.NewID(); id != want {
+			t.Fatalf("NewID == %q, want %q", id, want)
+		}
+	}
+	if id := httpx.SequentialIDs("").NewID(); !httpx.ValidRequestID(id) {
+		t.Fatalf("host-prefixed ID %q is not a valid request ID", id)
+	}
+}
+
+func TestRequestIDCodec(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	encrypted, err := httpx.EncryptedIDs(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, codec := range map[string]httpx.IDCodec{
+		"signed":    httpx.SignedIDs(key),
+		"encrypted": encrypted,
+	} {
+		var got string
+		h := httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = httpx.RequestID(req)
+		}), httpx.RequestIDGenerator(constIDs("dev-000001")), httpx.RequestIDCodec(codec))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		exposed := rec.Header().Get("X-Request-ID")
+		if got != "dev-000001" {
+			t.Errorf("%s: RequestID == %q", name, got)
+		}
+		if exposed == got {
+			t.Errorf("%s: identifier exposed without encoding", name)
+		}
+		if !httpx.ValidRequestID(exposed) {
+			t.Errorf("%s: encoded identifier %q is not valid", name, exposed)
+		}
+
+		// The exposed identifier round-trips.
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-ID", exposed)
+		got = ""
+		h = httpx.RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = httpx.RequestID(req)
+		}), httpx.RequestIDGenerator(constIDs("fresh")), httpx.RequestIDCodec(codec))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got != "dev-000001" {
+			t.Errorf("%s: round-tripped RequestID == %q", name, got)
+		}
+
+		// Forged identifiers are replaced.
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Request-ID", "dev-000002")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got != "fresh" {
+			t.Errorf("%s: forged RequestID accepted as %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import "net/http"
+
+// SetRoute records the route pattern which matched req, such as
+// "/users/{id}", for use as a low-cardinality label by metrics and access
+// logs, in place of the raw path. Patterns are relative to the mounted
+// application serving req, if any: the mount prefix is prepended. Later
+// calls replace the route recorded by earlier ones, so handlers refine
+// the route as they dispatch deeper into the tree.
+//
+// If req carries no request state, SetRoute is a no-op.
+func SetRoute(req *http.Request, pattern string) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	route := joinMountPrefix(MountPrefix(req), pattern)
+	st.mu.Lock()
+	st.route = route
+	st.mu.Unlock()
+}
+
+// Route returns the route pattern recorded for req using SetRoute, or the
+// empty string.
+func Route(req *http.Request) string {
+	st := stateOf(req)
+	if st == nil {
+		return ""
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.route
+}
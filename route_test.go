@@ -0,0 +1,57 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestRoute(t *testing.T) {
+	var ms httpx.Mounts
+	ms.Mount("/api", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if httpx.Shift(req) == "users" {
+			httpx.SetRoute(req, "/users/{id}")
+		}
+	}))
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/users/42", "/api/users/{id}"},
+		{"/api/other", "/api/*"},
+		{"/elsewhere", ""},
+	}
+	for _, tt := range tests {
+		s := httpx.ServeInstrumented(&ms, httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		if s.Route != tt.want {
+			t.Errorf("%s: Route == %q, want %q", tt.path, s.Route, tt.want)
+		}
+		if tt.want != "" && s.KV()["route"] != tt.want {
+			t.Errorf("%s: KV route == %v, want %q", tt.path, s.KV()["route"], tt.want)
+		}
+	}
+}
+
+func TestRouteWithoutState(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	httpx.SetRoute(req, "/")
+	if r := httpx.Route(req); r != "" {
+		t.Errorf("Route == %q, want empty", r)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A RouteSpec describes a route served by a handler tree.
+type RouteSpec struct {
+	// Method is the HTTP method. If empty, GET is assumed.
+	Method string
+
+	// Pattern is the request path, with parameters enclosed in braces,
+	// e.g. "/users/{id}/posts".
+	Pattern string
+
+	// Examples maps parameter names to example values, which are
+	// substituted into Pattern to produce concrete request paths.
+	Examples map[string]string
+}
+
+func (rs RouteSpec) method() string {
+	if rs.Method == "" {
+		return http.MethodGet
+	}
+	return rs.Method
+}
+
+func (rs RouteSpec) String() string {
+	return rs.method() + " " + rs.Pattern
+}
+
+// examplePath substitutes example values into the pattern.
+func (rs RouteSpec) examplePath() (string, error) {
+	var sb strings.Builder
+	rest := rs.Pattern
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open == -1 {
+			sb.WriteString(rest)
+			return sb.String(), nil
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated parameter in pattern %q", rs.Pattern)
+		}
+		name := rest[open+1 : open+end]
+		val, ok := rs.Examples[name]
+		if !ok {
+			return "", fmt.Errorf("no example value for parameter %q", name)
+		}
+		sb.WriteString(rest[:open])
+		sb.WriteString(val)
+		rest = rest[open+end+1:]
+	}
+}
+
+// A RouteError describes a problem with a route.
+type RouteError struct {
+	Route RouteSpec
+	Err   error
+}
+
+func (e *RouteError) Error() string {
+	return e.Route.String() + ": " + e.Err.Error()
+}
+
+// RouteErrors is a list of problems found in a set of routes.
+type RouteErrors []*RouteError
+
+func (errs RouteErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Routes is a declarative list of routes served by a handler tree.
+type Routes []RouteSpec
+
+// A MethodRule requires that every resource which handles Method also
+// handles all the methods listed in Requires.
+type MethodRule struct {
+	Method   string
+	Requires []string
+}
+
+// DefaultMethodRules is the method policy used by Routes.Validate:
+// resources which can be modified or deleted must also be readable.
+var DefaultMethodRules = []MethodRule{
+	{Method: http.MethodPut, Requires: []string{http.MethodGet}},
+	{Method: http.MethodPatch, Requires: []string{http.MethodGet}},
+	{Method: http.MethodDelete, Requires: []string{http.MethodGet}},
+}
+
+// Validate is like Check, using DefaultMethodRules.
+func (rs Routes) Validate() error {
+	return rs.Check(DefaultMethodRules)
+}
+
+// Check checks the routes for construction mistakes. It reports:
+//
+// - unreachable routes, which repeat the method and pattern of an earlier
+// route, possibly with different parameter names
+//
+// - ambiguous routes, for which some request path matches both a static
+// segment in one pattern and a parameter in another, such that the route
+// which handles the request depends on the matching order
+//
+// - method gaps, where a resource violates one of the specified rules
+//
+// Check returns all findings at once, as a RouteErrors value, so it is
+// suitable for enforcement in tests.
+func (rs Routes) Check(rules []MethodRule) error {
+	var errs RouteErrors
+	seen := make(map[string]int)                // method + shape -> index
+	methods := make(map[string]map[string]bool) // shape -> methods
+	var shapes []string                         // in declaration order
+	for i, r := range rs {
+		shape := patternShape(r.Pattern)
+		key := r.method() + " " + shape
+		if j, ok := seen[key]; ok {
+			errs = append(errs, &RouteError{
+				Route: r,
+				Err:   fmt.Errorf("unreachable: shadowed by %v", rs[j]),
+			})
+			continue
+		}
+		seen[key] = i
+		if methods[shape] == nil {
+			methods[shape] = make(map[string]bool)
+			shapes = append(shapes, shape)
+		}
+		methods[shape][r.method()] = true
+	}
+
+	for i := 0; i < len(shapes); i++ {
+		for j := i + 1; j < len(shapes); j++ {
+			if ambiguous(shapes[i], shapes[j]) {
+				errs = append(errs, &RouteError{
+					Route: rs.first(shapes[j]),
+					Err:   fmt.Errorf("ambiguous: overlaps %s", rs.first(shapes[i]).Pattern),
+				})
+			}
+		}
+	}
+
+	for _, shape := range shapes {
+		for _, rule := range rules {
+			if !methods[shape][rule.Method] {
+				continue
+			}
+			var missing []string
+			for _, m := range rule.Requires {
+				if !methods[shape][m] {
+					missing = append(missing, m)
+				}
+			}
+			if len(missing) > 0 {
+				sort.Strings(missing)
+				r := rs.first(shape)
+				r.Method = rule.Method
+				errs = append(errs, &RouteError{
+					Route: r,
+					Err:   errors.New("method gap: missing " + strings.Join(missing, ", ")),
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// first returns the first route with the specified shape.
+func (rs Routes) first(shape string) RouteSpec {
+	for _, r := range rs {
+		if patternShape(r.Pattern) == shape {
+			return r
+		}
+	}
+	return RouteSpec{}
+}
+
+// patternShape returns the pattern with all parameter names removed,
+// such that patterns which match the same paths have the same shape.
+func patternShape(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		if isParam(seg) {
+			segs[i] = "{}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func isParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+// ambiguous reports whether two distinct shapes match a common path.
+func ambiguous(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if as[i] != bs[i] && !isParam(as[i]) && !isParam(bs[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestRoutesValidate(t *testing.T) {
+	routes := httpx.Routes{
+		{Method: "GET", Pattern: "/users/{id}"},
+		{Method: "PUT", Pattern: "/users/{id}"},
+		{Method: "GET", Pattern: "/users/{name}"},
+		{Method: "GET", Pattern: "/users/me"},
+		{Method: "DELETE", Pattern: "/posts/{id}"},
+		{Method: "GET", Pattern: "/posts/{id}/comments"},
+	}
+	err := routes.Validate()
+	errs, ok := err.(httpx.RouteErrors)
+	if !ok {
+		t.Fatalf("got error %v, want RouteErrors", err)
+	}
+	want := []string{
+		"GET /users/{name}: unreachable",
+		"GET /users/me: ambiguous",
+		"DELETE /posts/{id}: method gap: missing GET",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(errs[i].Error(), w) {
+			t.Errorf("error %d: got %q, want prefix %q", i, errs[i], w)
+		}
+	}
+
+	if err := routes[:2].Validate(); err != nil {
+		t.Errorf("valid routes: got %v", err)
+	}
+	if err := routes[4:].Check(nil); err != nil {
+		t.Errorf("Check with no rules: got %v", err)
+	}
+}
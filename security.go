@@ -0,0 +1,81 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import "net/http"
+
+// Default values of the headers set by SecurityHeaders.
+const (
+	DefaultHSTS               = "max-age=63072000; includeSubDomains"
+	DefaultContentTypeOptions = "nosniff"
+	DefaultFrameOptions       = "DENY"
+	DefaultReferrerPolicy     = "strict-origin-when-cross-origin"
+	DefaultPermissionsPolicy  = "camera=(), microphone=(), geolocation=(), payment=()"
+)
+
+// OmitHeader, used as the value of a SecurityHeaders field, disables the
+// corresponding header.
+const OmitHeader = "-"
+
+// SecurityHeaders configures a baseline of security-related response
+// headers. The zero value sets all headers to their defaults. Each field
+// overrides the value of a header: an empty field selects the default, and
+// OmitHeader disables the header.
+type SecurityHeaders struct {
+	// HSTS is the value of the Strict-Transport-Security header. It is
+	// only sent in responses to requests received over TLS, since
+	// browsers ignore it otherwise.
+	HSTS string
+
+	// ContentTypeOptions is the value of the X-Content-Type-Options
+	// header.
+	ContentTypeOptions string
+
+	// FrameOptions is the value of the X-Frame-Options header.
+	FrameOptions string
+
+	// ReferrerPolicy is the value of the Referrer-Policy header.
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the value of the Permissions-Policy header.
+	PermissionsPolicy string
+}
+
+// Handler returns a handler which sets the configured headers, then calls
+// next. Since the headers are set before next is called, handlers can
+// still override them for individual responses, e.g. to allow framing.
+func (sh SecurityHeaders) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := w.Header()
+		if req.TLS != nil {
+			setSecurityHeader(h, "Strict-Transport-Security", sh.HSTS, DefaultHSTS)
+		}
+		setSecurityHeader(h, "X-Content-Type-Options", sh.ContentTypeOptions, DefaultContentTypeOptions)
+		setSecurityHeader(h, "X-Frame-Options", sh.FrameOptions, DefaultFrameOptions)
+		setSecurityHeader(h, "Referrer-Policy", sh.ReferrerPolicy, DefaultReferrerPolicy)
+		setSecurityHeader(h, "Permissions-Policy", sh.PermissionsPolicy, DefaultPermissionsPolicy)
+		next.ServeHTTP(w, req)
+	})
+}
+
+func setSecurityHeader(h http.Header, name, value, def string) {
+	switch value {
+	case OmitHeader:
+		return
+	case "":
+		value = def
+	}
+	h.Set(name, value)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	sh := httpx.SecurityHeaders{
+		FrameOptions:      "SAMEORIGIN",
+		PermissionsPolicy: httpx.OmitHeader,
+	}
+	h := sh.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/embed" {
+			w.Header().Del("X-Frame-Options")
+		}
+	}))
+	tests := []struct {
+		path   string
+		tls    bool
+		header string
+		want   string
+	}{
+		{"/", true, "Strict-Transport-Security", httpx.DefaultHSTS},
+		{"/", false, "Strict-Transport-Security", ""},
+		{"/", false, "X-Content-Type-Options", "nosniff"},
+		{"/", false, "X-Frame-Options", "SAMEORIGIN"},
+		{"/", false, "Referrer-Policy", httpx.DefaultReferrerPolicy},
+		{"/", false, "Permissions-Policy", ""},
+		{"/embed", false, "X-Frame-Options", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get(tt.header); got != tt.want {
+			t.Errorf("%s (TLS: %v): %s == %q, want %q", tt.path, tt.tls, tt.header, got, tt.want)
+		}
+	}
+}
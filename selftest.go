@@ -0,0 +1,79 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// SelfTest synthesizes a request for each of the specified routes, using
+// the example parameter values, and serves it using h. It verifies that
+// every request reaches a handler: requests which produce a 404 or 405
+// response, or cause h to panic, are reported as errors. If any route
+// fails, SelfTest returns a RouteErrors value listing all failures.
+//
+// SelfTest is meant to run at startup, before the server accepts traffic,
+// or in tests. Because the requests reach real handlers, h should be wired
+// with dependencies which tolerate them. Handlers can use IsSelfTest to
+// recognize synthesized requests.
+func SelfTest(h http.Handler, routes []RouteSpec) error {
+	var errs RouteErrors
+	for _, rs := range routes {
+		if err := selfTest(h, rs); err != nil {
+			errs = append(errs, &RouteError{Route: rs, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func selfTest(h http.Handler, rs RouteSpec) (err error) {
+	path, err := rs.examplePath()
+	if err != nil {
+		return err
+	}
+	ctx := context.WithValue(context.Background(), selfTestKey, true)
+	req, err := http.NewRequestWithContext(ctx, rs.method(), "http://example.com"+path, nil)
+	if err != nil {
+		return err
+	}
+	// Make the request look like one received by a server.
+	req.RequestURI = path
+	req.RemoteAddr = "192.0.2.1:1234"
+	rb := newResponseBuffer()
+
+	defer func() {
+		if val := recover(); val != nil {
+			err = fmt.Errorf("%s: handler panicked: %v", path, val)
+		}
+	}()
+	h.ServeHTTP(rb, req)
+
+	switch rb.code {
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return fmt.Errorf("%s: got status %d", path, rb.code)
+	}
+	return nil
+}
+
+// IsSelfTest reports whether req was synthesized by SelfTest.
+func IsSelfTest(req *http.Request) bool {
+	val, _ := req.Context().Value(selfTestKey).(bool)
+	return val
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestSelfTest(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !httpx.IsSelfTest(req) {
+			t.Errorf("IsSelfTest == false for synthesized request")
+		}
+		switch httpx.Shift(req) {
+		case "users":
+			if httpx.Shift(req) == "" {
+				http.NotFound(w, req)
+			}
+		case "panic":
+			panic("boom")
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	routes := []httpx.RouteSpec{
+		{Pattern: "/users/{id}", Examples: map[string]string{"id": "42"}},
+		{Method: "POST", Pattern: "/users/{id}"},
+		{Pattern: "/posts"},
+		{Pattern: "/panic"},
+	}
+	err := httpx.SelfTest(h, routes)
+	errs, ok := err.(httpx.RouteErrors)
+	if !ok {
+		t.Fatalf("got error %v, want RouteErrors", err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("got %d errors, want 3:\n%v", len(errs), errs)
+	}
+	for i, want := range routes[1:] {
+		if errs[i].Route.Pattern != want.Pattern || errs[i].Route.Method != want.Method {
+			t.Errorf("error %d is for %v, want %v", i, errs[i].Route, want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session defaults.
+const (
+	DefaultSessionCookie      = "session"
+	DefaultSessionIdleTimeout = 30 * time.Minute
+	DefaultSessionLifetime    = 24 * time.Hour
+)
+
+// SessionManager loads and saves server-side sessions, identified by a
+// cookie, and stored in a Store. The zero value of the optional fields is
+// usable, but Store must be set.
+//
+// For each request, the session is saved before the response header is
+// written, if it is not empty. Sessions which are idle for IdleTimeout,
+// or older than Lifetime, expire.
+type SessionManager struct {
+	// Store stores sessions. MemoryStore is suitable for a single
+	// instance; other implementations share sessions across instances.
+	Store Store
+
+	// Cookie is the name of the session cookie. If empty,
+	// DefaultSessionCookie is used.
+	Cookie string
+
+	// IdleTimeout and Lifetime are the idle and absolute expiry of
+	// sessions. If zero, DefaultSessionIdleTimeout and
+	// DefaultSessionLifetime are used.
+	IdleTimeout time.Duration
+	Lifetime    time.Duration
+
+	// Path and Domain are the attributes of the session cookie. If Path
+	// is empty, "/" is used.
+	Path   string
+	Domain string
+
+	// Insecure allows the session cookie to be sent over plain HTTP.
+	// Otherwise, the Secure attribute is set on the cookie.
+	Insecure bool
+}
+
+// A Session holds values associated with a client across requests. It is
+// safe for concurrent use.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string // the ID prior to Renew, to be deleted
+	created   time.Time
+	values    map[string]json.RawMessage
+	loaded    bool
+	dirty     bool
+	destroyed bool
+}
+
+// sessionRecord is the stored form of a Session.
+type sessionRecord struct {
+	Created time.Time                  `json:"created"`
+	Values  map[string]json.RawMessage `json:"values"`
+}
+
+// SessionFrom returns the session of req, loaded by SessionManager.Handler.
+// If req was not served by a SessionManager, SessionFrom returns nil.
+func SessionFrom(req *http.Request) *Session {
+	s, _ := req.Context().Value(sessionKey).(*Session)
+	return s
+}
+
+// ID returns the ID of the session, or the empty string, if the session
+// has not been saved yet.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Get decodes the value associated with key into v, which must be a
+// pointer. It reports whether the key was present.
+func (s *Session) Get(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set associates the JSON encoding of v with key.
+func (s *Session) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]json.RawMessage)
+	}
+	s.values[key] = raw
+	s.dirty = true
+	return nil
+}
+
+// Delete removes the value associated with key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+}
+
+// Renew assigns a new ID to the session, keeping its values. It must be
+// called when the privilege level of the session changes, such as on
+// login and logout, to prevent session fixation.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = ""
+	s.dirty = true
+}
+
+// Destroy deletes the session from the store, and expires the session
+// cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = nil
+	s.destroyed = true
+}
+
+// Handler returns a handler which loads the session of each request, makes
+// it available to next through SessionFrom, then saves it.
+func (m *SessionManager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s, err := m.load(req)
+		if err != nil {
+			SetError(req, err)
+			writeProblem(w, http.StatusInternalServerError, "")
+			return
+		}
+		w, finish := beforeWrite(w, func() {
+			if err := m.save(req.Context(), w, s); err != nil {
+				SetError(req, err)
+			}
+		})
+		ctx := context.WithValue(req.Context(), sessionKey, s)
+		next.ServeHTTP(w, req.WithContext(ctx))
+		finish()
+	})
+}
+
+func (m *SessionManager) load(req *http.Request) (*Session, error) {
+	s := &Session{created: time.Now()}
+	c, err := req.Cookie(m.cookie())
+	if err != nil || c.Value == "" {
+		return s, nil
+	}
+	b, ok, err := m.Store.Get(req.Context(), sessionStoreKey(c.Value))
+	if err != nil || !ok {
+		return s, err
+	}
+	var rec sessionRecord
+	if err := json.Unmarshal(b, &rec); err != nil || m.expired(rec.Created) {
+		// Start afresh, and delete the stale session once the new
+		// one is saved.
+		s.oldID = c.Value
+		return s, nil
+	}
+	s.id = c.Value
+	s.created = rec.Created
+	s.values = rec.Values
+	s.loaded = true
+	return s, nil
+}
+
+func (m *SessionManager) save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID != "" {
+		if err := m.Store.Delete(ctx, sessionStoreKey(s.oldID)); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+	if s.destroyed || len(s.values) == 0 {
+		// Empty sessions are not stored.
+		if s.id != "" {
+			if err := m.Store.Delete(ctx, sessionStoreKey(s.id)); err != nil {
+				return err
+			}
+		}
+		if s.loaded || s.destroyed {
+			http.SetCookie(w, m.newCookie("", -1))
+		}
+		return nil
+	}
+	if s.id == "" {
+		s.id = newSessionID()
+	}
+	// Saving loaded sessions on every request, modified or not, extends
+	// their idle expiry.
+	b, err := json.Marshal(sessionRecord{Created: s.created, Values: s.values})
+	if err != nil {
+		return err
+	}
+	ttl := m.idleTimeout()
+	if left := time.Until(s.created.Add(m.lifetime())); left < ttl {
+		ttl = left
+	}
+	if err := m.Store.Set(ctx, sessionStoreKey(s.id), b, ttl); err != nil {
+		return err
+	}
+	if !s.loaded || s.dirty {
+		http.SetCookie(w, m.newCookie(s.id, int(time.Until(s.created.Add(m.lifetime())).Seconds())))
+	}
+	return nil
+}
+
+func (m *SessionManager) expired(created time.Time) bool {
+	return time.Since(created) >= m.lifetime()
+}
+
+func (m *SessionManager) newCookie(value string, maxAge int) *http.Cookie {
+	path := m.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     m.cookie(),
+		Value:    value,
+		Path:     path,
+		Domain:   m.Domain,
+		MaxAge:   maxAge,
+		Secure:   !m.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (m *SessionManager) cookie() string {
+	if m.Cookie == "" {
+		return DefaultSessionCookie
+	}
+	return m.Cookie
+}
+
+func (m *SessionManager) idleTimeout() time.Duration {
+	if m.IdleTimeout <= 0 {
+		return DefaultSessionIdleTimeout
+	}
+	return m.IdleTimeout
+}
+
+func (m *SessionManager) lifetime() time.Duration {
+	if m.Lifetime <= 0 {
+		return DefaultSessionLifetime
+	}
+	return m.Lifetime
+}
+
+func sessionStoreKey(id string) string {
+	return "session:" + id
+}
+
+func newSessionID() string {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("httpx: crypto/rand: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
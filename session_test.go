@@ -0,0 +1,119 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestSessionManager(t *testing.T) {
+	store := httpx.NewMemoryStore(0)
+	m := &httpx.SessionManager{Store: store}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := httpx.SessionFrom(req)
+		switch req.URL.Path {
+		case "/login":
+			s.Renew()
+			s.Set("user", "alice")
+		case "/logout":
+			s.Destroy()
+		}
+		var user string
+		if _, err := s.Get("user", &user); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(user))
+	}))
+	serve := func(path string, c *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+		req := httptest.NewRequest("GET", path, nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == httpx.DefaultSessionCookie {
+				return rec, c
+			}
+		}
+		return rec, nil
+	}
+
+	if rec, c := serve("/", nil); c != nil || rec.Body.Len() != 0 {
+		t.Fatalf("empty session: got cookie %v, body %q", c, rec.Body.String())
+	}
+	_, c := serve("/login", nil)
+	if c == nil || !c.HttpOnly || !c.Secure {
+		t.Fatalf("login: got cookie %v", c)
+	}
+	rec, c2 := serve("/", c)
+	if got := rec.Body.String(); got != "alice" {
+		t.Errorf("user == %q, want %q", got, "alice")
+	}
+	if c2 != nil {
+		t.Errorf("unmodified session: got cookie %v", c2)
+	}
+
+	// Logging in again rotates the ID, and deletes the old session.
+	_, renewed := serve("/login", c)
+	if renewed == nil || renewed.Value == c.Value {
+		t.Fatalf("renew: got cookie %v, previous %v", renewed, c)
+	}
+	if rec, _ := serve("/", c); rec.Body.Len() != 0 {
+		t.Errorf("old session ID still valid")
+	}
+
+	_, expired := serve("/logout", renewed)
+	if expired == nil || expired.MaxAge >= 0 {
+		t.Fatalf("logout: got cookie %v", expired)
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("%d sessions left in store, want 0", n)
+	}
+}
+
+func TestSessionManagerLifetime(t *testing.T) {
+	store := httpx.NewMemoryStore(0)
+	m := &httpx.SessionManager{Store: store, Lifetime: 50 * time.Millisecond}
+	var id string
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := httpx.SessionFrom(req)
+		if req.URL.Path == "/set" {
+			s.Set("k", 1)
+		}
+		id = s.ID()
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/set", nil))
+	first := id
+	time.Sleep(60 * time.Millisecond)
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if id != "" {
+		t.Errorf("expired session %q was loaded", first)
+	}
+	if _, ok, _ := store.Get(context.Background(), "session:"+first); ok {
+		t.Error("expired session not removed from store")
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import "net/http"
+
+// SetError records err against the request being served, such that it is
+// reported in the Summary produced by ServeInstrumented, and hence in the
+// access log line, alongside the status of the response. Later calls
+// replace the error recorded by earlier ones.
+//
+// If req carries no request state, SetError is a no-op.
+func SetError(req *http.Request, err error) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	st.err = err
+	st.mu.Unlock()
+}
+
+// RequestError returns the error recorded for req using SetError, or nil.
+func RequestError(req *http.Request) error {
+	st := stateOf(req)
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestSetError(t *testing.T) {
+	errDB := errors.New("database unavailable")
+	tests := []struct {
+		name    string
+		status  int
+		wantKV  bool
+		wantErr error
+	}{
+		{name: "server error", status: http.StatusServiceUnavailable, wantKV: true, wantErr: errDB},
+		{name: "client error", status: http.StatusNotFound, wantErr: errDB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				httpx.SetError(req, errDB)
+				w.WriteHeader(tt.status)
+			})
+			s := httpx.ServeInstrumented(h, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			if s.Err != tt.wantErr {
+				t.Errorf("Err == %v, want %v", s.Err, tt.wantErr)
+			}
+			v, ok := s.KV()["error"]
+			if ok != tt.wantKV {
+				t.Fatalf("error in KV: %t, want %t", ok, tt.wantKV)
+			}
+			if ok && v != errDB.Error() {
+				t.Errorf("KV error == %v, want %q", v, errDB.Error())
+			}
+		})
+	}
+}
+
+func TestSetErrorWithoutState(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	httpx.SetError(req, errors.New("lost"))
+	if err := httpx.RequestError(req); err != nil {
+		t.Errorf("RequestError == %v, want nil", err)
+	}
+}
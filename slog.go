@@ -0,0 +1,55 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"acln.ro/log"
+)
+
+// SlogRequestLogger is like RequestLogger, but for loggers from the
+// log/slog package. It records the same keys as RequestLogger.
+func SlogRequestLogger(base *slog.Logger, req *http.Request) *slog.Logger {
+	attrs := kvAttrs(requestKV(req))
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+	return base.With(args...)
+}
+
+// SlogAttrs returns attributes representing the Summary, suitable for
+// logging using a *slog.Logger. The keys are the same as those produced
+// by KV.
+func (s Summary) SlogAttrs() []slog.Attr {
+	return kvAttrs(s.KV())
+}
+
+// kvAttrs converts kv to attributes, sorted by key.
+func kvAttrs(kv log.KV) []slog.Attr {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, k := range keys {
+		attrs[i] = slog.Any(k, kv[k])
+	}
+	return attrs
+}
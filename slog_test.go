@@ -0,0 +1,57 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestSlogRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	base := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("User-Agent", "test")
+	req = httpx.WithPath(req)
+	req = httpx.WithRequestID(req, "abc")
+
+	s := httpx.Summary{Status: 404, Duration: time.Millisecond, Written: 9}
+	httpx.SlogRequestLogger(base, req).LogAttrs(req.Context(), slog.LevelInfo, "done", s.SlogAttrs()...)
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"method":     "GET",
+		"path":       "/users/42",
+		"user_agent": "test",
+		"request_id": "abc",
+		"status":     float64(404),
+		"written":    float64(9),
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s == %v, want %v", k, rec[k], v)
+		}
+	}
+}
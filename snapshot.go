@@ -0,0 +1,229 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A Snapshotter is a Store whose contents can be saved and restored, so
+// that state such as rate limit counters and quotas survives restarts.
+type Snapshotter interface {
+	Store
+
+	// Snapshot writes the live entries of the store to w.
+	Snapshot(w io.Writer) error
+
+	// Restore loads the entries of a snapshot produced by Snapshot into
+	// the store, replacing entries with the same keys, and returns the
+	// number of entries restored. Entries which expired in the meantime
+	// are skipped.
+	Restore(r io.Reader) (int, error)
+}
+
+// Errors returned when restoring snapshots.
+var (
+	// ErrSnapshotVersion means that the snapshot was written by an
+	// unsupported version of the format. Nothing is restored.
+	ErrSnapshotVersion = errors.New("httpx: unsupported snapshot version")
+
+	// ErrSnapshotCorrupt means that some of the entries of the snapshot
+	// were damaged or truncated. The intact entries are restored.
+	ErrSnapshotCorrupt = errors.New("httpx: corrupt snapshot")
+)
+
+// snapshotMagic starts every snapshot, followed by the version byte.
+const (
+	snapshotMagic   = "httpxsnp"
+	snapshotVersion = 1
+)
+
+// maxSnapshotRecord bounds the size of a single record, such that damaged
+// length prefixes do not cause huge allocations.
+const maxSnapshotRecord = 64 << 20
+
+// Snapshot implements Snapshotter. The format is versioned, and every
+// entry carries a checksum.
+//
+// Entries are written least recently used first, so that restoring the
+// snapshot preserves their recency.
+func (s *MemoryStore) Snapshot(w io.Writer) error {
+	s.mu.Lock()
+	now := time.Now()
+	var records [][]byte
+	for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(*memoryEntry)
+		if !e.expired(now) {
+			records = append(records, encodeSnapshotRecord(e))
+		}
+	}
+	s.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	var buf [binary.MaxVarintLen64 + 4]byte
+	for _, rec := range records {
+		n := binary.PutUvarint(buf[:], uint64(len(rec)))
+		bw.Write(buf[:n])
+		bw.Write(rec)
+		binary.BigEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(rec))
+		bw.Write(buf[:4])
+	}
+	return bw.Flush()
+}
+
+// Restore implements Snapshotter. Damaged entries are skipped. If the
+// damage leaves the remainder of the snapshot unreadable, restoration
+// stops there. In both cases, Restore returns ErrSnapshotCorrupt, along
+// with the number of entries restored.
+func (s *MemoryStore) Restore(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, ErrSnapshotCorrupt
+		}
+		return 0, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, ErrSnapshotCorrupt
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return 0, ErrSnapshotVersion
+	}
+
+	var (
+		restored int
+		corrupt  bool
+		now      = time.Now()
+	)
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil || size > maxSnapshotRecord {
+			corrupt = true
+			break
+		}
+		rec := make([]byte, size+4)
+		if _, err := io.ReadFull(br, rec); err != nil {
+			corrupt = true
+			break
+		}
+		payload, sum := rec[:size], binary.BigEndian.Uint32(rec[size:])
+		if crc32.ChecksumIEEE(payload) != sum {
+			corrupt = true
+			continue
+		}
+		key, value, expires, ok := decodeSnapshotRecord(payload)
+		if !ok {
+			corrupt = true
+			continue
+		}
+		var ttl time.Duration
+		if !expires.IsZero() {
+			if ttl = expires.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		s.mu.Lock()
+		s.store(key, value, ttl, now)
+		s.mu.Unlock()
+		restored++
+	}
+	if corrupt {
+		return restored, ErrSnapshotCorrupt
+	}
+	return restored, nil
+}
+
+// encodeSnapshotRecord encodes e as the uvarint length of the key, the key,
+// the varint expiry time in Unix nanoseconds (zero for none), and the value.
+func encodeSnapshotRecord(e *memoryEntry) []byte {
+	var expires int64
+	if !e.expires.IsZero() {
+		expires = e.expires.UnixNano()
+	}
+	rec := make([]byte, 0, 2*binary.MaxVarintLen64+len(e.key)+len(e.value))
+	rec = binary.AppendUvarint(rec, uint64(len(e.key)))
+	rec = append(rec, e.key...)
+	rec = binary.AppendVarint(rec, expires)
+	return append(rec, e.value...)
+}
+
+func decodeSnapshotRecord(rec []byte) (key string, value []byte, expires time.Time, ok bool) {
+	klen, n := binary.Uvarint(rec)
+	if n <= 0 || klen > uint64(len(rec)-n) {
+		return "", nil, time.Time{}, false
+	}
+	rec = rec[n:]
+	key, rec = string(rec[:klen]), rec[klen:]
+	exp, n := binary.Varint(rec)
+	if n <= 0 {
+		return "", nil, time.Time{}, false
+	}
+	if exp != 0 {
+		expires = time.Unix(0, exp)
+	}
+	return key, copyBytes(rec[n:]), expires, true
+}
+
+// SnapshotFile writes a snapshot of s to the file at path, atomically
+// replacing any previous snapshot. It is typically called on graceful
+// shutdown.
+func SnapshotFile(s Snapshotter, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := s.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// RestoreFile restores the snapshot stored in the file at path into s,
+// and returns the number of entries restored. If the file does not exist,
+// RestoreFile returns 0 and a nil error, so that the first start of a
+// service is not an error. It is typically called on startup.
+func RestoreFile(s Snapshotter, path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
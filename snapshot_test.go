@@ -0,0 +1,127 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+var _ httpx.Snapshotter = (*httpx.MemoryStore)(nil)
+
+func snapshotStore(t *testing.T) *httpx.MemoryStore {
+	t.Helper()
+	ctx := context.Background()
+	s := httpx.NewMemoryStore(0)
+	s.Set(ctx, "session:a", []byte("alice"), 0)
+	s.Set(ctx, "doomed", []byte("x"), time.Millisecond)
+	if _, err := s.Incr(ctx, "ratelimit:1.2.3.4", 7, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	return s
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := snapshotStore(t).Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s := httpx.NewMemoryStore(0)
+	n, err := s.Restore(&buf)
+	if err != nil || n != 2 {
+		t.Fatalf("Restore == %d, %v, want 2, nil", n, err)
+	}
+	if v, ok, _ := s.Get(ctx, "session:a"); !ok || string(v) != "alice" {
+		t.Errorf("session:a == %q, %t", v, ok)
+	}
+	if n, err := s.Incr(ctx, "ratelimit:1.2.3.4", 1, time.Hour); n != 8 || err != nil {
+		t.Errorf("restored counter incremented to %d, %v, want 8", n, err)
+	}
+	if _, ok, _ := s.Get(ctx, "doomed"); ok {
+		t.Error("expired entry restored")
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	if err := snapshotStore(t).Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		snap    func() []byte
+		wantN   int
+		wantErr error
+	}{
+		{
+			name:    "empty",
+			snap:    func() []byte { return nil },
+			wantErr: httpx.ErrSnapshotCorrupt,
+		},
+		{
+			name: "version",
+			snap: func() []byte {
+				b := append([]byte(nil), good...)
+				b[8] = 99
+				return b
+			},
+			wantErr: httpx.ErrSnapshotVersion,
+		},
+		{
+			name: "damaged entry",
+			snap: func() []byte {
+				b := append([]byte(nil), good...)
+				b[12] ^= 0xff // inside the first record
+				return b
+			},
+			wantN:   1,
+			wantErr: httpx.ErrSnapshotCorrupt,
+		},
+		{
+			name:    "truncated",
+			snap:    func() []byte { return good[:len(good)-2] },
+			wantN:   1,
+			wantErr: httpx.ErrSnapshotCorrupt,
+		},
+	}
+	for _, tt := range tests {
+		n, err := httpx.NewMemoryStore(0).Restore(bytes.NewReader(tt.snap()))
+		if n != tt.wantN || err != tt.wantErr {
+			t.Errorf("%s: Restore == %d, %v, want %d, %v", tt.name, n, err, tt.wantN, tt.wantErr)
+		}
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.snap")
+	if n, err := httpx.RestoreFile(httpx.NewMemoryStore(0), path); n != 0 || err != nil {
+		t.Fatalf("RestoreFile on missing file == %d, %v, want 0, nil", n, err)
+	}
+	if err := httpx.SnapshotFile(snapshotStore(t), path); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := httpx.RestoreFile(httpx.NewMemoryStore(0), path); n != 2 || err != nil {
+		t.Errorf("RestoreFile == %d, %v, want 2, nil", n, err)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEHeartbeat is the interval at which an EventStream sends
+// heartbeat comments, which keep idle connections from being closed by
+// intermediaries.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// ErrStreamClosed is returned by the methods of an EventStream which has
+// been closed, or whose client has disconnected.
+var ErrStreamClosed = errors.New("httpx: event stream closed")
+
+// ErrNoFlusher is returned by SSE if the ResponseWriter does not
+// implement http.Flusher.
+var ErrNoFlusher = errors.New("httpx: ResponseWriter is not an http.Flusher")
+
+// An EventStream writes server-sent events, as specified by the HTML
+// standard, to a client. Its methods are safe for concurrent use.
+type EventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	req     *http.Request
+
+	mu     sync.Mutex
+	err    error // ErrStreamClosed, or the first write error
+	events int
+
+	heartbeat chan time.Duration
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// SSE starts an event stream as the response to req: it writes the
+// response header, and starts sending heartbeat comments every
+// DefaultSSEHeartbeat. SSE returns ErrNoFlusher, and writes nothing, if w
+// cannot be flushed.
+//
+// The stream ends when the client disconnects, when the server is shut
+// down, or when it is closed. The handler must call Close before it
+// returns. The number of events sent is annotated under the "sse_events"
+// key.
+func SSE(w http.ResponseWriter, req *http.Request) (*EventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrNoFlusher
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	AddMark(req, "sse_start")
+	s := &EventStream{
+		w:         w,
+		flusher:   flusher,
+		req:       req,
+		heartbeat: make(chan time.Duration),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run(serverShutdown(req))
+	return s, nil
+}
+
+func (s *EventStream) run(shutdown <-chan struct{}) {
+	defer close(s.done)
+	defer s.fail(ErrStreamClosed)
+	t := time.NewTicker(DefaultSSEHeartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.req.Context().Done():
+			return
+		case <-shutdown:
+			return
+		case <-s.closing:
+			return
+		case d := <-s.heartbeat:
+			if d > 0 {
+				t.Reset(d)
+			} else {
+				t.Stop()
+			}
+		case <-t.C:
+			s.Comment("heartbeat")
+		}
+	}
+}
+
+// LastEventID returns the ID of the last event received by the client
+// before it reconnected, as sent in the Last-Event-ID request header, so
+// that the stream can be resumed.
+func (s *EventStream) LastEventID() string {
+	return s.req.Header.Get("Last-Event-ID")
+}
+
+// Send sends an event. event and id are optional. data may contain
+// newlines. The event is flushed to the client immediately.
+func (s *EventStream) Send(event, id, data string) error {
+	var sb strings.Builder
+	if event != "" {
+		sb.WriteString("event: " + singleLine(event) + "\n")
+	}
+	if id != "" {
+		sb.WriteString("id: " + singleLine(id) + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	sb.WriteByte('\n')
+	return s.write(sb.String(), true)
+}
+
+// Retry instructs the client to wait for d before reconnecting.
+func (s *EventStream) Retry(d time.Duration) error {
+	return s.write("retry: "+strconv.FormatInt(d.Milliseconds(), 10)+"\n\n", false)
+}
+
+// Comment sends a comment, which clients ignore.
+func (s *EventStream) Comment(text string) error {
+	return s.write(": "+singleLine(text)+"\n\n", false)
+}
+
+// SetHeartbeat sets the interval at which heartbeat comments are sent. If
+// d is zero or less, heartbeats are disabled.
+func (s *EventStream) SetHeartbeat(d time.Duration) {
+	select {
+	case s.heartbeat <- d:
+	case <-s.done:
+	}
+}
+
+// Done returns a channel which is closed when the stream ends.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream, and waits for the heartbeats to stop. It is safe
+// to call Close multiple times.
+func (s *EventStream) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	Annotate(s.req, "sse_events", s.events)
+	return nil
+}
+
+func (s *EventStream) write(msg string, event bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		s.err = err
+		return err
+	}
+	s.flusher.Flush()
+	if event {
+		s.events++
+	}
+	return nil
+}
+
+// fail records err as the reason the stream ended, if it has not ended
+// already.
+func (s *EventStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// singleLine replaces the line breaks in s, which would otherwise end the
+// field they are part of.
+func singleLine(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// shutdowns maps each *http.Server to a channel which is closed when the
+// server is shut down.
+var shutdowns sync.Map
+
+// serverShutdown returns a channel which is closed when the server which
+// serves req is shut down, or nil if the server is unknown. Shutdown does
+// not cancel the contexts of active requests, and long-lived responses
+// must watch for it, lest they delay the shutdown until they time out.
+func serverShutdown(req *http.Request) <-chan struct{} {
+	srv, ok := req.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok {
+		return nil
+	}
+	ch := make(chan struct{})
+	v, loaded := shutdowns.LoadOrStore(srv, ch)
+	if !loaded {
+		srv.RegisterOnShutdown(func() {
+			shutdowns.Delete(srv)
+			close(ch)
+		})
+	}
+	return v.(chan struct{})
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestSSE(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httpx.WithRequestState(httptest.NewRequest("GET", "/", nil))
+	req.Header.Set("Last-Event-ID", "41")
+	s, err := httpx.SSE(rec, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.LastEventID(); got != "41" {
+		t.Errorf("LastEventID == %q, want %q", got, "41")
+	}
+	s.Send("update", "42", "line 1\nline 2")
+	s.Send("", "", "plain")
+	s.Comment("hi\nthere")
+	s.Retry(1500 * time.Millisecond)
+	s.Close()
+	if err := s.Send("", "", "late"); err != httpx.ErrStreamClosed {
+		t.Errorf("Send after Close: got error %v, want ErrStreamClosed", err)
+	}
+
+	want := "event: update\nid: 42\ndata: line 1\ndata: line 2\n\n" +
+		"data: plain\n\n" +
+		": hi there\n\n" +
+		"retry: 1500\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body == %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type == %q", got)
+	}
+	if got := httpx.Annotations(req)["sse_events"]; got != 2 {
+		t.Errorf("sse_events == %v, want 2", got)
+	}
+}
+
+func TestSSEHeartbeatAndDisconnect(t *testing.T) {
+	ended := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s, err := httpx.SSE(w, req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer close(ended)
+		defer s.Close()
+		s.SetHeartbeat(5 * time.Millisecond)
+		<-s.Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, ": heartbeat") {
+		t.Fatalf("got %q, %v, want a heartbeat", line, err)
+	}
+	cancel()
+	resp.Body.Close()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end on disconnect")
+	}
+}
+
+func TestSSEShutdown(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s, err := httpx.SSE(w, req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer s.Close()
+		close(started)
+		<-s.Done()
+	}))
+	srv.Start()
+	defer srv.Close()
+	go func() {
+		resp, err := srv.Client().Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestSSENoFlusher(t *testing.T) {
+	var w struct{ http.ResponseWriter }
+	w.ResponseWriter = httptest.NewRecorder()
+	if _, err := httpx.SSE(w, httptest.NewRequest("GET", "/", nil)); err != httpx.ErrNoFlusher {
+		t.Errorf("got error %v, want ErrNoFlusher", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"acln.ro/log"
+)
+
+// requestState is the mutable container shared by all the handlers and
+// middleware which serve a request. Unlike immutable context values, it
+// lets code deep in the handler tree record information which is visible
+// to the middleware which installed it.
+type requestState struct {
+	start time.Time
+
+	mu      sync.Mutex
+	memo    *MemoCache
+	notices []Notice
+	meta    map[string]interface{}
+	marks   []Mark
+	err     error
+	fields  log.KV
+
+	upstreamFailure UpstreamFailure
+	cancelCause     error
+	cancel          context.CancelCauseFunc
+	route           string
+	chain           *chainTrace
+	timings         *Timings
+}
+
+// WithRequestState installs a mutable per-request container in the context
+// of req, and returns the new *http.Request, with the updated context.
+// Features such as Memo store their data in this container.
+//
+// If the request context stores a container already, WithRequestState is
+// a no-op and returns req. Middleware in this package which depend on the
+// container install it themselves.
+func WithRequestState(req *http.Request) *http.Request {
+	if stateOf(req) != nil {
+		return req
+	}
+	st := &requestState{start: time.Now()}
+	return req.WithContext(context.WithValue(req.Context(), stateKey, st))
+}
+
+// stateOf returns the container associated with req, or nil.
+func stateOf(req *http.Request) *requestState {
+	st, _ := req.Context().Value(stateKey).(*requestState)
+	return st
+}
+
+// Memo returns the memoization cache associated with req. The cache is
+// shared by all handlers and middleware serving the request, so expensive
+// lookups, such as loading the user record or feature flags, are only
+// performed once per request.
+//
+// If req carries no container installed by WithRequestState, Memo returns
+// a new, empty cache, which is not shared.
+func Memo(req *http.Request) *MemoCache {
+	st := stateOf(req)
+	if st == nil {
+		return newMemoCache()
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.memo == nil {
+		st.memo = newMemoCache()
+	}
+	return st.memo
+}
+
+// MemoCache is a per-request cache. It is safe for concurrent use.
+type MemoCache struct {
+	mu      sync.Mutex
+	entries map[interface{}]*memoEntry
+}
+
+type memoEntry struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+func newMemoCache() *MemoCache {
+	return &MemoCache{entries: make(map[interface{}]*memoEntry)}
+}
+
+// Get returns the value associated with key, if any. Keys must be
+// comparable. To avoid collisions, packages should use keys of
+// unexported types, as with context values.
+func (mc *MemoCache) Get(key interface{}) (interface{}, bool) {
+	mc.mu.Lock()
+	e, ok := mc.entries[key]
+	mc.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	<-e.done
+	if e.err != nil {
+		return nil, false
+	}
+	return e.val, true
+}
+
+// Set associates val with key.
+func (mc *MemoCache) Set(key, val interface{}) {
+	e := &memoEntry{done: make(chan struct{}), val: val}
+	close(e.done)
+	mc.mu.Lock()
+	mc.entries[key] = e
+	mc.mu.Unlock()
+}
+
+// Do returns the value associated with key. If there is none, Do calls fn
+// to compute it, and stores the result. Concurrent calls to Do with the
+// same key wait for the first one to complete. Errors are returned to the
+// callers waiting at the time, but are not cached: later calls call fn
+// again. If fn panics, the panic propagates to the caller of Do, and
+// waiting callers call fn themselves.
+func (mc *MemoCache) Do(key interface{}, fn func() (interface{}, error)) (interface{}, error) {
+	mc.mu.Lock()
+	if e, ok := mc.entries[key]; ok {
+		mc.mu.Unlock()
+		<-e.done
+		if e.err == errMemoPanic {
+			return mc.Do(key, fn)
+		}
+		return e.val, e.err
+	}
+	e := &memoEntry{done: make(chan struct{})}
+	mc.entries[key] = e
+	mc.mu.Unlock()
+
+	// If fn panics, the entry is removed nevertheless, and the waiting
+	// callers retry, while the panic propagates to the caller.
+	returned := false
+	defer func() {
+		if !returned {
+			e.err = errMemoPanic
+		}
+		if e.err != nil {
+			mc.mu.Lock()
+			if mc.entries[key] == e {
+				delete(mc.entries, key)
+			}
+			mc.mu.Unlock()
+		}
+		close(e.done)
+	}()
+	e.val, e.err = fn()
+	returned = true
+	return e.val, e.err
+}
+
+// errMemoPanic marks the entries of MemoCache.Do calls which panicked.
+var errMemoPanic = errors.New("httpx: MemoCache.Do function panicked")
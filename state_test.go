@@ -0,0 +1,151 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+type memoKey string
+
+func TestMemo(t *testing.T) {
+	req := httpx.WithRequestState(httptest.NewRequest("GET", "/", nil))
+	if again := httpx.WithRequestState(req); again != req {
+		t.Fatal("WithRequestState replaced an existing container")
+	}
+
+	calls := 0
+	load := func() (interface{}, error) {
+		calls++
+		return "alice", nil
+	}
+	for i := 0; i < 3; i++ {
+		v, err := httpx.Memo(req).Do(memoKey("user"), load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != "alice" {
+			t.Fatalf("Do == %v, want %q", v, "alice")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+	if v, ok := httpx.Memo(req).Get(memoKey("user")); !ok || v != "alice" {
+		t.Fatalf("Get == %v, %t", v, ok)
+	}
+
+	errBoom := errors.New("boom")
+	if _, err := httpx.Memo(req).Do(memoKey("flags"), func() (interface{}, error) {
+		return nil, errBoom
+	}); err != errBoom {
+		t.Fatalf("Do: got error %v, want %v", err, errBoom)
+	}
+	if _, ok := httpx.Memo(req).Get(memoKey("flags")); ok {
+		t.Fatal("error result was cached")
+	}
+
+	httpx.Memo(req).Set(memoKey("geo"), "RO")
+	if v, _ := httpx.Memo(req).Get(memoKey("geo")); v != "RO" {
+		t.Fatalf("Get after Set == %v", v)
+	}
+
+	bare := httptest.NewRequest("GET", "/", nil)
+	httpx.Memo(bare).Set(memoKey("geo"), "RO")
+	if _, ok := httpx.Memo(bare).Get(memoKey("geo")); ok {
+		t.Fatal("Memo shared a cache for a request without state")
+	}
+}
+
+func TestMemoPanic(t *testing.T) {
+	mc := httpx.Memo(httpx.WithRequestState(httptest.NewRequest("GET", "/", nil)))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		mc.Do(memoKey("user"), func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+	waiter := make(chan interface{})
+	go func() {
+		v, _ := mc.Do(memoKey("user"), func() (interface{}, error) {
+			return "alice", nil
+		})
+		waiter <- v
+	}()
+	close(release)
+	if v := <-panicked; v != "boom" {
+		t.Errorf("recovered %v, want %q", v, "boom")
+	}
+	select {
+	case v := <-waiter:
+		if v != "alice" {
+			t.Errorf("waiter got %v, want %q", v, "alice")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after fn panicked")
+	}
+	if v, err := mc.Do(memoKey("user"), nil); err != nil || v != "alice" {
+		t.Errorf("Do == %v, %v, want %q", v, err, "alice")
+	}
+}
+
+func TestMemoErrorWaiters(t *testing.T) {
+	mc := httpx.Memo(httpx.WithRequestState(httptest.NewRequest("GET", "/", nil)))
+	errUnavailable := errors.New("unavailable")
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, errUnavailable
+	}
+	const waiters = 5
+	errs := make(chan error, waiters+1)
+	for i := 0; i < waiters+1; i++ {
+		go func() {
+			_, err := mc.Do(memoKey("user"), fn)
+			errs <- err
+		}()
+	}
+	// Let the callers start waiting on the first one.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < waiters+1; i++ {
+		if err := <-errs; err != errUnavailable {
+			t.Errorf("got error %v, want %v", err, errUnavailable)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("fn called %d times, want 1", n)
+	}
+
+	// The error is not cached.
+	v, err := mc.Do(memoKey("user"), func() (interface{}, error) { return "alice", nil })
+	if err != nil || v != "alice" {
+		t.Errorf("Do after error == %v, %v, want %q", v, err, "alice")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Created responds to req with 201 Created. The Location header is set to
+// location, resolved by ResolveLocation. If v is not nil, its JSON
+// encoding is written as the body, as by WriteJSON.
+func Created(w http.ResponseWriter, req *http.Request, location string, v interface{}) error {
+	return respondLocation(w, req, http.StatusCreated, location, v)
+}
+
+// Accepted responds to req with 202 Accepted, for work which completes
+// asynchronously. If location is not empty, it typically names a resource
+// which monitors the progress of the work, and the Location header is set
+// to it, resolved by ResolveLocation. If v is not nil, its JSON encoding
+// is written as the body, as by WriteJSON.
+func Accepted(w http.ResponseWriter, req *http.Request, location string, v interface{}) error {
+	return respondLocation(w, req, http.StatusAccepted, location, v)
+}
+
+// NoContent responds with 204 No Content. Any Content-Type and
+// Content-Length headers set already are removed, since the response
+// has no body.
+func NoContent(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respondLocation(w http.ResponseWriter, req *http.Request, status int, location string, v interface{}) error {
+	if location != "" {
+		w.Header().Set("Location", ResolveLocation(req, location))
+	}
+	if v == nil {
+		w.WriteHeader(status)
+		return nil
+	}
+	return WriteJSON(w, status, v)
+}
+
+// ResolveLocation resolves a location relative to the part of the request
+// path which has been consumed by the handlers serving req, by Shift or
+// by Mounts, and returns the resulting path. The consumed prefix is
+// treated as a directory: for a request to "/api/widgets" served by a
+// handler mounted at "/api" which has shifted "widgets", the location
+// "42" resolves to "/api/widgets/42", and "../gadgets/7" to
+// "/api/gadgets/7".
+//
+// Locations which are absolute URLs, or which begin with "/", are
+// returned unchanged. The consumed prefix is computed from the original
+// path recorded by WithPath, or from MountPrefix if there is none.
+func ResolveLocation(req *http.Request, location string) string {
+	ref, err := url.Parse(location)
+	if err != nil || ref.IsAbs() || ref.Host != "" || strings.HasPrefix(ref.Path, "/") {
+		return location
+	}
+	base := consumedPrefix(req)
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return (&url.URL{Path: base}).ResolveReference(ref).String()
+}
+
+// consumedPrefix returns the prefix of the original request path which
+// precedes req.URL.Path.
+func consumedPrefix(req *http.Request) string {
+	if orig := Path(req); orig != "" && strings.HasSuffix(orig, req.URL.Path) {
+		return orig[:len(orig)-len(req.URL.Path)]
+	}
+	return MountPrefix(req)
+}
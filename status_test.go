@@ -0,0 +1,108 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestResolveLocation(t *testing.T) {
+	tests := []struct {
+		path     string
+		shifts   int
+		location string
+		want     string
+	}{
+		{"/widgets", 1, "42", "/widgets/42"},
+		{"/api/v1/widgets", 3, "42", "/api/v1/widgets/42"},
+		{"/api/v1/widgets", 3, "../gadgets/7?x=1", "/api/v1/gadgets/7?x=1"},
+		{"/api/v1/widgets/", 3, "42", "/api/v1/widgets/42"},
+		{"/api/v1/widgets", 2, "widgets/42", "/api/v1/widgets/42"},
+		{"/api/v1/widgets", 3, "/elsewhere", "/elsewhere"},
+		{"/api/v1/widgets", 3, "https://example.com/x", "https://example.com/x"},
+		{"/widgets", 0, "42", "/42"},
+	}
+	for _, tt := range tests {
+		req := httpx.WithPath(httptest.NewRequest("POST", tt.path, nil))
+		for i := 0; i < tt.shifts; i++ {
+			httpx.Shift(req)
+		}
+		if got := httpx.ResolveLocation(req, tt.location); got != tt.want {
+			t.Errorf("%s after %d shifts: ResolveLocation(%q) == %q, want %q", tt.path, tt.shifts, tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLocationMount(t *testing.T) {
+	var got string
+	var ms httpx.Mounts
+	ms.Mount("/api", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.Shift(req)
+		httpx.Created(w, req, "42", nil)
+		got = w.Header().Get("Location")
+	}))
+	ms.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/widgets", nil))
+	if want := "/api/widgets/42"; got != want {
+		t.Errorf("Location == %q, want %q", got, want)
+	}
+}
+
+func TestCreated(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httpx.WithPath(httptest.NewRequest("POST", "/widgets", nil))
+	httpx.Shift(req)
+	if err := httpx.Created(rec, req, "42", map[string]int{"id": 42}); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if got, want := rec.Header().Get("Location"), "/widgets/42"; got != want {
+		t.Errorf("Location == %q, want %q", got, want)
+	}
+	if got, want := rec.Body.String(), "{\"id\":42}\n"; got != want {
+		t.Errorf("body == %q, want %q", got, want)
+	}
+}
+
+func TestAccepted(t *testing.T) {
+	rec := httptest.NewRecorder()
+	httpx.Accepted(rec, httptest.NewRequest("POST", "/", nil), "", nil)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if _, ok := rec.Header()["Location"]; ok {
+		t.Error("Location set for empty location")
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("body == %q, want empty", rec.Body.String())
+	}
+}
+
+func TestNoContent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	httpx.NoContent(rec)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "" {
+		t.Errorf("Content-Type == %q, want empty", got)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Store is a key-value store with per-entry expiry. It is the single
+// persistence interface used by the stateful parts of this package, such
+// as idempotency keys, sessions, nonces, rate limit counters and caches.
+// Implementing Store once, for Redis or SQL for example, makes all of
+// them available on top of that backend.
+//
+// Implementations must be safe for concurrent use. A ttl of zero or less
+// means the entry does not expire.
+type Store interface {
+	// Get returns the value associated with key. If the key is not
+	// present or has expired, Get returns a nil value, false, and
+	// a nil error.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+
+	// Set associates value with key, replacing any existing value.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Add associates value with key, if the key is not present already.
+	// It reports whether the value was stored.
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+
+	// Delete removes key from the store. Deleting a key which is not
+	// present is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Incr atomically adds delta to the integer value associated with key,
+	// and returns the new value. If the key is not present, it is created
+	// with the value delta, and the specified ttl. The ttl of existing
+	// keys is not modified.
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// ErrNotInteger is returned by MemoryStore.Incr if the existing value is
+// not an integer.
+var ErrNotInteger = errors.New("httpx: stored value is not an integer")
+
+// MemoryStore is an in-memory Store. It is the reference implementation
+// of the Store interface. Once the number of entries exceeds the
+// configured limit, the least recently used entries are evicted. Expired
+// entries are removed as they are looked up, and swept periodically.
+type MemoryStore struct {
+	max int
+
+	mu      sync.Mutex
+	lru     *list.List // of *memoryEntry, most recently used first
+	entries map[string]*list.Element
+	inserts int
+}
+
+// memoryStoreSweep is the number of insertions between sweeps of the
+// expired entries of a MemoryStore.
+const memoryStoreSweep = 1024
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time // zero means never
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// NewMemoryStore creates a MemoryStore which holds at most maxEntries
+// entries. If maxEntries is zero or less, the store is unbounded.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		max:     maxEntries,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.lookup(key, time.Now())
+	if e == nil {
+		return nil, false, nil
+	}
+	return copyBytes(e.value), true, nil
+}
+
+// Set implements Store.
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store(key, copyBytes(value), ttl, time.Now())
+	return nil
+}
+
+// Add implements Store.
+func (s *MemoryStore) Add(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.lookup(key, now) != nil {
+		return false, nil
+	}
+	s.store(key, copyBytes(value), ttl, now)
+	return true, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+// Incr implements Store.
+func (s *MemoryStore) Incr(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e := s.lookup(key, now)
+	if e == nil {
+		s.store(key, strconv.AppendInt(nil, delta, 10), ttl, now)
+		return delta, nil
+	}
+	n, err := strconv.ParseInt(string(e.value), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	n += delta
+	e.value = strconv.AppendInt(e.value[:0], n, 10)
+	return n, nil
+}
+
+// Len returns the number of entries in the store, including expired
+// entries which have not been evicted yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+// lookup returns the live entry for key, marking it as recently used.
+// Expired entries are removed. s.mu must be held.
+func (s *MemoryStore) lookup(key string, now time.Time) *memoryEntry {
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*memoryEntry)
+	if e.expired(now) {
+		s.remove(elem)
+		return nil
+	}
+	s.lru.MoveToFront(elem)
+	return e
+}
+
+// store stores an entry, then evicts entries if the store is over
+// capacity. s.mu must be held.
+func (s *MemoryStore) store(key string, value []byte, ttl time.Duration, now time.Time) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+	if elem, ok := s.entries[key]; ok {
+		e := elem.Value.(*memoryEntry)
+		e.value = value
+		e.expires = expires
+		s.lru.MoveToFront(elem)
+		return
+	}
+	if s.inserts++; s.inserts%memoryStoreSweep == 0 {
+		s.sweep(now)
+	}
+	e := &memoryEntry{key: key, value: value, expires: expires}
+	s.entries[key] = s.lru.PushFront(e)
+	s.evict()
+}
+
+// evict makes room in a store which is over capacity, by removing the
+// least recently used entries. s.mu must be held.
+func (s *MemoryStore) evict() {
+	if s.max <= 0 {
+		return
+	}
+	for s.lru.Len() > s.max {
+		s.remove(s.lru.Back())
+	}
+}
+
+// Sweep removes all expired entries from the store. The store sweeps
+// itself every so many insertions, so calling Sweep is only necessary
+// to reclaim memory sooner.
+func (s *MemoryStore) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for elem := s.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if elem.Value.(*memoryEntry).expired(now) {
+			s.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*memoryEntry).key)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+var _ httpx.Store = (*httpx.MemoryStore)(nil)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := httpx.NewMemoryStore(0)
+
+	if _, ok, _ := s.Get(ctx, "k"); ok {
+		t.Fatal("Get on empty store returned a value")
+	}
+	if err := s.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := s.Get(ctx, "k"); !ok || string(v) != "v" {
+		t.Fatalf("Get == %q, %t, want %q, true", v, ok, "v")
+	}
+	if added, _ := s.Add(ctx, "k", []byte("w"), 0); added {
+		t.Fatal("Add replaced an existing key")
+	}
+	if err := s.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if added, _ := s.Add(ctx, "k", []byte("w"), 0); !added {
+		t.Fatal("Add did not store a deleted key")
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		n, err := s.Incr(ctx, "n", 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("Incr == %d, want %d", n, i)
+		}
+	}
+	if _, err := s.Incr(ctx, "k", 1, 0); err != httpx.ErrNotInteger {
+		t.Fatalf("Incr on non-integer: got %v, want %v", err, httpx.ErrNotInteger)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := httpx.NewMemoryStore(0)
+
+	s.Set(ctx, "k", []byte("v"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := s.Get(ctx, "k"); ok {
+		t.Fatal("Get returned an expired value")
+	}
+
+	s.Set(ctx, "k", []byte("v"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	s.Sweep()
+	if n := s.Len(); n != 0 {
+		t.Fatalf("Len after Sweep == %d, want 0", n)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	s := httpx.NewMemoryStore(2)
+
+	s.Set(ctx, "a", []byte("a"), 0)
+	s.Set(ctx, "b", []byte("b"), 0)
+	s.Get(ctx, "a")
+	s.Set(ctx, "c", []byte("c"), 0)
+
+	if _, ok, _ := s.Get(ctx, "b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := s.Get(ctx, key); !ok {
+			t.Errorf("entry %q was evicted", key)
+		}
+	}
+}
+
+func TestMemoryStorePeriodicSweep(t *testing.T) {
+	ctx := context.Background()
+	s := httpx.NewMemoryStore(0)
+
+	for i := 0; i < 10; i++ {
+		s.Set(ctx, "expiring"+strconv.Itoa(i), []byte("v"), time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	const n = 1024
+	for i := 0; i < n; i++ {
+		s.Set(ctx, strconv.Itoa(i), []byte("v"), 0)
+	}
+	if got := s.Len(); got > n {
+		t.Errorf("Len == %d, want at most %d: expired entries were not swept", got, n)
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// summaryRecord is the stable JSON schema of a Summary. Durations are
+// recorded in nanoseconds.
+type summaryRecord struct {
+	Status          int               `json:"status"`
+	Duration        int64             `json:"duration_ns"`
+	QueueDelay      int64             `json:"queue_delay_ns,omitempty"`
+	TimeToFirstByte int64             `json:"ttfb_ns,omitempty"`
+	WriteDuration   int64             `json:"write_duration_ns,omitempty"`
+	Written         int64             `json:"written"`
+	BytesRead       int64             `json:"read"`
+	Proto           string            `json:"proto,omitempty"`
+	TLSVersion      uint16            `json:"tls_version,omitempty"`
+	CipherSuite     uint16            `json:"cipher_suite,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	UpstreamFailure string            `json:"upstream_failure,omitempty"`
+	CancelCause     string            `json:"cancel_cause,omitempty"`
+	Timeline        []markRecord      `json:"timeline,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	ServerTiming    []timingRecord    `json:"server_timing,omitempty"`
+}
+
+type timingRecord struct {
+	Name        string `json:"name"`
+	Duration    int64  `json:"duration_ns"`
+	Description string `json:"desc,omitempty"`
+}
+
+type markRecord struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset_ns"`
+}
+
+func (s Summary) record() summaryRecord {
+	r := summaryRecord{
+		Status:          s.Status,
+		Duration:        int64(s.Duration),
+		QueueDelay:      int64(s.QueueDelay),
+		TimeToFirstByte: int64(s.TimeToFirstByte),
+		WriteDuration:   int64(s.WriteDuration),
+		Written:         s.Written,
+		BytesRead:       s.BytesRead,
+		Proto:           s.Proto,
+		TLSVersion:      s.TLSVersion,
+		CipherSuite:     s.CipherSuite,
+		Error:           errorString(s.Err),
+		UpstreamFailure: string(s.UpstreamFailure),
+		CancelCause:     errorString(s.CancelCause),
+	}
+	for _, m := range s.Timeline {
+		r.Timeline = append(r.Timeline, markRecord{Name: m.Name, Offset: int64(m.Offset)})
+	}
+	for _, m := range s.ServerTiming {
+		r.ServerTiming = append(r.ServerTiming, timingRecord{Name: m.Name, Duration: int64(m.Duration), Description: m.Description})
+	}
+	if len(s.Annotations) > 0 {
+		r.Annotations = make(map[string]string, len(s.Annotations))
+		for k, v := range s.Annotations {
+			r.Annotations[k] = fmt.Sprint(plainValue(v))
+		}
+	}
+	return r
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// MarshalJSON implements json.Marshaler. The schema is stable: keys are
+// only ever added. Durations are recorded in nanoseconds, under keys with
+// the "_ns" suffix, errors as their messages, and annotations as strings.
+// Unlike KV, the error is recorded regardless of the status.
+func (s Summary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.record())
+}
+
+// MarshalLogfmt encodes the keys of s.KV as a logfmt line, sorted by key,
+// without a trailing newline.
+func (s Summary) MarshalLogfmt() ([]byte, error) {
+	kv := s.KV()
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(logfmtValue(plainValue(kv[k])))
+	}
+	return []byte(sb.String()), nil
+}
+
+// MarshalProto encodes s in the protocol buffers wire format, according
+// to the following schema, which is stable: fields are only ever added.
+//
+//	message Summary {
+//	  int32 status = 1;
+//	  int64 duration_ns = 2;
+//	  int64 queue_delay_ns = 3;
+//	  int64 ttfb_ns = 4;
+//	  int64 write_duration_ns = 5;
+//	  int64 written = 6;
+//	  int64 read = 7;
+//	  string proto = 8;
+//	  uint32 tls_version = 9;
+//	  uint32 cipher_suite = 10;
+//	  string error = 11;
+//	  string upstream_failure = 12;
+//	  string cancel_cause = 13;
+//	  repeated Mark timeline = 14;
+//	  map<string, string> annotations = 15;
+//	  repeated Timing server_timing = 16;
+//	}
+//
+//	message Mark {
+//	  string name = 1;
+//	  int64 offset_ns = 2;
+//	}
+//
+//	message Timing {
+//	  string name = 1;
+//	  int64 duration_ns = 2;
+//	  string desc = 3;
+//	}
+func (s Summary) MarshalProto() ([]byte, error) {
+	r := s.record()
+	var b []byte
+	b = protoVarint(b, 1, uint64(r.Status))
+	b = protoVarint(b, 2, uint64(r.Duration))
+	b = protoVarint(b, 3, uint64(r.QueueDelay))
+	b = protoVarint(b, 4, uint64(r.TimeToFirstByte))
+	b = protoVarint(b, 5, uint64(r.WriteDuration))
+	b = protoVarint(b, 6, uint64(r.Written))
+	b = protoVarint(b, 7, uint64(r.BytesRead))
+	b = protoString(b, 8, r.Proto)
+	b = protoVarint(b, 9, uint64(r.TLSVersion))
+	b = protoVarint(b, 10, uint64(r.CipherSuite))
+	b = protoString(b, 11, r.Error)
+	b = protoString(b, 12, r.UpstreamFailure)
+	b = protoString(b, 13, r.CancelCause)
+	for _, m := range r.Timeline {
+		var mb []byte
+		mb = protoString(mb, 1, m.Name)
+		mb = protoVarint(mb, 2, uint64(m.Offset))
+		b = protoBytes(b, 14, mb)
+	}
+	keys := make([]string, 0, len(r.Annotations))
+	for k := range r.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var eb []byte
+		eb = protoString(eb, 1, k)
+		eb = protoString(eb, 2, r.Annotations[k])
+		b = protoBytes(b, 15, eb)
+	}
+	for _, m := range r.ServerTiming {
+		var tb []byte
+		tb = protoString(tb, 1, m.Name)
+		tb = protoVarint(tb, 2, uint64(m.Duration))
+		tb = protoString(tb, 3, m.Description)
+		b = protoBytes(b, 16, tb)
+	}
+	return b, nil
+}
+
+// Protocol buffers wire types.
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+// protoVarint appends a varint field, omitting zero values, as proto3 does.
+func protoVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3|protoWireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func protoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protoBytes(b, field, []byte(s))
+}
+
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|protoWireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// A SummaryEncoding encodes summaries as records of a machine-readable
+// access log.
+type SummaryEncoding int
+
+// Summary encodings.
+const (
+	// SummaryJSON writes newline-delimited JSON records, as encoded
+	// by Summary.MarshalJSON.
+	SummaryJSON SummaryEncoding = iota
+
+	// SummaryLogfmt writes logfmt lines, as encoded by
+	// Summary.MarshalLogfmt.
+	SummaryLogfmt
+
+	// SummaryProto writes length-delimited protocol buffers records,
+	// as encoded by Summary.MarshalProto, each preceded by its length
+	// as a varint.
+	SummaryProto
+)
+
+// SummaryWriter writes one record per request to an io.Writer, for access
+// logs which are processed downstream rather than by a logging library.
+// Records are buffered, and written in their entirety. A SummaryWriter is
+// safe for concurrent use.
+type SummaryWriter struct {
+	enc SummaryEncoding
+
+	mu sync.Mutex
+	w  io.Writer
+	bw *bufio.Writer
+}
+
+// NewSummaryWriter returns a SummaryWriter which writes records encoded
+// using enc to w.
+func NewSummaryWriter(w io.Writer, enc SummaryEncoding) *SummaryWriter {
+	return &SummaryWriter{enc: enc, w: w, bw: bufio.NewWriter(w)}
+}
+
+// Write writes the record for s.
+func (sw *SummaryWriter) Write(s Summary) error {
+	var (
+		rec []byte
+		err error
+	)
+	switch sw.enc {
+	case SummaryJSON:
+		rec, err = s.MarshalJSON()
+		rec = append(rec, '\n')
+	case SummaryLogfmt:
+		rec, err = s.MarshalLogfmt()
+		rec = append(rec, '\n')
+	case SummaryProto:
+		var body []byte
+		body, err = s.MarshalProto()
+		rec = append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+	default:
+		return fmt.Errorf("httpx: unknown summary encoding %d", sw.enc)
+	}
+	if err != nil {
+		return err
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.bw.Available() < len(rec) {
+		if err := sw.bw.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err = sw.bw.Write(rec)
+	return err
+}
+
+// Flush writes any buffered records to the underlying writer.
+func (sw *SummaryWriter) Flush() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.bw.Flush()
+}
+
+// Rotate flushes the buffered records, then directs subsequent records to
+// w, and returns the previous writer, which the caller may close. Rotate
+// is the hook by which log files are rotated.
+func (sw *SummaryWriter) Rotate(w io.Writer) (io.Writer, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if err := sw.bw.Flush(); err != nil {
+		return nil, err
+	}
+	old := sw.w
+	sw.w = w
+	sw.bw.Reset(w)
+	return old, nil
+}
+
+// Handler returns a handler which serves requests using next, and writes
+// a record for each request.
+func (sw *SummaryWriter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw.Write(ServeInstrumented(next, w, WithRequestState(req)))
+	})
+}
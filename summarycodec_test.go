@@ -0,0 +1,184 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+var testSummary = httpx.Summary{
+	Status:       http.StatusBadGateway,
+	Duration:     1500 * time.Microsecond,
+	Written:      12,
+	Proto:        "HTTP/2.0",
+	Err:          errors.New("upstream reset"),
+	Timeline:     httpx.Timeline{{Name: "handler", Offset: time.Millisecond}},
+	Annotations:  map[string]interface{}{"user": "u1", "hits": 3},
+	ServerTiming: httpx.ServerTimings{{Name: "db", Duration: time.Millisecond}},
+}
+
+func TestSummaryMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(testSummary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"status":      float64(502),
+		"duration_ns": float64(1500000),
+		"written":     float64(12),
+		"read":        float64(0),
+		"proto":       "HTTP/2.0",
+		"error":       "upstream reset",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s == %v, want %v", k, got[k], v)
+		}
+	}
+	if ann, _ := got["annotations"].(map[string]interface{}); ann["hits"] != "3" {
+		t.Errorf("annotations == %v", got["annotations"])
+	}
+	if st, _ := got["server_timing"].([]interface{}); len(st) != 1 {
+		t.Errorf("server_timing == %v", got["server_timing"])
+	}
+}
+
+func TestSummaryMarshalLogfmt(t *testing.T) {
+	b, err := testSummary.MarshalLogfmt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(b)
+	for _, want := range []string{"status=502", "duration=1.5ms", `error="upstream reset"`, "user=u1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("%q does not contain %q", line, want)
+		}
+	}
+}
+
+func TestSummaryMarshalProto(t *testing.T) {
+	b, err := testSummary.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Walk the top-level fields.
+	fields := make(map[uint64][]interface{})
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[tag>>3] = append(fields[tag>>3], v)
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[tag>>3] = append(fields[tag>>3], string(b[:l]))
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type in tag %#x", tag)
+		}
+	}
+	tests := []struct {
+		field uint64
+		want  interface{}
+	}{
+		{1, uint64(502)},
+		{2, uint64(1500000)},
+		{6, uint64(12)},
+		{8, "HTTP/2.0"},
+		{11, "upstream reset"},
+	}
+	for _, tt := range tests {
+		if got := fields[tt.field]; len(got) != 1 || got[0] != tt.want {
+			t.Errorf("field %d == %v, want %v", tt.field, got, tt.want)
+		}
+	}
+	if len(fields[16]) != 1 {
+		t.Errorf("got %d server timing fields, want 1", len(fields[16]))
+	}
+	if len(fields[14]) != 1 || len(fields[15]) != 2 {
+		t.Errorf("got %d marks, %d annotations, want 1, 2", len(fields[14]), len(fields[15]))
+	}
+	if _, ok := fields[7]; ok {
+		t.Error("zero field 7 encoded")
+	}
+}
+
+func TestSummaryWriter(t *testing.T) {
+	var first, second bytes.Buffer
+	sw := httpx.NewSummaryWriter(&first, httpx.SummaryJSON)
+	h := sw.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	if first.Len() != 0 {
+		t.Error("record written before Flush")
+	}
+	old, err := sw.Rotate(&second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != &first {
+		t.Error("Rotate did not return the previous writer")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	if err := sw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for name, buf := range map[string]*bytes.Buffer{"first": &first, "second": &second} {
+		if got := strings.Count(buf.String(), "\n"); got != 1 {
+			t.Errorf("%s writer: got %d records, want 1", name, got)
+		}
+		if !strings.Contains(buf.String(), `"status":201`) {
+			t.Errorf("%s writer: got %q", name, buf.String())
+		}
+	}
+}
+
+func TestSummaryWriterProto(t *testing.T) {
+	var buf bytes.Buffer
+	sw := httpx.NewSummaryWriter(&buf, httpx.SummaryProto)
+	for i := 0; i < 2; i++ {
+		if err := sw.Write(testSummary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sw.Flush()
+	want, _ := testSummary.MarshalProto()
+	b := buf.Bytes()
+	for i := 0; i < 2; i++ {
+		l, n := binary.Uvarint(b)
+		if int(l) != len(want) || !bytes.Equal(b[n:n+int(l)], want) {
+			t.Fatalf("record %d does not match MarshalProto", i)
+		}
+		b = b[n+int(l):]
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// A Renderer renders HTML pages from a tree of html/template files, read
+// from a file system such as an embed.FS, or os.DirFS in development.
+//
+// Each page is parsed along with the shared templates, such as layouts
+// and partials, into a template set of its own, such that pages may
+// define the blocks of a layout independently of one another:
+//
+//	r := &httpx.Renderer{
+//		FS:     templates,
+//		Shared: []string{"layouts/*.html", "partials/*.html"},
+//		Pages:  "pages/*.html",
+//		Layout: "base.html",
+//	}
+//
+// The exported fields must not be modified after the first call to Load
+// or Render.
+type Renderer struct {
+	// FS holds the templates.
+	FS fs.FS
+
+	// Shared lists glob patterns, as accepted by fs.Glob, which match
+	// the templates parsed into every page.
+	Shared []string
+
+	// Pages is a glob pattern which matches the pages. Pages are
+	// named by their path in FS, such as "pages/index.html".
+	Pages string
+
+	// Layout is the name of the template executed to render a page,
+	// such as "base.html": as with template.ParseFS, files define
+	// templates named by their base name. If empty, the page itself is
+	// executed.
+	Layout string
+
+	// Funcs are the functions available to templates.
+	Funcs template.FuncMap
+
+	// Data, if not nil, computes per-request values for templates,
+	// such as CSRF tokens, which are available as .Values.
+	Data func(req *http.Request) map[string]interface{}
+
+	// Reload configures the Renderer to parse the templates anew for
+	// each page it renders, such that changes are picked up without a
+	// restart, for development.
+	Reload bool
+
+	mu    sync.Mutex
+	pages map[string]*template.Template
+}
+
+// TemplateData is the data with which pages are executed.
+type TemplateData struct {
+	// RequestID and User are the ID of the request, and the name of
+	// the authenticated user, if any.
+	RequestID string
+	User      string
+
+	// Values holds the values computed by Renderer.Data.
+	Values map[string]interface{}
+
+	// Data is the data passed to Render.
+	Data interface{}
+}
+
+// Load parses all the pages. Calling Load at startup reports template
+// errors early; otherwise, pages are parsed when they are first
+// rendered.
+func (r *Renderer) Load() error {
+	pages, err := r.parse()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.pages = pages
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Renderer) parse() (map[string]*template.Template, error) {
+	names, err := fs.Glob(r.FS, r.Pages)
+	if err != nil {
+		return nil, err
+	}
+	var shared []string
+	for _, pattern := range r.Shared {
+		matches, err := fs.Glob(r.FS, pattern)
+		if err != nil {
+			return nil, err
+		}
+		shared = append(shared, matches...)
+	}
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		t := template.New(path.Base(name)).Funcs(r.Funcs)
+		if len(shared) > 0 {
+			if t, err = t.ParseFS(r.FS, shared...); err != nil {
+				return nil, err
+			}
+		}
+		if t, err = t.ParseFS(r.FS, name); err != nil {
+			return nil, err
+		}
+		pages[name] = t
+	}
+	return pages, nil
+}
+
+func (r *Renderer) page(name string) (*template.Template, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pages == nil || r.Reload {
+		pages, err := r.parse()
+		if err != nil {
+			return nil, err
+		}
+		r.pages = pages
+	}
+	t, ok := r.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("httpx: no page %q", name)
+	}
+	return t, nil
+}
+
+// Render renders the named page as the response to req, with the
+// specified status, passing data to the templates as TemplateData.Data.
+// The page is rendered into a buffer before anything is written. If
+// rendering fails, Render responds with 500 Internal Server Error
+// instead, records the error using SetError, and returns it. Errors
+// writing the page are returned as they are.
+func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, status int, name string, data interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := r.execute(buf, req, name, data); err != nil {
+		SetError(req, err)
+		writeProblem(w, http.StatusInternalServerError, "")
+		return err
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// execute executes the named page into buf.
+func (r *Renderer) execute(buf *bytes.Buffer, req *http.Request, name string, data interface{}) error {
+	t, err := r.page(name)
+	if err != nil {
+		return err
+	}
+	td := TemplateData{
+		RequestID: RequestID(req),
+		User:      User(req),
+		Data:      data,
+	}
+	if r.Data != nil {
+		td.Values = r.Data(req)
+	}
+	if r.Layout != "" {
+		return t.ExecuteTemplate(buf, r.Layout, td)
+	}
+	return t.Execute(buf, td)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"acln.ro/httpx"
+)
+
+func TestRenderer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html":  {Data: []byte(`<title>{{block "title" .}}default{{end}}</title>{{template "content" .}}{{template "footer" .}}`)},
+		"partials/foot.html": {Data: []byte(`{{define "footer"}}<p>{{.RequestID}} {{index .Values "csrf"}}</p>{{end}}`)},
+		"pages/index.html":   {Data: []byte(`{{define "content"}}<h1>{{shout .Data}}</h1>{{end}}`)},
+		"pages/about.html":   {Data: []byte(`{{define "title"}}About{{end}}{{define "content"}}{{.User}}{{end}}`)},
+		"pages/broken.html":  {Data: []byte(`{{define "content"}}{{.Data.Missing}}{{end}}`)},
+	}
+	r := &httpx.Renderer{
+		FS:     fsys,
+		Shared: []string{"layouts/*.html", "partials/*.html"},
+		Pages:  "pages/*.html",
+		Layout: "base.html",
+		Funcs:  template.FuncMap{"shout": strings.ToUpper},
+		Data: func(*http.Request) map[string]interface{} {
+			return map[string]interface{}{"csrf": "tok"}
+		},
+	}
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		page   string
+		data   interface{}
+		status int
+		body   string
+	}{
+		{"pages/index.html", "<hi>", http.StatusOK, `<title>default</title><h1>&lt;HI&gt;</h1><p>r1 tok</p>`},
+		{"pages/about.html", nil, http.StatusOK, `<title>About</title>alice<p>r1 tok</p>`},
+		{"pages/broken.html", 42, http.StatusInternalServerError, ""},
+		{"pages/missing.html", nil, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		req := httpx.WithUser(httpx.WithRequestID(httptest.NewRequest("GET", "/", nil), "r1"), "alice")
+		rec := httptest.NewRecorder()
+		err := r.Render(rec, req, http.StatusOK, tt.page, tt.data)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status == %d, want %d (error %v)", tt.page, rec.Code, tt.status, err)
+		}
+		if tt.status != http.StatusOK {
+			if err == nil {
+				t.Errorf("%s: no error", tt.page)
+			}
+			continue
+		}
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("%s: body == %q, want %q", tt.page, got, tt.body)
+		}
+	}
+}
+
+func TestRendererReload(t *testing.T) {
+	fsys := fstest.MapFS{"page.html": {Data: []byte("v1")}}
+	r := &httpx.Renderer{FS: fsys, Pages: "*.html", Reload: true}
+	render := func() string {
+		rec := httptest.NewRecorder()
+		r.Render(rec, httptest.NewRequest("GET", "/", nil), http.StatusOK, "page.html", nil)
+		return rec.Body.String()
+	}
+	if got := render(); got != "v1" {
+		t.Fatalf("body == %q, want %q", got, "v1")
+	}
+	fsys["page.html"] = &fstest.MapFile{Data: []byte("v2")}
+	if got := render(); got != "v2" {
+		t.Errorf("after change: body == %q, want %q", got, "v2")
+	}
+}
+
+// failingWriter is a ResponseWriter whose Write method fails, and which
+// records the status codes written.
+type failingWriter struct {
+	header http.Header
+	codes  []int
+}
+
+func (fw *failingWriter) Header() http.Header { return fw.header }
+
+func (fw *failingWriter) WriteHeader(code int) { fw.codes = append(fw.codes, code) }
+
+func (fw *failingWriter) Write([]byte) (int, error) { return 0, errWriteFailed }
+
+var errWriteFailed = errors.New("connection reset")
+
+func TestRendererWriteError(t *testing.T) {
+	fsys := fstest.MapFS{"page.html": {Data: []byte("hello")}}
+	r := &httpx.Renderer{FS: fsys, Pages: "*.html"}
+	fw := &failingWriter{header: make(http.Header)}
+	err := r.Render(fw, httptest.NewRequest("GET", "/", nil), http.StatusOK, "page.html", nil)
+	if !errors.Is(err, errWriteFailed) {
+		t.Errorf("got error %v, want %v", err, errWriteFailed)
+	}
+	if len(fw.codes) != 1 || fw.codes[0] != http.StatusOK {
+		t.Errorf("status codes written == %v, want [200]", fw.codes)
+	}
+	if got, want := fw.header.Get("Content-Type"), "text/html; charset=utf-8"; got != want {
+		t.Errorf("Content-Type == %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Mark is a named point in the timeline of a request.
+type Mark struct {
+	// Name names the phase which begins or ends at the mark, e.g.
+	// "auth", or "first_byte".
+	Name string
+
+	// Offset is the time elapsed between the installation of the request
+	// state, typically at the entry of the middleware chain, and the mark.
+	Offset time.Duration
+}
+
+// Timeline is the sequence of marks recorded for a request, in
+// chronological order.
+type Timeline []Mark
+
+// String formats the timeline as "name=offset" pairs separated by spaces.
+func (tl Timeline) String() string {
+	parts := make([]string, len(tl))
+	for i, m := range tl {
+		parts[i] = m.Name + "=" + m.Offset.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// AddMark records a named mark in the timeline of req, at the current
+// time. Middleware contribute marks to make the phases of request
+// handling visible in the Summary. ServeInstrumented records the
+// "handler", "first_byte" and "last_byte" marks itself.
+//
+// If req carries no request state, AddMark is a no-op.
+func AddMark(req *http.Request, name string) {
+	addMarkAt(req, name, time.Now())
+}
+
+func addMarkAt(req *http.Request, name string, t time.Time) {
+	st := stateOf(req)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	st.marks = append(st.marks, Mark{Name: name, Offset: t.Sub(st.start)})
+	st.mu.Unlock()
+}
+
+// RequestTimeline returns the marks recorded for req so far.
+func RequestTimeline(req *http.Request) Timeline {
+	st := stateOf(req)
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	tl := append(Timeline(nil), st.marks...)
+	st.mu.Unlock()
+	sort.SliceStable(tl, func(i, j int) bool {
+		return tl[i].Offset < tl[j].Offset
+	})
+	return tl
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestTimeline(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpx.AddMark(req, "auth")
+		io.WriteString(w, "hello")
+	})
+	req := httpx.WithRequestState(httptest.NewRequest("GET", "/", nil))
+	httpx.AddMark(req, "entry")
+	s := httpx.ServeInstrumented(h, httptest.NewRecorder(), req)
+
+	want := []string{"entry", "handler", "auth", "first_byte", "last_byte"}
+	if len(s.Timeline) != len(want) {
+		t.Fatalf("got timeline %v, want marks %v", s.Timeline, want)
+	}
+	for i, name := range want {
+		if s.Timeline[i].Name != name {
+			t.Errorf("mark %d: got %q, want %q", i, s.Timeline[i].Name, name)
+		}
+		if i > 0 && s.Timeline[i].Offset < s.Timeline[i-1].Offset {
+			t.Errorf("mark %q precedes mark %q", s.Timeline[i].Name, s.Timeline[i-1].Name)
+		}
+	}
+	if _, ok := s.KV()["timeline"]; !ok {
+		t.Error("timeline missing from KV")
+	}
+}
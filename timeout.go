@@ -0,0 +1,256 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// ErrTimeout is the cancellation cause of requests which exceed the time
+// limit set by Timeout.
+var ErrTimeout = errors.New("httpx: request timed out")
+
+// A TimeoutOption configures Timeout.
+type TimeoutOption func(*timeoutConfig)
+
+type timeoutConfig struct {
+	status   int
+	response http.Handler
+}
+
+// TimeoutStatus configures the status of the default timeout response,
+// typically 503 Service Unavailable, the default, or 504 Gateway Timeout,
+// for handlers which wait on upstreams.
+func TimeoutStatus(code int) TimeoutOption {
+	return func(cfg *timeoutConfig) {
+		cfg.status = code
+	}
+}
+
+// TimeoutResponse configures the handler which writes the timeout
+// response. It must not read the request body. By default, a problem
+// details object with the status configured by TimeoutStatus is written.
+func TimeoutResponse(h http.Handler) TimeoutOption {
+	return func(cfg *timeoutConfig) {
+		cfg.response = h
+	}
+}
+
+// Timeout returns a handler which serves requests using next, with a time
+// limit of d. When the time limit is exceeded, the timeout response is
+// written and flushed, unless next has written something already, and
+// writes by next fail with http.ErrHandlerTimeout from then on. Then, the
+// request context is canceled with ErrTimeout, and the cause is recorded
+// as if by CancelRequest.
+//
+// Unlike http.TimeoutHandler, Timeout does not buffer the response, and
+// the ResponseWriter passed to next implements the same optional
+// interfaces as the original, including http.Flusher and http.Hijacker.
+// next runs on the goroutine serving the request, so Timeout returns only
+// once next has returned: next must observe the cancellation of the
+// request context. Since the timeout response is written through the
+// middleware chain, outer middleware, such as AccessLog, record it
+// accurately.
+//
+// Until it writes the response header, next modifies a private copy of
+// the header map, which is copied to the original when the header is
+// written, as with http.TimeoutHandler, such that it does not race with
+// the timeout response.
+func Timeout(next http.Handler, d time.Duration, opts ...TimeoutOption) http.Handler {
+	cfg := &timeoutConfig{status: http.StatusServiceUnavailable}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.response == nil {
+		cfg.response = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeProblem(w, cfg.status, "the request timed out")
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithRequestState(req)
+		// The context is canceled by the timer, rather than by a context
+		// deadline, so that the timeout response is written before next
+		// can observe the cancellation.
+		parent := req.Context()
+		cctx, cancel := context.WithCancelCause(parent)
+		defer cancel(nil)
+		ctx := &deadlineContext{Context: cctx, deadline: time.Now().Add(d)}
+		req = req.WithContext(ctx)
+
+		tw := &timeoutWriter{w: w, h: w.Header().Clone()}
+		timer := time.AfterFunc(d, func() {
+			tw.timeout(func() {
+				cfg.response.ServeHTTP(w, req)
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+			})
+			cancel(ErrTimeout)
+			CancelRequest(req, ErrTimeout)
+		})
+		defer func() {
+			timer.Stop()
+			tw.finish()
+		}()
+		next.ServeHTTP(tw.wrap(), req)
+	})
+}
+
+// deadlineContext reports the time limit set by Timeout as its deadline,
+// unless its parent has an earlier one.
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (ctx *deadlineContext) Deadline() (time.Time, bool) {
+	if d, ok := ctx.Context.Deadline(); ok && d.Before(ctx.deadline) {
+		return d, true
+	}
+	return ctx.deadline, true
+}
+
+// timeoutWriter serializes the writes of the handler and of the timeout
+// response.
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header // used by the handler until committed
+
+	mu        sync.Mutex
+	wrote     bool
+	committed bool
+	hijacked  bool
+	timedOut  bool
+	done      bool
+}
+
+// timeout marks the request as timed out, and calls respond if nothing
+// was written yet.
+func (tw *timeoutWriter) timeout(respond func()) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.done {
+		return
+	}
+	tw.timedOut = true
+	if !tw.wrote && !tw.hijacked {
+		tw.wrote = true
+		respond()
+	}
+}
+
+// finish prevents the timeout response from being written once the
+// handler has returned, and waits for one being written to complete.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	tw.done = true
+	tw.mu.Unlock()
+}
+
+// commit copies the private header map of the handler to the original,
+// the first time the handler writes, such that headers it sets from then
+// on, such as trailers, are set on the original directly. tw.mu must be
+// held, and the timeout must not have fired.
+func (tw *timeoutWriter) commit() {
+	tw.wrote = true
+	if tw.committed {
+		return
+	}
+	tw.committed = true
+	dst := tw.w.Header()
+	clear(dst)
+	copyHeader(dst, tw.h)
+}
+
+func (tw *timeoutWriter) wrap() http.ResponseWriter {
+	return httpsnoop.Wrap(tw.w, httpsnoop.Hooks{
+		Header: func(next httpsnoop.HeaderFunc) httpsnoop.HeaderFunc {
+			return func() http.Header {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.committed {
+					return next()
+				}
+				return tw.h
+			}
+		},
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.timedOut {
+					return
+				}
+				tw.commit()
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.timedOut {
+					return 0, http.ErrHandlerTimeout
+				}
+				tw.commit()
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.timedOut {
+					return 0, http.ErrHandlerTimeout
+				}
+				tw.commit()
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if !tw.timedOut {
+					tw.commit()
+					next()
+				}
+			}
+		},
+		Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.timedOut {
+					return nil, nil, http.ErrHandlerTimeout
+				}
+				conn, rw, err := next()
+				if err == nil {
+					tw.hijacked = true
+				}
+				return conn, rw, err
+			}
+		},
+	})
+}
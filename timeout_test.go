@@ -0,0 +1,138 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestTimeout(t *testing.T) {
+	writeErr := make(chan error, 1)
+	h := httpx.Timeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		_, err := w.Write([]byte("late"))
+		writeErr <- err
+	}), 10*time.Millisecond, httpx.TimeoutStatus(http.StatusGatewayTimeout))
+
+	rec := httptest.NewRecorder()
+	s := httpx.ServeInstrumented(h, rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusGatewayTimeout || s.Status != http.StatusGatewayTimeout {
+		t.Errorf("got status %d, summary status %d, want %d", rec.Code, s.Status, http.StatusGatewayTimeout)
+	}
+	if !rec.Flushed {
+		t.Error("timeout response not flushed")
+	}
+	if err := <-writeErr; err != http.ErrHandlerTimeout {
+		t.Errorf("late Write returned %v, want http.ErrHandlerTimeout", err)
+	}
+	if s.CancelCause != httpx.ErrTimeout {
+		t.Errorf("CancelCause == %v, want ErrTimeout", s.CancelCause)
+	}
+}
+
+func TestTimeoutStreaming(t *testing.T) {
+	h := httpx.Timeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if deadline, ok := req.Context().Deadline(); !ok || time.Until(deadline) > time.Second {
+			t.Errorf("request context has no deadline within the time limit")
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+		if cause := context.Cause(req.Context()); cause != httpx.ErrTimeout {
+			t.Errorf("context.Cause == %v, want ErrTimeout", cause)
+		}
+	}), 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+}
+
+func TestTimeoutFast(t *testing.T) {
+	h := httpx.Timeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}), time.Minute)
+	rec := httptest.NewRecorder()
+	s := httpx.ServeInstrumented(h, rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" || s.CancelCause != nil {
+		t.Errorf("got %d %q, cause %v, want 200 ok and no cause", rec.Code, rec.Body.String(), s.CancelCause)
+	}
+}
+
+func TestTimeoutHeaderRace(t *testing.T) {
+	// Run with -race: the handler sets headers across the deadline,
+	// while the timeout response is written.
+	h := httpx.Timeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i := 0; req.Context().Err() == nil; i++ {
+			w.Header().Set("X-Progress", strconv.Itoa(i))
+		}
+		w.Header().Set("X-Late", "1")
+	}), 5*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	for _, name := range []string{"X-Progress", "X-Late"} {
+		if v := rec.Header().Get(name); v != "" {
+			t.Errorf("%s == %q in the timeout response, want none", name, v)
+		}
+	}
+}
+
+func TestTimeoutHeaderCommit(t *testing.T) {
+	h := httpx.Timeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if got := w.Header().Get("X-Outer"); got != "1" {
+			t.Errorf("X-Outer == %q, want %q", got, "1")
+		}
+		w.Header().Del("X-Outer")
+		w.Header().Set("X-Inner", "1")
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(http.StatusAccepted)
+		w.Header().Set("X-Checksum", "abc")
+	}), time.Second)
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Outer", "1")
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status == %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	res := rec.Result()
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"X-Outer", res.Header.Get("X-Outer"), ""},
+		{"X-Inner", res.Header.Get("X-Inner"), "1"},
+		{"trailer X-Checksum", res.Trailer.Get("X-Checksum"), "abc"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s == %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TimingMetric is a named duration measured while serving a request, as
+// reported by the Server-Timing header.
+type TimingMetric struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// ServerTimings is the list of metrics recorded for a request.
+type ServerTimings []TimingMetric
+
+// String formats the metrics as the value of a Server-Timing header, e.g.
+// `db;dur=12.5, cache;dur=0.3;desc="miss"`. Durations are in milliseconds.
+func (ts ServerTimings) String() string {
+	parts := make([]string, len(ts))
+	for i, m := range ts {
+		s := timingToken(m.Name) + ";dur=" + strconv.FormatFloat(float64(m.Duration)/float64(time.Millisecond), 'f', -1, 64)
+		if m.Description != "" {
+			s += ";desc=" + strconv.Quote(m.Description)
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, ", ")
+}
+
+// timingToken replaces the characters which are not allowed in a
+// Server-Timing metric name with underscores.
+func timingToken(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Timings accumulates the timing metrics of a request. It is safe for
+// concurrent use.
+type Timings struct {
+	mu      sync.Mutex
+	metrics ServerTimings
+}
+
+// Timing returns the timing metrics of req. The metrics are shared by all
+// handlers and middleware serving the request, and are reported by
+// ServerTiming, and in the Summary.
+//
+// If req carries no container installed by WithRequestState, Timing
+// returns new, empty metrics, which are not shared.
+func Timing(req *http.Request) *Timings {
+	st := stateOf(req)
+	if st == nil {
+		return new(Timings)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.timings == nil {
+		st.timings = new(Timings)
+	}
+	return st.timings
+}
+
+// Add adds d to the metric called name. Durations added to the same
+// metric accumulate, so that, for example, all database queries are
+// reported under "db". If desc is not empty, it replaces the description
+// of the metric.
+func (t *Timings) Add(name string, d time.Duration, desc string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.metrics {
+		if m := &t.metrics[i]; m.Name == name {
+			m.Duration += d
+			if desc != "" {
+				m.Description = desc
+			}
+			return
+		}
+	}
+	t.metrics = append(t.metrics, TimingMetric{Name: name, Duration: d, Description: desc})
+}
+
+// Start starts measuring the metric called name. The measurement is added
+// when Stop is called on the returned TimingSpan.
+func (t *Timings) Start(name string) *TimingSpan {
+	return &TimingSpan{t: t, name: name, start: time.Now()}
+}
+
+// Metrics returns the metrics recorded so far, in the order in which
+// they were first added.
+func (t *Timings) Metrics() ServerTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(ServerTimings(nil), t.metrics...)
+}
+
+// A TimingSpan measures a single duration of a timing metric.
+type TimingSpan struct {
+	t     *Timings
+	name  string
+	start time.Time
+	once  sync.Once
+	d     time.Duration
+}
+
+// Stop adds the time elapsed since the span was started to its metric,
+// and returns it. Only the first call to Stop has an effect: subsequent
+// calls return the same duration.
+func (sp *TimingSpan) Stop() time.Duration {
+	sp.once.Do(func() {
+		sp.d = time.Since(sp.start)
+		sp.t.Add(sp.name, sp.d, "")
+	})
+	return sp.d
+}
+
+// requestTimings returns the timing metrics of req, if it carries request
+// state, or nil.
+func requestTimings(req *http.Request) ServerTimings {
+	st := stateOf(req)
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	t := st.timings
+	st.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	return t.Metrics()
+}
+
+// ServerTiming returns a handler which serves requests using next, and
+// writes the metrics recorded using Timing into the Server-Timing header
+// of the response, along with the "total" metric, which measures the time
+// elapsed until the response header was written. Metrics recorded after
+// the header was written are not reported in the header.
+//
+// Server-Timing reveals details about the internals of the service, so it
+// is typically enabled for trusted clients, or outside of production.
+func ServerTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = WithRequestState(req)
+		start := time.Now()
+		ww, finish := beforeWrite(w, func() {
+			ts := append(requestTimings(req), TimingMetric{Name: "total", Duration: time.Since(start)})
+			w.Header().Add("Server-Timing", ts.String())
+		})
+		next.ServeHTTP(ww, req)
+		finish()
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestServerTimingsString(t *testing.T) {
+	ts := httpx.ServerTimings{
+		{Name: "db", Duration: 12500 * time.Microsecond},
+		{Name: "cache lookup", Duration: 300 * time.Microsecond, Description: `miss "cold"`},
+	}
+	want := `db;dur=12.5, cache_lookup;dur=0.3;desc="miss \"cold\""`
+	if got := ts.String(); got != want {
+		t.Errorf("String() == %q, want %q", got, want)
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	h := httpx.ServerTiming(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		timing := httpx.Timing(req)
+		sp := timing.Start("db")
+		sp.Stop()
+		sp.Stop()
+		timing.Add("db", time.Millisecond, "")
+		timing.Add("cache", 2*time.Millisecond, "hit")
+		w.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	s := httpx.ServeInstrumented(h, rec, httptest.NewRequest("GET", "/", nil))
+
+	header := rec.Header().Get("Server-Timing")
+	for _, want := range []string{"db;dur=", `cache;dur=2;desc="hit"`, "total;dur="} {
+		if !strings.Contains(header, want) {
+			t.Errorf("Server-Timing == %q, does not contain %q", header, want)
+		}
+	}
+	if len(s.ServerTiming) != 2 {
+		t.Fatalf("got %d metrics in the summary, want 2", len(s.ServerTiming))
+	}
+	if db := s.ServerTiming[0]; db.Name != "db" || db.Duration < time.Millisecond {
+		t.Errorf("got %+v, want db accumulating at least 1ms", db)
+	}
+	if got := s.KV()["server_timing"]; got != s.ServerTiming.String() {
+		t.Errorf("KV()[%q] == %v, want %q", "server_timing", got, s.ServerTiming.String())
+	}
+}
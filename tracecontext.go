@@ -0,0 +1,174 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TraceContext is a W3C Trace Context, as carried by the traceparent and
+// tracestate headers.
+type TraceContext struct {
+	// TraceID is the trace identifier, as 32 lowercase hex digits.
+	TraceID string
+
+	// SpanID identifies the current span, as 16 lowercase hex digits.
+	SpanID string
+
+	// ParentID identifies the parent span, if any.
+	ParentID string
+
+	// Flags holds the trace flags.
+	Flags byte
+
+	// State is the value of the tracestate header, if any.
+	State string
+}
+
+// Sampled reports whether the sampled flag is set.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&0x01 != 0
+}
+
+// Traceparent returns the traceparent header value identifying the
+// current span.
+func (tc TraceContext) Traceparent() string {
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Child returns a trace context for a new span, whose parent is the
+// current span. Child is used to derive the context for outgoing requests.
+func (tc TraceContext) Child() TraceContext {
+	tc.ParentID = tc.SpanID
+	tc.SpanID = NewSpanID()
+	return tc
+}
+
+// Inject sets the traceparent and tracestate headers in h.
+func (tc TraceContext) Inject(h http.Header) {
+	h.Set("Traceparent", tc.Traceparent())
+	if tc.State != "" {
+		h.Set("Tracestate", tc.State)
+	} else {
+		h.Del("Tracestate")
+	}
+}
+
+var errBadTraceparent = errors.New("httpx: malformed traceparent")
+
+// ParseTraceparent parses a traceparent header value. The span identified
+// by the header becomes the ParentID of the returned TraceContext. The
+// SpanID of the returned TraceContext is empty.
+func ParseTraceparent(s string) (TraceContext, error) {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 {
+		return TraceContext{}, errBadTraceparent
+	}
+	version := s[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return TraceContext{}, errBadTraceparent
+	}
+	if version == "00" && len(s) != 55 {
+		return TraceContext{}, errBadTraceparent
+	}
+	if len(s) > 55 && s[55] != '-' {
+		return TraceContext{}, errBadTraceparent
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return TraceContext{}, errBadTraceparent
+	}
+	traceID, parentID, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(parentID) || !isLowerHex(flags) {
+		return TraceContext{}, errBadTraceparent
+	}
+	if isZeroHex(traceID) || isZeroHex(parentID) {
+		return TraceContext{}, errBadTraceparent
+	}
+	b, _ := hex.DecodeString(flags)
+	return TraceContext{
+		TraceID:  traceID,
+		ParentID: parentID,
+		Flags:    b[0],
+	}, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroHex(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+// NewTraceID returns a new random trace identifier.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID returns a new random span identifier.
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("httpx: crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithTraceContext stores tc in the context associated with req, and
+// returns the new *http.Request, with the updated context.
+func WithTraceContext(req *http.Request, tc TraceContext) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), traceKey, tc))
+}
+
+// Trace returns the trace context associated with req, if any.
+func Trace(req *http.Request) (TraceContext, bool) {
+	tc, ok := req.Context().Value(traceKey).(TraceContext)
+	return tc, ok
+}
+
+// TraceContextHandler returns a handler which parses the traceparent and
+// tracestate headers, stores the resulting trace context using
+// WithTraceContext, then calls next.
+//
+// Each request is assigned a new span, whose parent is the span identified
+// by the inbound traceparent header. If the header is absent or malformed,
+// a new trace is started.
+func TraceContextHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tc, err := ParseTraceparent(req.Header.Get("Traceparent"))
+		if err == nil {
+			tc.State = req.Header.Get("Tracestate")
+		} else {
+			tc = TraceContext{TraceID: NewTraceID()}
+		}
+		tc.SpanID = NewSpanID()
+		next.ServeHTTP(w, WithTraceContext(req, tc))
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		tc, err := httpx.ParseTraceparent(tt.in)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("ParseTraceparent(%q): err == %v, want ok == %t", tt.in, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("ParseTraceparent(%q): TraceID == %q", tt.in, tc.TraceID)
+		}
+		if tc.ParentID != "00f067aa0ba902b7" {
+			t.Errorf("ParseTraceparent(%q): ParentID == %q", tt.in, tc.ParentID)
+		}
+		if !tc.Sampled() {
+			t.Errorf("ParseTraceparent(%q): not sampled", tt.in)
+		}
+	}
+}
+
+func TestTraceContextHandler(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var tc httpx.TraceContext
+	h := httpx.TraceContextHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ok bool
+		tc, ok = httpx.Trace(req)
+		if !ok {
+			t.Fatal("no trace context")
+		}
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Traceparent", parent)
+	req.Header.Set("Tracestate", "vendor=value")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID == %q", tc.TraceID)
+	}
+	if tc.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("ParentID == %q", tc.ParentID)
+	}
+	if len(tc.SpanID) != 16 || tc.SpanID == tc.ParentID {
+		t.Errorf("SpanID == %q, want a new span", tc.SpanID)
+	}
+
+	child := tc.Child()
+	hdr := make(http.Header)
+	child.Inject(hdr)
+	got, err := httpx.ParseTraceparent(hdr.Get("Traceparent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TraceID != tc.TraceID || got.ParentID != child.SpanID {
+		t.Errorf("injected traceparent %q does not identify the child span", hdr.Get("Traceparent"))
+	}
+	if hdr.Get("Tracestate") != "vendor=value" {
+		t.Errorf("Tracestate == %q", hdr.Get("Tracestate"))
+	}
+}
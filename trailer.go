@@ -0,0 +1,88 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// invalidTrailers are the fields which net/http refuses to send as
+// trailers, as per RFC 9110, section 6.5.1.
+var invalidTrailers = map[string]bool{
+	"Authorization":       true,
+	"Cache-Control":       true,
+	"Connection":          true,
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Content-Range":       true,
+	"Content-Type":        true,
+	"Expect":              true,
+	"Host":                true,
+	"Keep-Alive":          true,
+	"Max-Forwards":        true,
+	"Pragma":              true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Range":               true,
+	"Realm":               true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Www-Authenticate":    true,
+}
+
+// DeclareTrailers announces the named trailers in the Trailer header of
+// the response, such that clients and intermediaries expect them. It
+// must be called before the response header is written to take effect,
+// but trailers set by SetTrailer are sent even if they were not
+// declared.
+//
+// Since HTTP/1.1 sends trailers only with the chunked transfer coding,
+// DeclareTrailers removes the Content-Length header, if set. HTTP/1.0
+// clients receive no trailers. DeclareTrailers panics if a name is not
+// allowed in trailers, such as Content-Length or Content-Type, which
+// net/http would otherwise drop silently.
+func DeclareTrailers(w http.ResponseWriter, names ...string) {
+	h := w.Header()
+	declared := make(map[string]bool)
+	for _, v := range h.Values("Trailer") {
+		for _, name := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if invalidTrailers[name] {
+			panic("httpx: " + name + " is not allowed in trailers")
+		}
+		if !declared[name] {
+			declared[name] = true
+			h.Add("Trailer", name)
+		}
+	}
+	h.Del("Content-Length")
+}
+
+// SetTrailer sets the trailer called name to value. It may be called at
+// any point while serving the request, including before the response
+// header is written: the trailer is never sent as part of the header.
+// The last value set before the handler returns is sent. SetTrailer
+// uses http.TrailerPrefix, such that it works regardless of whether
+// name was declared using DeclareTrailers.
+func SetTrailer(w http.ResponseWriter, name, value string) {
+	w.Header().Set(http.TrailerPrefix+http.CanonicalHeaderKey(name), value)
+}
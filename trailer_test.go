@@ -0,0 +1,93 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+func TestTrailers(t *testing.T) {
+	const body = "streamed body"
+	sum := sha256.Sum256([]byte(body))
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "13")
+		httpx.DeclareTrailers(w, "content-digest", "X-Status")
+		httpx.SetTrailer(w, "X-Status", "pending")
+		io.WriteString(w, body)
+		w.(http.Flusher).Flush()
+		httpx.SetTrailer(w, "Content-Digest", digest)
+		httpx.SetTrailer(w, "X-Status", "done")
+		httpx.SetTrailer(w, "X-Undeclared", "yes")
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("X-Status"); got != "" {
+		t.Errorf("X-Status sent as a header: %q", got)
+	}
+	if _, ok := resp.Trailer["Content-Digest"]; !ok {
+		t.Errorf("Content-Digest not declared, got trailers %v", resp.Trailer)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("body == %q, want %q", b, body)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Content-Digest", digest},
+		{"X-Status", "done"},
+		{"X-Undeclared", "yes"},
+	}
+	for _, tt := range tests {
+		if got := resp.Trailer.Get(tt.name); got != tt.want {
+			t.Errorf("trailer %s == %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeclareTrailersInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("DeclareTrailers did not panic for Content-Type")
+		}
+	}()
+	httpx.DeclareTrailers(httptest.NewRecorder(), "content-type")
+}
+
+func TestDeclareTrailersDuplicate(t *testing.T) {
+	rec := httptest.NewRecorder()
+	httpx.DeclareTrailers(rec, "X-A", "x-b")
+	httpx.DeclareTrailers(rec, "x-a")
+	got := rec.Header().Values("Trailer")
+	if len(got) != 2 || got[0] != "X-A" || got[1] != "X-B" {
+		t.Errorf("Trailer == %q, want [X-A X-B]", got)
+	}
+}
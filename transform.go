@@ -0,0 +1,166 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// A Transformer derives a representation from an original one, e.g. by
+// resizing or converting an image. The actual processing, and the library
+// performing it, are left to the implementation.
+type Transformer interface {
+	// Transform reads the original representation of the specified
+	// content type from src, and writes the derived representation to
+	// dst. params holds the query parameters which select the
+	// transformation. Transform returns the content type of the derived
+	// representation.
+	Transform(dst io.Writer, src io.Reader, contentType string, params url.Values) (string, error)
+}
+
+// A Transform registers a Transformer for a set of query parameters, such
+// as "w" and "h", or "format".
+type Transform struct {
+	Params      []string
+	Transformer Transformer
+}
+
+// Transforming serves derived representations of the content served by
+// Handler, typically an http.FileServer or a SignedUpstream.
+//
+// Requests which carry any of the query parameters of the registered
+// transforms are served by fetching the original representation from
+// Handler, without those parameters, and passing it through each of the
+// applicable transformers, in order. Requests without such parameters are
+// passed to Handler unmodified.
+//
+// Derived representations are identified by entity tags derived from the
+// entity tag of the original representation and the transformation
+// parameters, and are cached in Cache, if configured.
+type Transforming struct {
+	Handler    http.Handler
+	Transforms []Transform
+
+	// Cache, if not nil, stores derived representations for TTL.
+	Cache Store
+	TTL   time.Duration
+}
+
+func (t *Transforming) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	params := make(url.Values)
+	for _, tr := range t.Transforms {
+		for _, p := range tr.Params {
+			if v, ok := query[p]; ok {
+				params[p] = v
+			}
+		}
+	}
+	if len(params) == 0 || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		t.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	// Fetch the full original representation.
+	orig := req.Clone(req.Context())
+	for p := range params {
+		query.Del(p)
+	}
+	orig.URL.RawQuery = query.Encode()
+	orig.Method = http.MethodGet
+	for _, k := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		orig.Header.Del(k)
+	}
+	rb := newResponseBuffer()
+	t.Handler.ServeHTTP(rb, orig)
+	if rb.code != http.StatusOK {
+		rb.sendTo(w)
+		return
+	}
+
+	srcTag := rb.header.Get("ETag")
+	if srcTag == "" {
+		sum := sha256.Sum256(rb.body.Bytes())
+		srcTag = hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256([]byte(srcTag + "\x00" + params.Encode()))
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+	w.Header().Set("ETag", etag)
+	if cc := rb.header.Get("Cache-Control"); cc != "" {
+		w.Header().Set("Cache-Control", cc)
+	}
+	if matchETag(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	cacheKey := "transform:" + req.URL.Path + "?" + params.Encode() + "@" + etag
+	if t.Cache != nil {
+		if cached, ok, err := t.Cache.Get(req.Context(), cacheKey); err == nil && ok {
+			if idx := bytes.IndexByte(cached, '\n'); idx != -1 {
+				writeDerived(w, req, string(cached[:idx]), cached[idx+1:])
+				return
+			}
+		}
+	}
+
+	ctype := rb.header.Get("Content-Type")
+	body := rb.body.Bytes()
+	for _, tr := range t.applicable(params) {
+		var out bytes.Buffer
+		var err error
+		ctype, err = tr.Transformer.Transform(&out, bytes.NewReader(body), ctype, params)
+		if err != nil {
+			http.Error(w, "transformation failed: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		body = out.Bytes()
+	}
+	if t.Cache != nil {
+		entry := append([]byte(ctype+"\n"), body...)
+		t.Cache.Set(req.Context(), cacheKey, entry, t.TTL)
+	}
+	writeDerived(w, req, ctype, body)
+}
+
+// applicable returns the transforms selected by params, in order.
+func (t *Transforming) applicable(params url.Values) []Transform {
+	var trs []Transform
+	for _, tr := range t.Transforms {
+		for _, p := range tr.Params {
+			if _, ok := params[p]; ok {
+				trs = append(trs, tr)
+				break
+			}
+		}
+	}
+	return trs
+}
+
+func writeDerived(w http.ResponseWriter, req *http.Request, ctype string, body []byte) {
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
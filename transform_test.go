@@ -0,0 +1,87 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+type upperTransformer struct {
+	calls int
+}
+
+func (ut *upperTransformer) Transform(dst io.Writer, src io.Reader, ctype string, params url.Values) (string, error) {
+	ut.calls++
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+	dst.Write(bytes.ToUpper(b))
+	return "text/x-upper", nil
+}
+
+func TestTransforming(t *testing.T) {
+	files := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("case") != "" {
+			t.Error("transformation parameter passed to the original handler")
+		}
+		w.Header().Set("ETag", `"orig"`)
+		http.ServeContent(w, req, "a.txt", time.Time{}, bytes.NewReader([]byte("hello")))
+	})
+	ut := new(upperTransformer)
+	h := &httpx.Transforming{
+		Handler:    files,
+		Transforms: []httpx.Transform{{Params: []string{"case"}, Transformer: ut}},
+		Cache:      httpx.NewMemoryStore(10),
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/a.txt", nil))
+	if rec.Body.String() != "hello" {
+		t.Fatalf("untransformed body == %q", rec.Body)
+	}
+
+	var etag string
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/a.txt?case=upper", nil))
+		if rec.Body.String() != "HELLO" || rec.Header().Get("Content-Type") != "text/x-upper" {
+			t.Fatalf("transformed response: %q, %q", rec.Header().Get("Content-Type"), rec.Body)
+		}
+		etag = rec.Header().Get("ETag")
+		if etag == "" || etag == `"orig"` {
+			t.Fatalf("derived ETag == %q", etag)
+		}
+	}
+	if ut.calls != 1 {
+		t.Fatalf("transformer called %d times, want 1", ut.calls)
+	}
+
+	req := httptest.NewRequest("GET", "/a.txt?case=upper", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional request: got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// PropagatingTransport returns an http.RoundTripper which sets the
+// X-Request-ID header on outgoing requests to the request identifier
+// stored in their context, if any, and then delegates to base. If base
+// is nil, http.DefaultTransport is used.
+//
+// Outgoing requests whose context derives from an inbound request
+// context therefore carry the same identifier as the inbound request.
+// Requests which set the header explicitly are left unchanged.
+//
+// If a request sets the header explicitly to another identifier, such
+// that the downstream request is distinct from the inbound one, the
+// transport sets the X-Parent-Request-ID header to the identifier
+// stored in the context, unless it is set already. If the context stores
+// an attempt number set by means of ContextWithAttempt, the transport
+// also sets the X-Attempt header.
+func PropagatingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		id := RequestID(req)
+		attempt := AttemptFromContext(req.Context())
+		if id == "" && attempt == 0 {
+			return base.RoundTrip(req)
+		}
+		// RoundTrippers must not modify the request they are given.
+		out := cloneRequest(req)
+		if id != "" {
+			if explicit := out.Header.Get(DefaultRequestIDHeader); explicit == "" {
+				out.Header.Set(DefaultRequestIDHeader, id)
+			} else if explicit != id && out.Header.Get(ParentRequestIDHeader) == "" {
+				out.Header.Set(ParentRequestIDHeader, id)
+			}
+		}
+		if attempt > 0 {
+			out.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+		}
+		return base.RoundTrip(out)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// cloneRequest returns a shallow copy of req, with a deep copy of the
+// header.
+func cloneRequest(req *http.Request) *http.Request {
+	out := new(http.Request)
+	*out = *req
+	out.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		vv := make([]string, len(v))
+		copy(vv, v)
+		out.Header[k] = vv
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is a list of networks from which requests are trusted
+// to carry information set by a frontend proxy, such as request
+// identifiers or forwarding headers.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of networks in CIDR notation. Plain
+// IP addresses are accepted as well, and denote single hosts.
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	tp := make(TrustedProxies, 0, len(cidrs))
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: s}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			tp = append(tp, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		tp = append(tp, ipnet)
+	}
+	return tp, nil
+}
+
+// Contains reports whether ip belongs to one of the trusted networks.
+func (tp TrustedProxies) Contains(ip net.IP) bool {
+	for _, ipnet := range tp {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Trusts reports whether req was received directly from a trusted proxy,
+// as indicated by req.RemoteAddr.
+func (tp TrustedProxies) Trusts(req *http.Request) bool {
+	ip := remoteIP(req.RemoteAddr)
+	return ip != nil && tp.Contains(ip)
+}
+
+// remoteIP parses the IP address from a host:port or plain host string.
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
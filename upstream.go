@@ -0,0 +1,235 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// upstreamRequestHeaders lists the request headers passed to upstreams.
+var upstreamRequestHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// upstreamResponseHeaders lists the response headers passed to clients.
+var upstreamResponseHeaders = []string{
+	"Accept-Ranges",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+}
+
+// SignedUpstream serves files from object storage, or any other upstream
+// which is accessed by means of signed URLs. Each request is exchanged for
+// a signed upstream URL, and the upstream response is streamed to the
+// client without buffering. Range requests are passed through.
+//
+// If the connection to the upstream breaks while streaming the body, and
+// the upstream supports range requests for the representation, the
+// transfer resumes from the last byte received, using a Range request
+// conditional on the entity tag of the representation.
+//
+// Failures are classified and recorded using SetUpstreamFailure.
+type SignedUpstream struct {
+	// Sign exchanges the authorization carried by the request for a signed
+	// upstream URL. If Sign returns an error, the request is rejected with
+	// status 403. If Sign returns an empty URL, the request is rejected
+	// with status 404.
+	Sign func(req *http.Request) (url string, err error)
+
+	// Client makes upstream requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// MaxRetries limits the number of times a broken transfer is resumed.
+	// If zero, the limit is 2. If negative, transfers are not resumed.
+	MaxRetries int
+}
+
+func (su *SignedUpstream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	url, err := su.Sign(req)
+	if err != nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if url == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	ureq, err := http.NewRequestWithContext(req.Context(), req.Method, url, nil)
+	if err != nil {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	for _, k := range upstreamRequestHeaders {
+		if v := req.Header.Get(k); v != "" {
+			ureq.Header.Set(k, v)
+		}
+	}
+	resp, err := su.client().Do(ureq)
+	if err != nil {
+		SetUpstreamFailure(req, ClassifyUpstreamError(req, err))
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		SetUpstreamFailure(req, Upstream5xx)
+	}
+
+	for _, k := range upstreamResponseHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			w.Header().Set(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	cw := &copyWriter{w: w}
+	written, err := io.Copy(cw, resp.Body)
+	retries := su.MaxRetries
+	if retries == 0 {
+		retries = 2
+	}
+	for ; err != nil && cw.err == nil && retries > 0 && isConnBroken(err); retries-- {
+		first, last, ok := resumableRange(resp)
+		if !ok {
+			break
+		}
+		var body io.ReadCloser
+		body, err = su.resume(ureq, resp, first+written, last)
+		if err != nil {
+			break
+		}
+		var n int64
+		n, err = io.Copy(cw, body)
+		body.Close()
+		written += n
+	}
+	switch {
+	case err == nil:
+	case cw.err != nil || req.Context().Err() != nil:
+		SetUpstreamFailure(req, UpstreamClientGone)
+	default:
+		SetUpstreamFailure(req, UpstreamBodyCopy)
+	}
+}
+
+// copyWriter records write errors, which separates clients going away
+// from upstream read errors when copying response bodies.
+type copyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *copyWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if err != nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+func (su *SignedUpstream) client() *http.Client {
+	if su.Client != nil {
+		return su.Client
+	}
+	return http.DefaultClient
+}
+
+// resume requests the bytes [from, last] of the representation served by
+// the original response.
+func (su *SignedUpstream) resume(ureq *http.Request, orig *http.Response, from, last int64) (io.ReadCloser, error) {
+	rreq := ureq.Clone(ureq.Context())
+	rreq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, last))
+	rreq.Header.Set("If-Range", orig.Header.Get("ETag"))
+	rreq.Header.Del("If-None-Match")
+	rreq.Header.Del("If-Modified-Since")
+	resp, err := su.client().Do(rreq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, errors.New("httpx: upstream did not honor resumption range")
+	}
+	return resp.Body, nil
+}
+
+// resumableRange returns the byte range served by resp, if the transfer
+// can be resumed: the upstream must support byte ranges, and identify the
+// representation by a strong entity tag.
+func resumableRange(resp *http.Response) (first, last int64, ok bool) {
+	etag := resp.Header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return 0, 0, false
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength <= 0 {
+			return 0, 0, false
+		}
+		return 0, resp.ContentLength - 1, true
+	case http.StatusPartialContent:
+		// Content-Range: bytes first-last/complete
+		cr := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+		idx := strings.IndexByte(cr, '/')
+		if idx == -1 {
+			return 0, 0, false
+		}
+		bounds := strings.SplitN(cr[:idx], "-", 2)
+		if len(bounds) != 2 {
+			return 0, 0, false
+		}
+		first, err1 := strconv.ParseInt(bounds[0], 10, 64)
+		last, err2 := strconv.ParseInt(bounds[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return 0, 0, false
+		}
+		return first, last, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// isConnBroken reports whether err indicates that the upstream connection
+// broke, as opposed to the client going away.
+func isConnBroken(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/httpx"
+)
+
+func TestSignedUpstream(t *testing.T) {
+	const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+	broken := true
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("sig") != "ok" {
+			http.Error(w, "bad signature", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if broken && req.Header.Get("Range") == "" {
+			// Promise the full content, then break the connection.
+			broken = false
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(content[:10]))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, req, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer upstream.Close()
+
+	su := &httpx.SignedUpstream{
+		Sign: func(req *http.Request) (string, error) {
+			switch req.URL.Path {
+			case "/file":
+				return upstream.URL + "/file?sig=ok", nil
+			case "/denied":
+				return "", errors.New("denied")
+			default:
+				return "", nil
+			}
+		},
+	}
+	srv := httptest.NewServer(su)
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		rng    string
+		status int
+		body   string
+	}{
+		{"/file", "", http.StatusOK, content},
+		{"/file", "bytes=5-9", http.StatusPartialContent, content[5:10]},
+		{"/denied", "", http.StatusForbidden, ""},
+		{"/missing", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+		if tt.rng != "" {
+			req.Header.Set("Range", tt.rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.path, tt.rng, err)
+			continue
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.path, tt.rng, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.body != "" && string(body) != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.path, tt.rng, body, tt.body)
+		}
+	}
+}
+
+func TestSignedUpstreamFailures(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer secure.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		client *http.Client
+		want   httpx.UpstreamFailure
+	}{
+		{name: "5xx", url: failing.URL, want: httpx.Upstream5xx},
+		{name: "dial", url: closed.URL, want: httpx.UpstreamDial},
+		{name: "tls", url: secure.URL, want: httpx.UpstreamTLS},
+		{name: "timeout", url: slow.URL, client: &http.Client{Timeout: 50 * time.Millisecond}, want: httpx.UpstreamTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			su := &httpx.SignedUpstream{
+				Sign:   func(*http.Request) (string, error) { return tt.url, nil },
+				Client: tt.client,
+			}
+			s := httpx.ServeInstrumented(su, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			if s.UpstreamFailure != tt.want {
+				t.Errorf("UpstreamFailure == %q, want %q", s.UpstreamFailure, tt.want)
+			}
+			if got := s.KV()["upstream_failure"]; got != string(tt.want) {
+				t.Errorf("KV upstream_failure == %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
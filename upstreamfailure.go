@@ -0,0 +1,116 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// UpstreamFailure classifies the ways in which a request to an upstream
+// can fail. The values are stable, and suitable for use as metric labels.
+type UpstreamFailure string
+
+// Upstream failure classes.
+const (
+	// UpstreamDial means that the connection to the upstream could not
+	// be established.
+	UpstreamDial UpstreamFailure = "dial"
+
+	// UpstreamTLS means that the TLS handshake with the upstream failed.
+	UpstreamTLS UpstreamFailure = "tls"
+
+	// UpstreamTimeout means that the upstream did not respond in time.
+	UpstreamTimeout UpstreamFailure = "timeout"
+
+	// Upstream5xx means that the upstream responded with a 5xx status.
+	Upstream5xx UpstreamFailure = "upstream_5xx"
+
+	// UpstreamBodyCopy means that reading the response body from the
+	// upstream failed after the response header was sent.
+	UpstreamBodyCopy UpstreamFailure = "body_copy"
+
+	// UpstreamClientGone means that the client went away before the
+	// upstream response was delivered.
+	UpstreamClientGone UpstreamFailure = "client_gone"
+
+	// UpstreamOther covers all other errors.
+	UpstreamOther UpstreamFailure = "other"
+)
+
+// ClassifyUpstreamError classifies an error returned by an upstream round
+// trip made on behalf of req. It returns the empty string if err is nil.
+func ClassifyUpstreamError(req *http.Request, err error) UpstreamFailure {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		return UpstreamClientGone
+	}
+	var (
+		nerr   net.Error
+		operr  *net.OpError
+		rherr  tls.RecordHeaderError
+		verr   *tls.CertificateVerificationError
+		uaerr  x509.UnknownAuthorityError
+		hnerr  x509.HostnameError
+		cierr  x509.CertificateInvalidError
+		errTLS = errors.As(err, &rherr) || errors.As(err, &verr) ||
+			errors.As(err, &uaerr) || errors.As(err, &hnerr) || errors.As(err, &cierr)
+	)
+	switch {
+	case errTLS:
+		return UpstreamTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &nerr) && nerr.Timeout():
+		return UpstreamTimeout
+	case errors.As(err, &operr) && operr.Op == "dial":
+		return UpstreamDial
+	}
+	return UpstreamOther
+}
+
+// SetUpstreamFailure records the failure of the upstream on whose behalf
+// req is being served, such that it is reported in the Summary produced
+// by ServeInstrumented. Proxying handlers call SetUpstreamFailure; the
+// first failure recorded for a request is kept.
+//
+// If req carries no request state, SetUpstreamFailure is a no-op.
+func SetUpstreamFailure(req *http.Request, f UpstreamFailure) {
+	st := stateOf(req)
+	if st == nil || f == "" {
+		return
+	}
+	st.mu.Lock()
+	if st.upstreamFailure == "" {
+		st.upstreamFailure = f
+	}
+	st.mu.Unlock()
+}
+
+// RequestUpstreamFailure returns the upstream failure recorded for req
+// using SetUpstreamFailure, or the empty string.
+func RequestUpstreamFailure(req *http.Request) UpstreamFailure {
+	st := stateOf(req)
+	if st == nil {
+		return ""
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.upstreamFailure
+}
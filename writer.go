@@ -0,0 +1,106 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// beforeWrite wraps w such that fn is called exactly once, before the
+// response header is written. It is used by middleware which must modify
+// the header after the handler has started running, but before the
+// response is committed. The returned ResponseWriter implements the same
+// optional interfaces as w.
+//
+// If the handler returns without writing anything, it is up to the caller
+// to invoke the returned finish function, which calls fn if it was not
+// called already.
+func beforeWrite(w http.ResponseWriter, fn func()) (ww http.ResponseWriter, finish func()) {
+	done := false
+	once := func() {
+		if !done {
+			done = true
+			fn()
+		}
+	}
+	ww = httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				once()
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				once()
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				once()
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				once()
+				next()
+			}
+		},
+	})
+	return ww, once
+}
+
+// responseBuffer is an http.ResponseWriter which buffers the entire
+// response in memory. It is used by middleware which must inspect or
+// rewrite the response before sending it.
+type responseBuffer struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), code: http.StatusOK}
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(code int) {
+	if !rb.wroteHeader {
+		rb.code = code
+		rb.wroteHeader = true
+	}
+}
+
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	rb.WriteHeader(http.StatusOK)
+	return rb.body.Write(b)
+}
+
+// sendTo writes the buffered response to w.
+func (rb *responseBuffer) sendTo(w http.ResponseWriter) {
+	copyHeader(w.Header(), rb.header)
+	w.WriteHeader(rb.code)
+	w.Write(rb.body.Bytes())
+}
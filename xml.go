@@ -0,0 +1,140 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultXMLMaxBytes is the limit on the size of request bodies decoded
+// by DecodeXML, if no other limit is specified.
+const DefaultXMLMaxBytes = 1 << 20
+
+// WriteXML writes the XML encoding of v, preceded by the standard XML
+// header and followed by a newline, as the response to a request, with
+// the specified status. The Content-Type header is set to
+// "application/xml; charset=utf-8", unless it is set already, and the
+// Content-Length header is set as well.
+//
+// Like WriteJSON, WriteXML encodes v in its entirety before anything is
+// written, and responds with 500 Internal Server Error if encoding fails.
+func WriteXML(w http.ResponseWriter, status int, v interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(buf).Encode(v); err != nil {
+		writeProblem(w, http.StatusInternalServerError, "")
+		return err
+	}
+	buf.WriteByte('\n')
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/xml; charset=utf-8")
+	}
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// DecodeXML decodes the XML request body of req into v. Bodies larger
+// than limit bytes are rejected with an *http.MaxBytesError, for which
+// IsBodyTooLarge reports true. If limit is not positive,
+// DefaultXMLMaxBytes is used.
+//
+// The Content-Type of the request must be "application/xml", "text/xml",
+// or a media type with the "+xml" suffix. The character encoding is
+// taken from the charset parameter, if present, and from the XML
+// declaration otherwise, as specified by RFC 7303. UTF-8, US-ASCII and
+// ISO-8859-1 are supported.
+//
+// Requests with other media types or character encodings are rejected
+// with an error which wraps ErrUnsupportedMediaType, and malformed
+// documents with an error which wraps both ErrBadRequest and the error
+// returned by the decoder, such that ErrorMapper maps either to the
+// appropriate client error response.
+func DecodeXML(req *http.Request, v interface{}, limit int64) error {
+	if limit <= 0 {
+		limit = DefaultXMLMaxBytes
+	}
+	mt, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || !isXMLMediaType(mt) {
+		return fmt.Errorf("%w %q", ErrUnsupportedMediaType, mt)
+	}
+	b, err := readBody(req, limit)
+	if err != nil {
+		return err
+	}
+	return decodeXML(b, params["charset"], v)
+}
+
+// decodeXML decodes the XML document b into v. If charset is not empty,
+// it takes precedence over the encoding in the XML declaration.
+func decodeXML(b []byte, charset string, v interface{}) error {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.CharsetReader = xmlCharsetReader
+	if charset != "" {
+		r, err := xmlCharsetReader(charset, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		// The declaration is ignored once the body is converted.
+		dec = xml.NewDecoder(r)
+		dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) {
+			return r, nil
+		}
+	}
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, ErrUnsupportedMediaType) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+	return nil
+}
+
+func isXMLMediaType(mt string) bool {
+	return mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml")
+}
+
+// xmlCharsetReader converts input in the named character encoding to
+// UTF-8, as expected by xml.Decoder.CharsetReader.
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "l1":
+		b, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 0, len(b))
+		for _, c := range b {
+			out = utf8.AppendRune(out, rune(c))
+		}
+		return bytes.NewReader(out), nil
+	default:
+		return nil, fmt.Errorf("%w: charset %q", ErrUnsupportedMediaType, charset)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Andrei Tudor Călin
+//
+// Permission to use, copy, modify, and/or distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpx_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"acln.ro/httpx"
+)
+
+type xmlItem struct {
+	XMLName xml.Name `xml:"item"`
+	Name    string   `xml:"name"`
+}
+
+func TestWriteXML(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := httpx.WriteXML(rec, http.StatusOK, xmlItem{Name: "café"}); err != nil {
+		t.Fatal(err)
+	}
+	want := xml.Header + "<item><name>café</name></item>\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body == %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get("Content-Type"), "application/xml; charset=utf-8"; got != want {
+		t.Errorf("Content-Type == %q, want %q", got, want)
+	}
+}
+
+func TestDecodeXML(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctype string
+		body  string
+		want  string
+		err   error
+	}{
+		{
+			name:  "utf-8",
+			ctype: "application/xml",
+			body:  "<item><name>café</name></item>",
+			want:  "café",
+		},
+		{
+			name:  "charset parameter",
+			ctype: "text/xml; charset=ISO-8859-1",
+			body:  "<?xml version=\"1.0\" encoding=\"UTF-8\"?><item><name>caf\xe9</name></item>",
+			want:  "café",
+		},
+		{
+			name:  "declaration",
+			ctype: "application/atom+xml",
+			body:  "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><item><name>caf\xe9</name></item>",
+			want:  "café",
+		},
+		{
+			name:  "unsupported charset",
+			ctype: "application/xml; charset=koi8-r",
+			body:  "<item/>",
+			err:   httpx.ErrUnsupportedMediaType,
+		},
+		{
+			name:  "unsupported declaration",
+			ctype: "application/xml",
+			body:  "<?xml version=\"1.0\" encoding=\"koi8-r\"?><item/>",
+			err:   httpx.ErrUnsupportedMediaType,
+		},
+		{
+			name:  "media type",
+			ctype: "application/json",
+			body:  "{}",
+			err:   httpx.ErrUnsupportedMediaType,
+		},
+		{
+			name:  "malformed",
+			ctype: "application/xml",
+			body:  "<item><name>",
+			err:   httpx.ErrBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.ctype)
+		var item xmlItem
+		err := httpx.DecodeXML(req, &item, 0)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if item.Name != tt.want {
+			t.Errorf("%s: Name == %q, want %q", tt.name, item.Name, tt.want)
+		}
+	}
+}
+
+func TestDecodeXMLLimit(t *testing.T) {
+	body := "<item><name>" + strings.Repeat("x", 64) + "</name></item>"
+	for _, length := range []int64{int64(len(body)), -1} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/xml")
+		req.ContentLength = length
+		var item xmlItem
+		if err := httpx.DecodeXML(req, &item, 32); !httpx.IsBodyTooLarge(err) {
+			t.Errorf("Content-Length %d: got error %v, want body too large", length, err)
+		}
+	}
+}